@@ -0,0 +1,466 @@
+// Package asm implements a two-pass assembler for DCPU-16 1.7 assembly.
+//
+// The syntax follows the common community conventions: labels are written
+// as ":label" or "label:", comments start with ";", registers and mnemonics
+// are case-insensitive, and DAT emits words, character strings and
+// expressions. Operands accept expressions made of numbers, characters,
+// labels and the usual arithmetic and bitwise operators.
+//
+// Macros are defined with .macro and .endmacro, and invoked like an
+// instruction:
+//
+//	.macro push2 a, b
+//	    SET PUSH, a
+//	    SET PUSH, b
+//	.endmacro
+//	    push2 X, [0x1000]
+//
+// Parameters are substituted wherever they appear as identifiers in the
+// body. Macros may invoke other macros, up to maxMacroDepth levels deep.
+//
+// The supported directives are:
+//
+//	.org addr            continue assembling at addr, padding with zeros
+//	.dat values...       the same as DAT
+//	.fill count, value   emit count copies of value
+//	.reserve count       emit count zeros
+//	.equ name, value     define a symbol without emitting anything
+//	.include "file"      assemble file in place
+//
+// Included files are resolved relative to the file that includes them.
+package asm
+
+import (
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Error is an assembly error, annotated with the source line it occurred on.
+// File is empty for errors in the source passed to Assemble.
+type Error struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (err *Error) Error() string {
+	if err.File != "" {
+		return fmt.Sprintf("%s:%d: %v", err.File, err.Line, err.Err)
+	}
+	return fmt.Sprintf("line %d: %v", err.Line, err.Err)
+}
+
+// Assemble assembles the source into machine code that starts at address 0.
+// Included files are resolved relative to the current directory.
+func Assemble(src []byte) ([]core.Word, error) {
+	prog, err := Compile(src, "")
+	if err != nil {
+		return nil, err
+	}
+	return prog.Words, nil
+}
+
+// AssembleFile is like Assemble, but reads the source from the named file.
+func AssembleFile(path string) ([]core.Word, error) {
+	prog, err := CompileFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return prog.Words, nil
+}
+
+// CompileFile is like Compile, but reads the source from the named file.
+func CompileFile(path string) (*Program, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Compile(src, path)
+}
+
+// Compile assembles the source, returning the program along with its
+// symbols and listing. file names the source for errors and includes,
+// and may be empty.
+func Compile(src []byte, file string) (*Program, error) {
+	a := new(assembler)
+	if file != "" {
+		if abs, err := filepath.Abs(file); err == nil {
+			a.including = []string{abs}
+		}
+	}
+	if err := a.parse(string(src), file); err != nil {
+		return nil, err
+	}
+	if a.labels != nil {
+		a.statements = append(a.statements, &statement{labels: a.labels})
+	}
+	if err := a.layout(); err != nil {
+		return nil, err
+	}
+	return a.emit()
+}
+
+// maxLayoutPasses bounds the number of passes spent choosing short literals
+const maxLayoutPasses = 32
+
+type assembler struct {
+	statements []*statement
+	symbols    map[string]int
+	macros     map[string]*macro
+	labels     []string // labels waiting for the next statement
+	source     string   // text of the line being parsed, for the listing
+	including  []string // absolute paths of the files being parsed, for cycle detection
+}
+
+// statement is a single instruction or directive, along with the labels
+// that precede it
+type statement struct {
+	file      string
+	line      int
+	source    string
+	labels    []string
+	op        core.Opcode
+	a, b      *operand // b is nil for special opcodes
+	data      []expr   // DAT values; strings are expanded to one word per character
+	directive string   // .org, .fill or .equ, empty otherwise
+	args      []expr   // directive arguments
+	name      string   // the symbol defined by .equ
+	size      int      // size in words, as of the last layout pass
+}
+
+// parse is the first pass. It splits the source into statements,
+// expanding macros and includes.
+func (a *assembler) parse(src, file string) error {
+	lines := strings.Split(src, "\n")
+	for i := 0; i < len(lines); i++ {
+		tokens, err := lex(lines[i])
+		if err != nil {
+			return &Error{file, i + 1, err}
+		}
+		if isDirective(tokens, ".macro") {
+			end, err := a.defineMacro(file, lines, i)
+			if err != nil {
+				return err
+			}
+			i = end
+			continue
+		}
+		if isDirective(tokens, ".include") {
+			if err := a.include(file, tokens[1:]); err != nil {
+				if _, ok := err.(*Error); !ok {
+					err = &Error{file, i + 1, err}
+				}
+				return err
+			}
+			continue
+		}
+		a.source = lines[i]
+		if err := a.parseTokens(tokens, file, i+1, 0); err != nil {
+			return &Error{file, i + 1, err}
+		}
+	}
+	return nil
+}
+
+// include parses the file named by the .include arguments
+func (a *assembler) include(from string, args []token) error {
+	if len(args) != 1 || args[0].kind != tokenString {
+		return fmt.Errorf(".include requires a quoted file name")
+	}
+	path := args[0].text
+	if !filepath.IsAbs(path) && from != "" {
+		path = filepath.Join(filepath.Dir(from), path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	for _, f := range a.including {
+		if f == abs {
+			return fmt.Errorf("%s includes itself", path)
+		}
+	}
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	a.including = append(a.including, abs)
+	err = a.parse(string(src), path)
+	a.including = a.including[:len(a.including)-1]
+	return err
+}
+
+// parseTokens parses a single line of tokens. Any statement it produces is
+// attributed to the given source line.
+func (a *assembler) parseTokens(tokens []token, file string, line, depth int) error {
+	labels, tokens := splitLabels(tokens)
+	a.labels = append(a.labels, labels...)
+	if len(tokens) == 0 {
+		// label-only line, attach the labels to the next statement
+		return nil
+	}
+	if tokens[0].kind == tokenIdent {
+		if m, ok := a.macros[strings.ToLower(tokens[0].text)]; ok {
+			return a.expandMacro(m, tokens[1:], file, line, depth)
+		}
+	}
+	stmt, err := parseStatement(tokens)
+	if err != nil {
+		return err
+	}
+	stmt.file, stmt.line, stmt.source = file, line, a.source
+	stmt.labels = a.labels
+	a.labels = nil
+	a.statements = append(a.statements, stmt)
+	return nil
+}
+
+// splitLabels removes the leading labels, either :label or label:
+func splitLabels(tokens []token) (labels []string, rest []token) {
+	for {
+		if len(tokens) >= 2 && tokens[0].is(":") && tokens[1].kind == tokenIdent {
+			labels = append(labels, tokens[1].text)
+			tokens = tokens[2:]
+		} else if len(tokens) >= 2 && tokens[0].kind == tokenIdent && tokens[1].is(":") {
+			labels = append(labels, tokens[0].text)
+			tokens = tokens[2:]
+		} else {
+			return labels, tokens
+		}
+	}
+}
+
+// isDirective returns whether the line is the given directive
+func isDirective(tokens []token, name string) bool {
+	return len(tokens) > 0 && tokens[0].kind == tokenIdent && strings.EqualFold(tokens[0].text, name)
+}
+
+func parseStatement(tokens []token) (*statement, error) {
+	if tokens[0].kind != tokenIdent {
+		return nil, fmt.Errorf("expected instruction, found %s", tokens[0].text)
+	}
+	stmt := new(statement)
+	mnemonic := tokens[0].text
+	args := splitArgs(tokens[1:])
+	var err error
+	if strings.HasPrefix(mnemonic, ".") && !strings.EqualFold(mnemonic, ".dat") {
+		if err := stmt.parseDirective(strings.ToLower(mnemonic), args); err != nil {
+			return nil, err
+		}
+		return stmt, nil
+	}
+	if strings.EqualFold(mnemonic, "DAT") || strings.EqualFold(mnemonic, ".dat") {
+		if stmt.data, err = parseData(args); err != nil {
+			return nil, err
+		}
+		return stmt, nil
+	}
+	op, ok := core.OpcodeByName(mnemonic)
+	if !ok {
+		return nil, fmt.Errorf("unknown instruction %s", mnemonic)
+	}
+	stmt.op = op
+	if op.Special() {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s takes 1 operand, found %d", op, len(args))
+		}
+		stmt.a, err = parseOperand(args[0])
+		return stmt, err
+	}
+	if len(args) != 2 {
+		return nil, fmt.Errorf("%s takes 2 operands, found %d", op, len(args))
+	}
+	if stmt.b, err = parseOperand(args[0]); err != nil {
+		return nil, err
+	}
+	stmt.a, err = parseOperand(args[1])
+	return stmt, err
+}
+
+// splitArgs splits tokens on top-level commas
+func splitArgs(tokens []token) [][]token {
+	if len(tokens) == 0 {
+		return nil
+	}
+	var args [][]token
+	depth, start := 0, 0
+	for i, tok := range tokens {
+		switch {
+		case tok.is("["), tok.is("("):
+			depth++
+		case tok.is("]"), tok.is(")"):
+			depth--
+		case tok.is(",") && depth == 0:
+			args = append(args, tokens[start:i])
+			start = i + 1
+		}
+	}
+	return append(args, tokens[start:])
+}
+
+func parseData(args [][]token) ([]expr, error) {
+	var data []expr
+	for _, arg := range args {
+		if len(arg) == 1 && arg[0].kind == tokenString {
+			for _, r := range arg[0].text {
+				data = append(data, numberExpr(r))
+			}
+			continue
+		}
+		e, err := parseExpr(arg)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, e)
+	}
+	if data == nil {
+		return nil, fmt.Errorf("DAT requires at least one value")
+	}
+	return data, nil
+}
+
+// layout is the second pass. It assigns addresses to labels, choosing short
+// literals wherever the value fits. Shrinking an instruction can move labels,
+// so this repeats until the addresses settle.
+func (a *assembler) layout() error {
+	a.symbols = make(map[string]int)
+	for pass := 0; pass < maxLayoutPasses; pass++ {
+		symbols := make(map[string]int)
+		addr := 0
+		for _, stmt := range a.statements {
+			for _, label := range stmt.labels {
+				if _, ok := symbols[label]; ok && pass == 0 {
+					return &Error{stmt.file, stmt.line, fmt.Errorf("duplicate label %s", label)}
+				}
+				symbols[label] = addr
+			}
+			if stmt.directive == ".equ" {
+				if _, ok := symbols[stmt.name]; ok && pass == 0 {
+					return &Error{stmt.file, stmt.line, fmt.Errorf("duplicate symbol %s", stmt.name)}
+				}
+				// prefer this pass's values, falling back on the previous pass
+				// for symbols that are defined later on
+				val, err := stmt.args[0].eval(symbols)
+				if err != nil {
+					val, err = stmt.args[0].eval(a.symbols)
+				}
+				if err == nil {
+					symbols[stmt.name] = val
+				}
+			}
+			stmt.size = stmt.sizeWith(addr, a.symbols)
+			addr += stmt.size
+		}
+		if addr > 0x10000 {
+			return fmt.Errorf("program is too large (%d words)", addr)
+		}
+		if pass > 0 && equalSymbols(symbols, a.symbols) {
+			return nil
+		}
+		a.symbols = symbols
+	}
+	return fmt.Errorf("could not settle label addresses after %d passes", maxLayoutPasses)
+}
+
+func equalSymbols(m1, m2 map[string]int) bool {
+	if len(m1) != len(m2) {
+		return false
+	}
+	for k, v := range m1 {
+		if v2, ok := m2[k]; !ok || v != v2 {
+			return false
+		}
+	}
+	return true
+}
+
+func (stmt *statement) sizeWith(addr int, symbols map[string]int) int {
+	if stmt.data != nil {
+		return len(stmt.data)
+	}
+	if stmt.directive != "" {
+		return stmt.directiveSize(addr, symbols)
+	}
+	if stmt.op == 0 {
+		return 0
+	}
+	size := 1
+	if stmt.a.hasNextWord(true, symbols) {
+		size++
+	}
+	if stmt.b != nil && stmt.b.hasNextWord(false, symbols) {
+		size++
+	}
+	return size
+}
+
+// emit encodes the statements using the final symbol table
+func (a *assembler) emit() (*Program, error) {
+	prog := &Program{Symbols: make(Symbols, len(a.symbols))}
+	for name, val := range a.symbols {
+		prog.Symbols[name] = core.Word(val)
+	}
+	var words []core.Word
+	for _, stmt := range a.statements {
+		var encoded []core.Word
+		var err error
+		if stmt.directive != "" {
+			encoded, err = stmt.encodeDirective(len(words), a.symbols)
+		} else {
+			encoded, err = stmt.encode(a.symbols)
+		}
+		if err != nil {
+			return nil, &Error{stmt.file, stmt.line, err}
+		}
+		if len(encoded) != stmt.size {
+			// this shouldn't be possible once the layout has settled
+			return nil, &Error{stmt.file, stmt.line, fmt.Errorf("instruction size changed during assembly")}
+		}
+		prog.Listing = append(prog.Listing, ListingLine{
+			File:    stmt.file,
+			Line:    stmt.line,
+			Address: core.Word(len(words)),
+			Words:   encoded,
+			Source:  stmt.source,
+		})
+		words = append(words, encoded...)
+	}
+	prog.Words = words
+	return prog, nil
+}
+
+func (stmt *statement) encode(symbols map[string]int) ([]core.Word, error) {
+	if stmt.data != nil {
+		words := make([]core.Word, len(stmt.data))
+		for i, e := range stmt.data {
+			val, err := e.eval(symbols)
+			if err != nil {
+				return nil, err
+			}
+			words[i] = core.Word(val)
+		}
+		return words, nil
+	}
+	if stmt.op == 0 {
+		return nil, nil
+	}
+	aCode, aNext, err := stmt.a.encode(true, symbols)
+	if err != nil {
+		return nil, err
+	}
+	words := []core.Word{0}
+	words = append(words, aNext...)
+	if stmt.op.Special() {
+		words[0] = stmt.op.Code()<<5 | aCode<<10
+		return words, nil
+	}
+	bCode, bNext, err := stmt.b.encode(false, symbols)
+	if err != nil {
+		return nil, err
+	}
+	words[0] = stmt.op.Code() | bCode<<5 | aCode<<10
+	return append(words, bNext...), nil
+}
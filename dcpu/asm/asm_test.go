@@ -0,0 +1,330 @@
+package asm
+
+import (
+	"bytes"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAssembleSpecExample(t *testing.T) {
+	src := `
+; Try some basic stuff
+        SET A, 0x30              ; 7c01 0030
+        SET [0x1000], 0x20       ; 7fc1 0020 1000
+        SUB A, [0x1000]          ; 7803 1000
+        IFN A, 0x10              ; c413
+           SET PC, crash         ; df81
+        SET I, 10                ; acc1
+        SET A, 0x2000            ; 7c01 2000
+:loop   SET [0x2000+I], [A]      ; 22c1 2000
+        SUB I, 1                 ; 88c3
+        IFN I, 0                 ; 84d3
+           SET PC, loop          ; b781
+        SET X, 0x4               ; 9461
+        JSR testsub              ; d420
+        SET PC, crash            ; df81
+:testsub SHL X, 4                ; 946f
+        SET PC, POP              ; 6381
+:crash  SET PC, crash            ; df81
+`
+	// unlike the spec, the labels are close enough to use short literals
+	expected := []core.Word{
+		0x7c01, 0x0030, 0x7fc1, 0x0020, 0x1000, 0x7803, 0x1000, 0xc413,
+		0xdf81, 0xacc1, 0x7c01, 0x2000, 0x22c1, 0x2000, 0x88c3, 0x84d3,
+		0xb781, 0x9461, 0xd420, 0xdf81, 0x946f, 0x6381, 0xdf81,
+	}
+	words, err := Assemble([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(words) != len(expected) {
+		t.Fatalf("Unexpected program length; expected %d, found %d: %#04x", len(expected), len(words), words)
+	}
+	for i := range words {
+		if words[i] != expected[i] {
+			t.Errorf("Unexpected word at offset %d; expected %#04x, found %#04x", i, expected[i], words[i])
+		}
+	}
+}
+
+func TestAssembleOperands(t *testing.T) {
+	tests := []struct {
+		src   string
+		words []core.Word
+	}{
+		{"SET PUSH, POP", []core.Word{0x6301}},
+		{"SET [--SP], [SP++]", []core.Word{0x6301}},
+		{"SET A, PEEK", []core.Word{0x6401}},
+		{"SET A, [SP]", []core.Word{0x6401}},
+		{"SET A, PICK 3", []core.Word{0x6801, 3}},
+		{"SET A, [SP + 3]", []core.Word{0x6801, 3}},
+		{"SET A, -1", []core.Word{0x8001}},
+		{"SET A, 30", []core.Word{0xfc01}},
+		{"SET A, 31", []core.Word{0x7c01, 31}},
+		{"SET 1, A", []core.Word{0x03e1, 1}},
+		{"SET [B+1], [2+C-1]", []core.Word{0x4a21, 1, 1}},
+		{"SET [J-2], 'a'", []core.Word{0x7ee1, 'a', 0xfffe}},
+		{"ADD x, (1 << 8) | 2", []core.Word{0x7c62, 0x102}},
+		{"ADD x, (1 << 4) | 2", []core.Word{0xcc62}},
+		{"label: DAT \"hi\", label, 0", []core.Word{'h', 'i', 0, 0}},
+		{"JSR fn\n:fn IAG A", []core.Word{0x8820, 0x0120}},
+	}
+	for _, test := range tests {
+		words, err := Assemble([]byte(test.src))
+		if err != nil {
+			t.Errorf("%q: %v", test.src, err)
+			continue
+		}
+		if len(words) != len(test.words) {
+			t.Errorf("%q: expected %#04x, found %#04x", test.src, test.words, words)
+			continue
+		}
+		for i := range words {
+			if words[i] != test.words[i] {
+				t.Errorf("%q: expected %#04x, found %#04x", test.src, test.words, words)
+				break
+			}
+		}
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []string{
+		"FOO A, B",
+		"SET A",
+		"SET A, undefined",
+		":x SET A, 1\n:x SET A, 2",
+		"SET [A+B], 1",
+		"SET [PC], 1",
+		"JSR A, B",
+	}
+	for _, src := range tests {
+		if _, err := Assemble([]byte(src)); err == nil {
+			t.Errorf("%q: expected an error", src)
+		}
+	}
+}
+
+func TestAssembleMacros(t *testing.T) {
+	src := `
+.macro push2 a, b
+    SET PUSH, a
+    SET PUSH, b
+.endmacro
+.macro pushall()
+    push2 A, B
+    push2(C, [0x1000+I])
+.endm
+:start pushall
+    SET PC, start
+`
+	expected := []core.Word{0x0301, 0x0701, 0x0b01, 0x5b01, 0x1000, 0x8781}
+	words, err := Assemble([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(words) != len(expected) {
+		t.Fatalf("Unexpected program length; expected %d, found %d: %#04x", len(expected), len(words), words)
+	}
+	for i := range words {
+		if words[i] != expected[i] {
+			t.Errorf("Unexpected word at offset %d; expected %#04x, found %#04x", i, expected[i], words[i])
+		}
+	}
+}
+
+func TestAssembleMacroErrors(t *testing.T) {
+	tests := []string{
+		".macro foo\nSET A, 1",
+		".macro set\n.endmacro",
+		".macro foo\n.endmacro\n.macro foo\n.endmacro",
+		".macro foo a\nSET A, a\n.endmacro\nfoo",
+		".macro foo\nfoo\n.endmacro\nfoo",
+		".macro foo\n.macro bar\n.endmacro\n.endmacro",
+		".macro foo x\nSET x\n.endmacro\nfoo A",
+	}
+	for _, src := range tests {
+		if _, err := Assemble([]byte(src)); err == nil {
+			t.Errorf("%q: expected an error", src)
+		}
+	}
+}
+
+func TestAssembleDirectives(t *testing.T) {
+	src := `
+.equ count, end - data
+.equ VRAM 0x8000
+        SET A, count
+        SET [VRAM], A
+.org 8
+:data   .dat 1, 2
+        .fill 3, 0xffff
+        .reserve 2
+:end    DAT end
+`
+	expected := []core.Word{
+		0xa001, 0x03c1, 0x8000, 0, 0, 0, 0, 0,
+		1, 2, 0xffff, 0xffff, 0xffff, 0, 0, 15,
+	}
+	words, err := Assemble([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(words) != len(expected) {
+		t.Fatalf("Unexpected program length; expected %d, found %d: %#04x", len(expected), len(words), words)
+	}
+	for i := range words {
+		if words[i] != expected[i] {
+			t.Errorf("Unexpected word at offset %d; expected %#04x, found %#04x", i, expected[i], words[i])
+		}
+	}
+
+	errTests := []string{
+		"SET A, 1\n.org 0",
+		".equ A, 1",
+		".equ x, 1\n:x SET A, 1",
+		".fill 3",
+		".bogus 1",
+		".endmacro",
+	}
+	for _, src := range errTests {
+		if _, err := Assemble([]byte(src)); err == nil {
+			t.Errorf("%q: expected an error", src)
+		}
+	}
+}
+
+func TestAssembleInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "asm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"main.asm":       ".include \"lib/consts.asm\"\nSET A, answer",
+		"lib/consts.asm": ".equ answer, 0x42",
+		"loop.asm":       ".include \"loop2.asm\"",
+		"loop2.asm":      "SET A, 1\n.include \"loop.asm\"",
+	}
+	os.Mkdir(filepath.Join(dir, "lib"), 0755)
+	for name, src := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	words, err := AssembleFile(filepath.Join(dir, "main.asm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(words) != 2 || words[0] != 0x7c01 || words[1] != 0x42 {
+		t.Errorf("Unexpected program %#04x", words)
+	}
+	_, err = AssembleFile(filepath.Join(dir, "loop.asm"))
+	if err, ok := err.(*Error); !ok || err.File != filepath.Join(dir, "loop2.asm") || err.Line != 2 {
+		t.Errorf("Expected an include cycle error in loop2.asm line 2, found %v", err)
+	}
+}
+
+func TestCompileListing(t *testing.T) {
+	src := `.equ width, 32
+:start  SET A, width   ; comment
+        .fill 5, 1
+:halt   SUB PC, 1
+`
+	prog, err := Compile([]byte(src), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := prog.WriteListing(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `0000:                 .equ width, 32
+0000: 7c01 0020       :start  SET A, width   ; comment
+0002: 0001 0001 0001 ...          .fill 5, 1
+0007: 8b83            :halt   SUB PC, 1
+`
+	if buf.String() != expected {
+		t.Errorf("Unexpected listing:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := prog.Symbols.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "0x0000 start\n0x0007 halt\n0x0020 width\n" {
+		t.Errorf("Unexpected symbols:\n%s", buf.String())
+	}
+	symbols, err := ReadSymbols(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(symbols) != 3 || symbols["halt"] != 7 || symbols["width"] != 32 {
+		t.Errorf("Unexpected symbols %v", symbols)
+	}
+	if _, err := ReadSymbols(strings.NewReader("0x10000 big\n")); err == nil {
+		t.Error("Expected an error for an out of range address")
+	}
+	symbols, err = ReadSymbols(strings.NewReader("# other formats\nloop = 0x10\ndone: 0x20\ndraw 0x30\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(symbols, Symbols{"loop": 0x10, "done": 0x20, "draw": 0x30}) {
+		t.Errorf("Unexpected symbols %v", symbols)
+	}
+}
+
+func TestAssembleFizzBuzz(t *testing.T) {
+	src, err := ioutil.ReadFile("../../_samples/fizzbuzz.asm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	words, err := Assemble(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := new(core.State)
+	if err := state.LoadProgram(words, 0); err != nil {
+		t.Fatal(err)
+	}
+	// run until the first 5 lines have been printed
+	for i := 0; i < 10000 && state.I() <= 5; i++ {
+		if err := state.StepCycle(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := "12Fizz4Buzz"
+	var found []rune
+	for row := 0; row < 5; row++ {
+		for col := 0; col < 32; col++ {
+			w := state.Ram.Load(core.Word(0x8000 + row*32 + col))
+			if w == 0 {
+				break
+			}
+			found = append(found, rune(w&0x7f))
+		}
+	}
+	if string(found) != expected {
+		t.Errorf("Unexpected screen contents; expected %q, found %q", expected, string(found))
+	}
+}
+
+func TestParseWords(t *testing.T) {
+	src := "; a snippet\n0x7c01, 0x0030 ; SET A, 0x30\r\ndat 7de1 1000 0020 // SET [0x1000], 0x20\n0008: 8b83 # SUB PC, 1\n"
+	words, err := ParseWords([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []core.Word{0x7c01, 0x0030, 0x7de1, 0x1000, 0x0020, 0x8b83}
+	if !reflect.DeepEqual(words, expected) {
+		t.Errorf("Expected %#v, found %#v", expected, words)
+	}
+	if _, err := ParseWords([]byte("0x7c01, 0x10000")); err == nil {
+		t.Error("Expected an error for a word out of range")
+	}
+}
@@ -0,0 +1,117 @@
+package asm
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu/core"
+)
+
+// parseDirective parses the arguments of the directives that produce
+// statements. .macro and .include are handled while parsing lines.
+func (stmt *statement) parseDirective(name string, args [][]token) error {
+	var err error
+	switch name {
+	case ".org":
+		if len(args) != 1 {
+			return errors.New(".org takes 1 argument")
+		}
+		stmt.args, err = parseExprs(args)
+	case ".fill":
+		if len(args) != 2 {
+			return errors.New(".fill takes 2 arguments")
+		}
+		stmt.args, err = parseExprs(args)
+	case ".reserve":
+		if len(args) != 1 {
+			return errors.New(".reserve takes 1 argument")
+		}
+		name = ".fill"
+		stmt.args, err = parseExprs(args)
+		stmt.args = append(stmt.args, numberExpr(0))
+	case ".equ":
+		// accept both ".equ name, value" and ".equ name value"
+		if len(args) == 1 && len(args[0]) > 1 {
+			args = [][]token{args[0][:1], args[0][1:]}
+		}
+		if len(args) != 2 || len(args[0]) != 1 || args[0][0].kind != tokenIdent {
+			return errors.New(".equ requires a name and a value")
+		}
+		if isRegister(args[0][0].text) {
+			return fmt.Errorf("%s is a register", args[0][0].text)
+		}
+		stmt.name = args[0][0].text
+		stmt.args, err = parseExprs(args[1:])
+	case ".endmacro", ".endm":
+		return fmt.Errorf("%s without .macro", name)
+	default:
+		return fmt.Errorf("unknown directive %s", name)
+	}
+	stmt.directive = name
+	return err
+}
+
+func parseExprs(args [][]token) ([]expr, error) {
+	exprs := make([]expr, len(args))
+	for i, arg := range args {
+		e, err := parseExpr(arg)
+		if err != nil {
+			return nil, err
+		}
+		exprs[i] = e
+	}
+	return exprs, nil
+}
+
+// directiveSize returns the size of the directive when placed at addr.
+// Values that can't be evaluated yet are treated as 0 until the layout
+// settles; emit reports any that remain.
+func (stmt *statement) directiveSize(addr int, symbols map[string]int) int {
+	switch stmt.directive {
+	case ".org":
+		if target, err := stmt.args[0].eval(symbols); err == nil && target > addr {
+			return target - addr
+		}
+	case ".fill":
+		if count, err := stmt.args[0].eval(symbols); err == nil && count > 0 {
+			return count
+		}
+	}
+	return 0
+}
+
+// encodeDirective returns the words the directive emits at addr
+func (stmt *statement) encodeDirective(addr int, symbols map[string]int) ([]core.Word, error) {
+	switch stmt.directive {
+	case ".org":
+		target, err := stmt.args[0].eval(symbols)
+		if err != nil {
+			return nil, err
+		}
+		if target < addr {
+			return nil, fmt.Errorf(".org %#04x is before the current address %#04x", target, addr)
+		}
+		return make([]core.Word, target-addr), nil
+	case ".fill":
+		count, err := stmt.args[0].eval(symbols)
+		if err != nil {
+			return nil, err
+		}
+		if count < 0 || count > 0x10000 {
+			return nil, fmt.Errorf("invalid count %d", count)
+		}
+		val, err := stmt.args[1].eval(symbols)
+		if err != nil {
+			return nil, err
+		}
+		words := make([]core.Word, count)
+		for i := range words {
+			words[i] = core.Word(val)
+		}
+		return words, nil
+	case ".equ":
+		if _, err := stmt.args[0].eval(symbols); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
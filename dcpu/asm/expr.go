@@ -0,0 +1,180 @@
+package asm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// expr is an expression that evaluates to a value given the symbol table
+type expr interface {
+	eval(symbols map[string]int) (int, error)
+}
+
+// undefinedError is returned when an expression refers to an unknown symbol
+type undefinedError struct {
+	name string
+}
+
+func (err *undefinedError) Error() string {
+	return fmt.Sprintf("undefined symbol %s", err.name)
+}
+
+type numberExpr int
+
+func (e numberExpr) eval(symbols map[string]int) (int, error) {
+	return int(e), nil
+}
+
+type symbolExpr string
+
+func (e symbolExpr) eval(symbols map[string]int) (int, error) {
+	if val, ok := symbols[string(e)]; ok {
+		return val, nil
+	}
+	return 0, &undefinedError{string(e)}
+}
+
+type unaryExpr struct {
+	op      string
+	operand expr
+}
+
+func (e *unaryExpr) eval(symbols map[string]int) (int, error) {
+	val, err := e.operand.eval(symbols)
+	if err != nil {
+		return 0, err
+	}
+	if e.op == "-" {
+		return -val, nil
+	}
+	return ^val, nil
+}
+
+type binaryExpr struct {
+	op          string
+	left, right expr
+}
+
+func (e *binaryExpr) eval(symbols map[string]int) (int, error) {
+	left, err := e.left.eval(symbols)
+	if err != nil {
+		return 0, err
+	}
+	right, err := e.right.eval(symbols)
+	if err != nil {
+		return 0, err
+	}
+	switch e.op {
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	case "/", "%":
+		if right == 0 {
+			return 0, errors.New("division by zero")
+		}
+		if e.op == "/" {
+			return left / right, nil
+		}
+		return left % right, nil
+	case "&":
+		return left & right, nil
+	case "|":
+		return left | right, nil
+	case "^":
+		return left ^ right, nil
+	case "<<":
+		return left << uint(right), nil
+	case ">>":
+		return left >> uint(right), nil
+	}
+	panic("Unexpected operator " + e.op)
+}
+
+// binary operator precedence, from loosest to tightest
+var precedence = map[string]int{
+	"|": 1, "^": 2, "&": 3,
+	"<<": 4, ">>": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+// parseExpr parses tokens as a single expression
+func parseExpr(tokens []token) (expr, error) {
+	if len(tokens) == 0 {
+		return nil, errors.New("missing expression")
+	}
+	p := exprParser{tokens: tokens}
+	e, err := p.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %s in expression", p.tokens[p.pos].text)
+	}
+	return e, nil
+}
+
+type exprParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *exprParser) parseBinary(minPrec int) (expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.pos < len(p.tokens) {
+		tok := p.tokens[p.pos]
+		prec, ok := precedence[tok.text]
+		if tok.kind != tokenPunct || !ok || prec < minPrec {
+			break
+		}
+		p.pos++
+		right, err := p.parseBinary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{tok.text, left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (expr, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, errors.New("unexpected end of expression")
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+	switch {
+	case tok.is("-"), tok.is("~"):
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{tok.text, operand}, nil
+	case tok.is("+"):
+		return p.parseUnary()
+	case tok.is("("):
+		e, err := p.parseBinary(1)
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.tokens) || !p.tokens[p.pos].is(")") {
+			return nil, errors.New("missing )")
+		}
+		p.pos++
+		return e, nil
+	case tok.kind == tokenNumber:
+		return numberExpr(tok.value), nil
+	case tok.kind == tokenIdent:
+		if isRegister(tok.text) {
+			return nil, fmt.Errorf("unexpected register %s in expression", tok.text)
+		}
+		return symbolExpr(tok.text), nil
+	}
+	return nil, fmt.Errorf("unexpected %s in expression", tok.text)
+}
@@ -0,0 +1,128 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenIdent  tokenKind = iota // identifier, register or mnemonic
+	tokenNumber                  // number or character literal
+	tokenString                  // string literal, with escapes processed
+	tokenPunct                   // punctuation / operator
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value int // for tokenNumber
+}
+
+func (t token) is(punct string) bool {
+	return t.kind == tokenPunct && t.text == punct
+}
+
+// multi-character operators, checked before single characters
+var punctuation = []string{"<<", ">>", "++", "--"}
+
+// lex splits a single line of source into tokens, dropping any comment
+func lex(line string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == ';':
+			// comment
+			return tokens, nil
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case isIdentStart(c):
+			j := i + 1
+			for j < len(line) && isIdentChar(line[j]) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: line[i:j]})
+			i = j
+		case c >= '0' && c <= '9':
+			j := i + 1
+			for j < len(line) && isIdentChar(line[j]) {
+				j++
+			}
+			n, err := strconv.ParseInt(strings.Replace(line[i:j], "_", "", -1), 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %s", line[i:j])
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: line[i:j], value: int(n)})
+			i = j
+		case c == '"' || c == '\'':
+			str, j, err := lexQuoted(line, i)
+			if err != nil {
+				return nil, err
+			}
+			if c == '"' {
+				tokens = append(tokens, token{kind: tokenString, text: str})
+			} else {
+				if len([]rune(str)) != 1 {
+					return nil, fmt.Errorf("invalid character literal %s", line[i:j])
+				}
+				tokens = append(tokens, token{kind: tokenNumber, text: line[i:j], value: int([]rune(str)[0])})
+			}
+			i = j
+		default:
+			text := string(c)
+			for _, p := range punctuation {
+				if strings.HasPrefix(line[i:], p) {
+					text = p
+					break
+				}
+			}
+			if !strings.Contains(",[]()+-*/%&|^~:<>", string(c)) {
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+			tokens = append(tokens, token{kind: tokenPunct, text: text})
+			i += len(text)
+		}
+	}
+	return tokens, nil
+}
+
+// lexQuoted reads the quoted literal starting at line[start], returning the
+// unescaped contents and the index following the closing quote
+func lexQuoted(line string, start int) (string, int, error) {
+	quote := line[start]
+	var buf []byte
+	for i := start + 1; i < len(line); i++ {
+		c := line[i]
+		if c == quote {
+			return string(buf), i + 1, nil
+		}
+		if c == '\\' && i+1 < len(line) {
+			i++
+			switch line[i] {
+			case 'n':
+				c = '\n'
+			case 't':
+				c = '\t'
+			case 'r':
+				c = '\r'
+			case '0':
+				c = 0
+			default:
+				c = line[i]
+			}
+		}
+		buf = append(buf, c)
+	}
+	return "", 0, fmt.Errorf("unterminated literal %s", line[start:])
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,116 @@
+package asm
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"strings"
+)
+
+// maxMacroDepth limits how deeply macros may invoke other macros,
+// which also catches recursive macros
+const maxMacroDepth = 16
+
+type macro struct {
+	name   string
+	params []string
+	body   [][]token
+}
+
+// defineMacro reads the macro starting at lines[start], returning the index
+// of its .endmacro line
+func (a *assembler) defineMacro(file string, lines []string, start int) (int, error) {
+	tokens, _ := lex(lines[start])
+	m, err := parseMacroHeader(tokens[1:])
+	if err != nil {
+		return 0, &Error{file, start + 1, err}
+	}
+	if _, ok := core.OpcodeByName(m.name); ok || strings.EqualFold(m.name, "DAT") {
+		return 0, &Error{file, start + 1, fmt.Errorf("macro %s conflicts with an instruction", m.name)}
+	}
+	if _, ok := a.macros[strings.ToLower(m.name)]; ok {
+		return 0, &Error{file, start + 1, fmt.Errorf("duplicate macro %s", m.name)}
+	}
+	for i := start + 1; i < len(lines); i++ {
+		tokens, err := lex(lines[i])
+		if err != nil {
+			return 0, &Error{file, i + 1, err}
+		}
+		if isDirective(tokens, ".endmacro") || isDirective(tokens, ".endm") {
+			if a.macros == nil {
+				a.macros = make(map[string]*macro)
+			}
+			a.macros[strings.ToLower(m.name)] = m
+			return i, nil
+		}
+		if isDirective(tokens, ".macro") {
+			return 0, &Error{file, i + 1, errors.New("macros can't be defined inside a macro")}
+		}
+		if len(tokens) > 0 {
+			m.body = append(m.body, tokens)
+		}
+	}
+	return 0, &Error{file, start + 1, fmt.Errorf("macro %s is missing .endmacro", m.name)}
+}
+
+// parseMacroHeader parses "name a, b" or "name(a, b)"
+func parseMacroHeader(tokens []token) (*macro, error) {
+	if len(tokens) == 0 || tokens[0].kind != tokenIdent {
+		return nil, errors.New(".macro requires a name")
+	}
+	m := &macro{name: tokens[0].text}
+	for _, arg := range splitArgs(stripParens(tokens[1:])) {
+		if len(arg) != 1 || arg[0].kind != tokenIdent {
+			return nil, fmt.Errorf("invalid parameter list for macro %s", m.name)
+		}
+		m.params = append(m.params, arg[0].text)
+	}
+	return m, nil
+}
+
+// stripParens removes parentheses that surround the entire token list
+func stripParens(tokens []token) []token {
+	if len(tokens) >= 2 && tokens[0].is("(") && tokens[len(tokens)-1].is(")") {
+		return tokens[1 : len(tokens)-1]
+	}
+	return tokens
+}
+
+// expandMacro substitutes the arguments into the body of the macro and
+// parses the result
+func (a *assembler) expandMacro(m *macro, argTokens []token, file string, line, depth int) error {
+	if depth >= maxMacroDepth {
+		return fmt.Errorf("macro %s exceeds the maximum nesting depth of %d", m.name, maxMacroDepth)
+	}
+	args := splitArgs(stripParens(argTokens))
+	if len(args) != len(m.params) {
+		return fmt.Errorf("macro %s takes %d arguments, found %d", m.name, len(m.params), len(args))
+	}
+	for _, body := range m.body {
+		var expanded []token
+		for _, tok := range body {
+			if i := indexOf(m.params, tok); i >= 0 {
+				expanded = append(expanded, args[i]...)
+			} else {
+				expanded = append(expanded, tok)
+			}
+		}
+		if err := a.parseTokens(expanded, file, line, depth+1); err != nil {
+			return fmt.Errorf("in macro %s: %v", m.name, err)
+		}
+	}
+	return nil
+}
+
+// indexOf returns the index of the parameter named by tok, or -1
+func indexOf(params []string, tok token) int {
+	if tok.kind != tokenIdent {
+		return -1
+	}
+	for i, p := range params {
+		if p == tok.text {
+			return i
+		}
+	}
+	return -1
+}
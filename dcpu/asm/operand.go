@@ -0,0 +1,177 @@
+package asm
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"strings"
+)
+
+// operand is a parsed instruction operand
+type operand struct {
+	code    core.Word // operand encoding, excluding any short literal
+	value   expr      // next word or literal value, nil if the operand has none
+	literal bool      // a plain literal, which may be encoded as a short literal
+	long    bool      // the literal is known not to fit in a short literal
+}
+
+var registerCodes = map[string]core.Word{
+	"A": 0x00, "B": 0x01, "C": 0x02, "X": 0x03,
+	"Y": 0x04, "Z": 0x05, "I": 0x06, "J": 0x07,
+	"SP": 0x1b, "PC": 0x1c, "EX": 0x1d,
+}
+
+func isRegister(name string) bool {
+	_, ok := registerCodes[strings.ToUpper(name)]
+	return ok
+}
+
+// isGeneralRegister returns whether the name is one of A, B, C, X, Y, Z, I or J
+func isGeneralRegister(name string) bool {
+	code, ok := registerCodes[strings.ToUpper(name)]
+	return ok && code <= 0x07
+}
+
+func parseOperand(tokens []token) (*operand, error) {
+	if len(tokens) == 0 {
+		return nil, errors.New("missing operand")
+	}
+	if tokens[0].is("[") {
+		if !tokens[len(tokens)-1].is("]") {
+			return nil, errors.New("missing ]")
+		}
+		return parseIndirect(tokens[1 : len(tokens)-1])
+	}
+	if tokens[0].kind == tokenIdent {
+		name := strings.ToUpper(tokens[0].text)
+		if len(tokens) == 1 {
+			switch name {
+			case "PUSH", "POP":
+				return &operand{code: 0x18}, nil
+			case "PEEK":
+				return &operand{code: 0x19}, nil
+			}
+			if code, ok := registerCodes[name]; ok {
+				return &operand{code: code}, nil
+			}
+		} else if name == "PICK" {
+			e, err := parseExpr(tokens[1:])
+			if err != nil {
+				return nil, err
+			}
+			return &operand{code: 0x1a, value: e}, nil
+		}
+	}
+	e, err := parseExpr(tokens)
+	if err != nil {
+		return nil, err
+	}
+	return &operand{code: 0x1f, value: e, literal: true}, nil
+}
+
+// parseIndirect parses the contents of [ ]
+func parseIndirect(tokens []token) (*operand, error) {
+	if len(tokens) == 0 {
+		return nil, errors.New("empty [ ]")
+	}
+	// the stack forms
+	if len(tokens) == 2 && tokens[0].is("--") && strings.EqualFold(tokens[1].text, "SP") {
+		return &operand{code: 0x18}, nil // PUSH
+	}
+	if len(tokens) == 2 && strings.EqualFold(tokens[0].text, "SP") && tokens[1].is("++") {
+		return &operand{code: 0x18}, nil // POP
+	}
+	// find the register, if any, and remove it from the expression
+	reg := -1
+	for i, tok := range tokens {
+		if tok.kind == tokenIdent && isRegister(tok.text) {
+			if reg >= 0 {
+				return nil, errors.New("only one register may be used in [ ]")
+			}
+			reg = i
+		}
+	}
+	if reg < 0 {
+		e, err := parseExpr(tokens)
+		if err != nil {
+			return nil, err
+		}
+		return &operand{code: 0x1e, value: e}, nil
+	}
+	name := strings.ToUpper(tokens[reg].text)
+	if !isGeneralRegister(name) && name != "SP" {
+		return nil, fmt.Errorf("%s can't be used in [ ]", name)
+	}
+	var rest []token
+	switch {
+	case len(tokens) == 1:
+	case reg == 0 && tokens[1].is("+"):
+		rest = tokens[2:]
+	case reg == 0 && tokens[1].is("-"):
+		// [reg - expr] is [reg + -expr]
+		rest = tokens[1:]
+	case reg > 0 && tokens[reg-1].is("+"):
+		rest = append(append([]token{}, tokens[:reg-1]...), tokens[reg+1:]...)
+	default:
+		return nil, fmt.Errorf("%s must be added to the rest of [ ]", name)
+	}
+	code := registerCodes[name]
+	if rest == nil {
+		if name == "SP" {
+			return &operand{code: 0x19}, nil // PEEK
+		}
+		return &operand{code: 0x08 + code}, nil
+	}
+	e, err := parseExpr(rest)
+	if err != nil {
+		return nil, err
+	}
+	if name == "SP" {
+		return &operand{code: 0x1a, value: e}, nil // PICK
+	}
+	return &operand{code: 0x10 + code, value: e}, nil
+}
+
+// short returns whether the operand should be encoded as a short literal.
+// Only the a operand can hold one, and only if the value is known to fit.
+// Once a literal is seen not to fit, it's kept long so the layout settles.
+func (o *operand) short(isA bool, symbols map[string]int) bool {
+	if !isA || !o.literal || o.long {
+		return false
+	}
+	val, err := o.value.eval(symbols)
+	if err != nil {
+		return false
+	}
+	if fitsShortLiteral(val) {
+		return true
+	}
+	o.long = true
+	return false
+}
+
+// fitsShortLiteral returns whether val is in the short literal range -1..30
+func fitsShortLiteral(val int) bool {
+	w := core.Word(val)
+	return w == 0xffff || w <= 30
+}
+
+func (o *operand) hasNextWord(isA bool, symbols map[string]int) bool {
+	return o.value != nil && !o.short(isA, symbols)
+}
+
+// encode returns the operand code and the next word, if any
+func (o *operand) encode(isA bool, symbols map[string]int) (core.Word, []core.Word, error) {
+	if o.value == nil {
+		return o.code, nil, nil
+	}
+	short := o.short(isA, symbols)
+	val, err := o.value.eval(symbols)
+	if err != nil {
+		return 0, nil, err
+	}
+	if short {
+		return 0x21 + core.Word(val), nil, nil
+	}
+	return o.code, []core.Word{core.Word(val)}, nil
+}
@@ -0,0 +1,120 @@
+package asm
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Program is an assembled program
+type Program struct {
+	Words   []core.Word
+	Symbols Symbols
+	Listing []ListingLine
+}
+
+// ListingLine is a single statement of the listing
+type ListingLine struct {
+	File    string
+	Line    int
+	Address core.Word
+	Words   []core.Word // the words emitted by the statement
+	Source  string      // the source line, or the macro invocation it came from
+}
+
+// listingWords is the number of words shown on a single line of the listing
+const listingWords = 3
+
+// String formats the line as
+// 0000: 7c01 0030       SET A, 0x30
+// Long runs of data are truncated with "...".
+func (l ListingLine) String() string {
+	words := make([]string, 0, listingWords+1)
+	for i, w := range l.Words {
+		if i == listingWords {
+			words = append(words, "...")
+			break
+		}
+		words = append(words, fmt.Sprintf("%04x", w))
+	}
+	return fmt.Sprintf("%04x: %-14s  %s", l.Address, strings.Join(words, " "), strings.TrimRight(l.Source, " \t\r"))
+}
+
+// WriteListing writes the listing of the program to w
+func (p *Program) WriteListing(w io.Writer) error {
+	for _, line := range p.Listing {
+		if line.Source == "" && len(line.Words) == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Symbols maps labels and .equ names to their values
+type Symbols map[string]core.Word
+
+// Write writes the symbols to w, one "0x0000 name" pair per line, ordered
+// by value.
+func (s Symbols) Write(w io.Writer) error {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if s[names[i]] != s[names[j]] {
+			return s[names[i]] < s[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		if _, err := fmt.Fprintf(w, "%#04x %s\n", s[name], name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadSymbols reads symbols in the format written by Symbols.Write, or as
+// written by other assemblers: a name and an address on each line, in
+// either order, optionally separated by "=" or ":" as in "loop = 0x0010".
+// Blank lines and lines starting with ";" or "#" are ignored.
+func ReadSymbols(r io.Reader) (Symbols, error) {
+	s := make(Symbols)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, ";") || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.FieldsFunc(text, func(r rune) bool {
+			return unicode.IsSpace(r) || r == '=' || r == ':'
+		})
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected an address and a name", line)
+		}
+		addr, name := fields[0], fields[1]
+		if !isNumber(addr) {
+			addr, name = name, addr
+		}
+		val, err := strconv.ParseUint(addr, 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid address %s", line, addr)
+		}
+		s[name] = core.Word(val)
+	}
+	return s, scanner.Err()
+}
+
+// isNumber returns whether the field of a symbol file starts like a number,
+// rather than a name
+func isNumber(field string) bool {
+	return field[0] >= '0' && field[0] <= '9'
+}
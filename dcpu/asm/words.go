@@ -0,0 +1,47 @@
+package asm
+
+import (
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"strconv"
+	"strings"
+)
+
+// ParseWords parses a program written as hex words, as people paste them
+// from forums and online editors, e.g.
+//
+//	0x7c01, 0x0030 ; SET A, 0x30
+//	dat 7de1 1000 0020
+//
+// Words are separated by whitespace or commas, and are hex with or without
+// a 0x prefix. Comments start with ';', '#' or "//". A "dat" keyword and
+// address prefixes like "0000:" are skipped, so memory dumps can be pasted
+// too.
+func ParseWords(src []byte) ([]core.Word, error) {
+	var words []core.Word
+	for n, line := range strings.Split(string(src), "\n") {
+		for _, comment := range []string{";", "#", "//"} {
+			if i := strings.Index(line, comment); i >= 0 {
+				line = line[:i]
+			}
+		}
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\r'
+		})
+		for _, field := range fields {
+			if strings.EqualFold(field, "dat") || strings.HasSuffix(field, ":") {
+				continue
+			}
+			digits := field
+			if strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X") {
+				digits = digits[2:]
+			}
+			w, err := strconv.ParseUint(digits, 16, 16)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid word %#v", n+1, field)
+			}
+			words = append(words, core.Word(w))
+		}
+	}
+	return words, nil
+}
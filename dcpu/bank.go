@@ -0,0 +1,230 @@
+package dcpu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu/core"
+)
+
+// Bank is a bank-switching memory controller. It has a store of pages of
+// 0x1000 words, larger than the address space, any of which can be mapped
+// into the 16 slots of memory starting at each multiple of 0x1000. The
+// operation is selected by A when the device is sent HWI, and A is set to
+// the error code afterwards:
+//
+//	A=0 B is set to the number of pages in the store
+//	A=1 page C is mapped into slot B, replacing any page mapped there. If
+//	    C is 0xffff, the slot is unmapped and RAM shows through again.
+//	A=2 B is set to the page mapped into slot B, or 0xffff
+//
+// A slot can't be mapped over other memory-mapped devices.
+type Bank struct {
+	store []core.Word
+	slots [bankSlots]core.Word // the page mapped into each slot, or bankNone
+	state *core.State
+}
+
+const (
+	BankID           = 0x020d0001
+	BankVersion      = 1
+	BankManufacturer = 0x6b62616c
+)
+
+const (
+	bankPageWords = 0x1000
+	bankSlots     = 0x10000 / bankPageWords
+	bankNone      = 0xffff
+)
+
+const (
+	bankQueryPages = iota
+	bankMap
+	bankQuerySlot
+)
+
+// Bank errors
+const (
+	BankErrorNone = iota
+	BankErrorBadSlot
+	BankErrorBadPage
+	BankErrorConflict
+)
+
+// NewBank returns a Bank with a store of the given number of pages
+func NewBank(pages int) (*Bank, error) {
+	if pages <= 0 || pages >= bankNone {
+		return nil, errors.New("Bank must have between 1 and 0xfffe pages")
+	}
+	b := &Bank{store: make([]core.Word, pages*bankPageWords)}
+	for i := range b.slots {
+		b.slots[i] = bankNone
+	}
+	return b, nil
+}
+
+func (b *Bank) ID() uint32           { return BankID }
+func (b *Bank) Version() core.Word   { return BankVersion }
+func (b *Bank) Manufacturer() uint32 { return BankManufacturer }
+
+// Attach remembers the state, so the slots can be unmapped on reset
+func (b *Bank) Attach(s *core.State) error {
+	b.state = s
+	return nil
+}
+
+// Detach unmaps all the slots
+func (b *Bank) Detach(s *core.State) {
+	b.unmapAll()
+	b.state = nil
+}
+
+func (b *Bank) HandleInterrupt(s *core.State) error {
+	var code core.Word = BankErrorNone
+	switch s.A() {
+	case bankQueryPages:
+		s.SetB(core.Word(len(b.store) / bankPageWords))
+	case bankMap:
+		code = b.mapPage(&s.Ram, s.B(), s.C())
+	case bankQuerySlot:
+		if s.B() >= bankSlots {
+			code = BankErrorBadSlot
+			break
+		}
+		s.SetB(b.slots[s.B()])
+	}
+	s.SetA(code)
+	return nil
+}
+
+// mapPage maps page into slot, or unmaps the slot if page is bankNone. If
+// the page can't be mapped, the slot is left as it was.
+func (b *Bank) mapPage(ram *core.Memory, slot, page core.Word) core.Word {
+	if slot >= bankSlots {
+		return BankErrorBadSlot
+	}
+	if page != bankNone && int(page) >= len(b.store)/bankPageWords {
+		return BankErrorBadPage
+	}
+	previous := b.slots[slot]
+	if previous != bankNone {
+		ram.UnmapRegion(slot*bankPageWords, bankPageWords)
+		b.slots[slot] = bankNone
+	}
+	if page == bankNone {
+		return BankErrorNone
+	}
+	if err := b.mapSlot(ram, slot, page); err != nil {
+		if previous != bankNone {
+			// the previous page's region was just unmapped, so it's free
+			b.mapSlot(ram, slot, previous)
+		}
+		return BankErrorConflict
+	}
+	return BankErrorNone
+}
+
+// mapSlot maps the region of slot to page
+func (b *Bank) mapSlot(ram *core.Memory, slot, page core.Word) error {
+	words := b.store[int(page)*bankPageWords:][:bankPageWords]
+	get := func(offset core.Word) core.Word {
+		return words[offset]
+	}
+	set := func(offset, val core.Word) error {
+		words[offset] = val
+		return nil
+	}
+	if err := ram.MapRegionOwned(fmt.Sprintf("bank page %#x", page), slot*bankPageWords, bankPageWords, get, set, nil); err != nil {
+		return err
+	}
+	b.slots[slot] = page
+	return nil
+}
+
+func (b *Bank) unmapAll() {
+	for slot := range b.slots {
+		b.mapPage(&b.state.Ram, core.Word(slot), bankNone)
+	}
+}
+
+// Reset unmaps all the slots and clears the store
+func (b *Bank) Reset() {
+	if b.state != nil {
+		b.unmapAll()
+	}
+	for i := range b.store {
+		b.store[i] = 0
+	}
+}
+
+// bankSnapshot is the fixed-size portion of the snapshot of a Bank, which
+// is followed by the store
+type bankSnapshot struct {
+	Pages uint16
+	Slots [bankSlots]core.Word
+}
+
+func (b *Bank) Snapshot() ([]byte, error) {
+	var buf bytes.Buffer
+	snap := bankSnapshot{uint16(len(b.store) / bankPageWords), b.slots}
+	if err := binary.Write(&buf, binary.LittleEndian, &snap); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&buf, binary.LittleEndian, b.store); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Restore restores the store and re-maps the slots. The store must have as
+// many pages as when the snapshot was taken.
+func (b *Bank) Restore(data []byte) error {
+	if b.state == nil {
+		return errors.New("Bank isn't attached")
+	}
+	r := bytes.NewReader(data)
+	var snap bankSnapshot
+	if err := binary.Read(r, binary.LittleEndian, &snap); err != nil {
+		return core.ErrBadSnapshot
+	}
+	if int(snap.Pages) != len(b.store)/bankPageWords {
+		return fmt.Errorf("snapshot has %d pages, but the store has %d", snap.Pages, len(b.store)/bankPageWords)
+	}
+	for _, page := range snap.Slots {
+		if page != bankNone && int(page) >= int(snap.Pages) {
+			return core.ErrBadSnapshot
+		}
+	}
+	store := make([]core.Word, len(b.store))
+	if err := binary.Read(r, binary.LittleEndian, store); err != nil || r.Len() != 0 {
+		return core.ErrBadSnapshot
+	}
+	if err := b.setSlots(snap.Slots); err != nil {
+		return err
+	}
+	copy(b.store, store)
+	return nil
+}
+
+// setSlots maps the given pages into the slots, replacing those mapped. If
+// one can't be mapped, the slots are left as they were.
+func (b *Bank) setSlots(slots [bankSlots]core.Word) error {
+	previous := b.slots
+	b.unmapAll()
+	for slot, page := range slots {
+		if page == bankNone {
+			continue
+		}
+		if err := b.mapSlot(&b.state.Ram, core.Word(slot), page); err != nil {
+			b.unmapAll()
+			for slot, page := range previous {
+				if page != bankNone {
+					b.mapSlot(&b.state.Ram, core.Word(slot), page)
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package dcpu
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"testing"
+)
+
+func TestBank(t *testing.T) {
+	state := new(core.State)
+	bank, err := NewBank(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := state.AttachDevice(bank); err != nil {
+		t.Fatal(err)
+	}
+	hwi := func(a, b, c core.Word) {
+		state.SetA(a)
+		state.SetB(b)
+		state.SetC(c)
+		if err := bank.HandleInterrupt(state); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hwi(bankQueryPages, 0, 0)
+	if state.B() != 4 {
+		t.Errorf("Expected 4 pages, found %d", state.B())
+	}
+	hwi(bankMap, 0xf, 4)
+	if state.A() != BankErrorBadPage {
+		t.Errorf("Expected a bad page error, found %d", state.A())
+	}
+
+	// the top slot runs to the end of memory
+	state.Ram.Store(0xffff, 0x1111)
+	hwi(bankMap, 0xf, 2)
+	if state.A() != BankErrorNone || state.Ram.Load(0xffff) != 0 {
+		t.Fatalf("Expected page 2 to be mapped, found error %d", state.A())
+	}
+	state.Ram.Store(0xffff, 0x2222)
+	hwi(bankMap, 0x1, 2)
+	if state.Ram.Load(0x1fff) != 0x2222 {
+		t.Error("Expected the same page to be visible in both slots")
+	}
+	hwi(bankQuerySlot, 0x1, 0)
+	if state.B() != 2 {
+		t.Errorf("Expected page 2 in slot 1, found %#04x", state.B())
+	}
+
+	hwi(bankMap, 0xf, bankNone)
+	if state.Ram.Load(0xffff) != 0x1111 {
+		t.Error("Expected RAM to show through an unmapped slot")
+	}
+
+	// a slot can't be mapped over another device, and is left as it was
+	if err := state.Ram.MapRegion(0x3800, 0x10, func(core.Word) core.Word { return 0x3333 }, nil); err != nil {
+		t.Fatal(err)
+	}
+	hwi(bankMap, 0x3, 1)
+	if state.A() != BankErrorConflict || state.Ram.Load(0x3800) != 0x3333 {
+		t.Errorf("Expected a conflict error, found %d", state.A())
+	}
+	hwi(bankQuerySlot, 0x3, 0)
+	if state.B() != bankNone {
+		t.Errorf("Expected slot 3 to be empty, found %#04x", state.B())
+	}
+	state.Ram.UnmapRegion(0x3800, 0x10)
+
+	state.Reset()
+	if state.Ram.Load(0x1fff) != 0 {
+		t.Error("Expected reset to unmap the slots")
+	}
+	hwi(bankQuerySlot, 0x1, 0)
+	if state.B() != bankNone {
+		t.Errorf("Expected slot 1 to be empty, found %#04x", state.B())
+	}
+}
+
+func TestBankSnapshot(t *testing.T) {
+	state := new(core.State)
+	bank, err := NewBank(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := state.AttachDevice(bank); err != nil {
+		t.Fatal(err)
+	}
+	bank.mapPage(&state.Ram, 0x2, 1)
+	state.Ram.Store(0x2000, 0x1234)
+	data, err := bank.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bank.mapPage(&state.Ram, 0x2, bankNone)
+	bank.mapPage(&state.Ram, 0x5, 1)
+	state.Ram.Store(0x5000, 0x5678)
+	if err := bank.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	if state.Ram.Load(0x2000) != 0x1234 || state.Ram.Load(0x5000) != 0 {
+		t.Errorf("Expected page 1 to be mapped into slot 2 only, found %#x, %#x", state.Ram.Load(0x2000), state.Ram.Load(0x5000))
+	}
+	if bank.slots[0x5] != bankNone {
+		t.Errorf("Expected slot 5 to be empty, found %#04x", bank.slots[0x5])
+	}
+
+	// a slot that conflicts with another device leaves the bank as it was
+	bank.mapPage(&state.Ram, 0x2, bankNone)
+	if err := state.Ram.MapRegion(0x2800, 0x10, func(core.Word) core.Word { return 0 }, nil); err != nil {
+		t.Fatal(err)
+	}
+	bank.mapPage(&state.Ram, 0x5, 1)
+	if err := bank.Restore(data); err == nil {
+		t.Error("Expected an error restoring over another device")
+	}
+	if bank.slots[0x2] != bankNone || bank.slots[0x5] != 1 || state.Ram.Load(0x5000) != 0x1234 {
+		t.Error("Failed restore changed the bank")
+	}
+
+	for name, data := range map[string][]byte{
+		"truncated":  data[:len(data)-1],
+		"bad page":   append([]byte{4, 0, 4, 0}, data[4:]...),
+		"more pages": append([]byte{5}, data[1:]...),
+	} {
+		if err := bank.Restore(data); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
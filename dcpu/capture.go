@@ -0,0 +1,95 @@
+package dcpu
+
+import (
+	"bytes"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"io"
+	"sync"
+	"time"
+)
+
+// Capture is a VideoBackend that keeps the screen in memory, for tests and
+// servers running programs that write to the screen without a terminal. The
+// screen as of the last Flush can be read from any goroutine. The zero
+// value is ready to use.
+type Capture struct {
+	// If DumpTo is non-nil, the text of the screen is written to it,
+	// followed by a blank line, when it changes and on Close. Changes less
+	// than DumpInterval after the last screen written are only written with
+	// a later change, or on Close.
+	DumpTo       io.Writer
+	DumpInterval time.Duration
+	drawn        Screenshot // the cells drawn since the last Flush
+	mu           sync.Mutex
+	shown        Screenshot
+	dumped       []byte
+	dumpedAt     time.Time
+}
+
+func (c *Capture) Init() error {
+	return nil
+}
+
+func (c *Capture) SetCell(x, y int, ch rune, fg, bg byte, blink bool) {
+	if x <= 0 || y <= 0 || x > windowWidth || y > windowHeight {
+		c.drawn.Border = core.Word(bg)
+		return
+	}
+	c.drawn.Cells[(y-1)*windowWidth+x-1] = cellWord(ch, fg, bg, blink)
+}
+
+func (c *Capture) SetPalette(palette [16]core.Word) {
+	c.drawn.Palette = palette
+}
+
+func (c *Capture) Flush() {
+	c.mu.Lock()
+	c.shown = c.drawn
+	c.mu.Unlock()
+	if c.DumpTo != nil && time.Since(c.dumpedAt) >= c.DumpInterval {
+		c.dump()
+	}
+}
+
+func (c *Capture) Close() {
+	if c.DumpTo != nil {
+		c.dump()
+	}
+}
+
+// dump writes the text of the screen to DumpTo, if it's changed
+func (c *Capture) dump() {
+	text := []byte(c.Text())
+	if bytes.Equal(text, c.dumped) {
+		return
+	}
+	c.dumped, c.dumpedAt = text, time.Now()
+	c.DumpTo.Write(append(text, '\n'))
+}
+
+// Cell returns the word of video memory shown at column x and row y of the
+// display, not counting the border. While the machine's running, blinking
+// characters are in their background color every other blinkPeriod.
+func (c *Capture) Cell(x, y int) core.Word {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.shown.Cells[y*windowWidth+x]
+}
+
+// Border returns the palette entry of the border
+func (c *Capture) Border() byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return byte(c.shown.Border)
+}
+
+// Text returns the characters of the display, as Screenshot.WriteText
+// writes them without colors
+func (c *Capture) Text() string {
+	c.mu.Lock()
+	shot := c.shown
+	c.mu.Unlock()
+	var buf bytes.Buffer
+	shot.WriteText(&buf, false)
+	return buf.String()
+}
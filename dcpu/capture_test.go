@@ -0,0 +1,60 @@
+package dcpu
+
+import (
+	"bytes"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"strings"
+	"testing"
+)
+
+func TestCapture(t *testing.T) {
+	var dump bytes.Buffer
+	capture := &Capture{DumpTo: &dump}
+	m, err := NewMachine(WithVideoBackend(capture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Video.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Video.MapToMachine(0x8000, m); err != nil {
+		t.Fatal(err)
+	}
+	m.State.Ram.Store(0x1000, 0xf148)
+	m.State.Ram.Store(0x1001, 0xf1e9)
+	hwi := func(a, b core.Word) {
+		m.State.SetA(a)
+		m.State.SetB(b)
+		m.Video.HandleInterrupt(&m.State)
+	}
+	hwi(lemMapScreen, 0x1000)
+	hwi(lemSetBorderColor, 6)
+	if err := m.RefreshScreen(); err != nil {
+		t.Fatal(err)
+	}
+	if cell := capture.Cell(1, 0); cell != 0xf1e9 {
+		t.Errorf("Expected the blinking 'i' at (1, 0), found %#04x", cell)
+	}
+	if border := capture.Border(); border != 6 {
+		t.Errorf("Expected border color 6, found %d", border)
+	}
+	text := capture.Text()
+	if lines := strings.Split(text, "\n"); len(lines) != windowHeight+1 || lines[0] != "Hi"+strings.Repeat(" ", windowWidth-2) {
+		t.Errorf("Unexpected text %q", text)
+	}
+	if dump.String() != text+"\n" {
+		t.Errorf("Expected the screen to be dumped, found %q", dump.String())
+	}
+
+	// the screen is only dumped again once it changes
+	m.RefreshScreen()
+	capture.Close()
+	if dump.String() != text+"\n" {
+		t.Errorf("Expected an unchanged screen not to be dumped again, found %q", dump.String())
+	}
+	m.State.Ram.Store(0x1000, 0xf168)
+	m.RefreshScreen()
+	if expected := text + "\n" + "hi" + text[2:] + "\n"; dump.String() != expected {
+		t.Errorf("Expected the changed screen to be dumped, found %q", dump.String())
+	}
+}
@@ -0,0 +1,68 @@
+package dcpu
+
+import (
+	"runtime"
+	"time"
+)
+
+const (
+	// maxBatch is the most cycles the machine runs before yielding to check
+	// for requests and refresh the screen
+	maxBatch = 10000
+	// clockTick is how long the clock sleeps between batches of cycles
+	clockTick = time.Millisecond
+	// spinTime is how early the clock wakes before a batch is due, to be
+	// spent spinning. It hides the granularity of the OS timer.
+	spinTime = 200 * time.Microsecond
+)
+
+// clock tracks which cycles are due at a given clock rate. Cycle n is due
+// n / rate seconds after the clock starts, so the effective rate doesn't
+// drift however late the machine wakes.
+type clock struct {
+	rate   ClockRate
+	start  time.Time
+	cycles uint64 // the cycles run since start
+}
+
+// reset starts the clock afresh at the given rate
+func (c *clock) reset(rate ClockRate, now time.Time) {
+	c.rate = rate
+	c.start = now
+	c.cycles = 0
+}
+
+// due returns the number of cycles to run now, at most maxBatch
+func (c *clock) due(now time.Time) int {
+	if c.rate == Unthrottled {
+		return maxBatch
+	}
+	target := uint64(now.Sub(c.start).Seconds()*float64(c.rate)) + 1
+	if target <= c.cycles {
+		return 0
+	}
+	if target-c.cycles > maxBatch {
+		return maxBatch
+	}
+	return int(target - c.cycles)
+}
+
+// next returns when the next batch of cycles, a tick's worth, is due
+func (c *clock) next() time.Time {
+	if c.rate == Unthrottled {
+		return c.start
+	}
+	batch := uint64(float64(c.rate) * clockTick.Seconds())
+	if batch == 0 {
+		batch = 1
+	}
+	offset := float64(c.cycles+batch-1) / float64(c.rate)
+	return c.start.Add(time.Duration(offset * float64(time.Second)))
+}
+
+// spinUntil busy-waits until t, giving other goroutines a chance to run
+func spinUntil(t time.Time) {
+	for time.Now().Before(t) {
+		runtime.Gosched()
+	}
+}
@@ -0,0 +1,46 @@
+package dcpu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClock(t *testing.T) {
+	start := time.Now()
+	var c clock
+	c.reset(1000000, start) // 1MHz
+	if due := c.due(start); due != 1 {
+		t.Errorf("Expected 1 cycle due at start, found %d", due)
+	}
+	if due := c.due(start.Add(time.Millisecond)); due != 1001 {
+		t.Errorf("Expected 1001 cycles due after 1ms, found %d", due)
+	}
+	c.cycles = 1001
+	if next := c.next().Sub(start); next != 2*time.Millisecond {
+		t.Errorf("Expected the next batch after 2ms, found %v", next)
+	}
+	if due := c.due(start.Add(time.Second)); due != maxBatch {
+		t.Errorf("Expected a full batch when behind, found %d", due)
+	}
+
+	c.reset(Unthrottled, start)
+	if due := c.due(start); due != maxBatch {
+		t.Errorf("Expected a full batch when unthrottled, found %d", due)
+	}
+}
+
+func TestClockDrift(t *testing.T) {
+	// wake up late for every batch; the rate should still be exact
+	start := time.Now()
+	var c clock
+	c.reset(2000000, start) // 2MHz
+	now := start
+	for now.Sub(start) < time.Second {
+		c.cycles += uint64(c.due(now))
+		now = c.next().Add(37 * time.Microsecond)
+	}
+	rate := float64(c.cycles) / now.Sub(start).Seconds()
+	if rate < 2000000*0.99 || rate > 2000000*1.01 {
+		t.Errorf("Expected a rate within 1%% of 2MHz, found %.0fHz", rate)
+	}
+}
@@ -0,0 +1,63 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Breakpoint stops execution before the instruction at Address is executed.
+// If Condition is non-nil, the breakpoint only triggers when it returns true.
+type Breakpoint struct {
+	Address   Word
+	Condition func(s *State) bool
+}
+
+// BreakpointError is returned by StepCycle when a breakpoint triggers.
+// Like WatchpointError, it doesn't halt the State; calling StepCycle again
+// executes the instruction at PC without re-checking the breakpoint.
+type BreakpointError struct {
+	PC Word
+}
+
+func (err *BreakpointError) Error() string {
+	return fmt.Sprintf("breakpoint at %#04x", err.PC)
+}
+
+// AddBreakpoint adds a breakpoint at address. Any existing breakpoint at the
+// same address is replaced.
+func (s *State) AddBreakpoint(address Word, condition func(s *State) bool) {
+	for i := range s.breakpoints {
+		if s.breakpoints[i].Address == address {
+			s.breakpoints[i].Condition = condition
+			return
+		}
+	}
+	s.breakpoints = append(s.breakpoints, Breakpoint{address, condition})
+}
+
+// RemoveBreakpoint removes the breakpoint at address
+func (s *State) RemoveBreakpoint(address Word) error {
+	for i, bp := range s.breakpoints {
+		if bp.Address == address {
+			s.breakpoints = append(s.breakpoints[:i], s.breakpoints[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("RemoveBreakpoint: no breakpoint at the given address")
+}
+
+// Breakpoints returns the current breakpoints
+func (s *State) Breakpoints() []Breakpoint {
+	return append([]Breakpoint(nil), s.breakpoints...)
+}
+
+// checkBreakpoints returns whether a breakpoint triggers at PC
+func (s *State) checkBreakpoints() bool {
+	pc := s.PC()
+	for _, bp := range s.breakpoints {
+		if bp.Address == pc && (bp.Condition == nil || bp.Condition(s)) {
+			return true
+		}
+	}
+	return false
+}
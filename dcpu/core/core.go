@@ -2,27 +2,109 @@ package core
 
 import (
 	"fmt"
+	"strings"
 )
 
 type Word uint16
 
+// Signed interprets the word as a two's complement signed value
+func (w Word) Signed() int16 {
+	return int16(w)
+}
+
+// SignedWord returns the two's complement representation of v
+func SignedWord(v int16) Word {
+	return Word(v)
+}
+
 type OpcodeError struct {
-	Opcode byte
+	Opcode  byte
+	Special bool // Opcode is a special (non-basic) opcode
 }
 
 func (err *OpcodeError) Error() string {
+	if err.Special {
+		return fmt.Sprintf("invalid special opcode %#04x", err.Opcode)
+	}
 	return fmt.Sprintf("invalid opcode %#04x", err.Opcode)
 }
 
+// OpcodePolicy determines how the State handles invalid or reserved opcodes
+type OpcodePolicy int
+
+const (
+	OpcodePolicyHalt      OpcodePolicy = iota // halt with an OpcodeError
+	OpcodePolicyNOP                           // skip the instruction
+	OpcodePolicyInterrupt                     // skip the instruction and trigger an interrupt
+)
+
+func (p OpcodePolicy) String() string {
+	switch p {
+	case OpcodePolicyHalt:
+		return "halt"
+	case OpcodePolicyNOP:
+		return "nop"
+	case OpcodePolicyInterrupt:
+		return "interrupt"
+	}
+	return fmt.Sprintf("OpcodePolicy(%d)", int(p))
+}
+
+func (p *OpcodePolicy) Set(str string) error {
+	switch strings.ToLower(str) {
+	case "halt":
+		*p = OpcodePolicyHalt
+	case "nop":
+		*p = OpcodePolicyNOP
+	case "interrupt":
+		*p = OpcodePolicyInterrupt
+	default:
+		return fmt.Errorf("unknown opcode policy %#v", str)
+	}
+	return nil
+}
+
+// DivideByZeroError is returned by DIV, DVI, MOD and MDI when dividing by zero
+// if StrictDivide is set.
+type DivideByZeroError struct {
+	Opcode byte
+}
+
+func (err *DivideByZeroError) Error() string {
+	return fmt.Sprintf("division by zero (opcode %#04x)", err.Opcode)
+}
+
 type State struct {
 	Registers
-	Ram       Memory
-	lastError error   // once set, will be returned always
-	step      int     // fetch, decode, execute
-	cycleCost uint    // remaining cost of the opcode to execute
-	op, a, b  uint32  // operands and opcode (uint32 datatype used for math)
-	delayed   bool    // indicates whether we've already delayed the operand fetch
-	address   Address // location to store the result
+	Ram                  Memory
+	Devices              []Device                   // attached hardware, indexed by hardware number
+	Spec                 SpecVersion                // the specification to decode instructions with
+	StrictDivide         bool                       // halt with a DivideByZeroError instead of producing 0
+	InvalidOpcode        OpcodePolicy               // how to handle invalid opcodes
+	InvalidOpcodeMessage Word                       // interrupt message for OpcodePolicyInterrupt
+	StepHook             StepHook                   // called after each instruction, if non-nil
+	AccessHook           AccessHook                 // called on each memory access by the CPU, if non-nil
+	SelfModify           SelfModifyPolicy           // how to handle writes to executed addresses
+	SelfModifyLog        func(err *SelfModifyError) // receives writes to executed addresses for SelfModifyLog
+	lastError            error                      // once set, will be returned always
+	step                 int                        // fetch, decode, execute
+	cycleCost            uint                       // remaining cost of the opcode to execute
+	op, a, b             uint32                     // operands and opcode (uint32 datatype used for math)
+	delayed              bool                       // indicates whether we've already delayed the operand fetch
+	address              Address                    // location to store the result
+	queueing             bool                       // interrupt queueing is enabled
+	interrupts           []Word                     // queued interrupt messages
+	pc                   Word                       // address of the current instruction
+	watchpoints          []Watchpoint
+	watchHit             *WatchpointError // reported once the instruction finishes
+	breakpoints          []Breakpoint
+	breakResume          bool // resuming from a breakpoint at PC
+	cycles               uint64
+	hookInst             Instruction        // the current instruction, decoded for StepHook
+	hookRegs             Registers          // the registers when the instruction was fetched
+	history              *history           // undo records for StepBack, if enabled
+	executed             *[0x10000 / 8]byte // bitmap of executed addresses, for SelfModify
+	selfModifyHit        *SelfModifyError   // reported once the instruction finishes
 }
 
 const (
@@ -30,6 +112,7 @@ const (
 	stateStepDecodeA        // process the A operand
 	stateStepDecodeB        // process the B operand
 	stateStepExecute        // execute the instruction
+	stateStepSkip           // skip the next instruction after a failed conditional
 )
 
 type Address struct {
@@ -47,20 +130,68 @@ const (
 // If the machine halts, the relevant error is returned.
 // If the machine was already halted, the same error will be
 // returned from all future calls.
+// Attached TickDevices are ticked after the cycle.
 func (s *State) StepCycle() error {
+	cycles := s.cycles
+	err := s.stepCycle()
+	if s.cycles != cycles && s.lastError == nil {
+		if tickErr := s.tickDevices(); tickErr != nil {
+			return tickErr
+		}
+	}
+	return err
+}
+
+func (s *State) stepCycle() error {
 	if s.lastError != nil {
 		return s.lastError
 	}
+	s.cycles++
 
-step:
 	switch s.step {
 	case stateStepFetch:
+		if s.breakResume {
+			// the interrupt was handled and the breakpoint checked when we
+			// stopped at this instruction
+			s.breakResume = false
+		} else {
+			if s.history != nil {
+				s.history.beginRecord(s)
+			}
+			// Handle at most one interrupt between instructions
+			s.handleInterrupt()
+			if s.lastError != nil {
+				return s.lastError
+			}
+			if s.breakpoints != nil && s.checkBreakpoints() {
+				// no cycle is spent until the instruction is resumed
+				s.cycles--
+				s.breakResume = true
+				return &BreakpointError{s.PC()}
+			}
+		}
 		// Fetch the next opcode
+		s.pc = s.PC()
+		if s.StepHook != nil {
+			s.decodeForHook()
+		}
 		opcode := s.nextWord()
-		s.op, s.a, s.b = decodeOpcode(opcode)
-		if cost, err := cycleCost(s.op); err != nil {
-			s.lastError = err
-			return err
+		if s.SelfModify != SelfModifyIgnore {
+			s.markExecuted(s.pc, instructionLength(opcode, s.Spec))
+		}
+		s.op, s.a, s.b = decodeOpcode(opcode, s.Spec)
+		if cost, err := cycleCost(s.op, s.Spec); err != nil {
+			if s.InvalidOpcode == OpcodePolicyHalt {
+				s.lastError = err
+				return err
+			}
+			// skip the rest of the instruction without evaluating the operands
+			s.SetPC(s.PC() + instructionLength(opcode, s.Spec) - 1)
+			if s.InvalidOpcode == OpcodePolicyInterrupt {
+				s.TriggerInterrupt(s.InvalidOpcodeMessage)
+			}
+			// it's retired like any other instruction
+			return s.finishInstruction()
 		} else {
 			s.cycleCost = cost
 		}
@@ -69,28 +200,33 @@ step:
 		s.step = stateStepDecodeA
 		fallthrough
 	case stateStepDecodeA:
-		// decode operand A
-		val, loc, delay := s.fetchOperand(s.a, s.delayed)
-		s.delayed = delay
-		if delay {
+		// decode the first operand
+		// In 1.7, a (the source) is always handled before b. In 1.1, the
+		// destination comes first.
+		if s.Spec == Spec11 {
+			if !s.decodeDestination() {
+				break
+			}
+		} else if !s.decodeSource() {
 			break
 		}
-		s.a = uint32(val)
-		s.address = loc
-		if s.op >= opcodeExtendedOffset {
-			s.step = stateStepExecute
-		} else {
-			s.step = stateStepDecodeB
+		if s.lastError != nil {
+			return s.lastError
 		}
+		s.step = stateStepDecodeB
 		fallthrough
 	case stateStepDecodeB:
-		// decode operand B
-		val, _, delay := s.fetchOperand(s.b, s.delayed)
-		s.delayed = delay
-		if delay {
+		// decode the second operand
+		if s.Spec == Spec11 {
+			if !s.decodeSource() {
+				break
+			}
+		} else if !s.decodeDestination() {
 			break
 		}
-		s.b = uint32(val)
+		if s.lastError != nil {
+			return s.lastError
+		}
 		s.step = stateStepExecute
 		fallthrough
 	case stateStepExecute:
@@ -100,75 +236,107 @@ step:
 			break
 		}
 		// we now have valid opcodes, and we've spun enough cycles for the instruction
+		if s.StrictDivide && s.a == 0 {
+			switch s.op {
+			case opcodeDIV, opcodeDVI, opcodeMOD, opcodeMDI:
+				s.lastError = &DivideByZeroError{byte(s.op)}
+				return s.lastError
+			}
+		}
 		var val Word
 		switch s.op {
-		case opcodeSET:
-			val = Word(s.b)
+		case opcodeSET, opcodeSTI, opcodeSTD:
+			val = Word(s.a)
 		case opcodeADD:
-			result := s.a + s.b
+			result := s.b + s.a
 			val = Word(result)
-			s.SetO(Word(result >> 16))
+			s.SetEX(Word(result >> 16))
 		case opcodeSUB:
-			result := s.a - s.b
+			result := s.b - s.a
 			val = Word(result)
-			s.SetO(Word(result >> 16))
+			s.SetEX(Word(result >> 16))
 		case opcodeMUL:
-			result := s.a * s.b
+			result := s.b * s.a
 			val = Word(result)
-			s.SetO(Word(result >> 16))
+			s.SetEX(Word(result >> 16))
+		case opcodeMLI:
+			result := int32(Word(s.b).Signed()) * int32(Word(s.a).Signed())
+			val = Word(result)
+			s.SetEX(Word(result >> 16))
 		case opcodeDIV:
-			if s.b == 0 {
+			if s.a == 0 {
 				val = 0
-				s.SetO(0)
+				s.SetEX(0)
 			} else {
-				result := s.a / s.b
+				result := s.b / s.a
 				val = Word(result)
-				// O is a bit weird here
-				s.SetO(Word((s.a << 16) / s.b))
+				s.SetEX(Word((s.b << 16) / s.a))
+			}
+		case opcodeDVI:
+			if s.a == 0 {
+				val = 0
+				s.SetEX(0)
+			} else {
+				// use 64 bits so 0x8000 / -1 doesn't overflow the EX calculation
+				b, a := int64(Word(s.b).Signed()), int64(Word(s.a).Signed())
+				val = Word(b / a)
+				s.SetEX(Word((b << 16) / a))
 			}
 		case opcodeMOD:
-			if s.b == 0 {
+			if s.a == 0 {
 				val = 0
 			} else {
-				val = Word(s.a % s.b)
+				val = Word(s.b % s.a)
+			}
+		case opcodeMDI:
+			if s.a == 0 {
+				val = 0
+			} else {
+				// Go's % already takes the sign of the dividend, as the spec requires
+				val = SignedWord(Word(s.b).Signed() % Word(s.a).Signed())
 			}
-		case opcodeSHL:
-			result := s.a << s.b
-			val = Word(result)
-			s.SetO(Word(result >> 16))
-		case opcodeSHR:
-			val = Word(s.a >> s.b)
-			s.SetO(Word((s.a << 16) >> s.b))
 		case opcodeAND:
-			val = Word(s.a & s.b)
+			val = Word(s.b & s.a)
 		case opcodeBOR:
-			val = Word(s.a | s.b)
+			val = Word(s.b | s.a)
 		case opcodeXOR:
-			val = Word(s.a ^ s.b)
-		case opcodeIFE:
-			if !(s.a == s.b) {
-				s.skipInstruction()
-				break step
-			}
-			s.address = Address{}
-		case opcodeIFN:
-			if !(s.a != s.b) {
-				s.skipInstruction()
-				break step
+			val = Word(s.b ^ s.a)
+		case opcodeSHR:
+			val = Word(s.b >> s.a)
+			s.SetEX(Word((s.b << 16) >> s.a))
+		case opcodeASR:
+			val = SignedWord(Word(s.b).Signed() >> s.a)
+			s.SetEX(Word((int32(Word(s.b).Signed()) << 16) >> s.a))
+		case opcodeSHL:
+			result := s.b << s.a
+			val = Word(result)
+			s.SetEX(Word(result >> 16))
+		case opcodeIFB, opcodeIFC, opcodeIFE, opcodeIFN, opcodeIFG, opcodeIFA, opcodeIFL, opcodeIFU:
+			if !testCondition(s.op, s.b, s.a) {
+				// skipping costs an extra cycle
+				s.step = stateStepSkip
+				return s.finishInstruction()
 			}
 			s.address = Address{}
-		case opcodeIFG:
-			if !(s.a > s.b) {
-				s.skipInstruction()
-				break step
+		case opcodeADX:
+			result := s.b + s.a + uint32(s.EX())
+			val = Word(result)
+			if result > 0xffff {
+				s.SetEX(1)
+			} else {
+				s.SetEX(0)
 			}
-			s.address = Address{}
-		case opcodeIFB:
-			if !((s.a & s.b) != 0) {
-				s.skipInstruction()
-				break step
+		case opcodeSBX:
+			// EX is the borrow from a previous SUB/SBX, so treat it as signed
+			result := int32(s.b) - int32(s.a) + int32(s.EX().Signed())
+			val = Word(result)
+			if result < 0 {
+				s.SetEX(0xffff)
+			} else if result > 0xffff {
+				s.SetEX(1)
+			} else {
+				s.SetEX(0)
 			}
-			s.address = Address{}
 		case opcodeExtJSR:
 			val = s.PC()
 			s.DecrSP() // PUSH
@@ -177,6 +345,33 @@ step:
 				index:       s.SP(),
 			}
 			s.SetPC(Word(s.a))
+		case opcodeExtINT:
+			s.TriggerInterrupt(Word(s.a))
+			s.address = Address{}
+		case opcodeExtIAG:
+			val = s.IA()
+		case opcodeExtIAS:
+			s.SetIA(Word(s.a))
+			s.address = Address{}
+		case opcodeExtRFI:
+			s.queueing = false
+			s.SetA(s.pop())
+			s.SetPC(s.pop())
+			s.address = Address{}
+		case opcodeExtIAQ:
+			s.queueing = s.a != 0
+			s.address = Address{}
+		case opcodeExtHWN:
+			val = Word(len(s.Devices))
+		case opcodeExtHWQ:
+			s.queryDevice(Word(s.a))
+			s.address = Address{}
+		case opcodeExtHWI:
+			if err := s.interruptDevice(Word(s.a)); err != nil {
+				s.lastError = err
+				return err
+			}
+			s.address = Address{}
 		default:
 			// cycleCost should have already caught this
 			panic("Unexpected opcode")
@@ -185,44 +380,157 @@ step:
 			s.lastError = err
 			return err
 		}
+		// STI and STD adjust I and J after the store
+		switch s.op {
+		case opcodeSTI:
+			s.SetI(s.I() + 1)
+			s.SetJ(s.J() + 1)
+		case opcodeSTD:
+			s.SetI(s.I() - 1)
+			s.SetJ(s.J() - 1)
+		}
+		if s.lastError != nil {
+			// the instruction set the machine on fire
+			return s.lastError
+		}
 		s.step = stateStepFetch
+		return s.finishInstruction()
+	case stateStepSkip:
+		// skip one instruction per cycle. If the skipped instruction is itself
+		// a conditional, the instruction following it is skipped as well.
+		opcode := s.Ram.Load(s.PC())
+		s.SetPC(s.PC() + instructionLength(opcode, s.Spec))
+		// 1.1 only ever skips a single instruction
+		if op, _, _ := decodeOpcode(opcode, s.Spec); s.Spec == Spec11 || !isConditional(op) {
+			s.step = stateStepFetch
+		}
 	}
 	return nil
 }
 
-func decodeOpcode(value Word) (oooo, aaaaaa, bbbbbb uint32) {
-	oooo = uint32(value) & 0xF
-	aaaaaa = uint32(value>>4) & 0x3F
-	bbbbbb = uint32(value>>10) & 0x3F
-	if oooo == 0 {
-		// extended opcode
-		oooo, aaaaaa, bbbbbb = aaaaaa+opcodeExtendedOffset, bbbbbb, 0
+// decodeSource decodes the source operand a, returning false if the operand
+// fetch was delayed. For special opcodes this is the only operand, and it
+// is also the location to store the result.
+func (s *State) decodeSource() bool {
+	val, loc, delay := s.fetchOperand(s.a, true, s.delayed)
+	s.delayed = delay
+	if delay {
+		return false
+	}
+	s.a = uint32(val)
+	if s.op >= opcodeExtendedOffset {
+		s.address = loc
+	}
+	if s.op != opcodeExtIAG && s.op != opcodeExtHWN {
+		// IAG and HWN only write their operand
+		s.noteRead(loc, val)
+	}
+	return true
+}
+
+// decodeDestination decodes the destination operand b, returning false if
+// the operand fetch was delayed. Special opcodes have no destination operand.
+func (s *State) decodeDestination() bool {
+	if s.op >= opcodeExtendedOffset {
+		return true
+	}
+	val, loc, delay := s.fetchOperand(s.b, false, s.delayed)
+	s.delayed = delay
+	if delay {
+		return false
+	}
+	s.b = uint32(val)
+	s.address = loc
+	if s.op != opcodeSET && s.op != opcodeSTI && s.op != opcodeSTD {
+		// SET, STI and STD only write their destination
+		s.noteRead(loc, val)
+	}
+	return true
+}
+
+// decodeOpcode splits an instruction into its opcode, source operand a and
+// destination operand b, using the encoding for the given spec version.
+// Special opcodes are returned with opcodeExtendedOffset added,
+// with their single operand in a.
+func decodeOpcode(value Word, spec SpecVersion) (op, a, b uint32) {
+	if spec == Spec11 {
+		return decodeOpcode11(value)
+	}
+	return decodeOpcode17(value)
+}
+
+// decodeOpcode17 splits a 1.7 instruction of the form aaaaaabbbbbooooo.
+func decodeOpcode17(value Word) (ooooo, aaaaaa, bbbbb uint32) {
+	ooooo = uint32(value) & 0x1F
+	bbbbb = uint32(value>>5) & 0x1F
+	aaaaaa = uint32(value>>10) & 0x3F
+	if ooooo == 0 {
+		// special opcode
+		ooooo, bbbbb = bbbbb+opcodeExtendedOffset, 0
 	}
 	return
 }
 
+// decodeOpcode11 splits a 1.1 instruction of the form bbbbbbaaaaaaoooo.
+// In 1.1, a is the destination and b the source, so they are swapped to
+// match the 1.7 convention, and the opcode is translated to its 1.7 equivalent.
+func decodeOpcode11(value Word) (op, a, b uint32) {
+	oooo := uint32(value) & 0xF
+	aaaaaa := uint32(value>>4) & 0x3F
+	bbbbbb := uint32(value>>10) & 0x3F
+	if oooo == 0 {
+		// non-basic opcode
+		return aaaaaa + opcodeExtendedOffset, bbbbbb, 0
+	}
+	return spec11Opcodes[oooo], bbbbbb, aaaaaa
+}
+
 // cycleCost also doubles as an opcode validity test
-func cycleCost(opcode uint32) (uint, error) {
+func cycleCost(opcode uint32, spec SpecVersion) (uint, error) {
+	if spec == Spec11 {
+		switch opcode {
+		case opcodeSHR, opcodeSHL, opcodeExtJSR:
+			return 2, nil
+		case opcodeSET, opcodeADD, opcodeSUB, opcodeMUL, opcodeDIV, opcodeMOD,
+			opcodeAND, opcodeBOR, opcodeXOR, opcodeIFE, opcodeIFN, opcodeIFG, opcodeIFB:
+			// same as 1.7
+		default:
+			return 0, &OpcodeError{byte(opcode - opcodeExtendedOffset), true}
+		}
+	}
 	switch opcode {
-	case opcodeSET, opcodeAND, opcodeBOR, opcodeXOR:
+	case opcodeSET, opcodeAND, opcodeBOR, opcodeXOR, opcodeSHR, opcodeASR, opcodeSHL:
 		return 1, nil
-	case opcodeADD, opcodeSUB, opcodeMUL, opcodeSHR, opcodeSHL:
+	case opcodeADD, opcodeSUB, opcodeMUL, opcodeMLI, opcodeSTI, opcodeSTD:
 		return 2, nil
-	case opcodeDIV, opcodeMOD:
+	case opcodeDIV, opcodeDVI, opcodeMOD, opcodeMDI, opcodeADX, opcodeSBX:
 		return 3, nil
-	case opcodeIFE, opcodeIFN, opcodeIFG, opcodeIFB:
+	case opcodeIFB, opcodeIFC, opcodeIFE, opcodeIFN, opcodeIFG, opcodeIFA, opcodeIFL, opcodeIFU:
 		return 2, nil
-	case opcodeExtJSR:
+	case opcodeExtJSR, opcodeExtRFI:
+		return 3, nil
+	case opcodeExtINT:
+		return 4, nil
+	case opcodeExtIAG, opcodeExtIAS:
+		return 1, nil
+	case opcodeExtIAQ, opcodeExtHWN:
 		return 2, nil
+	case opcodeExtHWQ, opcodeExtHWI:
+		return 4, nil
+	}
+	if opcode >= opcodeExtendedOffset {
+		return 0, &OpcodeError{byte(opcode - opcodeExtendedOffset), true}
 	}
-	return 0, &OpcodeError{byte(opcode)}
+	return 0, &OpcodeError{byte(opcode), false}
 }
 
 // fetchOperand fetches the value indicated by the operand.
+// isA indicates whether this is the a (source) operand, which affects the
+// meaning of PUSH / POP in 1.7.
 // If the operand needs to fetch the next word and loadWord is false,
 // it returns true in delay. Otherwise, if loadWord is true, or if it
 // doesn't need to fetch a word, delay will be false and a value will be returned.
-func (s *State) fetchOperand(operand uint32, loadWord bool) (val Word, address Address, delay bool) {
+func (s *State) fetchOperand(operand uint32, isA, loadWord bool) (val Word, address Address, delay bool) {
 	switch operand {
 	case 0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07:
 		// register (A, B, C, X, Y, Z, I or J, in that order)
@@ -247,12 +555,21 @@ func (s *State) fetchOperand(operand uint32, loadWord bool) (val Word, address A
 			delay = true
 		}
 	case 0x18:
-		// POP / [SP++]
-		address = Address{
-			addressType: addressTypeMemory,
-			index:       s.SP(),
+		if isA || s.Spec == Spec11 {
+			// POP / [SP++]
+			address = Address{
+				addressType: addressTypeMemory,
+				index:       s.SP(),
+			}
+			s.IncrSP()
+		} else {
+			// PUSH / [--SP]
+			s.DecrSP()
+			address = Address{
+				addressType: addressTypeMemory,
+				index:       s.SP(),
+			}
 		}
-		s.IncrSP()
 	case 0x19:
 		// PEEK / [SP]
 		address = Address{
@@ -260,14 +577,24 @@ func (s *State) fetchOperand(operand uint32, loadWord bool) (val Word, address A
 			index:       s.SP(),
 		}
 	case 0x1a:
-		// PUSH / [--SP]
-		s.DecrSP()
-		address = Address{
-			addressType: addressTypeMemory,
-			index:       s.SP(),
+		if s.Spec == Spec11 {
+			// PUSH / [--SP]
+			s.DecrSP()
+			address = Address{
+				addressType: addressTypeMemory,
+				index:       s.SP(),
+			}
+		} else if loadWord {
+			// PICK n / [SP + next word]
+			address = Address{
+				addressType: addressTypeMemory,
+				index:       s.SP() + s.nextWord(),
+			}
+		} else {
+			delay = true
 		}
 	case 0x1b, 0x1c, 0x1d:
-		// SP / PC / O
+		// SP / PC / EX
 		// our register indexes go in the same order
 		address = Address{
 			addressType: addressTypeRegister,
@@ -295,7 +622,13 @@ func (s *State) fetchOperand(operand uint32, loadWord bool) (val Word, address A
 			// this shouldn't be possible
 			panic(fmt.Sprintf("Unexpected operand %#02x", operand))
 		}
-		val = Word(operand) - 0x20
+		if s.Spec == Spec11 {
+			// literal value 0x00-0x1f
+			val = Word(operand) - 0x20
+		} else {
+			// literal value 0xffff-0x1e (-1..30)
+			val = Word(operand) - 0x21
+		}
 	}
 	if address.addressType != addressTypeNone {
 		val = s.loadAddress(address)
@@ -322,6 +655,27 @@ func (s *State) loadAddress(address Address) Word {
 	return 0
 }
 
+// noteRead is called when an instruction consumes the value it loaded from
+// address. It notifies mapped regions and checks watchpoints.
+func (s *State) noteRead(address Address, val Word) {
+	if address.addressType != addressTypeMemory {
+		return
+	}
+	if s.Ram.mapped != nil {
+		s.Ram.notifyRead(address.index)
+	}
+	if s.Ram.readProtected != nil && s.lastError == nil && s.Ram.isReadProtected(address.index) {
+		// reported once the operand is decoded
+		s.lastError = &ProtectionError{Address: address.index, Read: true}
+	}
+	if s.watchpoints != nil {
+		s.checkWatchpoints(WatchRead, address.index, val, val)
+	}
+	if s.AccessHook != nil {
+		s.AccessHook(s, WatchRead, address.index, val, val)
+	}
+}
+
 func (s *State) storeAddress(address Address, value Word) error {
 	switch address.addressType {
 	case addressTypeNone:
@@ -329,30 +683,72 @@ func (s *State) storeAddress(address Address, value Word) error {
 	case addressTypeRegister:
 		s.Registers[address.index] = value
 	case addressTypeMemory:
-		return s.Ram.Store(address.index, value)
+		if s.watchpoints == nil && s.history == nil && s.SelfModify == SelfModifyIgnore && s.AccessHook == nil {
+			return s.storeMemory(address.index, value)
+		}
+		old := s.Ram.Load(address.index)
+		if err := s.storeMemory(address.index, value); err != nil {
+			return err
+		}
+		if s.history != nil {
+			s.history.recordWrite(address.index, old)
+		}
+		if s.watchpoints != nil {
+			s.checkWatchpoints(WatchWrite, address.index, old, value)
+		}
+		if s.SelfModify != SelfModifyIgnore {
+			s.checkSelfModify(address.index, old, value)
+		}
+		if s.AccessHook != nil {
+			s.AccessHook(s, WatchWrite, address.index, old, value)
+		}
 	}
 	return nil
 }
 
-// skipInstruction sets up the state to execute SET PC, a
-// where a is the address of the following instruction
-func (s *State) skipInstruction() {
-	opcode := s.Ram.Load(s.PC())
-	count := instructionLength(opcode)
-	s.op = opcodeSET
-	s.b = uint32(s.PC() + count)
-	s.address = Address{
-		addressType: addressTypeRegister,
-		index:       registerPC,
-	}
-	s.cycleCost = 1
+// isConditional returns whether the opcode is one of the IF instructions
+func isConditional(op uint32) bool {
+	return op >= opcodeIFB && op <= opcodeIFU
+}
+
+// testCondition evaluates the conditional opcode op against its operands
+func testCondition(op, b, a uint32) bool {
+	switch op {
+	case opcodeIFB:
+		return (b & a) != 0
+	case opcodeIFC:
+		return (b & a) == 0
+	case opcodeIFE:
+		return b == a
+	case opcodeIFN:
+		return b != a
+	case opcodeIFG:
+		return b > a
+	case opcodeIFA:
+		return Word(b).Signed() > Word(a).Signed()
+	case opcodeIFL:
+		return b < a
+	case opcodeIFU:
+		return Word(b).Signed() < Word(a).Signed()
+	}
+	panic("Unexpected conditional opcode")
+}
+
+// operandHasNextWord returns whether the operand consumes the word following
+// the instruction: [register + next word], PICK n, [next word] and next word.
+func operandHasNextWord(operand uint32, spec SpecVersion) bool {
+	if spec == Spec11 && operand == 0x1a {
+		// PUSH
+		return false
+	}
+	return (operand >= 0x10 && operand <= 0x17) || operand == 0x1a || operand == 0x1e || operand == 0x1f
 }
 
-func instructionLength(opcode Word) Word {
-	op, a, b := decodeOpcode(opcode)
+func instructionLength(opcode Word, spec SpecVersion) Word {
+	op, a, b := decodeOpcode(opcode, spec)
 	length := 1
 	operandCount := func(operand uint32) int {
-		if (operand >= 0x10 && operand <= 0x17) || operand == 0x1e || operand == 0x1f {
+		if operandHasNextWord(operand, spec) {
 			return 1
 		}
 		return 0
@@ -365,14 +761,12 @@ func instructionLength(opcode Word) Word {
 }
 
 // debugging aids
-//
 func (a Address) String() string {
 	switch a.addressType {
 	case addressTypeNone:
 		return "<None>"
 	case addressTypeRegister:
-		reg := []string{"A", "B", "C", "X", "Y", "Z", "I", "J", "PC", "SP", "O"}[a.index]
-		return fmt.Sprintf("<%s>", reg)
+		return fmt.Sprintf("<%s>", RegisterNames[a.index])
 	case addressTypeMemory:
 		return fmt.Sprintf("<[%#02x]>", a.index)
 	}
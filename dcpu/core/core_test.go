@@ -1,6 +1,9 @@
 package core
 
 import (
+	"bytes"
+	"errors"
+	"reflect"
 	"testing"
 )
 
@@ -40,18 +43,18 @@ func TestNotchAssemblerTest(t *testing.T) {
 	if state.PC() != 0x2 {
 		t.Errorf("Unexpected value for PC; expected %#02x, found %#02x", 0x2, state.PC())
 	}
-	// step the program for 1000 cycles, or until it hits the opcode 0x85C3
+	// step the program for 1000 cycles, or until it hits the opcode 0x8B83
 	// hitting 1000 cycles is considered failure
 	for i := 0; i < 1000; i++ {
 		t.Logf("%#02x: %#04x", state.PC(), state.Ram.Load(state.PC()))
 		if err := state.StepCycle(); err != nil {
 			t.Fatal(err)
 		}
-		if state.Ram.Load(state.PC()) == 0x85C3 { // sub PC, 1
+		if state.Ram.Load(state.PC()) == 0x8B83 { // sub PC, 1
 			break
 		}
 	}
-	if state.Ram.Load(state.PC()) != 0x85C3 {
+	if state.Ram.Load(state.PC()) != 0x8B83 {
 		// we exhausted our steps
 		t.Error("Program exceeded 1000 cycles")
 	}
@@ -70,38 +73,38 @@ var notchAssemblerTestProgram = [...]Word{
 	0x7C01, // 0
 	0xBEEF, // 1
 	//              set [0x1000], a
-	0x01E1, // 2
+	0x03C1, // 2
 	0x1000, // 3
 	//              ifn a, [0x1000]
-	0x780D, // 4
+	0x7813, // 4
 	0x1000, // 5
 	//                  set PC, end
-	0x7DC1, // 6
+	0x7F81, // 6
 	32,     // 7
 	//
 	//              set i, 0
-	0x8061, // 8
+	0x84C1, // 8
 	// :nextchar    ife [data+i], 0
-	0x816C, // 9
+	0x86D2, // 9
 	19,     // 10
 	//                  set PC, end
-	0x7DC1, // 11
+	0x7F81, // 11
 	32,     // 12
 	//              set [0x8000+i], [data+i]
-	0x5961, // 13
-	0x8000, // 14
-	19,     // 15
+	0x5AC1, // 13
+	19,     // 14
+	0x8000, // 15
 	//              add i, 1
-	0x8462, // 16
+	0x88C2, // 16
 	//              set PC, nextchar
-	0x7DC1, // 17
+	0x7F81, // 17
 	9,      // 18
 	//
 	// :data        dat "Hello world!", 0
 	'H', 'e', 'l', 'l', 'o', ' ', 'w', 'o', 'r', 'l', 'd', '!', 0, // 19-31
 	//
 	// :end         sub PC, 1
-	0x85C3, // 32
+	0x8B83, // 32
 }
 
 func TestNotchSpecExample(t *testing.T) {
@@ -150,13 +153,13 @@ func TestNotchSpecExample(t *testing.T) {
 		t.Errorf("Unexpected value for register I; expected %#x, found %#x", 0, state.I())
 	}
 	if state.PC() != 19 {
-		t.Errorf("Unexpected value for register PC; expected %#x, found t#x", 19, state.PC())
+		t.Errorf("Unexpected value for register PC; expected %#x, found %#x", 19, state.PC())
 	}
 	if state.SP() != 0 {
 		t.Errorf("Unexpected value for register SP; expected %#x, found %#x", 0, state.SP())
 	}
-	// 4 more cycles (2 more instructions) to put us into the subroutine
-	for i := 0; i < 4; i++ {
+	// 5 more cycles (2 more instructions) to put us into the subroutine
+	for i := 0; i < 5; i++ {
 		if err := state.StepCycle(); err != nil {
 			t.Fatal(err)
 		}
@@ -177,13 +180,13 @@ func TestNotchSpecExample(t *testing.T) {
 	if t.Failed() {
 		t.FailNow()
 	}
-	// run the program for 1000 cycles, or until it hits the instruction 0x7DC1 PC
+	// run the program for 1000 cycles, or until it hits the instruction 0x7F81 PC
 	success := false
 	for i := 0; i < 1000; i++ {
 		if err := state.StepCycle(); err != nil {
 			t.Fatal(err)
 		}
-		if state.Ram.Load(state.PC()) == 0x7DC1 && state.Ram.Load(state.PC()+1) == state.PC() {
+		if state.Ram.Load(state.PC()) == 0x7F81 && state.Ram.Load(state.PC()+1) == state.PC() {
 			success = true
 			break
 		}
@@ -195,15 +198,15 @@ func TestNotchSpecExample(t *testing.T) {
 
 	// Check register X, it should be 0x40
 	if state.X() != 0x40 {
-		t.Error("Unexpected value for register X; expected %#x, found %#x", 0x40, state.X())
+		t.Errorf("Unexpected value for register X; expected %#x, found %#x", 0x40, state.X())
 	}
 }
 
 var notchSpecExampleProgram = [...]Word{
-	0x7c01, 0x0030, 0x7de1, 0x1000, 0x0020, 0x7803, 0x1000, 0xc00d,
-	0x7dc1, 0x001a, 0xa861, 0x7c01, 0x2000, 0x2161, 0x2000, 0x8463,
-	0x806d, 0x7dc1, 0x000d, 0x9031, 0x7c10, 0x0018, 0x7dc1, 0x001a,
-	0x9037, 0x61c1, 0x7dc1, 0x001a, 0x0000, 0x0000, 0x0000, 0x0000,
+	0x7c01, 0x0030, 0x7fc1, 0x0020, 0x1000, 0x7803, 0x1000, 0xc413,
+	0x7f81, 0x001a, 0xacc1, 0x7c01, 0x2000, 0x22c1, 0x2000, 0x88c3,
+	0x84d3, 0x7f81, 0x000d, 0x9461, 0x7c20, 0x0018, 0x7f81, 0x001a,
+	0x946f, 0x6381, 0x7f81, 0x001a, 0x0000, 0x0000, 0x0000, 0x0000,
 }
 
 func TestMemoryMappedIO(t *testing.T) {
@@ -223,12 +226,12 @@ func TestMemoryMappedIO(t *testing.T) {
 	if err := state.Ram.MapRegion(0x8000, 0x400, get, set); err != nil {
 		t.Fatal(err)
 	}
-	// run the program for up to 1000 cycles, or until it hits opcode 0x85C3
+	// run the program for up to 1000 cycles, or until it hits opcode 0x8B83
 	for i := 0; i < 1000; i++ {
 		if err := state.StepCycle(); err != nil {
 			t.Fatal(err)
 		}
-		if state.Ram.Load(state.PC()) == 0x85C3 { // sub PC, 1
+		if state.Ram.Load(state.PC()) == 0x8B83 { // sub PC, 1
 			break
 		}
 	}
@@ -247,3 +250,1548 @@ func TestMemoryMappedIO(t *testing.T) {
 		}
 	}
 }
+
+func TestDecodeBytes(t *testing.T) {
+	data := []byte{0x12, 0x34, 0x56, 0x78, 0x9a}
+	if words := DecodeBytes(data, false); len(words) != 2 || words[0] != 0x1234 || words[1] != 0x5678 {
+		t.Errorf("Unexpected big endian words %#x", words)
+	}
+	if words := DecodeBytes(data, true); len(words) != 2 || words[0] != 0x3412 || words[1] != 0x7856 {
+		t.Errorf("Unexpected little endian words %#x", words)
+	}
+}
+
+func TestParseRegion(t *testing.T) {
+	tests := []struct {
+		str    string
+		region Region
+		ok     bool
+	}{
+		{"0x8000+384", Region{0x8000, 384}, true},
+		{"0+0x10000", Region{}, false}, // the length must fit in a word
+		{"0xff00+0x100", Region{0xff00, 0x100}, true},
+		{"0xff00+0x101", Region{}, false}, // past the end of memory
+		{"0x10000+1", Region{}, false},
+		{"0x100+0", Region{}, false},
+		{"0x100", Region{}, false},
+		{"0x100+", Region{}, false},
+		{"+16", Region{}, false},
+		{"0x100:16", Region{}, false},
+		{"zz+16", Region{}, false},
+	}
+	for _, test := range tests {
+		region, err := ParseRegion(test.str)
+		if (err == nil) != test.ok || region != test.region {
+			t.Errorf("%#v: expected %v (ok %v), found %v (%v)", test.str, test.region, test.ok, region, err)
+		}
+	}
+}
+
+func TestMapRegionConflicts(t *testing.T) {
+	var m Memory
+	get := func(address Word) Word { return 0x1234 }
+	set := func(address, val Word) error { return nil }
+	// regions that merely contain each other's lengths as addresses don't
+	// conflict
+	if err := m.MapRegion(0x1000, 0x1000, get, set); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.MapRegion(0x5000, 0x1000, get, set); err != nil {
+		t.Errorf("Unexpected conflict: %v", err)
+	}
+	if err := m.MapRegionOwned("screen", 0x0800, 0x1000, get, set, nil); err == nil {
+		t.Error("Expected a region overlapping the start of another to conflict")
+	}
+	if err := m.MapRegionOwned("ROM", 0x2000, 0x100, get, set, nil); err != nil {
+		t.Fatal(err)
+	}
+	err := m.MapRegion(0x20ff, 2, get, set)
+	if cerr, ok := err.(*RegionConflictError); !ok || cerr.Existing.Owner != "ROM" {
+		t.Errorf("Expected a conflict with the ROM, found %v", err)
+	} else if msg := cerr.Error(); msg != "MapRegion: region 0x20ff-0x2100 conflicts with ROM at 0x2000-0x20ff" {
+		t.Errorf("Unexpected message %q", msg)
+	}
+	if err := m.MapRegion(0xf000, 0x1000, get, set); err != nil {
+		t.Fatal(err)
+	}
+	if m.Load(0xffff) != 0x1234 {
+		t.Error("Expected a region at the top of memory to contain its last address")
+	}
+	regions := m.MappedRegions()
+	if len(regions) != 4 || regions[0].Start != 0x1000 || regions[1].Owner != "ROM" || regions[3].Start != 0xf000 {
+		t.Errorf("Unexpected mapped regions %v", regions)
+	}
+}
+
+func TestMapROM(t *testing.T) {
+	for _, policy := range []ROMWritePolicy{ROMWriteIgnore, ROMWriteInterrupt, ROMWriteHalt} {
+		state := new(State)
+		// SET [0x9000], 0x1234; SUB PC, 1
+		if err := state.LoadProgram([]Word{0x7fc1, 0x1234, 0x9000, 0x8b83}, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := state.Ram.MapROM(0x9000, []Word{0xbeef}, policy, 0x42); err != nil {
+			t.Fatal(err)
+		}
+		state.SetIA(0x100)
+		// SUB PC, 1
+		if err := state.LoadProgram([]Word{0x8b83}, 0x100); err != nil {
+			t.Fatal(err)
+		}
+		done := Word(3)
+		if policy == ROMWriteInterrupt {
+			done = 0x100
+		}
+		_, err := state.RunUntil(func(s *State) bool { return s.PC() == done }, 100)
+		switch policy {
+		case ROMWriteIgnore:
+			if err != nil || state.PC() != 3 {
+				t.Errorf("%v: expected the write to be ignored, found %v", policy, err)
+			}
+		case ROMWriteInterrupt:
+			if err != nil || state.PC() != 0x100 || state.A() != 0x42 {
+				t.Errorf("%v: expected interrupt 0x42, found %v at PC %#04x", policy, err, state.PC())
+			}
+		case ROMWriteHalt:
+			if rerr, ok := err.(*ROMWriteError); !ok || rerr.Address != 0x9000 {
+				t.Errorf("%v: expected a ROMWriteError, found %v", policy, err)
+			}
+		}
+		if state.Ram.Load(0x9000) != 0xbeef {
+			t.Errorf("%v: expected the ROM to be unchanged, found %#04x", policy, state.Ram.Load(0x9000))
+		}
+	}
+}
+
+func TestMemProtect(t *testing.T) {
+	state := new(State)
+	state.MemProtect(0x2000, 0x10, true)
+	state.MemProtect(0x1000, 0x10, true)
+	// after every existing region
+	state.MemProtect(0x3000, 0x10, true)
+	// bridging two regions
+	state.MemProtect(0x1008, 0x1000, true)
+	expected := []Region{{0x1000, 0x1010}, {0x3000, 0x10}}
+	if !reflect.DeepEqual(state.Ram.protected, expected) {
+		t.Errorf("Expected protected regions %v, found %v", expected, state.Ram.protected)
+	}
+	// splitting a region
+	state.MemProtect(0x1800, 0x100, false)
+	expected = []Region{{0x1000, 0x800}, {0x1900, 0x710}, {0x3000, 0x10}}
+	if !reflect.DeepEqual(state.Ram.protected, expected) {
+		t.Errorf("Expected protected regions %v, found %v", expected, state.Ram.protected)
+	}
+	for _, test := range []struct {
+		address   Word
+		protected bool
+	}{{0x0fff, false}, {0x1000, true}, {0x17ff, true}, {0x1800, false}, {0x1900, true}, {0x300f, true}, {0x3010, false}} {
+		if err := state.Ram.Store(test.address, 1); (err != nil) != test.protected {
+			t.Errorf("%#04x: expected protected %v, found %v", test.address, test.protected, err)
+		}
+	}
+}
+
+func TestMemProtectReads(t *testing.T) {
+	state := new(State)
+	// SET A, [0x9000]
+	if err := state.LoadProgram([]Word{0x7801, 0x9000}, 0); err != nil {
+		t.Fatal(err)
+	}
+	state.Ram.Store(0x9000, 0x1234)
+	if err := state.MemProtectReads(0x9000, 0x10, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Ram.Store(0x9000, 1); err == nil {
+		t.Error("Expected read-protected memory to be write-protected too")
+	}
+	_, err := state.StepInstruction()
+	if perr, ok := err.(*ProtectionError); !ok || !perr.Read || perr.Address != 0x9000 {
+		t.Errorf("Expected a read ProtectionError, found %v", err)
+	}
+	if state.A() != 0 {
+		t.Errorf("Expected the instruction not to finish, found A %#04x", state.A())
+	}
+
+	state.Reset()
+	if err := state.LoadProgram([]Word{0x7801, 0x9000}, 0); err != nil {
+		t.Fatal(err)
+	}
+	state.MemProtectReads(0x9000, 0x10, false)
+	if _, err := state.StepInstruction(); err != nil {
+		t.Errorf("Expected the read to be allowed, found %v", err)
+	}
+}
+
+func TestDumpMemoryOptions(t *testing.T) {
+	state := new(State)
+	if err := state.LoadProgram([]Word{'h', 'i', 0x1234, 0, 0, 0, 0, 0, 0, 0xffff}, 0xfff6); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := state.Ram.DumpMemory(&buf, []int{0xfffe}); err != nil {
+		t.Fatal(err)
+	}
+	// the last row of memory is included
+	expected := "fff0: 0000 0000 0000 0000 0000 0000 0068 0069\n" +
+		"fff8: 1234 0000 0000 0000 0000 0000 \033[44m0000\033[m ffff\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected dump; expected %q, found %q", expected, buf.String())
+	}
+
+	buf.Reset()
+	opts := DumpOptions{Ranges: []Region{{0xfff6, 5}}, Width: 4, ASCII: true}
+	if err := state.Ram.DumpMemoryOptions(&buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	expected = "fff6: 0068 0069 1234 0000  |hi4.|\n" +
+		"fffa: 0000                 |.|\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected dump; expected %q, found %q", expected, buf.String())
+	}
+
+	buf.Reset()
+	opts = DumpOptions{Ranges: []Region{{0xfff6, 3}}, Labels: map[Word]string{0xfff6: "greeting", 0xfff8: "magic"}}
+	if err := state.Ram.DumpMemoryOptions(&buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	expected = "greeting:\nmagic:\nfff6: 0068 0069 1234\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected dump; expected %q, found %q", expected, buf.String())
+	}
+}
+
+func TestDumpSource(t *testing.T) {
+	state := new(State)
+	program := []Word{0x7c01, 0x0030, 1, 2, 3, 4, 5, 6, 0x8b83}
+	if err := state.LoadProgram(program, 0x10); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := state.Ram.DumpSource(&buf, Region{0x10, 9}, SourceGo, "program"); err != nil {
+		t.Fatal(err)
+	}
+	expected := "var program = []uint16{\n" +
+		"\t0x7c01, 0x0030, 0x0001, 0x0002, 0x0003, 0x0004, 0x0005, 0x0006,\n" +
+		"\t0x8b83,\n" +
+		"}\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected Go source; expected %q, found %q", expected, buf.String())
+	}
+
+	buf.Reset()
+	if err := state.Ram.DumpSource(&buf, Region{0x10, 2}, SourceC, "program"); err != nil {
+		t.Fatal(err)
+	}
+	expected = "#include <stdint.h>\n\nconst uint16_t program[2] = {\n\t0x7c01, 0x0030,\n};\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected C source; expected %q, found %q", expected, buf.String())
+	}
+}
+
+func TestSoftwareInterrupt(t *testing.T) {
+	state := new(State)
+	if err := state.LoadProgram(interruptTestProgram[:], 0); err != nil {
+		t.Fatal(err)
+	}
+	// run the program for up to 1000 cycles, or until it reaches :halt
+	for i := 0; i < 1000; i++ {
+		if err := state.StepCycle(); err != nil {
+			t.Fatal(err)
+		}
+		if state.step == stateStepFetch && state.PC() == 6 {
+			break
+		}
+	}
+	if state.PC() != 6 {
+		t.Fatalf("Program exceeded 1000 cycles")
+	}
+	if state.C() != 0x42 {
+		t.Errorf("Unexpected value for register C; expected %#x, found %#x", 0x42, state.C())
+	}
+	if state.A() != 7 || state.B() != 7 {
+		t.Errorf("Register A was not restored by RFI; expected A and B to be %#x, found %#x and %#x", 7, state.A(), state.B())
+	}
+	if state.SP() != 0 {
+		t.Errorf("Unexpected value for register SP; expected %#x, found %#x", 0, state.SP())
+	}
+	if state.queueing {
+		t.Errorf("Interrupt queueing was not disabled by RFI")
+	}
+}
+
+var interruptTestProgram = [...]Word{
+	//              set a, 7
+	0xA001, // 0
+	//              ias handler
+	0x7D40, // 1
+	7,      // 2
+	//              int 0x42
+	0x7D00, // 3
+	0x0042, // 4
+	//              set b, a
+	0x0021, // 5
+	// :halt        sub PC, 1
+	0x8B83, // 6
+	// :handler     set c, a
+	0x0041, // 7
+	//              rfi 0
+	0x8560, // 8
+}
+
+func TestTriggerInterrupt(t *testing.T) {
+	state := new(State)
+	if err := state.LoadProgram([]Word{0x8B83}, 0); err != nil { // sub PC, 1
+		t.Fatal(err)
+	}
+	// with IA at 0, interrupts are dropped
+	state.TriggerInterrupt(1)
+	if len(state.interrupts) != 0 {
+		t.Errorf("Interrupt was queued while IA was 0")
+	}
+	state.SetIA(0x1000)
+	state.queueing = true
+	for i := 0; i < maxQueuedInterrupts; i++ {
+		state.TriggerInterrupt(Word(i))
+	}
+	if err := state.StepCycle(); err != nil {
+		t.Fatal(err)
+	}
+	state.TriggerInterrupt(0xffff)
+	if err := state.StepCycle(); err != ErrInterruptOverflow {
+		t.Errorf("Unexpected error; expected %v, found %v", ErrInterruptOverflow, err)
+	}
+}
+
+type testDevice struct {
+	interrupts int
+	lastA      Word
+}
+
+func (d *testDevice) ID() uint32           { return 0x12345678 }
+func (d *testDevice) Version() Word        { return 0x1802 }
+func (d *testDevice) Manufacturer() uint32 { return 0x1c6c8b36 }
+func (d *testDevice) HandleInterrupt(s *State) error {
+	d.interrupts++
+	d.lastA = s.A()
+	return nil
+}
+
+func TestHardware(t *testing.T) {
+	state := new(State)
+	dev := new(testDevice)
+	state.Devices = []Device{dev}
+	if err := state.LoadProgram(hardwareTestProgram[:], 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if err := state.StepCycle(); err != nil {
+			t.Fatal(err)
+		}
+		if state.Ram.Load(state.PC()) == 0x8B83 { // sub PC, 1
+			break
+		}
+	}
+	if state.I() != 1 {
+		t.Errorf("Unexpected value for register I; expected %#x, found %#x", 1, state.I())
+	}
+	if state.A() != 0x5678 || state.B() != 0x1234 {
+		t.Errorf("Unexpected hardware ID; expected %#x, found %#x%04x", 0x12345678, state.B(), state.A())
+	}
+	if state.C() != 0x1802 {
+		t.Errorf("Unexpected hardware version; expected %#x, found %#x", 0x1802, state.C())
+	}
+	if state.X() != 0x8b36 || state.Y() != 0x1c6c {
+		t.Errorf("Unexpected manufacturer; expected %#x, found %#x%04x", 0x1c6c8b36, state.Y(), state.X())
+	}
+	if dev.interrupts != 1 {
+		t.Errorf("Unexpected interrupt count; expected %d, found %d", 1, dev.interrupts)
+	}
+	if dev.lastA != 0x5678 {
+		t.Errorf("Device saw unexpected value for register A; expected %#x, found %#x", 0x5678, dev.lastA)
+	}
+}
+
+type failingDevice struct {
+	testDevice
+}
+
+var errDeviceFailed = errors.New("device failed")
+
+func (d *failingDevice) HandleInterrupt(s *State) error { return errDeviceFailed }
+
+func TestDeviceError(t *testing.T) {
+	state := new(State)
+	state.Devices = []Device{new(testDevice), new(failingDevice)}
+	if err := state.LoadProgram([]Word{0x8640, 0x8a40}, 0); err != nil { // hwi 0; hwi 1
+		t.Fatal(err)
+	}
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		err = state.StepCycle()
+	}
+	if derr, ok := err.(*DeviceError); !ok || derr.Index != 1 || derr.Err != errDeviceFailed {
+		t.Errorf("Expected a DeviceError for device 1, found %v", err)
+	}
+}
+
+type pluggableDevice struct {
+	testDevice
+	ticks uint64
+	word  Word
+}
+
+func (d *pluggableDevice) Attach(s *State) error {
+	get := func(address Word) Word { return d.word }
+	set := func(address, val Word) error { d.word = val; return nil }
+	return s.Ram.MapRegion(0x9000, 1, get, set)
+}
+
+func (d *pluggableDevice) Detach(s *State) {
+	s.Ram.UnmapRegion(0x9000, 1)
+}
+
+func (d *pluggableDevice) Tick(s *State) error {
+	d.ticks++
+	return nil
+}
+
+func TestAttachDevice(t *testing.T) {
+	state := new(State)
+	first, second := new(testDevice), new(pluggableDevice)
+	if index, err := state.AttachDevice(first); err != nil || index != 0 {
+		t.Fatalf("Unexpected attach; index %d (%v)", index, err)
+	}
+	if index, err := state.AttachDevice(second); err != nil || index != 1 {
+		t.Fatalf("Unexpected attach; index %d (%v)", index, err)
+	}
+	if _, err := state.AttachDevice(second); err == nil {
+		t.Error("Expected an error attaching a device twice")
+	}
+	// SET [0x9000], 0x1234; SUB PC, 1
+	if err := state.LoadProgram([]Word{0x7fc1, 0x1234, 0x9000, 0x8b83}, 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := state.StepCycle(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if second.word != 0x1234 || second.ticks != 5 {
+		t.Errorf("Unexpected device state; word %#x, %d ticks", second.word, second.ticks)
+	}
+
+	if err := state.DetachDevice(first); err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Devices) != 1 || state.Devices[0] != second {
+		t.Errorf("Expected the second device to be renumbered, found %v", state.Devices)
+	}
+	if err := state.DetachDevice(second); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Ram.Store(0x9000, 0x5678); err != nil || second.word != 0x1234 {
+		t.Errorf("Expected the device's memory to be unmapped (%v)", err)
+	}
+	if err := state.DetachDevice(second); err == nil {
+		t.Error("Expected an error detaching a detached device")
+	}
+}
+
+var hardwareTestProgram = [...]Word{
+	0x1A00, // hwn i
+	0x8620, // hwq 0
+	0x8640, // hwi 0
+	0x8A40, // hwi 1
+	0x8B83, // sub PC, 1
+}
+
+// runInstruction executes a single instruction with the given A and B
+// registers, and returns the resulting A and EX registers.
+func runInstruction(t *testing.T, instruction, a, b Word) (Word, Word) {
+	state := new(State)
+	if err := state.LoadProgram([]Word{instruction}, 0); err != nil {
+		t.Fatal(err)
+	}
+	state.SetA(a)
+	state.SetB(b)
+	stepInstruction(t, state)
+	return state.A(), state.EX()
+}
+
+// stepInstruction steps the state until it has executed one instruction
+func stepInstruction(t *testing.T, state *State) {
+	for i := 0; i == 0 || state.step != stateStepFetch; i++ {
+		if i >= 10 {
+			t.Fatalf("Instruction %#04x exceeded 10 cycles", state.Ram.Load(0))
+		}
+		if err := state.StepCycle(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSignedArithmetic(t *testing.T) {
+	tests := []struct {
+		name        string
+		instruction Word
+		a, b        int16
+		result, ex  Word
+	}{
+		{"MLI", 0x0405, -2, 3, SignedWord(-6), 0xffff},
+		{"MLI", 0x0405, -0x8000, -1, 0x8000, 0},
+		{"MLI", 0x0405, 0x4000, 4, 0, 1},
+		{"DVI", 0x0407, -7, 2, SignedWord(-3), SignedWord(-0x8000)},
+		{"DVI", 0x0407, 7, -2, SignedWord(-3), SignedWord(-0x8000)},
+		{"DVI", 0x0407, -0x8000, -1, 0x8000, 0},
+		{"DVI", 0x0407, 5, 0, 0, 0},
+		{"MDI", 0x0409, -7, 16, SignedWord(-7), 0},
+		{"MDI", 0x0409, 7, -2, 1, 0},
+		{"MDI", 0x0409, -0x8000, -1, 0, 0},
+		{"MDI", 0x0409, 7, 0, 0, 0},
+		{"ASR", 0x040e, -0x8000, 4, 0xf800, 0},
+		{"ASR", 0x040e, -1, 1, 0xffff, 0x8000},
+		{"ASR", 0x040e, 0x0100, 4, 0x0010, 0},
+	}
+	for _, test := range tests {
+		result, ex := runInstruction(t, test.instruction, SignedWord(test.a), SignedWord(test.b))
+		if result != test.result || ex != test.ex {
+			t.Errorf("%s %d, %d: expected %#04x (EX %#04x), found %#04x (EX %#04x)", test.name, test.a, test.b, test.result, test.ex, result, ex)
+		}
+	}
+}
+
+func TestCarryArithmetic(t *testing.T) {
+	tests := []struct {
+		name             string
+		instruction      Word
+		a, b, ex         Word
+		result, resultEx Word
+	}{
+		{"ADX", 0x041a, 1, 2, 1, 4, 0},
+		{"ADX", 0x041a, 0xffff, 0, 1, 0, 1},
+		{"ADX", 0x041a, 0xffff, 0xffff, 1, 0xffff, 1},
+		{"SBX", 0x041b, 5, 3, 0, 2, 0},
+		{"SBX", 0x041b, 0, 1, 0, 0xffff, 0xffff},
+		{"SBX", 0x041b, 0, 1, 0xffff, 0xfffe, 0xffff},
+		{"SBX", 0x041b, 0xffff, 0, 1, 0, 1},
+	}
+	for _, test := range tests {
+		state := new(State)
+		if err := state.LoadProgram([]Word{test.instruction}, 0); err != nil {
+			t.Fatal(err)
+		}
+		state.SetA(test.a)
+		state.SetB(test.b)
+		state.SetEX(test.ex)
+		stepInstruction(t, state)
+		if state.A() != test.result || state.EX() != test.resultEx {
+			t.Errorf("%s %#04x, %#04x with EX %#04x: expected %#04x (EX %#04x), found %#04x (EX %#04x)", test.name, test.a, test.b, test.ex, test.result, test.resultEx, state.A(), state.EX())
+		}
+	}
+}
+
+func TestStringInstructions(t *testing.T) {
+	tests := []struct {
+		name        string
+		instruction Word
+		dest        int // register index that receives B
+		value, i, j Word
+	}{
+		{"STI A, B", 0x041e, registerA, 0x1234, 1, 1},
+		{"STD A, B", 0x041f, registerA, 0x1234, 0xffff, 0xffff},
+		{"STI I, B", 0x04de, registerI, 0x1235, 0x1235, 1},
+	}
+	for _, test := range tests {
+		state := new(State)
+		if err := state.LoadProgram([]Word{test.instruction}, 0); err != nil {
+			t.Fatal(err)
+		}
+		state.SetB(0x1234)
+		stepInstruction(t, state)
+		if state.Registers[test.dest] != test.value || state.I() != test.i || state.J() != test.j {
+			t.Errorf("%s: expected %#04x (I %#04x, J %#04x), found %#04x (I %#04x, J %#04x)", test.name, test.value, test.i, test.j, state.Registers[test.dest], state.I(), state.J())
+		}
+	}
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []struct {
+		name        string
+		instruction Word
+		a, b        Word
+		taken       bool
+	}{
+		{"IFB", 0x0410, 0x0f0f, 0x0100, true},
+		{"IFB", 0x0410, 0x0f0f, 0xf0f0, false},
+		{"IFC", 0x0411, 0x0f0f, 0xf0f0, true},
+		{"IFC", 0x0411, 0x0f0f, 0x0100, false},
+		{"IFG", 0x0414, 0xffff, 1, true},
+		{"IFA", 0x0415, 0xffff, 1, false},
+		{"IFA", 0x0415, 1, 0xffff, true},
+		{"IFL", 0x0416, 1, 0xffff, true},
+		{"IFL", 0x0416, 0xffff, 1, false},
+		{"IFU", 0x0417, 0xffff, 1, true},
+		{"IFU", 0x0417, 1, 0xffff, false},
+	}
+	for _, test := range tests {
+		state := new(State)
+		if err := state.LoadProgram([]Word{test.instruction}, 0); err != nil {
+			t.Fatal(err)
+		}
+		state.SetA(test.a)
+		state.SetB(test.b)
+		stepInstruction(t, state)
+		if taken := state.PC() == 1; taken != test.taken {
+			t.Errorf("%s %#04x, %#04x: expected taken to be %v, found PC %#x", test.name, test.a, test.b, test.taken, state.PC())
+		}
+	}
+}
+
+func TestChainedSkip(t *testing.T) {
+	state := new(State)
+	if err := state.LoadProgram(chainedSkipTestProgram[:], 0); err != nil {
+		t.Fatal(err)
+	}
+	// SET (1 cycle), IFE (2 cycles), then skipping IFE and SET (1 cycle each)
+	for i := 0; i < 5; i++ {
+		if err := state.StepCycle(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if state.step != stateStepFetch {
+		t.Errorf("Unexpectedly stopped mid-instruction")
+	}
+	if state.PC() != 5 {
+		t.Errorf("Unexpected value for register PC; expected %#x, found %#x", 5, state.PC())
+	}
+	stepInstruction(t, state)
+	if state.B() != 0 || state.C() != 1 {
+		t.Errorf("Unexpected values for registers B and C; expected %#x and %#x, found %#x and %#x", 0, 1, state.B(), state.C())
+	}
+}
+
+var chainedSkipTestProgram = [...]Word{
+	//              set a, 1
+	0x8801, // 0
+	//              ife a, 2
+	0x8C12, // 1
+	//                  ife a, 1
+	0x8812, // 2
+	//                      set b, [0x1000]
+	0x7821, // 3
+	0x1000, // 4
+	//              set c, 1
+	0x8841, // 5
+	// :halt        sub PC, 1
+	0x8B83, // 6
+}
+
+func TestStackOperands(t *testing.T) {
+	state := new(State)
+	if err := state.LoadProgram(stackOperandTestProgram[:], 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if err := state.StepCycle(); err != nil {
+			t.Fatal(err)
+		}
+		if state.Ram.Load(state.PC()) == 0x8B83 { // sub PC, 1
+			break
+		}
+	}
+	if state.PC() != 13 {
+		t.Fatalf("Unexpected value for register PC; expected %#x, found %#x", 13, state.PC())
+	}
+	if state.A() != 0x1111 {
+		t.Errorf("Unexpected value for register A; expected %#x, found %#x", 0x1111, state.A())
+	}
+	if state.B() != 30 {
+		t.Errorf("Unexpected value for register B; expected %#x, found %#x", 30, state.B())
+	}
+	if state.C() != 0 {
+		t.Errorf("Unexpected value for register C; expected %#x, found %#x", 0, state.C())
+	}
+	if state.X() != 0xffff {
+		t.Errorf("Unexpected value for register X; expected %#x, found %#x", 0xffff, state.X())
+	}
+	if state.SP() != 0xfffe {
+		t.Errorf("Unexpected value for register SP; expected %#x, found %#x", 0xfffe, state.SP())
+	}
+}
+
+var stackOperandTestProgram = [...]Word{
+	//              set push, 0x1111
+	0x7F01, // 0
+	0x1111, // 1
+	//              set push, 0x2222
+	0x7F01, // 2
+	0x2222, // 3
+	//              set a, pick 1
+	0x6801, // 4
+	0x0001, // 5
+	//              set pick 0, -1
+	0x8341, // 6
+	0x0000, // 7
+	//              set b, 30
+	0xFC21, // 8
+	//              ife a, 0
+	0x8412, // 9
+	//                  set c, pick 1
+	0x6841, // 10
+	0x0001, // 11
+	//              set x, peek
+	0x6461, // 12
+	// :halt        sub PC, 1
+	0x8B83, // 13
+}
+
+func TestInvalidSpecialOpcode(t *testing.T) {
+	state := new(State)
+	// 0x0000 is the reserved special opcode 0x00, and 0x0260 is the unassigned special opcode 0x13
+	for _, word := range []Word{0x0000, 0x0260} {
+		if err := state.LoadProgram([]Word{word}, 0); err != nil {
+			t.Fatal(err)
+		}
+		state.lastError = nil
+		state.step = stateStepFetch
+		state.SetPC(0)
+		err := state.StepCycle()
+		opErr, ok := err.(*OpcodeError)
+		if !ok {
+			t.Errorf("Unexpected error for %#04x; expected *OpcodeError, found %v", word, err)
+			continue
+		}
+		if !opErr.Special || opErr.Opcode != byte(word>>5) {
+			t.Errorf("Unexpected error for %#04x; expected special opcode %#02x, found %v", word, word>>5, opErr)
+		}
+	}
+}
+
+func TestSpec11(t *testing.T) {
+	state := new(State)
+	state.Spec = Spec11
+	if err := state.LoadProgram(notchAssemblerTestProgram11[:], 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if err := state.StepCycle(); err != nil {
+			t.Fatal(err)
+		}
+		if state.Ram.Load(state.PC()) == 0x85C3 { // sub PC, 1
+			break
+		}
+	}
+	expected := "Hello world!"
+	for i := 0; i < len(expected); i++ {
+		if state.Ram.Load(Word(0x8000+i)) != Word(expected[i]) {
+			t.Errorf("Unexpected output in video ram; expected %v, found %v", []byte(expected), state.Ram.GetSlice(0x8000, 0x800B))
+			break
+		}
+	}
+
+	state = new(State)
+	state.Spec = Spec11
+	if err := state.LoadProgram(notchSpecExampleProgram11[:], 0); err != nil {
+		t.Fatal(err)
+	}
+	// 1.1 timing differs from 1.7, so check the first section
+	for i := 0; i < 11; i++ {
+		if err := state.StepCycle(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if state.step != stateStepFetch || state.PC() != 10 {
+		t.Errorf("Unexpected value for register PC; expected %#x, found %#x", 10, state.PC())
+	}
+	// run the program for 1000 cycles, or until it hits the instruction 0x7DC1 PC
+	for i := 0; i < 1000; i++ {
+		if err := state.StepCycle(); err != nil {
+			t.Fatal(err)
+		}
+		if state.Ram.Load(state.PC()) == 0x7DC1 && state.Ram.Load(state.PC()+1) == state.PC() {
+			break
+		}
+	}
+	if state.X() != 0x40 {
+		t.Errorf("Unexpected value for register X; expected %#x, found %#x", 0x40, state.X())
+	}
+	if state.SP() != 0 {
+		t.Errorf("Unexpected value for register SP; expected %#x, found %#x", 0, state.SP())
+	}
+}
+
+// notchAssemblerTestProgram11 is notchAssemblerTestProgram assembled for 1.1
+var notchAssemblerTestProgram11 = [...]Word{
+	0x7C01, 0xBEEF, 0x01E1, 0x1000, 0x780D, 0x1000, 0x7DC1, 32,
+	0x8061, 0x816C, 19, 0x7DC1, 32, 0x5961, 0x8000, 19,
+	0x8462, 0x7DC1, 9,
+	'H', 'e', 'l', 'l', 'o', ' ', 'w', 'o', 'r', 'l', 'd', '!', 0,
+	0x85C3,
+}
+
+// notchSpecExampleProgram11 is the example program from the 1.1 spec
+var notchSpecExampleProgram11 = [...]Word{
+	0x7c01, 0x0030, 0x7de1, 0x1000, 0x0020, 0x7803, 0x1000, 0xc00d,
+	0x7dc1, 0x001a, 0xa861, 0x7c01, 0x2000, 0x2161, 0x2000, 0x8463,
+	0x806d, 0x7dc1, 0x000d, 0x9031, 0x7c10, 0x0018, 0x7dc1, 0x001a,
+	0x9037, 0x61c1, 0x7dc1, 0x001a, 0x0000, 0x0000, 0x0000, 0x0000,
+}
+
+func TestDivideByZero(t *testing.T) {
+	// DIV A, B and MOD A, B
+	for _, instruction := range []Word{0x0406, 0x0408} {
+		state := new(State)
+		if err := state.LoadProgram([]Word{instruction}, 0); err != nil {
+			t.Fatal(err)
+		}
+		state.SetA(5)
+		state.SetEX(0x1234)
+		stepInstruction(t, state)
+		if state.A() != 0 {
+			t.Errorf("Unexpected result for %#04x; expected %#x, found %#x", instruction, 0, state.A())
+		}
+		if instruction == 0x0406 && state.EX() != 0 {
+			t.Errorf("Unexpected value for register EX; expected %#x, found %#x", 0, state.EX())
+		}
+
+		state = new(State)
+		state.StrictDivide = true
+		if err := state.LoadProgram([]Word{instruction}, 0); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		for i := 0; i < 10 && err == nil; i++ {
+			err = state.StepCycle()
+		}
+		if _, ok := err.(*DivideByZeroError); !ok {
+			t.Errorf("Unexpected error for %#04x in strict mode; expected *DivideByZeroError, found %v", instruction, err)
+		}
+	}
+}
+
+func TestInvalidOpcodePolicy(t *testing.T) {
+	// 0x7c18 is the unassigned basic opcode 0x18 with a next word literal,
+	// followed by set a, 1 and a handler at 0x10 that sets b to the message
+	program := []Word{0x7C18, 0x1234, 0x8801}
+	handler := []Word{0x0021} // set b, a
+
+	state := new(State)
+	if err := state.LoadProgram(program, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.StepCycle(); err == nil {
+		t.Errorf("Expected an error for an invalid opcode")
+	}
+
+	state = new(State)
+	state.InvalidOpcode = OpcodePolicyNOP
+	var hooked []Word
+	state.StepHook = func(s *State, pc Word, inst Instruction, cycles uint64) {
+		hooked = append(hooked, pc)
+	}
+	if err := state.LoadProgram(program, 0); err != nil {
+		t.Fatal(err)
+	}
+	stepInstruction(t, state)
+	if state.PC() != 2 {
+		t.Errorf("Unexpected value for register PC; expected %#x, found %#x", 2, state.PC())
+	}
+	// the skipped instruction still finishes, as far as the hook's concerned
+	if len(hooked) != 1 || hooked[0] != 0 {
+		t.Errorf("Expected the StepHook to be called for the invalid opcode at 0, found %v", hooked)
+	}
+	stepInstruction(t, state)
+	if state.A() != 1 {
+		t.Errorf("Unexpected value for register A; expected %#x, found %#x", 1, state.A())
+	}
+
+	state = new(State)
+	state.InvalidOpcode = OpcodePolicyInterrupt
+	state.InvalidOpcodeMessage = 0xdead
+	state.SetIA(0x10)
+	if err := state.LoadProgram(program, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.LoadProgram(handler, 0x10); err != nil {
+		t.Fatal(err)
+	}
+	stepInstruction(t, state)
+	stepInstruction(t, state)
+	if state.B() != 0xdead {
+		t.Errorf("Unexpected value for register B; expected %#x, found %#x", 0xdead, state.B())
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		words  []Word
+		spec   SpecVersion
+		text   string
+		length Word
+		cost   uint
+	}{
+		{[]Word{0x7fc1, 0x0020, 0x1000}, Spec17, "SET [0x1000], 0x20", 3, 3},
+		{[]Word{0x22c1, 0x2000}, Spec17, "SET [0x2000+I], [A]", 2, 2},
+		{[]Word{0x88c3}, Spec17, "SUB I, 0x1", 1, 2},
+		{[]Word{0x8341, 0x0002}, Spec17, "SET PICK 0x2, 0xffff", 2, 2},
+		{[]Word{0x6381}, Spec17, "SET PC, POP", 1, 1},
+		{[]Word{0x0301}, Spec17, "SET PUSH, A", 1, 1},
+		{[]Word{0x7c20, 0x0017}, Spec17, "JSR 0x17", 2, 4},
+		{[]Word{0x1a00}, Spec17, "HWN I", 1, 2},
+		{[]Word{0x0000}, Spec17, "<special 0x00> A", 1, 0},
+		{[]Word{0x5961, 0x8000, 0x0013}, Spec11, "SET [0x8000+I], [0x0013+I]", 3, 3},
+		{[]Word{0x7c10, 0x0018}, Spec11, "JSR 0x18", 2, 3},
+		{[]Word{0x61c1}, Spec11, "SET PC, POP", 1, 1},
+		{[]Word{0x7c01}, Spec17, "SET A, 0x0", 2, 2},
+	}
+	for _, test := range tests {
+		inst, length := DecodeSpec(test.words, test.spec)
+		if inst.String() != test.text || length != test.length || inst.Cost != test.cost {
+			t.Errorf("Unexpected decoding of %#04x; expected %q (length %d, cost %d), found %q (length %d, cost %d)", test.words, test.text, test.length, test.cost, inst, length, inst.Cost)
+		}
+	}
+}
+
+func TestWatchpoints(t *testing.T) {
+	program := []Word{
+		0x9bc1, 0x1000, // SET [0x1000], 5
+		0x7801, 0x1000, // SET A, [0x1000]
+		0x8bc2, 0x1000, // ADD [0x1000], 1
+		0x8b83, // SUB PC, 1
+	}
+	// runUntilWatch steps until a watchpoint triggers, returning nil if none
+	// does before the program halts
+	runUntilWatch := func(state *State) *WatchpointError {
+		for i := 0; i < 20; i++ {
+			if err := state.StepCycle(); err != nil {
+				if err, ok := err.(*WatchpointError); ok {
+					return err
+				}
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}
+	tests := []struct {
+		kind     WatchKind
+		expected []WatchpointError
+	}{
+		{WatchWrite, []WatchpointError{
+			{WatchWrite, 0, 0x1000, 0, 5},
+			{WatchWrite, 4, 0x1000, 5, 6},
+		}},
+		{WatchRead, []WatchpointError{
+			{WatchRead, 2, 0x1000, 5, 5},
+			{WatchRead, 4, 0x1000, 5, 5},
+		}},
+	}
+	for _, test := range tests {
+		state := new(State)
+		if err := state.LoadProgram(program, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := state.AddWatchpoint(0x0fff, 2, test.kind); err != nil {
+			t.Fatal(err)
+		}
+		for _, expected := range test.expected {
+			hit := runUntilWatch(state)
+			if hit == nil {
+				t.Fatalf("%v: expected %v", test.kind, &expected)
+			}
+			if *hit != expected {
+				t.Errorf("%v: unexpected hit; expected %v, found %v", test.kind, &expected, hit)
+			}
+		}
+		if hit := runUntilWatch(state); hit != nil {
+			t.Errorf("%v: unexpected hit %v", test.kind, hit)
+		}
+		if state.A() != 5 || state.Ram.Load(0x1000) != 6 {
+			t.Errorf("%v: watchpoints changed the result of the program", test.kind)
+		}
+	}
+
+	state := new(State)
+	if err := state.AddWatchpoint(0x1000, 1, 0); err == nil {
+		t.Error("Expected an error for an invalid watch kind")
+	}
+	if err := state.RemoveWatchpoint(0x1000, 1); err == nil {
+		t.Error("Expected an error removing a missing watchpoint")
+	}
+}
+
+func TestBreakpoints(t *testing.T) {
+	program := []Word{
+		0x8802, // ADD A, 1
+		0x8781, // SET PC, 0
+	}
+	state := new(State)
+	if err := state.LoadProgram(program, 0); err != nil {
+		t.Fatal(err)
+	}
+	// stop before ADD A, 1 once A is 3
+	state.AddBreakpoint(0, func(s *State) bool { return s.A() == 3 })
+	for i := 0; ; i++ {
+		if i >= 100 {
+			t.Fatal("Breakpoint didn't trigger")
+		}
+		if err := state.StepCycle(); err != nil {
+			if err, ok := err.(*BreakpointError); !ok || err.PC != 0 {
+				t.Fatal(err)
+			}
+			break
+		}
+	}
+	if state.A() != 3 {
+		t.Errorf("Expected A to be 3 at the breakpoint, found %d", state.A())
+	}
+	// resuming executes the instruction without triggering again
+	stepInstruction(t, state)
+	if state.A() != 4 {
+		t.Errorf("Expected A to be 4 after resuming, found %d", state.A())
+	}
+	// an unconditional breakpoint triggers every time
+	state.AddBreakpoint(0, nil)
+	stepInstruction(t, state)
+	if err := state.StepCycle(); err == nil {
+		t.Error("Expected the unconditional breakpoint to trigger")
+	}
+	if len(state.Breakpoints()) != 1 {
+		t.Errorf("Expected AddBreakpoint to replace the existing breakpoint, found %d", len(state.Breakpoints()))
+	}
+	if err := state.RemoveBreakpoint(0); err != nil {
+		t.Error(err)
+	}
+	if err := state.RemoveBreakpoint(0); err == nil {
+		t.Error("Expected an error removing a missing breakpoint")
+	}
+}
+
+func TestStepHook(t *testing.T) {
+	program := []Word{
+		0x7c01, 0x0030, // SET A, 0x30
+		0x8412, // IFE A, 0
+		0x8802, // ADD A, 1
+		0x8432, // IFE B, 0
+		0x8802, // ADD A, 1
+		0x8b83, // SUB PC, 1
+	}
+	state := new(State)
+	if err := state.LoadProgram(program, 0); err != nil {
+		t.Fatal(err)
+	}
+	type step struct {
+		pc     Word
+		inst   string
+		cycles uint64
+	}
+	var steps []step
+	state.StepHook = func(s *State, pc Word, inst Instruction, cycles uint64) {
+		steps = append(steps, step{pc, inst.String(), cycles})
+	}
+	for i := 0; i < 11; i++ {
+		if err := state.StepCycle(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the failed IFE is reported, but not the instruction it skips
+	expected := []step{
+		{0, "SET A, 0x30", 2},
+		{2, "IFE A, 0x0", 4},
+		{4, "IFE B, 0x0", 7},
+		{5, "ADD A, 0x1", 9},
+		{6, "SUB PC, 0x1", 11},
+	}
+	if len(steps) != len(expected) {
+		t.Fatalf("Expected %d steps, found %d: %v", len(expected), len(steps), steps)
+	}
+	for i := range steps {
+		if steps[i] != expected[i] {
+			t.Errorf("Unexpected step %d; expected %v, found %v", i, expected[i], steps[i])
+		}
+	}
+	if state.Cycles() != 11 {
+		t.Errorf("Expected 11 cycles, found %d", state.Cycles())
+	}
+
+	// without a hook, stepping must not allocate
+	state.StepHook = nil
+	if allocs := testing.AllocsPerRun(100, func() { state.StepCycle() }); allocs != 0 {
+		t.Errorf("Expected StepCycle not to allocate, found %v allocations", allocs)
+	}
+}
+
+func TestMappedReadNotify(t *testing.T) {
+	program := []Word{
+		0x7801, 0x9000, // SET A, [0x9000]
+		0x8bc1, 0x9000, // SET [0x9000], 1
+		0x8bc2, 0x9001, // ADD [0x9001], 1
+		0x8b83, // SUB PC, 1
+	}
+	state := new(State)
+	if err := state.LoadProgram(program, 0); err != nil {
+		t.Fatal(err)
+	}
+	var buffer [2]Word
+	var reads []Word
+	get := func(address Word) Word {
+		return buffer[address]
+	}
+	set := func(address, val Word) error {
+		buffer[address] = val
+		return nil
+	}
+	read := func(address Word) {
+		reads = append(reads, address)
+	}
+	if err := state.Ram.MapRegionNotify(0x9000, 2, get, set, read); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 8; i++ {
+		if err := state.StepCycle(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// SET only writes its destination, so only the first and last
+	// instructions read
+	if len(reads) != 2 || reads[0] != 0 || reads[1] != 1 {
+		t.Errorf("Unexpected reads %v", reads)
+	}
+	if buffer != [2]Word{1, 1} {
+		t.Errorf("Unexpected buffer %v", buffer)
+	}
+	// loads from outside the CPU don't notify
+	state.Ram.Load(0x9000)
+	if len(reads) != 2 {
+		t.Errorf("Expected Load not to notify, found reads %v", reads)
+	}
+}
+
+func TestStepBack(t *testing.T) {
+	program := []Word{
+		0x8801,         // SET A, 1
+		0x0301,         // SET PUSH, A
+		0x9bc2, 0x1000, // ADD [0x1000], 5
+		0x7821, 0x1000, // SET B, [0x1000]
+		0x0000, // invalid
+	}
+	state := new(State)
+	if err := state.LoadProgram(program, 0); err != nil {
+		t.Fatal(err)
+	}
+	state.EnableHistory(10)
+	run := func() error {
+		for i := 0; i < 20; i++ {
+			if err := state.StepCycle(); err != nil {
+				return err
+			}
+		}
+		t.Fatal("Expected the program to halt")
+		return nil
+	}
+	if err := run(); err == nil {
+		t.Fatal("Expected an error")
+	}
+	cycles := state.Cycles()
+	// the first step undoes the failed fetch of the invalid opcode
+	if n := state.StepBack(1); n != 1 || state.PC() != 6 {
+		t.Fatalf("Expected to step back to PC 6, stepped back %d to %#x", n, state.PC())
+	}
+	if n := state.StepBack(2); n != 2 {
+		t.Fatalf("Expected to step back 2 instructions, stepped back %d", n)
+	}
+	if state.PC() != 2 || state.B() != 0 || state.Ram.Load(0x1000) != 0 {
+		t.Errorf("Unexpected state after stepping back; PC %#x, B %#x, [0x1000] %#x", state.PC(), state.B(), state.Ram.Load(0x1000))
+	}
+	if state.A() != 1 || state.SP() != 0xffff || state.Ram.Load(0xffff) != 1 {
+		t.Errorf("Stepping back undid too much; A %#x, SP %#x, [SP] %#x", state.A(), state.SP(), state.Ram.Load(0xffff))
+	}
+	// replaying reaches the same state
+	if err := run(); err == nil {
+		t.Fatal("Expected an error replaying")
+	}
+	if state.B() != 5 || state.Cycles() != cycles {
+		t.Errorf("Unexpected state after replaying; B %#x, cycles %d (expected %d)", state.B(), state.Cycles(), cycles)
+	}
+	// stepping back is limited by the history size
+	if n := state.StepBack(100); n != 5 || state.PC() != 0 || state.SP() != 0 || state.Ram.Load(0xffff) != 0 {
+		t.Errorf("Expected to step back to the start, stepped back %d to PC %#x", n, state.PC())
+	}
+	state.EnableHistory(2)
+	stepInstruction(t, state)
+	stepInstruction(t, state)
+	stepInstruction(t, state)
+	if state.HistoryLen() != 2 || state.StepBack(5) != 2 || state.PC() != 1 {
+		t.Errorf("Expected a history of 2 instructions, stepped back to PC %#x", state.PC())
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	program := []Word{
+		0x8802,         // ADD A, 1
+		0x03c2, 0x1000, // ADD [0x1000], A
+		0x8781, // SET PC, 0
+	}
+	state := new(State)
+	if err := state.LoadProgram(program, 0); err != nil {
+		t.Fatal(err)
+	}
+	run := func(s *State, cycles int) {
+		for i := 0; i < cycles; i++ {
+			if err := s.StepCycle(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// stop partway through ADD [0x1000], A
+	run(state, 4)
+	data, err := state.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	run(state, 50)
+
+	restored := new(State)
+	if err := restored.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Cycles() != 4 {
+		t.Errorf("Unexpected cycle count; expected %d, found %d", 4, restored.Cycles())
+	}
+	run(restored, 50)
+	if restored.Registers != state.Registers || restored.Cycles() != state.Cycles() {
+		t.Errorf("Restored state diverged; registers %v, cycles %d (expected %v, %d)", restored.Registers, restored.Cycles(), state.Registers, state.Cycles())
+	}
+	if restored.Ram.Load(0x1000) != state.Ram.Load(0x1000) {
+		t.Errorf("Restored memory diverged; expected %#x, found %#x", state.Ram.Load(0x1000), restored.Ram.Load(0x1000))
+	}
+
+	if err := restored.Restore(data[:len(data)-1]); err != ErrBadSnapshot {
+		t.Errorf("Expected %v restoring a truncated snapshot, found %v", ErrBadSnapshot, err)
+	}
+	restored.Devices = []Device{new(testDevice)}
+	if err := restored.Restore(data); err == nil {
+		t.Error("Expected an error restoring with different devices")
+	}
+	restored.Devices = nil
+
+	// corrupt the fixed-size fields, which follow the magic, the version
+	// and the registers
+	offset := 4 + 2 + 2*registerCount
+	corrupt := func(name string, field int, value []byte, step ...byte) {
+		bad := append([]byte(nil), data...)
+		copy(bad[offset+field:], value)
+		if len(step) > 0 {
+			bad[offset] = step[0]
+		}
+		if err := restored.Restore(bad); err != ErrBadSnapshot {
+			t.Errorf("Expected %v restoring a snapshot with a bad %s, found %v", ErrBadSnapshot, name, err)
+		}
+	}
+	corrupt("step", 0, []byte{9, 0, 0, 0})
+	corrupt("opcode", 8, []byte{0x18, 0, 0, 0})
+	corrupt("address type", 21, []byte{7, 0, 0, 0})
+	corrupt("register index", 21, []byte{addressTypeRegister, 0, 0, 0, registerCount, 0})
+	// operands that haven't been decoded hold 6 bit codes, and decoded ones
+	// hold words
+	corrupt("a operand", 12, []byte{0x40, 0, 0, 0}, stateStepDecodeA)
+	corrupt("b operand", 16, []byte{0x40, 0, 0, 0}, stateStepDecodeA)
+	corrupt("b operand", 16, []byte{0x40, 0, 0, 0}, stateStepDecodeB)
+	corrupt("a operand", 12, []byte{0, 0, 1, 0}, stateStepExecute)
+}
+
+// snapshotTestDevice is a testDevice with a byte of state to snapshot
+type snapshotTestDevice struct {
+	testDevice
+	value byte
+}
+
+func (d *snapshotTestDevice) Snapshot() ([]byte, error) { return []byte{d.value}, nil }
+func (d *snapshotTestDevice) Restore(data []byte) error {
+	if len(data) != 1 {
+		return ErrBadSnapshot
+	}
+	d.value = data[0]
+	return nil
+}
+
+func TestSnapshotRollback(t *testing.T) {
+	first, second := &snapshotTestDevice{value: 1}, &snapshotTestDevice{value: 2}
+	state := new(State)
+	state.Devices = []Device{first, second}
+	data, err := state.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.value, second.value = 3, 4
+	state.SetA(5)
+	if err := state.Restore(data); err != nil || first.value != 1 || second.value != 2 || state.A() != 0 {
+		t.Fatalf("Unexpected restore; error %v, values %d, %d, A %#x", err, first.value, second.value, state.A())
+	}
+
+	// the second device's state is replaced with an empty one it rejects
+	first.value, second.value = 3, 4
+	state.SetA(5)
+	bad := append(data[:len(data)-5:len(data)-5], 0, 0, 0, 0)
+	if err := state.Restore(bad); err == nil {
+		t.Fatal("Expected an error restoring a bad device state")
+	}
+	if first.value != 3 || second.value != 4 || state.A() != 5 {
+		t.Errorf("Failed restore changed the state; values %d, %d, A %#x", first.value, second.value, state.A())
+	}
+}
+
+func TestSelfModify(t *testing.T) {
+	program := []Word{
+		0x8801,                 // SET A, 1
+		0x7fc1, 0x8c01, 0x0000, // SET [0x0000], 0x8c01 (SET A, 2)
+		0x8bc1, 0x0010, // SET [0x0010], 1
+		0x8781, // SET PC, 0
+	}
+	run := func(policy SelfModifyPolicy, log func(err *SelfModifyError)) (*State, error) {
+		state := new(State)
+		if err := state.LoadProgram(program, 0); err != nil {
+			t.Fatal(err)
+		}
+		state.SelfModify = policy
+		state.SelfModifyLog = log
+		for i := 0; i < 20; i++ {
+			if err := state.StepCycle(); err != nil {
+				return state, err
+			}
+		}
+		return state, nil
+	}
+
+	if _, err := run(SelfModifyIgnore, nil); err != nil {
+		t.Errorf("Unexpected error ignoring self-modifying code: %v", err)
+	}
+
+	var logged []SelfModifyError
+	if _, err := run(SelfModifyLog, func(err *SelfModifyError) { logged = append(logged, *err) }); err != nil {
+		t.Errorf("Unexpected error logging self-modifying code: %v", err)
+	}
+	expected := SelfModifyError{PC: 1, Address: 0, Old: 0x8801, New: 0x8c01}
+	if len(logged) != 3 || logged[0] != expected {
+		t.Errorf("Unexpected log %v", logged)
+	}
+
+	state, err := run(SelfModifyPause, nil)
+	if serr, ok := err.(*SelfModifyError); !ok || *serr != expected {
+		t.Fatalf("Expected %v, found %v", &expected, err)
+	}
+	if state.PC() != 4 {
+		t.Errorf("Expected to pause after the write, found PC %#x", state.PC())
+	}
+	// the pause doesn't halt the state
+	for i := 0; i < 3; i++ {
+		if err := state.StepCycle(); err != nil {
+			t.Fatalf("Unexpected error resuming: %v", err)
+		}
+	}
+}
+
+func TestHalted(t *testing.T) {
+	tests := []struct {
+		program []Word
+		spec    SpecVersion
+		halted  bool
+	}{
+		{[]Word{0x8b83}, Spec17, true},          // SUB PC, 1
+		{[]Word{0x8781}, Spec17, true},          // SET PC, 0
+		{[]Word{0x7f81, 0x0000}, Spec17, true},  // SET PC, 0x0000
+		{[]Word{0x7f82, 0xfffe}, Spec17, true},  // ADD PC, 0xfffe
+		{[]Word{0x85c3}, Spec11, true},          // SUB PC, 1
+		{[]Word{0x8b81, 0x8781}, Spec17, false}, // SET PC, 1; SET PC, 0
+		{[]Word{0x0381}, Spec17, false},         // SET PC, A
+	}
+	for i, test := range tests {
+		state := new(State)
+		state.Spec = test.spec
+		if err := state.LoadProgram(test.program, 0); err != nil {
+			t.Fatal(err)
+		}
+		stepInstruction(t, state)
+		stepInstruction(t, state)
+		if state.Halted() != test.halted {
+			t.Errorf("Test %d: expected halted %v, found %v", i, test.halted, state.Halted())
+		}
+	}
+
+	// an interrupt could break the loop
+	state := new(State)
+	if err := state.LoadProgram([]Word{0x8b83}, 0); err != nil {
+		t.Fatal(err)
+	}
+	state.SetIA(0x10)
+	state.Devices = []Device{new(testDevice)}
+	stepInstruction(t, state)
+	if state.Halted() {
+		t.Error("Expected a device with interrupts enabled to prevent halting")
+	}
+	state.SetIA(0)
+	if !state.Halted() {
+		t.Error("Expected to halt once interrupts are disabled")
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	program := []Word{
+		0x7c01, 0x0030, // SET A, 0x30
+		0x97c1, 0x1000, // SET [0x1000], 4
+		0x9bc1, 0x1001, // SET [0x1001], 5
+	}
+	state := new(State)
+	if err := state.LoadProgram(program, 0); err != nil {
+		t.Fatal(err)
+	}
+	before, err := state.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		stepInstruction(t, state)
+	}
+	after, err := state.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff, err := DiffSnapshots(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "A: 0000 -> 0030\nPC: 0000 -> 0006\n[1000]: 0000 0000 -> 0004 0005"
+	if diff.String() != expected {
+		t.Errorf("Unexpected diff:\n%s", diff)
+	}
+	if diff.Empty() || diff.OldCycles != 0 || diff.NewCycles != state.Cycles() {
+		t.Errorf("Unexpected diff summary %+v", diff)
+	}
+	if diff, err := DiffSnapshots(after, after); err != nil || !diff.Empty() {
+		t.Errorf("Expected no differences, found %v (%v)", diff, err)
+	}
+	if _, err := DiffSnapshots(before, after[:10]); err != ErrBadSnapshot {
+		t.Errorf("Expected %v, found %v", ErrBadSnapshot, err)
+	}
+}
+
+func TestMemorySearch(t *testing.T) {
+	var m Memory
+	copy(m.ram[0x100:], []Word{1, 2, 1, 2, 1})
+	copy(m.ram[0x200:], []Word{0x4869, 0x2100}) // "Hi!" packed
+	copy(m.ram[0x300:], []Word{0xf048, 0xf069}) // "Hi" in video memory
+	words := func(ws ...Word) []Word { return ws }
+	equal := func(a, b []Word) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+	if found := m.Find(words(1, 2, 1)); !equal(found, words(0x100, 0x102)) {
+		t.Errorf("Unexpected matches for the pattern: %#x", found)
+	}
+	if found, err := m.FindString("Hi!", StringPacked); err != nil || !equal(found, words(0x200)) {
+		t.Errorf("Unexpected matches for the packed string: %#x (%v)", found, err)
+	}
+	if found, err := m.FindString("Hi", StringUnpacked); err != nil || !equal(found, words(0x300)) {
+		t.Errorf("Unexpected matches for the unpacked string: %#x (%v)", found, err)
+	}
+	if _, err := m.FindString("é", StringUnpacked); err == nil {
+		t.Error("Expected an error for a non-ASCII string")
+	}
+	regions := m.FindRanges(func(address, value Word) bool { return value == 1 || value == 2 })
+	if len(regions) != 1 || regions[0] != (Region{0x100, 5}) {
+		t.Errorf("Unexpected ranges %v", regions)
+	}
+}
+
+func TestChecksums(t *testing.T) {
+	var m Memory
+	// "12345678", packed two bytes per word
+	copy(m.ram[0x10:], []Word{0x3132, 0x3334, 0x3536, 0x3738})
+	if crc := m.CRC16(0x10, 4); crc != 0xa12b {
+		t.Errorf("Unexpected CRC16 %#04x", crc)
+	}
+	if hash := m.FNV(0x10, 4); hash != 0x0aa8abcd {
+		t.Errorf("Unexpected FNV hash %#08x", hash)
+	}
+	if m.CRC16(0, 0) != 0xffff || m.FNV(0, 0) != 0x811c9dc5 {
+		t.Error("Unexpected checksums of an empty range")
+	}
+}
+
+type resetDevice struct {
+	testDevice
+	resets int
+}
+
+func (d *resetDevice) Reset() { d.resets++ }
+
+func TestReset(t *testing.T) {
+	state := new(State)
+	dev := new(resetDevice)
+	state.Devices = []Device{dev}
+	if err := state.LoadProgram([]Word{0x8801, 0x8b83}, 0); err != nil { // SET A, 1; SUB PC, 1
+		t.Fatal(err)
+	}
+	state.MemProtect(0x1000, 1, true)
+	state.TriggerInterrupt(5)
+	for i := 0; i < 4; i++ {
+		if err := state.StepCycle(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state.Reset()
+	if state.Registers != (Registers{}) || state.Cycles() != 0 || state.Ram.Load(0) != 0 || len(state.interrupts) != 0 {
+		t.Errorf("State was not reset; registers %v, cycles %d", state.Registers, state.Cycles())
+	}
+	if dev.resets != 1 {
+		t.Errorf("Expected the device to be reset once, found %d", dev.resets)
+	}
+	if err := state.Ram.Store(0x1000, 1); err == nil {
+		t.Error("Expected protected regions to survive a reset")
+	}
+}
+
+func TestStepInstruction(t *testing.T) {
+	state := new(State)
+	if err := state.LoadProgram([]Word{0x7c01, 0x0030, 0x8802}, 0); err != nil { // SET A, 0x30; ADD A, 1
+		t.Fatal(err)
+	}
+	if cycles, err := state.StepInstruction(); err != nil || cycles != 2 || !state.BetweenInstructions() {
+		t.Errorf("Unexpected first instruction; %d cycles (%v)", cycles, err)
+	}
+	if cycles, err := state.StepInstruction(); err != nil || cycles != 2 || state.A() != 0x31 {
+		t.Errorf("Unexpected second instruction; %d cycles, A %#x (%v)", cycles, state.A(), err)
+	}
+}
+
+func TestRunUntil(t *testing.T) {
+	program := []Word{
+		0x8802,         // :loop ADD A, 1
+		0x7c12, 0x0005, // IFE A, 5
+		0x8b83, // SUB PC, 1
+		0x8781, // SET PC, loop
+	}
+	state := new(State)
+	if err := state.LoadProgram(program, 0); err != nil {
+		t.Fatal(err)
+	}
+	if cycles, err := state.RunFor(5); err != nil || cycles != 5 {
+		t.Errorf("Unexpected RunFor; %d cycles (%v)", cycles, err)
+	}
+	_, err := state.RunUntil(func(s *State) bool { return s.A() == 3 }, 0)
+	if err != nil || state.A() != 3 || !state.BetweenInstructions() {
+		t.Errorf("Unexpected RunUntil; A %#x (%v)", state.A(), err)
+	}
+	if cycles, err := state.RunUntil(func(s *State) bool { return false }, 3); err != ErrCycleLimit || cycles != 3 {
+		t.Errorf("Expected to reach the cycle limit, found %d cycles (%v)", cycles, err)
+	}
+	if _, err := state.RunUntil(func(s *State) bool { return false }, 1000); err != ErrHalted || state.A() != 5 {
+		t.Errorf("Expected the program to halt, found A %#x (%v)", state.A(), err)
+	}
+}
@@ -0,0 +1,237 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Opcode identifies a decoded instruction, independent of the spec version
+// it was decoded from. Special opcodes are distinct from basic opcodes.
+type Opcode uint32
+
+// Special returns whether the opcode is a special (non-basic) opcode
+func (op Opcode) Special() bool {
+	return op >= opcodeExtendedOffset
+}
+
+// Valid returns whether the opcode is a known instruction
+func (op Opcode) Valid() bool {
+	_, ok := opcodeNames[op]
+	return ok
+}
+
+// Conditional returns whether the opcode is one of the IF instructions
+func (op Opcode) Conditional() bool {
+	return isConditional(uint32(op))
+}
+
+// String returns the mnemonic of the opcode
+func (op Opcode) String() string {
+	if name, ok := opcodeNames[op]; ok {
+		return name
+	}
+	if op.Special() {
+		return fmt.Sprintf("<special %#02x>", uint32(op-opcodeExtendedOffset))
+	}
+	return fmt.Sprintf("<opcode %#02x>", uint32(op))
+}
+
+// Code returns the 5-bit value the opcode is encoded with. For special
+// opcodes, this is the value stored in the b field of the instruction.
+func (op Opcode) Code() Word {
+	if op.Special() {
+		return Word(op - opcodeExtendedOffset)
+	}
+	return Word(op)
+}
+
+// OpcodeByName returns the 1.7 opcode with the given mnemonic, ignoring case
+func OpcodeByName(name string) (Opcode, bool) {
+	name = strings.ToUpper(name)
+	for op, n := range opcodeNames {
+		if n == name {
+			return op, true
+		}
+	}
+	return 0, false
+}
+
+var opcodeNames = map[Opcode]string{
+	opcodeSET: "SET", opcodeADD: "ADD", opcodeSUB: "SUB", opcodeMUL: "MUL",
+	opcodeMLI: "MLI", opcodeDIV: "DIV", opcodeDVI: "DVI", opcodeMOD: "MOD",
+	opcodeMDI: "MDI", opcodeAND: "AND", opcodeBOR: "BOR", opcodeXOR: "XOR",
+	opcodeSHR: "SHR", opcodeASR: "ASR", opcodeSHL: "SHL", opcodeIFB: "IFB",
+	opcodeIFC: "IFC", opcodeIFE: "IFE", opcodeIFN: "IFN", opcodeIFG: "IFG",
+	opcodeIFA: "IFA", opcodeIFL: "IFL", opcodeIFU: "IFU", opcodeADX: "ADX",
+	opcodeSBX: "SBX", opcodeSTI: "STI", opcodeSTD: "STD",
+	opcodeExtJSR: "JSR", opcodeExtINT: "INT", opcodeExtIAG: "IAG",
+	opcodeExtIAS: "IAS", opcodeExtRFI: "RFI", opcodeExtIAQ: "IAQ",
+	opcodeExtHWN: "HWN", opcodeExtHWQ: "HWQ", opcodeExtHWI: "HWI",
+}
+
+// OperandKind identifies the addressing mode of an operand
+type OperandKind int
+
+const (
+	OperandNone             OperandKind = iota // no operand
+	OperandRegister                            // register
+	OperandRegisterIndirect                    // [register]
+	OperandRegisterOffset                      // [register + next word]
+	OperandPush                                // PUSH / [--SP]
+	OperandPop                                 // POP / [SP++]
+	OperandPeek                                // PEEK / [SP]
+	OperandPick                                // PICK n / [SP + next word]
+	OperandIndirect                            // [next word]
+	OperandLiteral                             // next word or short literal
+)
+
+// Operand is a decoded instruction operand
+type Operand struct {
+	Kind     OperandKind
+	Register string // register name for the register kinds, including SP, PC and EX
+	Value    Word   // the next word or literal value
+	NextWord bool   // the operand consumes the word following the instruction
+}
+
+// String formats the operand as assembly
+func (o Operand) String() string {
+	switch o.Kind {
+	case OperandRegister:
+		return o.Register
+	case OperandRegisterIndirect:
+		return fmt.Sprintf("[%s]", o.Register)
+	case OperandRegisterOffset:
+		return fmt.Sprintf("[%#04x+%s]", o.Value, o.Register)
+	case OperandPush:
+		return "PUSH"
+	case OperandPop:
+		return "POP"
+	case OperandPeek:
+		return "PEEK"
+	case OperandPick:
+		return fmt.Sprintf("PICK %#x", o.Value)
+	case OperandIndirect:
+		return fmt.Sprintf("[%#04x]", o.Value)
+	case OperandLiteral:
+		return fmt.Sprintf("%#x", o.Value)
+	}
+	return ""
+}
+
+// Instruction is a decoded instruction
+type Instruction struct {
+	Opcode Opcode
+	A      Operand // the source operand, or the only operand of a special opcode
+	B      Operand // the destination operand; OperandNone for special opcodes
+	Cost   uint    // cycles to execute, including next words but not skips
+}
+
+// String formats the instruction as assembly, e.g. "SET [0x1000], A"
+func (inst Instruction) String() string {
+	if inst.B.Kind == OperandNone {
+		return fmt.Sprintf("%s %s", inst.Opcode, inst.A)
+	}
+	return fmt.Sprintf("%s %s, %s", inst.Opcode, inst.B, inst.A)
+}
+
+// Length returns the length of the instruction in words, including next words
+func (inst Instruction) Length() Word {
+	length := Word(1)
+	if inst.A.NextWord {
+		length++
+	}
+	if inst.B.NextWord {
+		length++
+	}
+	return length
+}
+
+// RegisterNames lists the names of the registers, in the order of Registers
+var RegisterNames = [registerCount]string{"A", "B", "C", "X", "Y", "Z", "I", "J", "SP", "PC", "EX", "IA"}
+
+// Decode decodes the 1.7 instruction at the start of words, returning the
+// instruction and its length in words. Next words missing from the end of
+// words are decoded as 0. Invalid opcodes are decoded with a Cost of 0.
+func Decode(words []Word) (Instruction, Word) {
+	return DecodeSpec(words, Spec17)
+}
+
+// DecodeSpec is like Decode, but decodes using the given spec version.
+func DecodeSpec(words []Word, spec SpecVersion) (Instruction, Word) {
+	var opcode Word
+	if len(words) > 0 {
+		opcode = words[0]
+	}
+	op, a, b := decodeOpcode(opcode, spec)
+	inst := Instruction{Opcode: Opcode(op)}
+	if cost, err := cycleCost(op, spec); err == nil {
+		inst.Cost = cost
+	}
+	length := Word(1)
+	nextWord := func() Word {
+		var w Word
+		if int(length) < len(words) {
+			w = words[length]
+		}
+		length++
+		return w
+	}
+	// next words follow the order the operands are evaluated in
+	if spec == Spec11 && !inst.Opcode.Special() {
+		inst.B = decodeOperand(b, false, spec, nextWord)
+		inst.A = decodeOperand(a, true, spec, nextWord)
+	} else {
+		inst.A = decodeOperand(a, true, spec, nextWord)
+		if !inst.Opcode.Special() {
+			inst.B = decodeOperand(b, false, spec, nextWord)
+		}
+	}
+	if inst.Cost > 0 {
+		inst.Cost += uint(length - 1)
+	}
+	return inst, length
+}
+
+func decodeOperand(operand uint32, isA bool, spec SpecVersion, nextWord func() Word) Operand {
+	var o Operand
+	if operandHasNextWord(operand, spec) {
+		o.NextWord = true
+		o.Value = nextWord()
+	}
+	switch {
+	case operand <= 0x07:
+		o.Kind, o.Register = OperandRegister, RegisterNames[operand]
+	case operand <= 0x0f:
+		o.Kind, o.Register = OperandRegisterIndirect, RegisterNames[operand-0x08]
+	case operand <= 0x17:
+		o.Kind, o.Register = OperandRegisterOffset, RegisterNames[operand-0x10]
+	case operand == 0x18:
+		if isA || spec == Spec11 {
+			o.Kind = OperandPop
+		} else {
+			o.Kind = OperandPush
+		}
+	case operand == 0x19:
+		o.Kind = OperandPeek
+	case operand == 0x1a:
+		if spec == Spec11 {
+			o.Kind = OperandPush
+		} else {
+			o.Kind = OperandPick
+		}
+	case operand <= 0x1d:
+		o.Kind, o.Register = OperandRegister, RegisterNames[operand-0x1b+registerSP]
+	case operand == 0x1e:
+		o.Kind = OperandIndirect
+	case operand == 0x1f:
+		o.Kind = OperandLiteral
+	default:
+		o.Kind = OperandLiteral
+		if spec == Spec11 {
+			o.Value = Word(operand) - 0x20
+		} else {
+			o.Value = Word(operand) - 0x21
+		}
+	}
+	return o
+}
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SnapshotDiff is the difference between two snapshots
+type SnapshotDiff struct {
+	Registers  []RegisterChange
+	Memory     []MemoryChange
+	OldCycles  uint64
+	NewCycles  uint64
+	Interrupts bool // the interrupt queue or queueing changed
+}
+
+// RegisterChange is a register whose value differs between snapshots
+type RegisterChange struct {
+	Name     string
+	Old, New Word
+}
+
+// MemoryChange is a run of consecutive words that differ between snapshots
+type MemoryChange struct {
+	Start    Word
+	Old, New []Word
+}
+
+// DiffSnapshots compares two snapshots taken by State.Snapshot, returning
+// the registers and memory ranges that changed from old to new. The state
+// of devices isn't compared.
+func DiffSnapshots(old, new []byte) (*SnapshotDiff, error) {
+	a, err := parseSnapshot(old)
+	if err != nil {
+		return nil, err
+	}
+	b, err := parseSnapshot(new)
+	if err != nil {
+		return nil, err
+	}
+	diff := &SnapshotDiff{OldCycles: a.Cycles, NewCycles: b.Cycles}
+	for i, name := range RegisterNames {
+		if a.Registers[i] != b.Registers[i] {
+			diff.Registers = append(diff.Registers, RegisterChange{name, a.Registers[i], b.Registers[i]})
+		}
+	}
+	for addr := 0; addr < len(a.ram); addr++ {
+		if a.ram[addr] == b.ram[addr] {
+			continue
+		}
+		end := addr + 1
+		for end < len(a.ram) && a.ram[end] != b.ram[end] {
+			end++
+		}
+		diff.Memory = append(diff.Memory, MemoryChange{
+			Start: Word(addr),
+			Old:   append([]Word(nil), a.ram[addr:end]...),
+			New:   append([]Word(nil), b.ram[addr:end]...),
+		})
+		addr = end
+	}
+	if a.Queueing != b.Queueing || len(a.interrupts) != len(b.interrupts) {
+		diff.Interrupts = true
+	} else {
+		for i := range a.interrupts {
+			if a.interrupts[i] != b.interrupts[i] {
+				diff.Interrupts = true
+				break
+			}
+		}
+	}
+	return diff, nil
+}
+
+// Empty returns whether the snapshots have the same registers, memory and
+// interrupts. The cycle counts may differ.
+func (d *SnapshotDiff) Empty() bool {
+	return len(d.Registers) == 0 && len(d.Memory) == 0 && !d.Interrupts
+}
+
+// String formats the diff one change per line, e.g.
+//
+//	A: 0000 -> 0030
+//	[1000]: 0000 0000 -> 0005 0006
+func (d *SnapshotDiff) String() string {
+	var lines []string
+	for _, r := range d.Registers {
+		lines = append(lines, fmt.Sprintf("%s: %04x -> %04x", r.Name, r.Old, r.New))
+	}
+	for _, m := range d.Memory {
+		lines = append(lines, fmt.Sprintf("[%04x]: %s -> %s", m.Start, formatWords(m.Old), formatWords(m.New)))
+	}
+	if d.Interrupts {
+		lines = append(lines, "interrupt queue changed")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatWords(words []Word) string {
+	strs := make([]string, len(words))
+	for i, w := range words {
+		strs[i] = fmt.Sprintf("%04x", w)
+	}
+	return strings.Join(strs, " ")
+}
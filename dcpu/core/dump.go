@@ -0,0 +1,180 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DumpOptions controls the output of DumpMemoryOptions
+type DumpOptions struct {
+	// Ranges are the regions to dump, in full. If nil, every row of memory
+	// with a non-zero or highlighted word is dumped.
+	Ranges []Region
+	// Width is the number of words per row; 0 means 8
+	Width int
+	// ASCII adds a column showing the low byte of each word as a character
+	ASCII bool
+	// Highlights are addresses to highlight, such as PC
+	Highlights []Word
+	// Labels names addresses, such as a program's symbols. Each label is
+	// written on a line of its own before the row holding its address.
+	Labels map[Word]string
+	// Disassemble, if non-nil, returns the disassembly of the instruction at
+	// address and its length in words. Each row then holds one instruction,
+	// followed by its disassembly, instead of Width words.
+	Disassemble func(m *Memory, address Word) (string, Word)
+}
+
+// DumpMemoryOptions writes rows of memory to w in the format
+// 0000: 1111 2222 3333 4444 5555 6666 7777 8888
+// as controlled by opts. Like DumpMemory, it shows the RAM underneath any
+// mapped regions.
+func (m *Memory) DumpMemoryOptions(w io.Writer, opts DumpOptions) error {
+	width := opts.Width
+	if width <= 0 {
+		width = 8
+	}
+	highlights := make(map[Word]bool, len(opts.Highlights))
+	for _, addr := range opts.Highlights {
+		highlights[addr] = true
+	}
+	d := dumper{m: m, opts: opts, highlights: highlights, w: bufio.NewWriter(w)}
+	ranges := opts.Ranges
+	if ranges == nil {
+		// all of memory, in two halves as a Region can't hold it
+		ranges = []Region{{0, 0x8000}, {0x8000, 0x8000}}
+	}
+	for _, r := range ranges {
+		end := int(r.Start) + int(r.Length)
+		for addr := int(r.Start); addr < end; {
+			length := width
+			if opts.Disassemble != nil {
+				_, l := opts.Disassemble(m, Word(addr))
+				length = int(l)
+				if length < 1 {
+					length = 1
+				}
+			}
+			if addr+length > end {
+				length = end - addr
+			}
+			if opts.Ranges != nil || d.interesting(addr, length) {
+				d.row(addr, length, width)
+			}
+			addr += length
+		}
+	}
+	return d.w.Flush()
+}
+
+type dumper struct {
+	m          *Memory
+	opts       DumpOptions
+	highlights map[Word]bool
+	w          *bufio.Writer
+}
+
+// interesting returns whether the row has a non-zero or highlighted word
+func (d *dumper) interesting(addr, length int) bool {
+	for i := addr; i < addr+length; i++ {
+		if d.m.ram[i] != 0 || d.highlights[Word(i)] {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *dumper) row(addr, length, width int) {
+	for i := addr; i < addr+length && d.opts.Labels != nil; i++ {
+		if label := d.opts.Labels[Word(i)]; label != "" {
+			fmt.Fprintf(d.w, "%s:\n", label)
+		}
+	}
+	fmt.Fprintf(d.w, "%04x:", addr)
+	columns := width
+	if d.opts.Disassemble != nil {
+		// no instruction is longer than 3 words
+		columns = 3
+	}
+	for i := addr; i < addr+length; i++ {
+		if d.highlights[Word(i)] {
+			fmt.Fprintf(d.w, " \033[44m%04x\033[m", d.m.ram[i])
+		} else {
+			fmt.Fprintf(d.w, " %04x", d.m.ram[i])
+		}
+	}
+	if d.opts.ASCII || d.opts.Disassemble != nil {
+		// pad short rows so the columns line up
+		d.w.WriteString(strings.Repeat("     ", padding(columns, length)))
+	}
+	if d.opts.ASCII {
+		d.w.WriteString("  |")
+		for i := addr; i < addr+length; i++ {
+			if ch := byte(d.m.ram[i]); ch >= 0x20 && ch < 0x7f {
+				d.w.WriteByte(ch)
+			} else {
+				d.w.WriteByte('.')
+			}
+		}
+		d.w.WriteByte('|')
+		if d.opts.Disassemble != nil {
+			d.w.WriteString(strings.Repeat(" ", padding(columns, length)))
+		}
+	}
+	if d.opts.Disassemble != nil {
+		text, _ := d.opts.Disassemble(d.m, Word(addr))
+		d.w.WriteString("  " + text)
+	}
+	d.w.WriteByte('\n')
+}
+
+// padding returns the number of columns left after length, or 0
+func padding(columns, length int) int {
+	if length > columns {
+		return 0
+	}
+	return columns - length
+}
+
+// SourceFormat is a language DumpSource can write memory in
+type SourceFormat int
+
+const (
+	SourceGo SourceFormat = iota // a []uint16 variable
+	SourceC                      // a const uint16_t array
+)
+
+// DumpSource writes the words of r to w as the declaration of an array
+// called name, in the given language, to embed a program in other
+// projects. Like DumpMemory, it shows the RAM underneath any mapped
+// regions.
+func (m *Memory) DumpSource(w io.Writer, r Region, format SourceFormat, name string) error {
+	bw := bufio.NewWriter(w)
+	switch format {
+	case SourceGo:
+		fmt.Fprintf(bw, "var %s = []uint16{\n", name)
+	case SourceC:
+		fmt.Fprintf(bw, "#include <stdint.h>\n\nconst uint16_t %s[%d] = {\n", name, r.Length)
+	default:
+		return fmt.Errorf("unknown source format %d", format)
+	}
+	end := int(r.Start) + int(r.Length)
+	for addr := int(r.Start); addr < end; addr += 8 {
+		bw.WriteString("\t")
+		for i := addr; i < addr+8 && i < end; i++ {
+			if i > addr {
+				bw.WriteString(" ")
+			}
+			fmt.Fprintf(bw, "0x%04x,", m.ram[i])
+		}
+		bw.WriteString("\n")
+	}
+	if format == SourceGo {
+		bw.WriteString("}\n")
+	} else {
+		bw.WriteString("};\n")
+	}
+	return bw.Flush()
+}
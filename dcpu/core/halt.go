@@ -0,0 +1,32 @@
+package core
+
+// Halted returns whether the State is spinning on a halt idiom, such as
+// "SET PC, <its own address>" or "SUB PC, 1". That is, the instruction it
+// just executed jumps to itself and has no other effect, and no interrupt
+// can arrive to break the loop. It's only meaningful between instructions.
+func (s *State) Halted() bool {
+	pc := s.PC()
+	if s.step != stateStepFetch || s.lastError != nil || pc != s.pc {
+		return false
+	}
+	if s.IA() != 0 && (len(s.interrupts) > 0 || len(s.Devices) > 0) {
+		// an interrupt handler could change PC
+		return false
+	}
+	words := [3]Word{s.Ram.Load(pc), s.Ram.Load(pc + 1), s.Ram.Load(pc + 2)}
+	inst, length := DecodeSpec(words[:], s.Spec)
+	if inst.B.Kind != OperandRegister || inst.B.Register != "PC" || inst.A.Kind != OperandLiteral {
+		return false
+	}
+	// PC has advanced past the instruction by the time it executes
+	next := pc + length
+	switch inst.Opcode {
+	case opcodeSET:
+		return inst.A.Value == pc
+	case opcodeADD:
+		return next+inst.A.Value == pc
+	case opcodeSUB:
+		return next-inst.A.Value == pc
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Device is a piece of hardware attached to the DCPU-16.
+// Devices are enumerated by the program with HWN and HWQ, and are sent
+// interrupts with HWI.
+type Device interface {
+	// ID returns the 32-bit hardware ID
+	ID() uint32
+	// Version returns the hardware version
+	Version() Word
+	// Manufacturer returns the 32-bit manufacturer ID
+	Manufacturer() uint32
+	// HandleInterrupt is called when the program sends HWI to the device.
+	// The device may read and modify the state. If an error is returned,
+	// the machine is halted.
+	HandleInterrupt(s *State) error
+}
+
+// PluggableDevice is implemented by devices that need to set up when
+// they're attached with AttachDevice, e.g. to map memory
+type PluggableDevice interface {
+	Device
+	// Attach is called before the device is attached. If it returns an
+	// error, the device isn't attached.
+	Attach(s *State) error
+	// Detach is called once the device is detached, and should undo Attach
+	Detach(s *State)
+}
+
+// TickDevice is implemented by devices that act on their own, e.g. to
+// raise interrupts or update mapped memory. Tick is called after every
+// cycle; if it returns an error, the machine is halted.
+type TickDevice interface {
+	Device
+	Tick(s *State) error
+}
+
+// AttachDevice attaches dev, returning its hardware number. If dev is a
+// PluggableDevice, it's attached first.
+func (s *State) AttachDevice(dev Device) (Word, error) {
+	if len(s.Devices) >= 0xffff {
+		return 0, errors.New("AttachDevice: too many devices")
+	}
+	for _, d := range s.Devices {
+		if d == dev {
+			return 0, errors.New("AttachDevice: device is already attached")
+		}
+	}
+	if pd, ok := dev.(PluggableDevice); ok {
+		if err := pd.Attach(s); err != nil {
+			return 0, err
+		}
+	}
+	s.Devices = append(s.Devices, dev)
+	return Word(len(s.Devices) - 1), nil
+}
+
+// DetachDevice detaches dev. The devices after it are renumbered, so
+// programs should enumerate them again with HWN and HWQ.
+func (s *State) DetachDevice(dev Device) error {
+	for i, d := range s.Devices {
+		if d == dev {
+			s.Devices = append(s.Devices[:i], s.Devices[i+1:]...)
+			if pd, ok := dev.(PluggableDevice); ok {
+				pd.Detach(s)
+			}
+			return nil
+		}
+	}
+	return errors.New("DetachDevice: device is not attached")
+}
+
+// tickDevices ticks the attached TickDevices
+func (s *State) tickDevices() error {
+	for i, dev := range s.Devices {
+		if td, ok := dev.(TickDevice); ok {
+			if err := td.Tick(s); err != nil {
+				s.lastError = &DeviceError{Word(i), err}
+				return s.lastError
+			}
+		}
+	}
+	return nil
+}
+
+// queryDevice implements HWQ, loading the device information into
+// A, B, C, X and Y. Registers are zeroed if there is no such device.
+func (s *State) queryDevice(index Word) {
+	var id, manufacturer uint32
+	var version Word
+	if int(index) < len(s.Devices) {
+		dev := s.Devices[index]
+		id, version, manufacturer = dev.ID(), dev.Version(), dev.Manufacturer()
+	}
+	s.SetA(Word(id))
+	s.SetB(Word(id >> 16))
+	s.SetC(version)
+	s.SetX(Word(manufacturer))
+	s.SetY(Word(manufacturer >> 16))
+}
+
+// DeviceError is returned by StepCycle when a device's HandleInterrupt
+// fails
+type DeviceError struct {
+	Index Word // the hardware number of the device
+	Err   error
+}
+
+func (err *DeviceError) Error() string {
+	return fmt.Sprintf("device %d: %v", err.Index, err.Err)
+}
+
+// interruptDevice implements HWI. Interrupting a missing device does nothing.
+func (s *State) interruptDevice(index Word) error {
+	if int(index) >= len(s.Devices) {
+		return nil
+	}
+	if err := s.Devices[index].HandleInterrupt(s); err != nil {
+		return &DeviceError{index, err}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package core
+
+// history is a ring buffer of the changes made by recent instructions
+type history struct {
+	records []historyRecord
+	next    int // the slot of the next record
+	count   int // the number of valid records
+}
+
+// historyRecord holds what's needed to undo a single instruction
+type historyRecord struct {
+	regs       Registers
+	cycles     uint64
+	queueing   bool
+	interrupts []Word // the interrupt queue, reused between records
+	writes     []memoryWrite
+}
+
+type memoryWrite struct {
+	address, old Word
+}
+
+// EnableHistory keeps enough history to undo the last n instructions with
+// StepBack. Passing 0 disables history. Changing the size discards any
+// existing history.
+//
+// Only changes made by the CPU are recorded. Memory written directly by
+// devices, for example in response to HWI, isn't restored.
+func (s *State) EnableHistory(n int) {
+	if n <= 0 {
+		s.history = nil
+		return
+	}
+	s.history = &history{records: make([]historyRecord, n)}
+}
+
+// HistoryLen returns the number of instructions that can be undone
+func (s *State) HistoryLen() int {
+	if s.history == nil {
+		return 0
+	}
+	return s.history.count
+}
+
+// StepBack undoes up to n instructions, returning the number undone.
+// A partially executed instruction is undone first, and counts towards n.
+// Stepping back clears any error the State halted with.
+func (s *State) StepBack(n int) int {
+	h := s.history
+	if h == nil {
+		return 0
+	}
+	undone := 0
+	for ; undone < n && h.count > 0; undone++ {
+		h.next = (h.next + len(h.records) - 1) % len(h.records)
+		h.count--
+		rec := &h.records[h.next]
+		for i := len(rec.writes) - 1; i >= 0; i-- {
+			w := rec.writes[i]
+			s.Ram.Store(w.address, w.old)
+		}
+		s.Registers = rec.regs
+		s.cycles = rec.cycles
+		s.queueing = rec.queueing
+		s.interrupts = append(s.interrupts[:0], rec.interrupts...)
+	}
+	if undone > 0 {
+		s.step = stateStepFetch
+		s.lastError = nil
+		s.watchHit = nil
+		s.selfModifyHit = nil
+		s.breakResume = false
+	}
+	return undone
+}
+
+// beginRecord starts recording a new instruction, overwriting the oldest
+// record if the history is full
+func (h *history) beginRecord(s *State) {
+	rec := &h.records[h.next]
+	rec.regs = s.Registers
+	// the cycle that's fetching the instruction has already been counted
+	rec.cycles = s.cycles - 1
+	rec.queueing = s.queueing
+	rec.interrupts = append(rec.interrupts[:0], s.interrupts...)
+	rec.writes = rec.writes[:0]
+	h.next = (h.next + 1) % len(h.records)
+	if h.count < len(h.records) {
+		h.count++
+	}
+}
+
+// recordWrite records the previous value of a memory write
+func (h *history) recordWrite(address, old Word) {
+	rec := &h.records[(h.next+len(h.records)-1)%len(h.records)]
+	rec.writes = append(rec.writes, memoryWrite{address, old})
+}
@@ -0,0 +1,71 @@
+package core
+
+// StepHook is called after each instruction executes, with the address the
+// instruction was fetched from, the instruction as decoded at the time it
+// was fetched, and the number of cycles the State has run, including this
+// instruction. Skipped instructions don't invoke the hook.
+type StepHook func(s *State, pc Word, inst Instruction, cycles uint64)
+
+// Cycles returns the number of cycles the State has run
+func (s *State) Cycles() uint64 {
+	return s.cycles
+}
+
+// FetchRegisters returns the registers as they were when the current
+// instruction was fetched. It's only meaningful within a StepHook.
+func (s *State) FetchRegisters() Registers {
+	return s.hookRegs
+}
+
+// decodeForHook decodes the instruction at PC for the StepHook
+func (s *State) decodeForHook() {
+	s.hookRegs = s.Registers
+	pc := s.PC()
+	words := [3]Word{s.Ram.Load(pc), s.Ram.Load(pc + 1), s.Ram.Load(pc + 2)}
+	s.hookInst, _ = DecodeSpec(words[:], s.Spec)
+}
+
+// finishInstruction is called once an instruction has executed. It invokes
+// the StepHook and reports any watchpoint or self-modifying write the
+// instruction triggered.
+func (s *State) finishInstruction() error {
+	if s.StepHook != nil {
+		s.StepHook(s, s.pc, s.hookInst, s.cycles)
+	}
+	if s.watchHit != nil {
+		err := s.watchHit
+		s.watchHit = nil
+		return err
+	}
+	if s.selfModifyHit != nil {
+		err := s.selfModifyHit
+		s.selfModifyHit = nil
+		return err
+	}
+	return nil
+}
+
+// AccessHook is called when an instruction reads or writes memory, with
+// kind WatchRead or WatchWrite, the address, and the value before and after
+// the access. Accesses by devices and debuggers don't invoke the hook.
+type AccessHook func(s *State, kind WatchKind, address, old, new Word)
+
+// BetweenInstructions returns whether the State has finished an instruction
+// and not yet started the next one
+func (s *State) BetweenInstructions() bool {
+	return s.step == stateStepFetch
+}
+
+// StepInstruction steps cycles until the current instruction has finished,
+// returning the number of cycles run. Errors are returned as from StepCycle.
+func (s *State) StepInstruction() (uint64, error) {
+	start := s.cycles
+	for {
+		if err := s.StepCycle(); err != nil {
+			return s.cycles - start, err
+		}
+		if s.BetweenInstructions() {
+			return s.cycles - start, nil
+		}
+	}
+}
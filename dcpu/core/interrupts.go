@@ -0,0 +1,68 @@
+package core
+
+import (
+	"errors"
+)
+
+// ErrInterruptOverflow is returned when more than maxQueuedInterrupts
+// interrupts are queued at once. The spec says the DCPU-16 catches fire.
+var ErrInterruptOverflow = errors.New("interrupt queue overflow")
+
+const maxQueuedInterrupts = 256
+
+// TriggerInterrupt raises an interrupt with the given message.
+// If IA is 0 the interrupt is dropped. Otherwise it is queued and will be
+// handled between instructions, one interrupt per instruction.
+// This must be called from the same goroutine that calls StepCycle.
+func (s *State) TriggerInterrupt(message Word) {
+	if s.IA() == 0 {
+		return
+	}
+	if len(s.interrupts) >= maxQueuedInterrupts {
+		s.lastError = ErrInterruptOverflow
+		return
+	}
+	s.interrupts = append(s.interrupts, message)
+}
+
+// handleInterrupt dispatches the next queued interrupt, if any.
+// The handler is entered with queueing enabled, PC and A pushed to the stack,
+// PC set to IA and A set to the interrupt message.
+func (s *State) handleInterrupt() {
+	if s.queueing || len(s.interrupts) == 0 {
+		return
+	}
+	message := s.interrupts[0]
+	copy(s.interrupts, s.interrupts[1:])
+	s.interrupts = s.interrupts[:len(s.interrupts)-1]
+	if s.IA() == 0 {
+		// IA was cleared after the interrupt was queued
+		return
+	}
+	s.queueing = true
+	if err := s.push(s.PC()); err != nil {
+		s.lastError = err
+		return
+	}
+	if err := s.push(s.A()); err != nil {
+		s.lastError = err
+		return
+	}
+	s.SetPC(s.IA())
+	s.SetA(message)
+}
+
+// push stores the value at [--SP]
+func (s *State) push(value Word) error {
+	s.DecrSP()
+	return s.storeAddress(Address{addressTypeMemory, s.SP()}, value)
+}
+
+// pop returns [SP++]
+func (s *State) pop() Word {
+	address := Address{addressTypeMemory, s.SP()}
+	val := s.loadAddress(address)
+	s.noteRead(address, val)
+	s.IncrSP()
+	return val
+}
@@ -3,15 +3,22 @@ package core
 import (
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"sort"
+	"strconv"
+	"strings"
 )
 
 type ProtectionError struct {
 	Address Word
+	Read    bool // the CPU read from a read-protected region, rather than writing
 }
 
 func (err *ProtectionError) Error() string {
+	if err.Read {
+		return fmt.Sprintf("read protection violation at address %#x", err.Address)
+	}
 	return fmt.Sprintf("protection violation at address %#x", err.Address)
 }
 
@@ -20,35 +27,64 @@ var ErrOutOfBounds = errors.New("out of bounds")
 type Memory struct {
 	ram       [0x10000]Word
 	protected []Region
-	mapped    []MMIORegion
+	// regions that fault when the CPU reads them, as well as writes
+	readProtected []Region
+	mapped        []MMIORegion
+	// a bit for each mapped word, so unmapped words skip searching mapped
+	isMapped [0x10000 / 64]uint64
 }
 
-func (m *Memory) Load(offset Word) Word {
-	for _, region := range m.mapped {
-		if region.Contains(offset) {
-			return region.get(offset - region.Start)
+// wordMapped returns whether offset is in a mapped region
+func (m *Memory) wordMapped(offset Word) bool {
+	return m.isMapped[offset/64]&(1<<(offset%64)) != 0
+}
+
+// markMapped sets or clears the mapped bits of the region
+func (m *Memory) markMapped(r Region, mapped bool) {
+	for i := int(r.Start); i < int(r.Start)+int(r.Length); i++ {
+		if mapped {
+			m.isMapped[i/64] |= 1 << uint(i%64)
+		} else {
+			m.isMapped[i/64] &^= 1 << uint(i%64)
 		}
 	}
+}
+
+func (m *Memory) Load(offset Word) Word {
+	if m.wordMapped(offset) {
+		region := &m.mapped[m.mappedAt(offset)]
+		return region.Get(offset - region.Start)
+	}
 	return m.ram[offset]
 }
 
 func (m *Memory) Store(offset, value Word) error {
-	for _, region := range m.mapped {
-		if region.Contains(offset) {
-			return region.set(offset-region.Start, value)
-		}
+	if m.wordMapped(offset) {
+		region := &m.mapped[m.mappedAt(offset)]
+		return region.Set(offset-region.Start, value)
 	}
-	for _, region := range m.protected {
-		if region.Contains(offset) {
-			return &ProtectionError{offset}
-		} else if region.Start > offset {
-			break
-		}
+	if i := searchRegions(m.protected, offset); i >= 0 && m.protected[i].Contains(offset) {
+		return &ProtectionError{Address: offset}
 	}
 	m.ram[offset] = value
 	return nil
 }
 
+// mappedAt returns the index of the mapped region containing offset, which
+// must be mapped
+func (m *Memory) mappedAt(offset Word) int {
+	i := sort.Search(len(m.mapped), func(i int) bool { return m.mapped[i].Start > offset })
+	return i - 1
+}
+
+// searchRegions returns the index of the last of the sorted regions
+// starting at or before offset, or -1. That's the only one that can
+// contain offset.
+func searchRegions(regions []Region, offset Word) int {
+	i := sort.Search(len(regions), func(i int) bool { return regions[i].Start > offset })
+	return i - 1
+}
+
 // GetSlice is intended for testing purposes
 func (m Memory) GetSlice(start, end Word) []Word {
 	return m.ram[start:end]
@@ -60,7 +96,13 @@ type Region struct {
 }
 
 func (r Region) Contains(address Word) bool {
-	return address >= r.Start && address < r.Start+r.Length
+	// compare as ints, as a region at the top of memory ends at 0x10000
+	return address >= r.Start && int(address) < int(r.Start)+int(r.Length)
+}
+
+// Overlaps returns whether the regions share any addresses
+func (r Region) Overlaps(r2 Region) bool {
+	return int(r.Start) < int(r2.Start)+int(r2.Length) && int(r2.Start) < int(r.Start)+int(r.Length)
 }
 
 // End() returns the first address not contained in the region
@@ -83,34 +125,112 @@ func (r Region) Union(r2 Region) Region {
 	return reg
 }
 
+// ParseRegion parses a region of the form addr+length, such as 0x8000+384,
+// as the command line gives them. The region must be in memory, and not
+// empty.
+func ParseRegion(str string) (Region, error) {
+	i := strings.Index(str, "+")
+	if i < 0 {
+		return Region{}, fmt.Errorf("expected addr+length, found %#v", str)
+	}
+	start, err := strconv.ParseUint(str[:i], 0, 16)
+	if err != nil {
+		return Region{}, fmt.Errorf("invalid address %#v", str[:i])
+	}
+	length, err := strconv.ParseUint(str[i+1:], 0, 16)
+	if err != nil || length == 0 || start+length > 0x10000 {
+		return Region{}, fmt.Errorf("invalid length %#v", str[i+1:])
+	}
+	return Region{Word(start), Word(length)}, nil
+}
+
+// MMIORegion is a region of memory mapped to functions, as with MapRegion
 type MMIORegion struct {
 	Region
-	get func(address Word) Word
-	set func(address, val Word) error
+	Owner string // who mapped the region, for diagnostics; may be empty
+	Get   func(address Word) Word
+	Set   func(address, val Word) error
+	Read  func(address Word) // may be nil
+}
+
+// String returns the region's owner and range
+func (r MMIORegion) String() string {
+	owner := r.Owner
+	if owner == "" {
+		owner = "mapped region"
+	}
+	return fmt.Sprintf("%s at %#04x-%#04x", owner, r.Start, int(r.Start)+int(r.Length)-1)
+}
+
+// RegionConflictError is returned when mapping a region that overlaps one
+// already mapped
+type RegionConflictError struct {
+	Region   Region     // the region that couldn't be mapped
+	Existing MMIORegion // the mapped region it overlaps
+}
+
+func (err *RegionConflictError) Error() string {
+	return fmt.Sprintf("MapRegion: region %#04x-%#04x conflicts with %v", err.Region.Start, int(err.Region.Start)+int(err.Region.Length)-1, err.Existing)
 }
 
 // MapRegion maps a region of memory to a pair of get/set functions.
 // If set returns an error, the machine is halted.
 // The address in both functions is relative to the start of the region.
 func (m *Memory) MapRegion(start, length Word, get func(address Word) Word, set func(address, val Word) error) error {
+	return m.MapRegionNotify(start, length, get, set, nil)
+}
+
+// MapRegionNotify is like MapRegion, but also calls read whenever an
+// instruction reads from the region. get is also used by debuggers and to
+// load the destination of instructions like SET, so devices that react to
+// reads, such as ring buffers, should do so in read instead. read is called
+// after get, and may be nil.
+func (m *Memory) MapRegionNotify(start, length Word, get func(address Word) Word, set func(address, val Word) error, read func(address Word)) error {
+	return m.MapRegionOwned("", start, length, get, set, read)
+}
+
+// MapRegionOwned is like MapRegionNotify, but names the owner of the region
+// for MappedRegions and the errors of conflicting mappings. If the region
+// overlaps one already mapped, a *RegionConflictError is returned.
+func (m *Memory) MapRegionOwned(owner string, start, length Word, get func(address Word) Word, set func(address, val Word) error, read func(address Word)) error {
 	if int(start)+int(length) > len(m.ram) {
 		return ErrOutOfBounds
 	}
-	for _, region := range m.mapped {
-		if region.Contains(start) || region.Contains(length) {
-			return errors.New("MapRegion: this region conflicts with an existing mapped region")
-		} else if region.Start > start+length {
-			break
+	// regions are kept sorted, so only the neighbours can overlap
+	i := sort.Search(len(m.mapped), func(i int) bool { return m.mapped[i].Start >= start })
+	for _, j := range [2]int{i - 1, i} {
+		if j >= 0 && j < len(m.mapped) && m.mapped[j].Overlaps(Region{start, length}) {
+			return &RegionConflictError{Region{start, length}, m.mapped[j]}
 		}
 	}
-	m.mapped = append(m.mapped, MMIORegion{
+	m.mapped = append(m.mapped, MMIORegion{})
+	copy(m.mapped[i+1:], m.mapped[i:])
+	m.mapped[i] = MMIORegion{
 		Region: Region{start, length},
-		get:    get,
-		set:    set,
-	})
+		Owner:  owner,
+		Get:    get,
+		Set:    set,
+		Read:   read,
+	}
+	m.markMapped(Region{start, length}, true)
 	return nil
 }
 
+// MappedRegions returns the mapped regions, in address order
+func (m *Memory) MappedRegions() []MMIORegion {
+	return append([]MMIORegion(nil), m.mapped...)
+}
+
+// notifyRead calls the read function of the mapped region containing offset
+func (m *Memory) notifyRead(offset Word) {
+	if !m.wordMapped(offset) {
+		return
+	}
+	if region := &m.mapped[m.mappedAt(offset)]; region.Read != nil {
+		region.Read(offset - region.Start)
+	}
+}
+
 // UnampRegion only unmaps if the region precisely matches an existing mapped region
 func (m *Memory) UnmapRegion(start, length Word) error {
 	if int(start)+int(length) > len(m.ram) {
@@ -119,10 +239,9 @@ func (m *Memory) UnmapRegion(start, length Word) error {
 	for i, region := range m.mapped {
 		if region.Start == start && region.Length == length {
 			// this is the one
-			copy(m.mapped[i:], m.mapped[i+1:])
+			m.mapped = append(m.mapped[:i], m.mapped[i+1:]...)
+			m.markMapped(region.Region, false)
 			return nil
-		} else if region.Start > start {
-			break
 		}
 	}
 	return errors.New("UnmapRegion: no region matches the input")
@@ -133,57 +252,13 @@ func (m *Memory) UnmapRegion(start, length Word) error {
 // highlights is a slice of addresses that should be highlighted
 // when emitted. Primarily intended for highlighting PC. Note that
 // an otherwise-zero row will still be emitted if a word needs to
-// be highlighted.
+// be highlighted. See DumpMemoryOptions for more control.
 func (m *Memory) DumpMemory(w io.Writer, highlights []int) error {
-	var hslice []int
-	hnext := -1
-	if len(highlights) > 0 {
-		// copy and sort the highlights
-		hslice = make([]int, len(highlights))
-		copy(hslice, highlights)
-		sort.Ints(hslice)
-		hnext = hslice[0]
-		hslice = hslice[1:]
-	}
-	const width = 8
-	for i, j := 0, width; j < len(m.ram); i, j = i+width, j+width {
-		var nonzero bool
-		if hnext >= i && hnext < j {
-			nonzero = true
-		} else {
-			// test the memory for a non-zero
-			for k := i; k < j; k++ {
-				if m.ram[k] != 0 {
-					nonzero = true
-					break
-				}
-			}
-		}
-		if nonzero {
-			// print the row
-			if _, err := io.WriteString(w, fmt.Sprintf("%04x:", i)); err != nil {
-				return err
-			}
-			for k := i; k < j; k++ {
-				start, end := "", ""
-				if hnext == k {
-					start = "\033[44m"
-					end = "\033[m"
-					if len(hslice) > 0 {
-						hnext = hslice[0]
-						hslice = hslice[1:]
-					}
-				}
-				if _, err := io.WriteString(w, fmt.Sprintf(" %s%04x%s", start, m.ram[k], end)); err != nil {
-					return err
-				}
-			}
-			if _, err := w.Write([]byte{'\n'}); err != nil {
-				return err
-			}
-		}
+	opts := DumpOptions{Highlights: make([]Word, len(highlights))}
+	for i, addr := range highlights {
+		opts.Highlights[i] = Word(addr)
 	}
-	return nil
+	return m.DumpMemoryOptions(w, opts)
 }
 
 // LoadProgram loads a program from the given slice into Ram at the given offset.
@@ -196,68 +271,125 @@ func (s *State) LoadProgram(input []Word, offset Word) error {
 	return nil
 }
 
+// DecodeBytes converts the bytes of a binary program into words, taking
+// each pair as big endian, or little endian if little is set. An odd byte
+// at the end is dropped.
+func DecodeBytes(data []byte, little bool) []Word {
+	words := make([]Word, len(data)/2)
+	for i := range words {
+		b1, b2 := Word(data[i*2]), Word(data[i*2+1])
+		if little {
+			words[i] = b2<<8 + b1
+		} else {
+			words[i] = b1<<8 + b2
+		}
+	}
+	return words
+}
+
 // MemProtect marks a region of memory as protected (or unprotected).
 // Returns ErrOutOfBounds if the region exceeds the bounds of Ram.
 func (s *State) MemProtect(offset, length Word, protected bool) error {
+	if int(offset)+int(length) > len(s.Ram.ram) {
+		return ErrOutOfBounds
+	}
+	s.Ram.protected = updateRegions(s.Ram.protected, Region{offset, length}, protected)
+	return nil
+}
+
+// MemProtectReads marks a region of memory as read-protected (or not). The
+// CPU faults with a ProtectionError when an instruction reads from the
+// region, e.g. to catch programs reading uninitialized memory. Writes are
+// faulted too, so the region is also protected as with MemProtect; removing
+// read protection leaves that in place.
+func (s *State) MemProtectReads(offset, length Word, protected bool) error {
 	if int(offset)+int(length) > len(s.Ram.ram) {
 		return ErrOutOfBounds
 	}
 	if protected {
-		if s.Ram.protected == nil {
-			s.Ram.protected = []Region{{offset, length}}
-		} else {
-			// try to unify with any existing regions
-			// we'd use a range expression but we might have to delete entries
-			for i := 0; i < len(s.Ram.protected); i++ {
-				region := &s.Ram.protected[i]
-				if region.Start > offset+length {
-					// we've found our insertion point
-					s.Ram.protected = append(s.Ram.protected, Region{})
-					copy(s.Ram.protected[i+1:], s.Ram.protected[i:])
-					s.Ram.protected[i] = Region{offset, length}
-					break
-				}
-				if region.End() > offset {
-					// we've found overlap
-					if i+1 < len(s.Ram.protected) && s.Ram.protected[i+1].Start < offset+length {
-						// we're bridging two protected regions. Unify them
-						next := s.Ram.protected[i+1]
-						region.Length = next.End() - region.Start
-						copy(s.Ram.protected[i+1:], s.Ram.protected[i+2:])
-						s.Ram.protected = s.Ram.protected[:len(s.Ram.protected)-1]
-					} else {
-						// we're extending a region
-						*region = region.Union(Region{offset, length})
-					}
-					break
-				}
+		s.MemProtect(offset, length, true)
+	}
+	s.Ram.readProtected = updateRegions(s.Ram.readProtected, Region{offset, length}, protected)
+	if len(s.Ram.readProtected) == 0 {
+		s.Ram.readProtected = nil
+	}
+	return nil
+}
+
+// isReadProtected returns whether the CPU faults reading offset
+func (m *Memory) isReadProtected(offset Word) bool {
+	i := searchRegions(m.readProtected, offset)
+	return i >= 0 && m.readProtected[i].Contains(offset)
+}
+
+// updateRegions adds r to or removes it from the sorted, disjoint regions,
+// returning the new regions. Adjacent and overlapping regions are merged.
+func updateRegions(regions []Region, r Region, add bool) []Region {
+	start, end := int(r.Start), int(r.Start)+int(r.Length)
+	var updated []Region
+	for _, region := range regions {
+		rstart, rend := int(region.Start), int(region.Start)+int(region.Length)
+		if add && rend >= start && rstart <= end {
+			// merge it into r
+			if rstart < start {
+				start = rstart
+			}
+			if rend > end {
+				end = rend
 			}
+			continue
 		}
-	} else if s.Ram.protected != nil {
-		// we'd use a range expression but we might end up deleting the current entry
-		for i := 0; i < len(s.Ram.protected); i++ {
-			region := &s.Ram.protected[i]
-			if region.Start > offset+length {
-				break
+		if !add && rend > start && rstart < end {
+			// keep the parts outside r
+			if rstart < start {
+				updated = append(updated, Region{region.Start, Word(start - rstart)})
 			}
-			if region.End() > offset {
-				// we've got at least partial overlap
-				if region.Start >= offset {
-					// region is starting inside the area
-					if !region.Contains(offset + length) {
-						// total overlap
-						copy(s.Ram.protected[i:], s.Ram.protected[i+1:])
-						s.Ram.protected = s.Ram.protected[:len(s.Ram.protected)-1]
-					} else {
-						// region extends past our end
-						region.Start, region.Length = offset+length, region.End()-(offset+length)
-					}
+			if rend > end {
+				updated = append(updated, Region{Word(end), Word(rend - end)})
+			}
+			continue
+		}
+		updated = append(updated, region)
+	}
+	if add && end-start == 0x10000 {
+		// a Region can't hold all of memory, so split it in two
+		updated = append(updated, Region{0, 0x8000}, Region{0x8000, 0x8000})
+	} else if add && end > start {
+		updated = append(updated, Region{Word(start), Word(end - start)})
+	}
+	sort.Slice(updated, func(i, j int) bool { return updated[i].Start < updated[j].Start })
+	return updated
+}
+
+// CRC16 returns the CRC-16/CCITT-FALSE checksum of length words starting
+// at start, with each word taken as two bytes, high byte first. Addresses
+// wrap around the end of memory, and mapped regions are read as the CPU
+// sees them.
+func (m *Memory) CRC16(start, length Word) Word {
+	crc := Word(0xffff)
+	for i := Word(0); i < length; i++ {
+		w := m.Load(start + i)
+		for _, b := range [2]Word{w >> 8, w & 0xff} {
+			crc ^= b << 8
+			for bit := 0; bit < 8; bit++ {
+				if crc&0x8000 != 0 {
+					crc = crc<<1 ^ 0x1021
 				} else {
-					// region starts before our area and extends into it
-					region.Length = offset - region.Start
+					crc <<= 1
 				}
 			}
 		}
 	}
-	return nil
+	return crc
+}
+
+// FNV returns the 32-bit FNV-1a hash of length words starting at start,
+// with the same byte order and addressing as CRC16
+func (m *Memory) FNV(start, length Word) uint32 {
+	h := fnv.New32a()
+	for i := Word(0); i < length; i++ {
+		w := m.Load(start + i)
+		h.Write([]byte{byte(w >> 8), byte(w)})
+	}
+	return h.Sum32()
 }
@@ -2,30 +2,58 @@ package core
 
 // basic opcodes
 const (
-	opcodeSET = 0x1
-	opcodeADD = 0x2
-	opcodeSUB = 0x3
-	opcodeMUL = 0x4
-	opcodeDIV = 0x5
-	opcodeMOD = 0x6
-	opcodeSHL = 0x7
-	opcodeSHR = 0x8
-	opcodeAND = 0x9
-	opcodeBOR = 0xa
-	opcodeXOR = 0xb
-	opcodeIFE = 0xc
-	opcodeIFN = 0xd
-	opcodeIFG = 0xe
-	opcodeIFB = 0xf
+	opcodeSET = 0x01
+	opcodeADD = 0x02
+	opcodeSUB = 0x03
+	opcodeMUL = 0x04
+	opcodeMLI = 0x05
+	opcodeDIV = 0x06
+	opcodeDVI = 0x07
+	opcodeMOD = 0x08
+	opcodeMDI = 0x09
+	opcodeAND = 0x0a
+	opcodeBOR = 0x0b
+	opcodeXOR = 0x0c
+	opcodeSHR = 0x0d
+	opcodeASR = 0x0e
+	opcodeSHL = 0x0f
+	opcodeIFB = 0x10
+	opcodeIFC = 0x11
+	opcodeIFE = 0x12
+	opcodeIFN = 0x13
+	opcodeIFG = 0x14
+	opcodeIFA = 0x15
+	opcodeIFL = 0x16
+	opcodeIFU = 0x17
+	opcodeADX = 0x1a
+	opcodeSBX = 0x1b
+	opcodeSTI = 0x1e
+	opcodeSTD = 0x1f
 )
 
-// non-basic opcodes
+// special opcodes
 const (
-	opcodeJSR = 0x1
+	opcodeJSR = 0x01
+	opcodeINT = 0x08
+	opcodeIAG = 0x09
+	opcodeIAS = 0x0a
+	opcodeRFI = 0x0b
+	opcodeIAQ = 0x0c
+	opcodeHWN = 0x10
+	opcodeHWQ = 0x11
+	opcodeHWI = 0x12
 )
 
-// extended non-basic opcodes (internal representation)
+// extended special opcodes (internal representation)
 const (
-	opcodeExtJSR = 0x101
+	opcodeExtJSR = opcodeExtendedOffset + opcodeJSR
+	opcodeExtINT = opcodeExtendedOffset + opcodeINT
+	opcodeExtIAG = opcodeExtendedOffset + opcodeIAG
+	opcodeExtIAS = opcodeExtendedOffset + opcodeIAS
+	opcodeExtRFI = opcodeExtendedOffset + opcodeRFI
+	opcodeExtIAQ = opcodeExtendedOffset + opcodeIAQ
+	opcodeExtHWN = opcodeExtendedOffset + opcodeHWN
+	opcodeExtHWQ = opcodeExtendedOffset + opcodeHWQ
+	opcodeExtHWI = opcodeExtendedOffset + opcodeHWI
 )
 const opcodeExtendedOffset = 0x100
@@ -1,5 +1,7 @@
 package core
 
+import "strings"
+
 const (
 	registerA = iota
 	registerB
@@ -11,7 +13,8 @@ const (
 	registerJ
 	registerSP
 	registerPC
-	registerO
+	registerEX
+	registerIA
 	registerCount
 )
 
@@ -109,10 +112,28 @@ func (r *Registers) IncrPC() {
 	r.SetPC(r.PC() + 1)
 }
 
-func (r *Registers) O() Word {
-	return r[registerO]
+func (r *Registers) EX() Word {
+	return r[registerEX]
+}
+
+func (r *Registers) SetEX(value Word) {
+	r[registerEX] = value
+}
+
+func (r *Registers) IA() Word {
+	return r[registerIA]
+}
+
+func (r *Registers) SetIA(value Word) {
+	r[registerIA] = value
 }
 
-func (r *Registers) SetO(value Word) {
-	r[registerO] = value
+// Register returns the value of the named register, ignoring case
+func (r *Registers) Register(name string) (Word, bool) {
+	for i, n := range RegisterNames {
+		if strings.EqualFold(n, name) {
+			return r[i], true
+		}
+	}
+	return 0, false
 }
@@ -0,0 +1,40 @@
+package core
+
+// ResetDevice is implemented by devices with state that should be cleared
+// when the State is reset
+type ResetDevice interface {
+	Device
+	Reset()
+}
+
+// Reset returns the State to power-on: the registers, RAM, interrupt queue,
+// cycle count and any error are cleared, and attached ResetDevices are
+// reset. Configuration is kept, including the spec, policies, hooks,
+// devices, breakpoints, watchpoints and protected and mapped regions.
+// History is discarded.
+func (s *State) Reset() {
+	s.Registers = Registers{}
+	s.Ram.ram = [0x10000]Word{}
+	s.lastError = nil
+	s.step = stateStepFetch
+	s.cycleCost = 0
+	s.op, s.a, s.b = 0, 0, 0
+	s.delayed = false
+	s.address = Address{}
+	s.queueing = false
+	s.interrupts = nil
+	s.pc = 0
+	s.watchHit = nil
+	s.breakResume = false
+	s.cycles = 0
+	s.executed = nil
+	s.selfModifyHit = nil
+	if s.history != nil {
+		s.EnableHistory(len(s.history.records))
+	}
+	for _, dev := range s.Devices {
+		if rd, ok := dev.(ResetDevice); ok {
+			rd.Reset()
+		}
+	}
+}
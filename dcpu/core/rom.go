@@ -0,0 +1,79 @@
+package core
+
+import "fmt"
+
+// ROMWritePolicy determines what happens when the CPU writes to a region
+// mapped with MapROM. Unlike writes to protected memory, these needn't stop
+// the State.
+type ROMWritePolicy int
+
+const (
+	ROMWriteIgnore    ROMWritePolicy = iota // the write does nothing
+	ROMWriteInterrupt                       // the write does nothing, and triggers an interrupt
+	ROMWriteHalt                            // halt with a ROMWriteError
+)
+
+func (p ROMWritePolicy) String() string {
+	switch p {
+	case ROMWriteIgnore:
+		return "ignore"
+	case ROMWriteInterrupt:
+		return "interrupt"
+	case ROMWriteHalt:
+		return "halt"
+	}
+	return fmt.Sprintf("ROMWritePolicy(%d)", int(p))
+}
+
+// ROMWriteError is returned by StepCycle when the CPU writes to a ROM with
+// the ROMWriteHalt policy
+type ROMWriteError struct {
+	Address Word
+}
+
+func (err *ROMWriteError) Error() string {
+	return fmt.Sprintf("write to ROM at address %#04x", err.Address)
+}
+
+// romInterrupt is returned by the set function of a ROM with the
+// ROMWriteInterrupt policy. The State turns it into an interrupt; anything
+// else storing to the ROM sees it as an error.
+type romInterrupt struct {
+	ROMWriteError
+	message Word
+}
+
+// MapROM maps words at start as read-only memory, e.g. to emulate a boot
+// ROM. The words are copied. Writes are handled according to policy, with
+// message being the interrupt message for ROMWriteInterrupt. The ROM can be
+// removed with UnmapRegion.
+func (m *Memory) MapROM(start Word, words []Word, policy ROMWritePolicy, message Word) error {
+	if len(words) >= len(m.ram) || int(start)+len(words) > len(m.ram) {
+		return ErrOutOfBounds
+	}
+	rom := append([]Word(nil), words...)
+	get := func(offset Word) Word {
+		return rom[offset]
+	}
+	set := func(offset, val Word) error {
+		switch policy {
+		case ROMWriteInterrupt:
+			return &romInterrupt{ROMWriteError{start + offset}, message}
+		case ROMWriteHalt:
+			return &ROMWriteError{start + offset}
+		}
+		return nil
+	}
+	return m.MapRegionOwned("ROM", start, Word(len(rom)), get, set, nil)
+}
+
+// storeMemory stores value at address on behalf of the CPU, turning writes
+// to ROMs with the ROMWriteInterrupt policy into interrupts
+func (s *State) storeMemory(address, value Word) error {
+	err := s.Ram.Store(address, value)
+	if rerr, ok := err.(*romInterrupt); ok {
+		s.TriggerInterrupt(rerr.message)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,48 @@
+package core
+
+import "errors"
+
+var (
+	// ErrCycleLimit is returned by RunUntil when the cycle limit is reached
+	// before the predicate is satisfied
+	ErrCycleLimit = errors.New("cycle limit reached")
+	// ErrHalted is returned by RunUntil when the program halts (see Halted)
+	// before the predicate is satisfied
+	ErrHalted = errors.New("program halted")
+)
+
+// RunFor runs up to the given number of cycles, returning the number run.
+// It stops early if StepCycle returns an error.
+func (s *State) RunFor(cycles uint64) (uint64, error) {
+	for i := uint64(0); i < cycles; i++ {
+		if err := s.StepCycle(); err != nil {
+			return i, err
+		}
+	}
+	return cycles, nil
+}
+
+// RunUntil runs until pred returns true, returning the number of cycles
+// run. pred is called between instructions, starting before the first one.
+// If limit is non-zero, at most limit cycles are run before returning
+// ErrCycleLimit.
+func (s *State) RunUntil(pred func(s *State) bool, limit uint64) (uint64, error) {
+	var cycles uint64
+	for {
+		if s.BetweenInstructions() {
+			if pred(s) {
+				return cycles, nil
+			}
+			if s.Halted() {
+				return cycles, ErrHalted
+			}
+		}
+		if limit != 0 && cycles == limit {
+			return cycles, ErrCycleLimit
+		}
+		if err := s.StepCycle(); err != nil {
+			return cycles, err
+		}
+		cycles++
+	}
+}
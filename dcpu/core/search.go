@@ -0,0 +1,83 @@
+package core
+
+import "errors"
+
+// StringEncoding selects how FindString lays out characters in memory
+type StringEncoding int
+
+const (
+	// StringUnpacked stores one character per word, in the low 7 bits as
+	// in video memory. The high bits, such as colors, are ignored.
+	StringUnpacked StringEncoding = iota
+	// StringPacked stores two characters per word, high byte first. A
+	// string with an odd length matches the high byte of its last word.
+	StringPacked
+)
+
+// Find returns the addresses of every occurrence of pattern, in address
+// order. Occurrences may overlap, but don't wrap around the end of memory.
+// Memory is read as the CPU sees it, including mapped regions.
+func (m *Memory) Find(pattern []Word) []Word {
+	return m.find(len(pattern), func(addr Word, i int) bool {
+		return m.Load(addr) == pattern[i]
+	})
+}
+
+// FindString returns the addresses of every occurrence of the ASCII string
+// str in the given encoding, in address order
+func (m *Memory) FindString(str string, encoding StringEncoding) ([]Word, error) {
+	for i := 0; i < len(str); i++ {
+		if str[i] >= 0x80 {
+			return nil, errors.New("FindString: string is not ASCII")
+		}
+	}
+	if encoding == StringPacked {
+		words := (len(str) + 1) / 2
+		return m.find(words, func(addr Word, i int) bool {
+			w := m.Load(addr)
+			if Word(str[i*2]) != w>>8 {
+				return false
+			}
+			return i*2+1 >= len(str) || Word(str[i*2+1]) == w&0xff
+		}), nil
+	}
+	return m.find(len(str), func(addr Word, i int) bool {
+		return Word(str[i]) == m.Load(addr)&0x7f
+	}), nil
+}
+
+// find returns the addresses where match is true for each of the n words
+// starting there
+func (m *Memory) find(n int, match func(addr Word, i int) bool) []Word {
+	if n == 0 {
+		return nil
+	}
+	var found []Word
+outer:
+	for start := 0; start+n <= len(m.ram); start++ {
+		for i := 0; i < n; i++ {
+			if !match(Word(start+i), i) {
+				continue outer
+			}
+		}
+		found = append(found, Word(start))
+	}
+	return found
+}
+
+// FindRanges returns the runs of consecutive addresses whose words satisfy
+// pred, in address order
+func (m *Memory) FindRanges(pred func(address, value Word) bool) []Region {
+	var regions []Region
+	for addr := 0; addr < len(m.ram); addr++ {
+		if !pred(Word(addr), m.Load(Word(addr))) {
+			continue
+		}
+		if n := len(regions); n > 0 && int(regions[n-1].End()) == addr && regions[n-1].Length < 0xffff {
+			regions[n-1].Length++
+		} else {
+			regions = append(regions, Region{Word(addr), 1})
+		}
+	}
+	return regions
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SelfModifyPolicy determines how the State handles the CPU writing to an
+// address it has previously executed
+type SelfModifyPolicy int
+
+const (
+	SelfModifyIgnore SelfModifyPolicy = iota // don't track executed addresses
+	SelfModifyLog                            // pass a SelfModifyError to SelfModifyLog and continue
+	SelfModifyPause                          // return a SelfModifyError once the instruction finishes
+)
+
+func (p SelfModifyPolicy) String() string {
+	switch p {
+	case SelfModifyIgnore:
+		return "ignore"
+	case SelfModifyLog:
+		return "log"
+	case SelfModifyPause:
+		return "pause"
+	}
+	return fmt.Sprintf("SelfModifyPolicy(%d)", int(p))
+}
+
+func (p *SelfModifyPolicy) Set(str string) error {
+	switch strings.ToLower(str) {
+	case "ignore":
+		*p = SelfModifyIgnore
+	case "log":
+		*p = SelfModifyLog
+	case "pause":
+		*p = SelfModifyPause
+	default:
+		return fmt.Errorf("unknown self-modify policy %#v", str)
+	}
+	return nil
+}
+
+// SelfModifyError describes a write to an address that has been executed.
+// Like a WatchpointError, it doesn't halt the State when returned by
+// StepCycle; calling StepCycle again resumes execution.
+type SelfModifyError struct {
+	PC      Word // address of the instruction that made the write
+	Address Word
+	Old     Word
+	New     Word
+}
+
+func (err *SelfModifyError) Error() string {
+	return fmt.Sprintf("self-modifying code: instruction at %#04x wrote %#04x to executed address %#04x (was %#04x)", err.PC, err.New, err.Address, err.Old)
+}
+
+// markExecuted records that the instruction at pc has been executed,
+// including its next words
+func (s *State) markExecuted(pc, length Word) {
+	if s.executed == nil {
+		s.executed = new([0x10000 / 8]byte)
+	}
+	for i := Word(0); i < length; i++ {
+		addr := pc + i
+		s.executed[addr/8] |= 1 << (addr % 8)
+	}
+}
+
+// checkSelfModify reports a write to an executed address according to the
+// SelfModify policy. Only the first write of an instruction pauses it.
+func (s *State) checkSelfModify(address, old, new Word) {
+	if s.executed == nil || s.executed[address/8]&(1<<(address%8)) == 0 {
+		return
+	}
+	err := &SelfModifyError{s.pc, address, old, new}
+	switch s.SelfModify {
+	case SelfModifyLog:
+		if s.SelfModifyLog != nil {
+			s.SelfModifyLog(err)
+		}
+	case SelfModifyPause:
+		if s.selfModifyHit == nil {
+			s.selfModifyHit = err
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// SnapshotDevice is implemented by devices with state that should be saved
+// in snapshots of the State
+type SnapshotDevice interface {
+	Device
+	Snapshot() ([]byte, error)
+	Restore(data []byte) error
+}
+
+// ErrBadSnapshot is returned when restoring data that isn't a valid snapshot
+var ErrBadSnapshot = errors.New("invalid snapshot")
+
+const (
+	stateSnapshotMagic   = "DCPS"
+	stateSnapshotVersion = 1
+)
+
+// stateSnapshot is the fixed-size portion of a snapshot
+type stateSnapshot struct {
+	Magic        [4]byte
+	Version      uint16
+	Registers    Registers
+	Step         int32
+	CycleCost    uint32
+	Op, A, B     uint32
+	Delayed      bool
+	AddressType  int32
+	AddressIndex Word
+	Queueing     bool
+	PC           Word
+	Cycles       uint64
+	BreakResume  bool
+	Interrupts   uint16
+	Devices      uint16
+}
+
+// Snapshot captures the registers, RAM, interrupt queue and cycle count,
+// along with the progress of the current instruction and the state of any
+// attached SnapshotDevices. Memory-mapped regions are not included, as
+// their contents belong to the devices that mapped them.
+func (s *State) Snapshot() ([]byte, error) {
+	var buf bytes.Buffer
+	snap := stateSnapshot{
+		Version:      stateSnapshotVersion,
+		Registers:    s.Registers,
+		Step:         int32(s.step),
+		CycleCost:    uint32(s.cycleCost),
+		Op:           s.op,
+		A:            s.a,
+		B:            s.b,
+		Delayed:      s.delayed,
+		AddressType:  int32(s.address.addressType),
+		AddressIndex: s.address.index,
+		Queueing:     s.queueing,
+		PC:           s.pc,
+		Cycles:       s.cycles,
+		BreakResume:  s.breakResume,
+		Interrupts:   uint16(len(s.interrupts)),
+		Devices:      uint16(len(s.Devices)),
+	}
+	copy(snap.Magic[:], stateSnapshotMagic)
+	binary.Write(&buf, binary.LittleEndian, &snap)
+	binary.Write(&buf, binary.LittleEndian, &s.Ram.ram)
+	binary.Write(&buf, binary.LittleEndian, s.interrupts)
+	for i, dev := range s.Devices {
+		var data []byte
+		if sd, ok := dev.(SnapshotDevice); ok {
+			var err error
+			if data, err = sd.Snapshot(); err != nil {
+				return nil, fmt.Errorf("device %d: %v", i, err)
+			}
+		}
+		binary.Write(&buf, binary.LittleEndian, uint32(len(data)))
+		buf.Write(data)
+	}
+	return buf.Bytes(), nil
+}
+
+// parsedSnapshot is the contents of a snapshot
+type parsedSnapshot struct {
+	stateSnapshot
+	ram        [0x10000]Word
+	interrupts []Word
+	devices    [][]byte
+}
+
+// parseSnapshot parses a snapshot taken by Snapshot
+func parseSnapshot(data []byte) (*parsedSnapshot, error) {
+	r := bytes.NewReader(data)
+	snap := new(parsedSnapshot)
+	if err := binary.Read(r, binary.LittleEndian, &snap.stateSnapshot); err != nil {
+		return nil, ErrBadSnapshot
+	}
+	if string(snap.Magic[:]) != stateSnapshotMagic {
+		return nil, ErrBadSnapshot
+	}
+	if snap.Version != stateSnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+	if err := binary.Read(r, binary.LittleEndian, &snap.ram); err != nil {
+		return nil, ErrBadSnapshot
+	}
+	snap.interrupts = make([]Word, snap.Interrupts)
+	if err := binary.Read(r, binary.LittleEndian, snap.interrupts); err != nil {
+		return nil, ErrBadSnapshot
+	}
+	snap.devices = make([][]byte, snap.Devices)
+	for i := range snap.devices {
+		var length uint32
+		if err := binary.Read(r, binary.LittleEndian, &length); err != nil || int64(length) > int64(r.Len()) {
+			return nil, ErrBadSnapshot
+		}
+		snap.devices[i] = make([]byte, length)
+		io.ReadFull(r, snap.devices[i])
+	}
+	if r.Len() != 0 {
+		return nil, ErrBadSnapshot
+	}
+	return snap, nil
+}
+
+// check returns ErrBadSnapshot if the progress of the current instruction
+// isn't one the State could have been in running the given spec, so a
+// corrupt snapshot can't make it misbehave
+func (snap *parsedSnapshot) check(spec SpecVersion) error {
+	switch snap.Step {
+	case stateStepFetch, stateStepSkip:
+	case stateStepDecodeA, stateStepDecodeB, stateStepExecute:
+		if _, err := cycleCost(snap.Op, spec); err != nil {
+			return ErrBadSnapshot
+		}
+		// operands still to be decoded hold their 6 bit codes, and those
+		// already decoded hold words
+		maxA, maxB := uint32(0xffff), uint32(0xffff)
+		if snap.Step == stateStepDecodeA {
+			maxA, maxB = 0x3f, 0x3f
+		} else if snap.Step == stateStepDecodeB && spec == Spec11 {
+			maxA = 0x3f
+		} else if snap.Step == stateStepDecodeB {
+			maxB = 0x3f
+		}
+		if snap.A > maxA || snap.B > maxB {
+			return ErrBadSnapshot
+		}
+	default:
+		return ErrBadSnapshot
+	}
+	switch snap.AddressType {
+	case addressTypeNone, addressTypeMemory:
+	case addressTypeRegister:
+		if snap.AddressIndex >= registerCount {
+			return ErrBadSnapshot
+		}
+	default:
+		return ErrBadSnapshot
+	}
+	return nil
+}
+
+// Restore restores a snapshot taken by Snapshot. The same devices must be
+// attached as when the snapshot was taken. Restoring clears any error the
+// State halted with, as well as its history.
+func (s *State) Restore(data []byte) error {
+	snap, err := parseSnapshot(data)
+	if err != nil {
+		return err
+	}
+	if err := snap.check(s.Spec); err != nil {
+		return err
+	}
+	if int(snap.Devices) != len(s.Devices) {
+		return fmt.Errorf("snapshot has %d devices, but %d are attached", snap.Devices, len(s.Devices))
+	}
+	// the devices restored before one that fails are rolled back, so a bad
+	// snapshot leaves the State as it was
+	previous := make([][]byte, len(s.Devices))
+	for i, dev := range s.Devices {
+		if sd, ok := dev.(SnapshotDevice); ok {
+			if previous[i], err = sd.Snapshot(); err != nil {
+				return fmt.Errorf("device %d: %v", i, err)
+			}
+		}
+	}
+	for i, dev := range s.Devices {
+		if sd, ok := dev.(SnapshotDevice); ok {
+			if err := sd.Restore(snap.devices[i]); err != nil {
+				for j := 0; j < i; j++ {
+					if sd, ok := s.Devices[j].(SnapshotDevice); ok {
+						sd.Restore(previous[j])
+					}
+				}
+				return fmt.Errorf("device %d: %v", i, err)
+			}
+		}
+	}
+	s.Registers = snap.Registers
+	s.Ram.ram = snap.ram
+	s.step = int(snap.Step)
+	s.cycleCost = uint(snap.CycleCost)
+	s.op, s.a, s.b = snap.Op, snap.A, snap.B
+	s.delayed = snap.Delayed
+	s.address = Address{int(snap.AddressType), snap.AddressIndex}
+	s.queueing = snap.Queueing
+	s.interrupts = snap.interrupts
+	s.pc = snap.PC
+	s.cycles = snap.Cycles
+	s.breakResume = snap.BreakResume
+	s.lastError = nil
+	s.watchHit = nil
+	s.selfModifyHit = nil
+	if s.history != nil {
+		s.EnableHistory(len(s.history.records))
+	}
+	return nil
+}
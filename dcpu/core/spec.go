@@ -0,0 +1,57 @@
+package core
+
+import (
+	"fmt"
+)
+
+// SpecVersion selects the version of the DCPU-16 specification that
+// programs are decoded and executed against.
+// The zero value is Spec17.
+type SpecVersion int
+
+const (
+	Spec17 SpecVersion = iota // DCPU-16 1.7
+	Spec11                    // DCPU-16 1.1
+)
+
+func (v SpecVersion) String() string {
+	switch v {
+	case Spec17:
+		return "1.7"
+	case Spec11:
+		return "1.1"
+	}
+	return fmt.Sprintf("SpecVersion(%d)", int(v))
+}
+
+func (v *SpecVersion) Set(str string) error {
+	switch str {
+	case "1.7":
+		*v = Spec17
+	case "1.1":
+		*v = Spec11
+	default:
+		return fmt.Errorf("unknown spec version %#v", str)
+	}
+	return nil
+}
+
+// spec11Opcodes maps 1.1 basic opcodes to their 1.7 equivalents
+var spec11Opcodes = [0x10]uint32{
+	0x0: 0, // non-basic
+	0x1: opcodeSET,
+	0x2: opcodeADD,
+	0x3: opcodeSUB,
+	0x4: opcodeMUL,
+	0x5: opcodeDIV,
+	0x6: opcodeMOD,
+	0x7: opcodeSHL,
+	0x8: opcodeSHR,
+	0x9: opcodeAND,
+	0xa: opcodeBOR,
+	0xb: opcodeXOR,
+	0xc: opcodeIFE,
+	0xd: opcodeIFN,
+	0xe: opcodeIFG,
+	0xf: opcodeIFB,
+}
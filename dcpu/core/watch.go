@@ -0,0 +1,96 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// WatchKind selects the memory accesses a watchpoint triggers on
+type WatchKind int
+
+const (
+	WatchRead      WatchKind = 1 << iota // the instruction reads the address
+	WatchWrite                           // the instruction writes the address
+	WatchReadWrite = WatchRead | WatchWrite
+)
+
+func (k WatchKind) String() string {
+	switch k {
+	case WatchRead:
+		return "read"
+	case WatchWrite:
+		return "write"
+	case WatchReadWrite:
+		return "read/write"
+	}
+	return fmt.Sprintf("WatchKind(%d)", int(k))
+}
+
+// Watchpoint watches a region of memory for accesses by the CPU.
+// Accesses by devices and debuggers don't trigger watchpoints.
+type Watchpoint struct {
+	Region
+	Kind WatchKind
+}
+
+// WatchpointError is returned by StepCycle once the instruction that
+// triggered a watchpoint has finished executing. Unlike other errors it
+// doesn't halt the State; calling StepCycle again resumes execution.
+type WatchpointError struct {
+	Kind    WatchKind // WatchRead or WatchWrite
+	PC      Word      // address of the instruction that triggered the watchpoint
+	Address Word
+	Old     Word // the value before the access
+	New     Word // the value after the access; the same as Old for reads
+}
+
+func (err *WatchpointError) Error() string {
+	if err.Kind == WatchRead {
+		return fmt.Sprintf("watchpoint: instruction at %#04x read %#04x from %#04x", err.PC, err.Old, err.Address)
+	}
+	return fmt.Sprintf("watchpoint: instruction at %#04x wrote %#04x to %#04x (was %#04x)", err.PC, err.New, err.Address, err.Old)
+}
+
+// AddWatchpoint watches the given region of memory.
+// Returns ErrOutOfBounds if the region exceeds the bounds of Ram.
+func (s *State) AddWatchpoint(start, length Word, kind WatchKind) error {
+	if int(start)+int(length) > len(s.Ram.ram) {
+		return ErrOutOfBounds
+	}
+	if kind&WatchReadWrite == 0 || kind&^WatchReadWrite != 0 {
+		return fmt.Errorf("invalid watch kind %v", kind)
+	}
+	s.watchpoints = append(s.watchpoints, Watchpoint{Region{start, length}, kind})
+	return nil
+}
+
+// RemoveWatchpoint only removes the watchpoint if the region precisely
+// matches an existing watchpoint
+func (s *State) RemoveWatchpoint(start, length Word) error {
+	for i, wp := range s.watchpoints {
+		if wp.Start == start && wp.Length == length {
+			s.watchpoints = append(s.watchpoints[:i], s.watchpoints[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("RemoveWatchpoint: no watchpoint matches the input")
+}
+
+// Watchpoints returns the current watchpoints
+func (s *State) Watchpoints() []Watchpoint {
+	return append([]Watchpoint(nil), s.watchpoints...)
+}
+
+// checkWatchpoints records a hit if the access matches a watchpoint.
+// Only the first hit of an instruction is reported.
+func (s *State) checkWatchpoints(kind WatchKind, address, old, new Word) {
+	if s.watchHit != nil {
+		return
+	}
+	for _, wp := range s.watchpoints {
+		if wp.Kind&kind != 0 && wp.Contains(address) {
+			s.watchHit = &WatchpointError{kind, s.pc, address, old, new}
+			return
+		}
+	}
+}
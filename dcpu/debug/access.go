@@ -0,0 +1,123 @@
+package debug
+
+import (
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"io"
+	"sync"
+)
+
+// AccessStats counts the memory reads and writes made by the CPU, grouped
+// into buckets of consecutive addresses. It's safe to read the AccessStats
+// while the machine is running.
+type AccessStats struct {
+	bucketSize int
+	mu         sync.Mutex
+	reads      []uint64
+	writes     []uint64
+}
+
+// AccessBucket is the number of accesses to a range of addresses
+type AccessBucket struct {
+	core.Region
+	Reads  uint64
+	Writes uint64
+}
+
+// NewAccessStats returns an AccessStats that groups addresses into buckets
+// of bucketSize words. bucketSize must be a power of 2; use 1 to count each
+// address separately.
+func NewAccessStats(bucketSize int) (*AccessStats, error) {
+	if bucketSize <= 0 || bucketSize > 0x8000 || bucketSize&(bucketSize-1) != 0 {
+		return nil, fmt.Errorf("invalid bucket size %d", bucketSize)
+	}
+	buckets := 0x10000 / bucketSize
+	return &AccessStats{
+		bucketSize: bucketSize,
+		reads:      make([]uint64, buckets),
+		writes:     make([]uint64, buckets),
+	}, nil
+}
+
+// Attach installs the AccessStats as the AccessHook of s, chaining to any
+// existing hook.
+func (a *AccessStats) Attach(s *core.State) {
+	prev := s.AccessHook
+	if prev == nil {
+		s.AccessHook = a.Hook
+		return
+	}
+	s.AccessHook = func(s *core.State, kind core.WatchKind, address, old, new core.Word) {
+		prev(s, kind, address, old, new)
+		a.Hook(s, kind, address, old, new)
+	}
+}
+
+// Hook is a core.AccessHook that counts the access
+func (a *AccessStats) Hook(s *core.State, kind core.WatchKind, address, old, new core.Word) {
+	bucket := int(address) / a.bucketSize
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if kind == core.WatchRead {
+		a.reads[bucket]++
+	} else {
+		a.writes[bucket]++
+	}
+}
+
+// Histogram returns the buckets that have been accessed, in address order
+func (a *AccessStats) Histogram() []AccessBucket {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var buckets []AccessBucket
+	for i := range a.reads {
+		if a.reads[i] == 0 && a.writes[i] == 0 {
+			continue
+		}
+		region := core.Region{Start: core.Word(i * a.bucketSize), Length: core.Word(a.bucketSize)}
+		buckets = append(buckets, AccessBucket{region, a.reads[i], a.writes[i]})
+	}
+	return buckets
+}
+
+// Count returns the number of reads and writes to the buckets overlapping
+// region. For exact counts, region should be aligned to the bucket size.
+func (a *AccessStats) Count(region core.Region) (reads, writes uint64) {
+	if region.Length == 0 {
+		return 0, 0
+	}
+	first := int(region.Start) / a.bucketSize
+	last := (int(region.Start) + int(region.Length) - 1) / a.bucketSize
+	if last >= len(a.reads) {
+		last = len(a.reads) - 1
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := first; i <= last; i++ {
+		reads += a.reads[i]
+		writes += a.writes[i]
+	}
+	return
+}
+
+// Reset clears the counts
+func (a *AccessStats) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := range a.reads {
+		a.reads[i], a.writes[i] = 0, 0
+	}
+}
+
+// Fprint writes the histogram to w, one bucket per line, e.g.
+//
+//	0x8000-0x80ff  reads 0  writes 384
+func (a *AccessStats) Fprint(w io.Writer) error {
+	for _, b := range a.Histogram() {
+		_, err := fmt.Fprintf(w, "%#04x-%#04x  reads %d  writes %d\n", b.Start, int(b.Start)+int(b.Length)-1, b.Reads, b.Writes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
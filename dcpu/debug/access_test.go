@@ -0,0 +1,48 @@
+package debug
+
+import (
+	"bytes"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"testing"
+)
+
+func TestAccessStats(t *testing.T) {
+	if _, err := NewAccessStats(3); err == nil {
+		t.Error("Expected an error for a bucket size that isn't a power of 2")
+	}
+	// :loop ADD [0x8000], 1
+	//       SET A, [0x1000]
+	//       SET PC, loop
+	program := []core.Word{0x8bc2, 0x8000, 0x7801, 0x1000, 0x8781}
+	state := new(core.State)
+	if err := state.LoadProgram(program, 0); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := NewAccessStats(0x100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats.Attach(state)
+	for i := 0; i < 3; i++ {
+		stepOne(t, state)
+		stepOne(t, state)
+		stepOne(t, state)
+	}
+	if reads, writes := stats.Count(core.Region{Start: 0x8000, Length: 0x400}); reads != 3 || writes != 3 {
+		t.Errorf("Unexpected video counts; reads %d, writes %d", reads, writes)
+	}
+	if reads, writes := stats.Count(core.Region{Start: 0x1000, Length: 1}); reads != 3 || writes != 0 {
+		t.Errorf("Unexpected work RAM counts; reads %d, writes %d", reads, writes)
+	}
+	var buf bytes.Buffer
+	if err := stats.Fprint(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "0x1000-0x10ff  reads 3  writes 0\n0x8000-0x80ff  reads 3  writes 3\n"; buf.String() != expected {
+		t.Errorf("Unexpected histogram:\n%s", buf.String())
+	}
+	stats.Reset()
+	if len(stats.Histogram()) != 0 {
+		t.Errorf("Expected no counts after resetting, found %v", stats.Histogram())
+	}
+}
@@ -0,0 +1,108 @@
+package debug
+
+import (
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"io"
+	"sync"
+)
+
+// Frame is a call made with JSR that hasn't returned yet
+type Frame struct {
+	Call   core.Word // the address of the JSR
+	Target core.Word // the address that was called
+	Return core.Word // the return address pushed by the JSR
+	SP     core.Word // the stack pointer, pointing at the return address
+}
+
+// CallStack maintains a shadow call stack by tracking JSR instructions.
+// A call is considered to have returned once its return address has been
+// popped off the stack, however that happens, so calls that unwind the
+// stack without returning are handled too. It's safe to read the CallStack
+// while the machine is running.
+type CallStack struct {
+	mu     sync.Mutex
+	frames []Frame // outermost first
+}
+
+var opcodeJSR, _ = core.OpcodeByName("JSR")
+
+// NewCallStack returns an empty CallStack
+func NewCallStack() *CallStack {
+	return new(CallStack)
+}
+
+// Attach installs the CallStack as the StepHook of s, chaining to any
+// existing hook.
+func (c *CallStack) Attach(s *core.State) {
+	s.StepHook = chainHook(s.StepHook, c.Hook)
+}
+
+// Hook is a core.StepHook that updates the call stack
+func (c *CallStack) Hook(s *core.State, pc core.Word, inst core.Instruction, cycles uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	depth := stackDepth(s.SP())
+	for n := len(c.frames); n > 0 && stackDepth(c.frames[n-1].SP) > depth; n-- {
+		c.frames = c.frames[:n-1]
+	}
+	if inst.Opcode == opcodeJSR {
+		c.frames = append(c.frames, Frame{
+			Call:   pc,
+			Target: s.PC(),
+			Return: pc + inst.Length(),
+			SP:     s.SP(),
+		})
+	}
+}
+
+// stackDepth returns the number of words on a stack that starts at 0 and
+// grows down
+func stackDepth(sp core.Word) int {
+	return (0x10000 - int(sp)) & 0xffff
+}
+
+// Frames returns the active calls, innermost first
+func (c *CallStack) Frames() []Frame {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	frames := make([]Frame, len(c.frames))
+	for i, f := range c.frames {
+		frames[len(frames)-1-i] = f
+	}
+	return frames
+}
+
+// Reset empties the call stack
+func (c *CallStack) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.frames = nil
+}
+
+// FprintBacktrace writes a backtrace to w, starting with pc and followed by
+// the call sites of frames, which are innermost first. If symbols is
+// non-nil, the addresses are symbolized with it, e.g.
+//
+//	#0  0x0012  draw+2
+//	#1  0x0004  main+4
+func FprintBacktrace(w io.Writer, pc core.Word, frames []Frame, symbols map[string]core.Word) error {
+	var table symbolTable
+	if len(symbols) > 0 {
+		table = newSymbolTable(symbols)
+	}
+	addrs := []core.Word{pc}
+	for _, f := range frames {
+		addrs = append(addrs, f.Call)
+	}
+	for i, addr := range addrs {
+		line := fmt.Sprintf("#%-2d %#04x", i, addr)
+		if sym := table.lookup(addr); sym != "" {
+			line += "  " + sym
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
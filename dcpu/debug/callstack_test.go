@@ -0,0 +1,88 @@
+package debug
+
+import (
+	"bytes"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"testing"
+)
+
+func TestCallStack(t *testing.T) {
+	// :main JSR outer
+	//       SET PC, main
+	// :outer JSR inner
+	//       SET PC, POP
+	// :inner SET A, 1
+	//       SET PC, POP
+	program := []core.Word{
+		0x8c20, // JSR 2
+		0x8781, // SET PC, 0
+		0x9420, // JSR 4
+		0x6381, // SET PC, POP
+		0x8801, // SET A, 1
+		0x6381, // SET PC, POP
+	}
+	state := new(core.State)
+	if err := state.LoadProgram(program, 0); err != nil {
+		t.Fatal(err)
+	}
+	stack := NewCallStack()
+	stack.Attach(state)
+	steps := []struct {
+		pc    core.Word
+		depth int
+	}{
+		{2, 1}, // called outer
+		{4, 2}, // called inner
+		{5, 2},
+		{3, 1}, // returned to outer
+		{1, 0}, // returned to main
+		{0, 0},
+	}
+	for i, step := range steps {
+		stepOne(t, state)
+		frames := stack.Frames()
+		if state.PC() != step.pc || len(frames) != step.depth {
+			t.Fatalf("Step %d: expected PC %#x with %d frames, found PC %#x with %v", i, step.pc, step.depth, state.PC(), frames)
+		}
+		if i == 1 {
+			if frames[0] != (Frame{Call: 2, Target: 4, Return: 3, SP: 0xfffe}) || frames[1].Call != 0 {
+				t.Errorf("Unexpected frames %v", frames)
+			}
+			var buf bytes.Buffer
+			FprintBacktrace(&buf, state.PC(), frames, map[string]core.Word{"main": 0, "outer": 2, "inner": 4})
+			if expected := "#0  0x0004  inner\n#1  0x0002  outer\n#2  0x0000  main\n"; buf.String() != expected {
+				t.Errorf("Unexpected backtrace:\n%s", buf.String())
+			}
+		}
+	}
+
+	// unwinding the stack discards the calls
+	stepOne(t, state)
+	stepOne(t, state)
+	state.SetSP(0)
+	stepOne(t, state)
+	if frames := stack.Frames(); len(frames) != 0 {
+		t.Errorf("Expected the stack to unwind, found %v", frames)
+	}
+}
+
+// stepOne steps the state until an instruction has been executed
+func stepOne(t *testing.T, state *core.State) {
+	executed := false
+	hook := state.StepHook
+	state.StepHook = func(s *core.State, pc core.Word, inst core.Instruction, cycles uint64) {
+		if hook != nil {
+			hook(s, pc, inst, cycles)
+		}
+		executed = true
+	}
+	defer func() { state.StepHook = hook }()
+	for i := 0; !executed; i++ {
+		if i >= 10 {
+			t.Fatal("Instruction exceeded 10 cycles")
+		}
+		if err := state.StepCycle(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
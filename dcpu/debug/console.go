@@ -0,0 +1,28 @@
+package debug
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"io"
+)
+
+// Console is a hardware device that gives programs a way to print without
+// going through video memory. When it is sent HWI, the word in A is written
+// to W as a character, encoded as UTF-8.
+type Console struct {
+	W io.Writer
+}
+
+const (
+	ConsoleID           = 0x0deb1601
+	ConsoleVersion      = 1
+	ConsoleManufacturer = 0x6b62616c
+)
+
+func (c *Console) ID() uint32           { return ConsoleID }
+func (c *Console) Version() core.Word   { return ConsoleVersion }
+func (c *Console) Manufacturer() uint32 { return ConsoleManufacturer }
+
+func (c *Console) HandleInterrupt(s *core.State) error {
+	_, err := io.WriteString(c.W, string(rune(s.A())))
+	return err
+}
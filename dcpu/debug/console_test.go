@@ -0,0 +1,22 @@
+package debug
+
+import (
+	"bytes"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"testing"
+)
+
+func TestConsole(t *testing.T) {
+	var buf bytes.Buffer
+	console := &Console{&buf}
+	state := new(core.State)
+	for _, ch := range "hé\n" {
+		state.SetA(core.Word(ch))
+		if err := console.HandleInterrupt(state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if buf.String() != "hé\n" {
+		t.Errorf("Unexpected output %q", buf.String())
+	}
+}
@@ -0,0 +1,96 @@
+package debug
+
+import (
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"io"
+	"sync"
+)
+
+// Coverage records which addresses have been executed, including the next
+// words of each instruction. It's safe to read the Coverage while the
+// machine is running.
+type Coverage struct {
+	mu     sync.Mutex
+	bitmap [0x10000 / 8]byte
+}
+
+// NewCoverage returns an empty Coverage
+func NewCoverage() *Coverage {
+	return new(Coverage)
+}
+
+// Attach installs the Coverage as the StepHook of s, chaining to any
+// existing hook.
+func (c *Coverage) Attach(s *core.State) {
+	s.StepHook = chainHook(s.StepHook, c.Hook)
+}
+
+// Hook is a core.StepHook that marks the instruction as executed
+func (c *Coverage) Hook(s *core.State, pc core.Word, inst core.Instruction, cycles uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := core.Word(0); i < inst.Length(); i++ {
+		addr := pc + i
+		c.bitmap[addr/8] |= 1 << (addr % 8)
+	}
+}
+
+// Executed returns whether address has been executed
+func (c *Coverage) Executed(address core.Word) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bitmap[address/8]&(1<<(address%8)) != 0
+}
+
+// Bitmap returns a copy of the coverage bitmap. Address n is executed if bit
+// n%8 (counting from the least significant bit) of byte n/8 is set.
+func (c *Coverage) Bitmap() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]byte(nil), c.bitmap[:]...)
+}
+
+// Count returns the number of executed addresses
+func (c *Coverage) Count() int {
+	count := 0
+	for _, b := range c.Bitmap() {
+		for ; b != 0; b &= b - 1 {
+			count++
+		}
+	}
+	return count
+}
+
+// Regions returns the executed addresses as a list of contiguous regions,
+// in address order
+func (c *Coverage) Regions() []core.Region {
+	bitmap := c.Bitmap()
+	var regions []core.Region
+	for addr := 0; addr < 0x10000; addr++ {
+		if bitmap[addr/8]&(1<<uint(addr%8)) == 0 {
+			continue
+		}
+		if n := len(regions); n > 0 && int(regions[n-1].Start)+int(regions[n-1].Length) == addr && regions[n-1].Length < 0xffff {
+			regions[n-1].Length++
+		} else {
+			regions = append(regions, core.Region{Start: core.Word(addr), Length: 1})
+		}
+	}
+	return regions
+}
+
+// Fprint writes the executed regions to w, one per line, followed by the
+// number of executed addresses, e.g.
+//
+//	0x0000-0x0003
+//	4 words executed
+func (c *Coverage) Fprint(w io.Writer) error {
+	for _, r := range c.Regions() {
+		if _, err := fmt.Fprintf(w, "%#04x-%#04x\n", r.Start, int(r.Start)+int(r.Length)-1); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d words executed\n", c.Count())
+	return err
+}
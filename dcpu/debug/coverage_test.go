@@ -0,0 +1,45 @@
+package debug
+
+import (
+	"bytes"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	// A isn't 0, so SET B, 1 is skipped
+	program := []core.Word{
+		0x7c01, 0x0030, // SET A, 0x30
+		0x8412,         // IFE A, 0
+		0x8821,         // SET B, 1
+		0x7c21, 0x0002, // SET B, 2
+		0x9b81, // SET PC, 5
+	}
+	state := new(core.State)
+	if err := state.LoadProgram(program, 0); err != nil {
+		t.Fatal(err)
+	}
+	coverage := NewCoverage()
+	coverage.Attach(state)
+	for i := 0; i < 20; i++ {
+		if err := state.StepCycle(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !coverage.Executed(1) || coverage.Executed(3) || !coverage.Executed(6) {
+		t.Errorf("Unexpected coverage %v", coverage.Regions())
+	}
+	if coverage.Count() != 6 {
+		t.Errorf("Unexpected count; expected %d, found %d", 6, coverage.Count())
+	}
+	if bitmap := coverage.Bitmap(); len(bitmap) != 0x2000 || bitmap[0] != 0x77 {
+		t.Errorf("Unexpected bitmap %#x", bitmap[0])
+	}
+	var buf bytes.Buffer
+	if err := coverage.Fprint(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "0x0000-0x0002\n0x0004-0x0006\n6 words executed\n"; buf.String() != expected {
+		t.Errorf("Unexpected dump:\n%s", buf.String())
+	}
+}
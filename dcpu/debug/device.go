@@ -0,0 +1,40 @@
+package debug
+
+import "github.com/kballard/dcpu16/dcpu/core"
+
+// Device is a hardware device that gives programs access to debugging
+// facilities. The operation is selected by A when the device is sent HWI:
+//
+//	A=0 CRC16: A is set to the CRC-16 of C words starting at B
+//	A=1 FNV:   B:A is set to the 32-bit FNV-1a hash of C words starting at B
+//
+// The checksums are computed as by core.Memory's CRC16 and FNV, so tests on
+// the host can compare them. Unknown operations are ignored.
+type Device struct{}
+
+const (
+	DeviceID           = 0x0deb1600
+	DeviceVersion      = 1
+	DeviceManufacturer = 0x6b62616c
+)
+
+const (
+	deviceCRC16 = iota
+	deviceFNV
+)
+
+func (d *Device) ID() uint32           { return DeviceID }
+func (d *Device) Version() core.Word   { return DeviceVersion }
+func (d *Device) Manufacturer() uint32 { return DeviceManufacturer }
+
+func (d *Device) HandleInterrupt(s *core.State) error {
+	switch s.A() {
+	case deviceCRC16:
+		s.SetA(s.Ram.CRC16(s.B(), s.C()))
+	case deviceFNV:
+		hash := s.Ram.FNV(s.B(), s.C())
+		s.SetA(core.Word(hash))
+		s.SetB(core.Word(hash >> 16))
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package debug
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"testing"
+)
+
+func TestDevice(t *testing.T) {
+	// SET B, 0x10
+	// SET C, 2
+	// HWI 0
+	program := []core.Word{0xc421, 0x8c41, 0x8640}
+	state := new(core.State)
+	if err := state.LoadProgram(program, 0); err != nil {
+		t.Fatal(err)
+	}
+	state.Devices = []core.Device{new(Device)}
+	state.Ram.Store(0x10, 0x1234)
+	state.Ram.Store(0x11, 0x5678)
+	for i := 0; i < 3; i++ {
+		stepOne(t, state)
+	}
+	if expected := state.Ram.CRC16(0x10, 2); state.A() != expected {
+		t.Errorf("Unexpected CRC16; expected %#04x, found %#04x", expected, state.A())
+	}
+
+	state.SetPC(2)
+	state.SetA(1)
+	stepOne(t, state)
+	hash := uint32(state.B())<<16 | uint32(state.A())
+	if expected := state.Ram.FNV(0x10, 2); hash != expected {
+		t.Errorf("Unexpected FNV hash; expected %#08x, found %#08x", expected, hash)
+	}
+}
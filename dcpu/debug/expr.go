@@ -0,0 +1,256 @@
+// Package debug implements debugging aids for the DCPU-16 emulator.
+package debug
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"strconv"
+	"strings"
+)
+
+// Expr is an expression evaluated against the state of the machine, such as
+//
+//	A == 0x1f && [0x8000] != 0
+//
+// Registers are referred to by name and [expr] reads a word of RAM. The
+// operators are those of C, with arithmetic wrapping to 16 bits and
+// comparisons producing 1 or 0.
+type Expr struct {
+	src  string
+	eval evalFunc
+}
+
+// ParseExpr parses an expression. Names that aren't registers are looked up
+// in symbols, which may be nil.
+func ParseExpr(str string, symbols map[string]core.Word) (*Expr, error) {
+	tokens, err := lexExpr(str)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens, symbols: symbols}
+	eval, err := p.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %s in expression", p.tokens[p.pos])
+	}
+	return &Expr{str, eval}, nil
+}
+
+// Eval evaluates the expression against s
+func (e *Expr) Eval(s *core.State) core.Word {
+	return e.eval(s)
+}
+
+// Test returns whether the expression is non-zero. It's suitable for use as
+// a breakpoint condition.
+func (e *Expr) Test(s *core.State) bool {
+	return e.eval(s) != 0
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// operators, with multi-character operators first
+var exprOperators = []string{
+	"||", "&&", "==", "!=", "<=", ">=", "<<", ">>",
+	"<", ">", "+", "-", "*", "/", "%", "&", "|", "^", "~", "!", "(", ")", "[", "]",
+}
+
+func lexExpr(str string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(str); {
+		c := str[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case isAlnum(c):
+			j := i + 1
+			for j < len(str) && isAlnum(str[j]) {
+				j++
+			}
+			tokens = append(tokens, str[i:j])
+			i = j
+		default:
+			found := false
+			for _, op := range exprOperators {
+				if strings.HasPrefix(str[i:], op) {
+					tokens = append(tokens, op)
+					i += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+func isAlnum(c byte) bool {
+	return c == '_' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// binary operator precedence, from loosest to tightest
+var exprPrecedence = map[string]int{
+	"||": 1, "&&": 2, "|": 3, "^": 4, "&": 5,
+	"==": 6, "!=": 6,
+	"<": 7, "<=": 7, ">": 7, ">=": 7,
+	"<<": 8, ">>": 8,
+	"+": 9, "-": 9,
+	"*": 10, "/": 10, "%": 10,
+}
+
+type exprParser struct {
+	tokens  []string
+	pos     int
+	symbols map[string]core.Word
+}
+
+type evalFunc func(s *core.State) core.Word
+
+func (p *exprParser) parseBinary(minPrec int) (evalFunc, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.pos < len(p.tokens) {
+		op := p.tokens[p.pos]
+		prec, ok := exprPrecedence[op]
+		if !ok || prec < minPrec {
+			break
+		}
+		p.pos++
+		right, err := p.parseBinary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryOp(op, left, right)
+	}
+	return left, nil
+}
+
+func boolWord(b bool) core.Word {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func binaryOp(op string, left, right evalFunc) evalFunc {
+	switch op {
+	case "||":
+		// short-circuit, like C
+		return func(s *core.State) core.Word { return boolWord(left(s) != 0 || right(s) != 0) }
+	case "&&":
+		return func(s *core.State) core.Word { return boolWord(left(s) != 0 && right(s) != 0) }
+	}
+	return func(s *core.State) core.Word {
+		l, r := left(s), right(s)
+		switch op {
+		case "|":
+			return l | r
+		case "^":
+			return l ^ r
+		case "&":
+			return l & r
+		case "==":
+			return boolWord(l == r)
+		case "!=":
+			return boolWord(l != r)
+		case "<":
+			return boolWord(l < r)
+		case "<=":
+			return boolWord(l <= r)
+		case ">":
+			return boolWord(l > r)
+		case ">=":
+			return boolWord(l >= r)
+		case "<<":
+			return l << r
+		case ">>":
+			return l >> r
+		case "+":
+			return l + r
+		case "-":
+			return l - r
+		case "*":
+			return l * r
+		case "/":
+			// like DIV, division by zero produces 0
+			if r == 0 {
+				return 0
+			}
+			return l / r
+		case "%":
+			if r == 0 {
+				return 0
+			}
+			return l % r
+		}
+		panic("Unexpected operator " + op)
+	}
+}
+
+func (p *exprParser) parseUnary() (evalFunc, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, errors.New("unexpected end of expression")
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+	switch tok {
+	case "-", "~", "!":
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		switch tok {
+		case "-":
+			return func(s *core.State) core.Word { return -operand(s) }, nil
+		case "~":
+			return func(s *core.State) core.Word { return ^operand(s) }, nil
+		}
+		return func(s *core.State) core.Word { return boolWord(operand(s) == 0) }, nil
+	case "(", "[":
+		inner, err := p.parseBinary(1)
+		if err != nil {
+			return nil, err
+		}
+		closing := map[string]string{"(": ")", "[": "]"}[tok]
+		if p.pos >= len(p.tokens) || p.tokens[p.pos] != closing {
+			return nil, fmt.Errorf("missing %s", closing)
+		}
+		p.pos++
+		if tok == "[" {
+			return func(s *core.State) core.Word { return s.Ram.Load(inner(s)) }, nil
+		}
+		return inner, nil
+	}
+	if tok[0] >= '0' && tok[0] <= '9' {
+		n, err := strconv.ParseUint(tok, 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", tok)
+		}
+		return func(s *core.State) core.Word { return core.Word(n) }, nil
+	}
+	if isAlnum(tok[0]) {
+		var regs core.Registers
+		if _, ok := regs.Register(tok); ok {
+			name := tok
+			return func(s *core.State) core.Word {
+				val, _ := s.Register(name)
+				return val
+			}, nil
+		}
+		if val, ok := p.symbols[tok]; ok {
+			return func(s *core.State) core.Word { return val }, nil
+		}
+		return nil, fmt.Errorf("unknown name %s", tok)
+	}
+	return nil, fmt.Errorf("unexpected %s in expression", tok)
+}
@@ -0,0 +1,53 @@
+package debug
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"testing"
+)
+
+func TestExpr(t *testing.T) {
+	state := new(core.State)
+	state.SetA(0x1f)
+	state.SetB(3)
+	state.SetSP(0xfffe)
+	if err := state.LoadProgram([]core.Word{0x1234, 0x0005}, 0x8000); err != nil {
+		t.Fatal(err)
+	}
+	symbols := map[string]core.Word{"screen": 0x8000}
+	tests := []struct {
+		expr     string
+		expected core.Word
+	}{
+		{"A", 0x1f},
+		{"a == 0x1f && [0x8000] != 0", 1},
+		{"A == 0x1f && [0x8000] == 0", 0},
+		{"B == 2 || [screen+1] == 5", 1},
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"0 - 1", 0xffff},
+		{"-1 > 0", 1},
+		{"SP >= 0xfff0", 1},
+		{"[screen] >> 8 & 0xf", 2},
+		{"!A", 0},
+		{"~0", 0xffff},
+		{"A / 0", 0},
+		{"[[0x8001] + 0x7ffb]", 0x1234},
+	}
+	for _, test := range tests {
+		e, err := ParseExpr(test.expr, symbols)
+		if err != nil {
+			t.Errorf("%q: %v", test.expr, err)
+			continue
+		}
+		if val := e.Eval(state); val != test.expected {
+			t.Errorf("%q: expected %#04x, found %#04x", test.expr, test.expected, val)
+		}
+	}
+
+	errTests := []string{"", "A ==", "(A", "[A", "foo", "A $ B", "0x10000", "A B"}
+	for _, str := range errTests {
+		if _, err := ParseExpr(str, symbols); err == nil {
+			t.Errorf("%q: expected an error", str)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package debug
+
+import (
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Profiler accumulates the cycles spent executing the instruction at each
+// address. Cycles spent handling an interrupt are charged to the instruction
+// that follows it. It's safe to read the Profiler while the machine is
+// running.
+type Profiler struct {
+	mu     sync.Mutex
+	cycles [0x10000]uint64
+	total  uint64
+	last   uint64 // the cycle count when the previous instruction finished
+}
+
+// Hotspot is the number of cycles spent at a single address
+type Hotspot struct {
+	Address core.Word
+	Cycles  uint64
+	Symbol  string // the nearest symbol at or before Address, e.g. "loop+2"
+}
+
+// NewProfiler returns an empty Profiler
+func NewProfiler() *Profiler {
+	return new(Profiler)
+}
+
+// Attach installs the Profiler as the StepHook of s, chaining to any
+// existing hook. Only cycles executed after attaching are counted.
+func (p *Profiler) Attach(s *core.State) {
+	p.mu.Lock()
+	p.last = s.Cycles()
+	p.mu.Unlock()
+	s.StepHook = chainHook(s.StepHook, p.Hook)
+}
+
+// Hook is a core.StepHook that counts the instruction's cycles
+func (p *Profiler) Hook(s *core.State, pc core.Word, inst core.Instruction, cycles uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if cycles > p.last {
+		p.cycles[pc] += cycles - p.last
+		p.total += cycles - p.last
+	}
+	p.last = cycles
+}
+
+// Total returns the number of cycles counted
+func (p *Profiler) Total() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.total
+}
+
+// Cycles returns the number of cycles counted at address
+func (p *Profiler) Cycles(address core.Word) uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cycles[address]
+}
+
+// Top returns the n addresses with the most cycles, busiest first. If
+// symbols is non-nil, the hotspots are symbolized with it.
+func (p *Profiler) Top(n int, symbols map[string]core.Word) []Hotspot {
+	p.mu.Lock()
+	var spots []Hotspot
+	for addr, cycles := range p.cycles {
+		if cycles > 0 {
+			spots = append(spots, Hotspot{Address: core.Word(addr), Cycles: cycles})
+		}
+	}
+	p.mu.Unlock()
+	sort.SliceStable(spots, func(i, j int) bool {
+		return spots[i].Cycles > spots[j].Cycles
+	})
+	if n >= 0 && len(spots) > n {
+		spots = spots[:n]
+	}
+	if len(symbols) > 0 {
+		table := newSymbolTable(symbols)
+		for i := range spots {
+			spots[i].Symbol = table.lookup(spots[i].Address)
+		}
+	}
+	return spots
+}
+
+// Fprint writes a report of the top n hotspots to w, e.g.
+//
+//	cycles      %  address
+//	 12000  60.0%  0x0004  loop+1
+func (p *Profiler) Fprint(w io.Writer, n int, symbols map[string]core.Word) error {
+	total := p.Total()
+	if _, err := fmt.Fprintf(w, "%8s  %5s  %s\n", "cycles", "%", "address"); err != nil {
+		return err
+	}
+	for _, spot := range p.Top(n, symbols) {
+		percent := 100 * float64(spot.Cycles) / float64(total)
+		line := fmt.Sprintf("%8d  %4.1f%%  %#04x", spot.Cycles, percent, spot.Address)
+		if spot.Symbol != "" {
+			line += "  " + spot.Symbol
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// symbolTable maps addresses to the nearest preceding symbol
+type symbolTable []symbolEntry
+
+type symbolEntry struct {
+	name    string
+	address core.Word
+}
+
+func newSymbolTable(symbols map[string]core.Word) symbolTable {
+	table := make(symbolTable, 0, len(symbols))
+	for name, addr := range symbols {
+		table = append(table, symbolEntry{name, addr})
+	}
+	// sort by address, preferring the alphabetically first name
+	sort.Slice(table, func(i, j int) bool {
+		if table[i].address != table[j].address {
+			return table[i].address < table[j].address
+		}
+		return table[i].name < table[j].name
+	})
+	return table
+}
+
+// lookup returns "name" or "name+offset" for the nearest symbol at or before
+// address, or "" if there is none
+func (t symbolTable) lookup(address core.Word) string {
+	i := sort.Search(len(t), func(i int) bool { return t[i].address > address })
+	if i == 0 {
+		return ""
+	}
+	// step back to the first name at that address
+	sym := t[i-1]
+	for i > 1 && t[i-2].address == sym.address {
+		i--
+		sym = t[i-1]
+	}
+	if sym.address == address {
+		return sym.name
+	}
+	return fmt.Sprintf("%s+%d", sym.name, address-sym.address)
+}
@@ -0,0 +1,52 @@
+package debug
+
+import (
+	"bytes"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"strings"
+	"testing"
+)
+
+func TestProfiler(t *testing.T) {
+	state := new(core.State)
+	if err := state.LoadProgram(traceProgram, 0); err != nil {
+		t.Fatal(err)
+	}
+	profiler := NewProfiler()
+	profiler.Attach(state)
+	for i := 0; i < 10; i++ {
+		if err := state.StepCycle(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if profiler.Total() != 10 {
+		t.Errorf("Unexpected total; expected %d, found %d", 10, profiler.Total())
+	}
+	symbols := map[string]core.Word{"start": 0, "loop": 3}
+	expected := []Hotspot{{3, 7, "loop"}, {0, 2, "start"}, {2, 1, "start+2"}}
+	top := profiler.Top(10, symbols)
+	if len(top) != len(expected) {
+		t.Fatalf("Unexpected hotspots %v", top)
+	}
+	for i, spot := range top {
+		if spot != expected[i] {
+			t.Errorf("Unexpected hotspot %d; expected %v, found %v", i, expected[i], spot)
+		}
+	}
+	if top := profiler.Top(1, nil); len(top) != 1 || top[0] != (Hotspot{3, 7, ""}) {
+		t.Errorf("Unexpected top hotspot %v", top)
+	}
+
+	var buf bytes.Buffer
+	if err := profiler.Fprint(&buf, 2, symbols); err != nil {
+		t.Fatal(err)
+	}
+	report := []string{
+		"  cycles      %  address",
+		"       7  70.0%  0x0003  loop",
+		"       2  20.0%  0x0000  start",
+	}
+	if buf.String() != strings.Join(report, "\n")+"\n" {
+		t.Errorf("Unexpected report:\n%s", buf.String())
+	}
+}
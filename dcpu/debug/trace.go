@@ -0,0 +1,145 @@
+package debug
+
+import (
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"io"
+	"strings"
+	"sync"
+)
+
+// TraceEntry is a single executed instruction
+type TraceEntry struct {
+	PC          core.Word
+	Instruction core.Instruction
+	Cycles      uint64 // the cycle count once the instruction finished
+	Before      core.Registers
+	After       core.Registers
+}
+
+// String formats the entry along with the registers it changed, e.g.
+// 0000: SET A, 0x30                A=0000->0030
+// PC is only listed if the instruction jumped.
+func (e TraceEntry) String() string {
+	var changes []string
+	for i, name := range core.RegisterNames {
+		before, after := e.Before[i], e.After[i]
+		if name == "PC" {
+			// ignore the normal advance past the instruction
+			if after != e.PC+e.Instruction.Length() {
+				changes = append(changes, fmt.Sprintf("PC->%04x", after))
+			}
+		} else if before != after {
+			changes = append(changes, fmt.Sprintf("%s=%04x->%04x", name, before, after))
+		}
+	}
+	inst := e.Instruction.String()
+	if !e.Instruction.Opcode.Valid() {
+		inst = "<invalid>"
+	}
+	return strings.TrimRight(fmt.Sprintf("%04x: %-26s %s", e.PC, inst, strings.Join(changes, " ")), " ")
+}
+
+// Tracer records executed instructions, either by streaming them to a
+// writer or by keeping the most recent ones in a ring buffer.
+// It's safe to read the Tracer while the machine is running.
+type Tracer struct {
+	// Filter restricts tracing to instructions within the given regions.
+	// All instructions are traced if it's empty.
+	Filter []core.Region
+	mu     sync.Mutex
+	w      io.Writer
+	err    error
+	ring   []TraceEntry
+	next   int  // the next slot of ring to write
+	full   bool // ring has wrapped
+}
+
+// NewTracer returns a Tracer that writes each entry to w on its own line
+func NewTracer(w io.Writer) *Tracer {
+	return &Tracer{w: w}
+}
+
+// NewRingTracer returns a Tracer that keeps the last size entries
+func NewRingTracer(size int) *Tracer {
+	return &Tracer{ring: make([]TraceEntry, size)}
+}
+
+// Attach installs the Tracer as the StepHook of s, chaining to any
+// existing hook.
+func (t *Tracer) Attach(s *core.State) {
+	s.StepHook = chainHook(s.StepHook, t.Hook)
+}
+
+// chainHook returns a StepHook that calls prev, if any, followed by hook
+func chainHook(prev, hook core.StepHook) core.StepHook {
+	if prev == nil {
+		return hook
+	}
+	return func(s *core.State, pc core.Word, inst core.Instruction, cycles uint64) {
+		prev(s, pc, inst, cycles)
+		hook(s, pc, inst, cycles)
+	}
+}
+
+// Hook is a core.StepHook that records the instruction
+func (t *Tracer) Hook(s *core.State, pc core.Word, inst core.Instruction, cycles uint64) {
+	if len(t.Filter) > 0 && !inRegions(t.Filter, pc) {
+		return
+	}
+	entry := TraceEntry{pc, inst, cycles, s.FetchRegisters(), s.Registers}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.w != nil {
+		if t.err == nil {
+			_, t.err = fmt.Fprintln(t.w, entry)
+		}
+		return
+	}
+	if len(t.ring) == 0 {
+		return
+	}
+	t.ring[t.next] = entry
+	t.next++
+	if t.next == len(t.ring) {
+		t.next, t.full = 0, true
+	}
+}
+
+func inRegions(regions []core.Region, address core.Word) bool {
+	for _, r := range regions {
+		if r.Contains(address) {
+			return true
+		}
+	}
+	return false
+}
+
+// Err returns the first error encountered writing the trace
+func (t *Tracer) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Entries returns the entries in the ring buffer, oldest first
+func (t *Tracer) Entries() []TraceEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.full {
+		return append([]TraceEntry(nil), t.ring[:t.next]...)
+	}
+	entries := make([]TraceEntry, 0, len(t.ring))
+	entries = append(entries, t.ring[t.next:]...)
+	return append(entries, t.ring[:t.next]...)
+}
+
+// Fprint writes the entries in the ring buffer to w, oldest first
+func (t *Tracer) Fprint(w io.Writer) error {
+	for _, entry := range t.Entries() {
+		if _, err := fmt.Fprintln(w, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package debug
+
+import (
+	"bytes"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"strings"
+	"testing"
+)
+
+// traceProgram is
+//
+//	SET A, 0x30
+//	SET PUSH, A
+//	:loop SET PC, loop
+var traceProgram = []core.Word{0x7c01, 0x0030, 0x0301, 0x9381}
+
+func runTraced(t *testing.T, tracer *Tracer, cycles int) {
+	state := new(core.State)
+	if err := state.LoadProgram(traceProgram, 0); err != nil {
+		t.Fatal(err)
+	}
+	tracer.Attach(state)
+	for i := 0; i < cycles; i++ {
+		if err := state.StepCycle(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTracer(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewTracer(&buf)
+	runTraced(t, tracer, 4)
+	expected := []string{
+		"0000: SET A, 0x30                A=0000->0030",
+		"0002: SET PUSH, A                SP=0000->ffff",
+		"0003: SET PC, 0x3                PC->0003",
+	}
+	if buf.String() != strings.Join(expected, "\n")+"\n" {
+		t.Errorf("Unexpected trace:\n%s", buf.String())
+	}
+
+	// the ring buffer keeps the most recent entries
+	tracer = NewRingTracer(2)
+	runTraced(t, tracer, 6)
+	entries := tracer.Entries()
+	if len(entries) != 2 || entries[0].PC != 3 || entries[1].PC != 3 || entries[1].Cycles != 6 {
+		t.Errorf("Unexpected entries %v", entries)
+	}
+
+	// filtering
+	tracer = NewRingTracer(10)
+	tracer.Filter = []core.Region{{Start: 2, Length: 1}}
+	runTraced(t, tracer, 6)
+	entries = tracer.Entries()
+	if len(entries) != 1 || entries[0].PC != 2 {
+		t.Errorf("Unexpected filtered entries %v", entries)
+	}
+}
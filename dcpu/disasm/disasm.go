@@ -0,0 +1,163 @@
+// Package disasm converts DCPU-16 machine code back into assembly.
+package disasm
+
+import (
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"io"
+	"strings"
+)
+
+// Line is a single disassembled instruction
+type Line struct {
+	Address     core.Word
+	Words       []core.Word // the encoded instruction, including next words
+	Instruction core.Instruction
+	Label       string // the symbol at Address, if annotated
+}
+
+// String formats the line as
+// 0000: 7c01 0030       SET A, 0x30
+// Invalid instructions are emitted as DAT. Labels are appended as a comment.
+func (l Line) String() string {
+	words := make([]string, len(l.Words))
+	for i, w := range l.Words {
+		words[i] = fmt.Sprintf("%04x", w)
+	}
+	str := fmt.Sprintf("%04x: %-14s  %s", l.Address, strings.Join(words, " "), l.Text())
+	if l.Label != "" {
+		str += "  ; " + l.Label
+	}
+	return str
+}
+
+// Text returns just the assembly for the line
+func (l Line) Text() string {
+	if !l.Instruction.Opcode.Valid() {
+		return fmt.Sprintf("DAT %#04x", l.Words[0])
+	}
+	return l.Instruction.String()
+}
+
+// Disassemble disassembles words, which are located at origin.
+// Invalid instructions are emitted as a single-word DAT.
+func Disassemble(words []core.Word, origin core.Word, spec core.SpecVersion) []Line {
+	var lines []Line
+	for i := 0; i < len(words); {
+		inst, length := core.DecodeSpec(words[i:], spec)
+		if !inst.Opcode.Valid() {
+			length = 1
+		}
+		end := i + int(length)
+		if end > len(words) {
+			// truncated instruction
+			end = len(words)
+		}
+		lines = append(lines, Line{
+			Address:     origin + core.Word(i),
+			Words:       words[i:end],
+			Instruction: inst,
+		})
+		i = end
+	}
+	return lines
+}
+
+// Memory disassembles count instructions from memory, starting at start.
+func Memory(ram *core.Memory, start core.Word, count int, spec core.SpecVersion) []Line {
+	lines := make([]Line, 0, count)
+	addr := start
+	for i := 0; i < count; i++ {
+		// no instruction is longer than 3 words
+		words := []core.Word{ram.Load(addr), ram.Load(addr + 1), ram.Load(addr + 2)}
+		inst, length := core.DecodeSpec(words, spec)
+		if !inst.Opcode.Valid() {
+			length = 1
+		}
+		lines = append(lines, Line{
+			Address:     addr,
+			Words:       words[:length],
+			Instruction: inst,
+		})
+		addr += length
+	}
+	return lines
+}
+
+// Density returns the proportion of the first count words that disassemble
+// into valid instructions, as a rough measure of whether the words are
+// code, e.g. to guess the byte order of a binary program.
+func Density(words []core.Word, count int, spec core.SpecVersion) float64 {
+	if count > len(words) {
+		count = len(words)
+	}
+	if count == 0 {
+		return 0
+	}
+	valid := 0
+	for _, line := range Disassemble(words[:count], 0, spec) {
+		if line.Instruction.Opcode.Valid() {
+			valid += len(line.Words)
+		}
+	}
+	return float64(valid) / float64(count)
+}
+
+// guessWords is the number of words at the start of a binary program
+// whose instructions are checked to guess its byte order
+const guessWords = 64
+
+// GuessLittleEndian returns whether more of the instructions at the start
+// of the binary program are valid when it's read as little endian. Ties go
+// to big endian, the usual order.
+func GuessLittleEndian(data []byte, spec core.SpecVersion) bool {
+	big := Density(core.DecodeBytes(data, false), guessWords, spec)
+	little := Density(core.DecodeBytes(data, true), guessWords, spec)
+	return little > big
+}
+
+// DumpFunc returns a function disassembling single instructions from
+// memory, for the Disassemble option of core.Memory's DumpMemoryOptions.
+// Instructions at the addresses of symbols are labelled.
+func DumpFunc(spec core.SpecVersion, symbols map[string]core.Word) func(m *core.Memory, address core.Word) (string, core.Word) {
+	labels := Labels(symbols)
+	return func(m *core.Memory, address core.Word) (string, core.Word) {
+		line := Memory(m, address, 1, spec)[0]
+		text := line.Text()
+		if label := labels[address]; label != "" {
+			text += "  ; " + label
+		}
+		return text, core.Word(len(line.Words))
+	}
+}
+
+// Annotate sets the Label of each line whose address has a symbol. When
+// several symbols share an address, the first in sorted order is used.
+func Annotate(lines []Line, symbols map[string]core.Word) {
+	labels := Labels(symbols)
+	for i := range lines {
+		lines[i].Label = labels[lines[i].Address]
+	}
+}
+
+// Labels returns the label for each address with a symbol, as Annotate
+// chooses them, e.g. for the Labels option of core.DumpOptions
+func Labels(symbols map[string]core.Word) map[core.Word]string {
+	labels := make(map[core.Word]string, len(symbols))
+	for name, addr := range symbols {
+		if label, ok := labels[addr]; !ok || name < label {
+			labels[addr] = name
+		}
+	}
+	return labels
+}
+
+// Fprint writes the lines to w, one per line.
+func Fprint(w io.Writer, lines []Line) error {
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
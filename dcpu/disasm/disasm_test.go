@@ -0,0 +1,122 @@
+package disasm
+
+import (
+	"bytes"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	program := []core.Word{
+		0x7c01, 0x0030, // set a, 0x30
+		0x7fc1, 0x0020, 0x1000, // set [0x1000], 0x20
+		0x0000,         // invalid
+		0x7c20, 0x0018, // jsr 0x18
+		0x6381, // set PC, pop
+	}
+	expected := []string{
+		"0100: 7c01 0030       SET A, 0x30",
+		"0102: 7fc1 0020 1000  SET [0x1000], 0x20",
+		"0105: 0000            DAT 0x0000",
+		"0106: 7c20 0018       JSR 0x18",
+		"0108: 6381            SET PC, POP",
+	}
+	lines := Disassemble(program, 0x100, core.Spec17)
+	if len(lines) != len(expected) {
+		t.Fatalf("Unexpected line count; expected %d, found %d", len(expected), len(lines))
+	}
+	for i, line := range lines {
+		if line.String() != expected[i] {
+			t.Errorf("Unexpected disassembly; expected %q, found %q", expected[i], line.String())
+		}
+	}
+
+	state := new(core.State)
+	if err := state.LoadProgram(program, 0x100); err != nil {
+		t.Fatal(err)
+	}
+	lines = Memory(&state.Ram, 0x100, len(expected), core.Spec17)
+	for i, line := range lines {
+		if line.String() != expected[i] {
+			t.Errorf("Unexpected disassembly from memory; expected %q, found %q", expected[i], line.String())
+		}
+	}
+}
+
+func TestDensity(t *testing.T) {
+	program := []core.Word{0x7c01, 0x0030, 0x7fc1, 0x0020, 0x1000, 0x7c20, 0x0018, 0x6381}
+	if d := Density(program, 64, core.Spec17); d != 1 {
+		t.Errorf("Expected the program to be all code, found a density of %v", d)
+	}
+	swapped := make([]core.Word, len(program))
+	for i, w := range program {
+		swapped[i] = w<<8 | w>>8
+	}
+	if d := Density(swapped, 64, core.Spec17); d >= 1 {
+		t.Errorf("Expected the byte-swapped program to be less dense, found %v", d)
+	}
+	if d := Density(nil, 64, core.Spec17); d != 0 {
+		t.Errorf("Expected no words to have a density of 0, found %v", d)
+	}
+}
+
+func TestGuessLittleEndian(t *testing.T) {
+	// the program of TestDensity
+	big := []byte{0x7c, 0x01, 0x00, 0x30, 0x7f, 0xc1, 0x00, 0x20, 0x10, 0x00, 0x7c, 0x20, 0x00, 0x18, 0x63, 0x81}
+	little := make([]byte, len(big))
+	for i := 0; i < len(big); i += 2 {
+		little[i], little[i+1] = big[i+1], big[i]
+	}
+	tests := []struct {
+		name   string
+		data   []byte
+		little bool
+	}{
+		{"big endian", big, false},
+		{"little endian", little, true},
+		// ties go to big endian
+		{"empty", nil, false},
+		{"zeros", make([]byte, 16), false},
+		{"palindromic words", []byte{0x01, 0x01, 0x01, 0x01}, false},
+	}
+	for _, test := range tests {
+		if guess := GuessLittleEndian(test.data, core.Spec17); guess != test.little {
+			t.Errorf("%s: expected little endian %v, found %v", test.name, test.little, guess)
+		}
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	lines := Disassemble([]core.Word{0x8401, 0x8b83}, 0, core.Spec17)
+	Annotate(lines, map[string]core.Word{"start": 0, "begin": 0, "halt": 1})
+	expected := []string{
+		"0000: 8401            SET A, 0x0  ; begin",
+		"0001: 8b83            SUB PC, 0x1  ; halt",
+	}
+	for i, line := range lines {
+		if line.String() != expected[i] {
+			t.Errorf("Unexpected line %d; expected %q, found %q", i, expected[i], line.String())
+		}
+	}
+}
+
+func TestDumpFunc(t *testing.T) {
+	state := new(core.State)
+	// SET A, 0x30; SUB PC, 1
+	if err := state.LoadProgram([]core.Word{0x7c01, 0x0030, 0x8b83}, 0); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	opts := core.DumpOptions{
+		Ranges:      []core.Region{{Start: 0, Length: 3}},
+		Disassemble: DumpFunc(core.Spec17, map[string]core.Word{"halt": 2}),
+	}
+	if err := state.Ram.DumpMemoryOptions(&buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	expected := "0000: 7c01 0030       SET A, 0x30\n" +
+		"0002: 8b83            SUB PC, 0x1  ; halt\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected dump; expected %q, found %q", expected, buf.String())
+	}
+}
@@ -0,0 +1,41 @@
+package dcpu
+
+import (
+	"encoding/binary"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"io"
+)
+
+// Disk images are stored as big-endian words, like programs. Reads past the
+// end of a short image return zeros, and writes past the end extend it.
+
+// sectorWords is the size of a sector on both the M35FD and the HMD2043
+const sectorWords = 512
+
+// readSectors copies count sectors starting at sector from the disk into
+// memory at address
+func readSectors(disk io.ReaderAt, s *core.State, sector, count, address core.Word) error {
+	buf := make([]byte, int(count)*sectorWords*2)
+	n, err := disk.ReadAt(buf, int64(sector)*sectorWords*2)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	for i := n; i < len(buf); i++ {
+		buf[i] = 0
+	}
+	for i := 0; i < len(buf)/2; i++ {
+		s.Ram.Store(address+core.Word(i), core.Word(binary.BigEndian.Uint16(buf[i*2:])))
+	}
+	return nil
+}
+
+// writeSectors copies count sectors from memory at address to the disk
+// starting at sector
+func writeSectors(disk io.WriterAt, s *core.State, sector, count, address core.Word) error {
+	buf := make([]byte, int(count)*sectorWords*2)
+	for i := 0; i < len(buf)/2; i++ {
+		binary.BigEndian.PutUint16(buf[i*2:], uint16(s.Ram.Load(address+core.Word(i))))
+	}
+	_, err := disk.WriteAt(buf, int64(sector)*sectorWords*2)
+	return err
+}
@@ -0,0 +1,126 @@
+package dcpu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/kballard/dcpu16/dcpu/core"
+)
+
+// DMA is a controller that copies memory in the background, much faster
+// than the program could. The operation is selected by A when the device is
+// sent HWI:
+//
+//	A=0 B is set to the number of words left to copy, or 0 if idle
+//	A=1 start copying C words from B to X, and set C to 1; if a copy is
+//	    already in progress, nothing happens and C is set to 0
+//	A=2 if B is non-zero, an interrupt with message B is raised when a
+//	    copy finishes; if B is 0 interrupts are turned off
+//
+// Overlapping ranges are copied as if through a temporary buffer, though
+// the program sees the destination change as the copy goes.
+type DMA struct {
+	WordsPerCycle int // the words copied each cycle; 0 means 1
+	src, dst      core.Word
+	left          core.Word
+	backward      bool // copying from the end, for an overlapping copy upwards
+	message       core.Word
+}
+
+const (
+	DMAID           = 0x020b0001
+	DMAVersion      = 1
+	DMAManufacturer = 0x6b62616c
+)
+
+const (
+	dmaStatus = iota
+	dmaCopy
+	dmaSetInterrupt
+)
+
+func (d *DMA) ID() uint32           { return DMAID }
+func (d *DMA) Version() core.Word   { return DMAVersion }
+func (d *DMA) Manufacturer() uint32 { return DMAManufacturer }
+
+func (d *DMA) HandleInterrupt(s *core.State) error {
+	switch s.A() {
+	case dmaStatus:
+		s.SetB(d.left)
+	case dmaCopy:
+		if d.left != 0 {
+			s.SetC(0)
+			break
+		}
+		d.src, d.dst, d.left = s.B(), s.X(), s.C()
+		// the destination starts inside the source, so copy from the end
+		d.backward = d.dst-d.src < d.left && d.dst != d.src
+		if d.backward {
+			d.src += d.left - 1
+			d.dst += d.left - 1
+		}
+		s.SetC(1)
+	case dmaSetInterrupt:
+		d.message = s.B()
+	}
+	return nil
+}
+
+// Tick copies the next words, raising an interrupt once the copy finishes
+func (d *DMA) Tick(s *core.State) error {
+	if d.left == 0 {
+		return nil
+	}
+	n := d.WordsPerCycle
+	if n <= 0 {
+		n = 1
+	}
+	for ; n > 0 && d.left > 0; n-- {
+		if err := s.Ram.Store(d.dst, s.Ram.Load(d.src)); err != nil {
+			d.left = 0
+			return err
+		}
+		if d.backward {
+			d.src--
+			d.dst--
+		} else {
+			d.src++
+			d.dst++
+		}
+		d.left--
+	}
+	if d.left == 0 && d.message != 0 {
+		s.TriggerInterrupt(d.message)
+	}
+	return nil
+}
+
+// Reset abandons any copy and turns off interrupts
+func (d *DMA) Reset() {
+	d.left = 0
+	d.message = 0
+}
+
+// dmaSnapshot is the snapshot of a DMA
+type dmaSnapshot struct {
+	Src, Dst, Left core.Word
+	Backward       bool
+	Message        core.Word
+}
+
+func (d *DMA) Snapshot() ([]byte, error) {
+	var buf bytes.Buffer
+	snap := dmaSnapshot{d.src, d.dst, d.left, d.backward, d.message}
+	if err := binary.Write(&buf, binary.LittleEndian, &snap); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (d *DMA) Restore(data []byte) error {
+	var snap dmaSnapshot
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &snap); err != nil {
+		return core.ErrBadSnapshot
+	}
+	d.src, d.dst, d.left, d.backward, d.message = snap.Src, snap.Dst, snap.Left, snap.Backward, snap.Message
+	return nil
+}
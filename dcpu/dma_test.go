@@ -0,0 +1,63 @@
+package dcpu
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"testing"
+)
+
+func TestDMA(t *testing.T) {
+	state := new(core.State)
+	dma := &DMA{WordsPerCycle: 2}
+	if _, err := state.AttachDevice(dma); err != nil {
+		t.Fatal(err)
+	}
+	// SUB PC, 1
+	if err := state.LoadProgram([]core.Word{0x8b83}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.LoadProgram([]core.Word{0x8b83}, 0x100); err != nil {
+		t.Fatal(err)
+	}
+	state.SetIA(0x100)
+	hwi := func(a, b, c, x core.Word) {
+		state.SetA(a)
+		state.SetB(b)
+		state.SetC(c)
+		state.SetX(x)
+		if err := dma.HandleInterrupt(state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := core.Word(0); i < 5; i++ {
+		state.Ram.Store(0x1000+i, 0x10+i)
+	}
+
+	hwi(dmaSetInterrupt, 0x42, 0, 0)
+	// an overlapping copy upwards
+	hwi(dmaCopy, 0x1000, 5, 0x1002)
+	if state.C() != 1 {
+		t.Fatal("Expected the copy to start")
+	}
+	hwi(dmaCopy, 0x2000, 1, 0x3000)
+	if state.C() != 0 {
+		t.Error("Expected a second copy to be refused")
+	}
+	if _, err := state.RunFor(2); err != nil {
+		t.Fatal(err)
+	}
+	hwi(dmaStatus, 0, 0, 0)
+	if state.B() != 1 {
+		t.Errorf("Expected 1 word left after 2 cycles, found %d", state.B())
+	}
+	if _, err := state.RunUntil(func(s *core.State) bool { return s.PC() == 0x100 }, 100); err != nil {
+		t.Fatal(err)
+	}
+	if state.A() != 0x42 {
+		t.Errorf("Expected interrupt message 0x42, found %#04x", state.A())
+	}
+	for i := core.Word(0); i < 5; i++ {
+		if w := state.Ram.Load(0x1002 + i); w != 0x10+i {
+			t.Errorf("Expected %#04x at %#04x, found %#04x", 0x10+i, 0x1002+i, w)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package dcpu
+
+import "github.com/kballard/dcpu16/dcpu/core"
+
+// Event is something that happened to a running machine, sent on EventsC.
+// It's one of Halted, BreakpointHit, ProtectionFault, DeviceError,
+// TestPassed, TestFailure or Stopped.
+type Event interface {
+	isEvent()
+}
+
+// Halted is sent when the program is spinning on a halt idiom (see
+// core.State.Halted). The machine stops running cycles, but keeps
+// refreshing the screen until it's stopped.
+type Halted struct {
+	PC     core.Word
+	Cycles uint64
+}
+
+// BreakpointHit is sent when the machine stops at a breakpoint. It's
+// followed by Stopped.
+type BreakpointHit struct {
+	PC core.Word
+}
+
+// ProtectionFault is sent when the program writes to protected memory, or
+// reads from read-protected memory. It's followed by Stopped.
+type ProtectionFault struct {
+	PC      core.Word
+	Address core.Word
+	Read    bool
+}
+
+// DeviceError is sent when a device fails to handle an interrupt. It's
+// followed by Stopped.
+type DeviceError struct {
+	PC     core.Word
+	Device core.Word // the hardware number of the device
+	Err    error
+}
+
+// Stopped is the last event sent when the machine stops running without
+// Stop being called. Err is the *MachineError that stopped it, or the
+// context's error if it was started with StartContext. Stop must still be
+// called to shut down the screen.
+type Stopped struct {
+	Err error
+}
+
+func (Halted) isEvent()          {}
+func (BreakpointHit) isEvent()   {}
+func (ProtectionFault) isEvent() {}
+func (DeviceError) isEvent()     {}
+func (Stopped) isEvent()         {}
+
+// eventBuffer is the capacity of EventsC. The last two slots are kept free
+// for the events sent as the machine stops, so they never block.
+const eventBuffer = 8
+
+// errorEvent returns the event describing the error that stopped the
+// machine, if there's one more specific than Stopped
+func errorEvent(err error) Event {
+	merr, ok := err.(*MachineError)
+	if !ok {
+		return nil
+	}
+	switch e := merr.UnderlyingError.(type) {
+	case *core.BreakpointError:
+		return BreakpointHit{e.PC}
+	case *core.ProtectionError:
+		return ProtectionFault{merr.PC, e.Address, e.Read}
+	case *core.DeviceError:
+		if e.Err == ErrTestPassed {
+			return TestPassed{merr.PC}
+		}
+		if failure, ok := e.Err.(*TestFailure); ok {
+			evt := *failure
+			evt.PC = merr.PC
+			return evt
+		}
+		return DeviceError{merr.PC, e.Index, e.Err}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package dcpu
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"image"
+	"sync"
+)
+
+// The size in pixels of the frames of a Framebuffer: the 128x96 pixel
+// display and its border
+const (
+	FrameWidth  = windowWidth*4 + 2*screenshotBorder
+	FrameHeight = windowHeight*8 + 2*screenshotBorder
+)
+
+// Framebuffer is a VideoBackend and FontDisplay that renders the screen as
+// pixels, with the LEM1802's font and palette, for graphical frontends. The
+// frontend shows the latest Frame, which is rendered on each Flush, from
+// its own goroutine. The zero value is ready to use.
+type Framebuffer struct {
+	screen Screenshot // the cells drawn since the last Flush, and so on
+	mu     sync.Mutex
+	shown  Screenshot
+	frame  *image.RGBA
+}
+
+func (f *Framebuffer) Init() error {
+	f.screen = Screenshot{Font: defaultFont, Palette: DefaultPalette}
+	return nil
+}
+
+func (f *Framebuffer) SetCell(x, y int, ch rune, fg, bg byte, blink bool) {
+	if x <= 0 || y <= 0 || x > windowWidth || y > windowHeight {
+		f.screen.Border = core.Word(bg)
+		return
+	}
+	f.screen.Cells[(y-1)*windowWidth+x-1] = cellWord(ch, fg, bg, blink)
+}
+
+// cellWord encodes a cell drawn with SetCell as the word in video memory
+func cellWord(ch rune, fg, bg byte, blink bool) core.Word {
+	cell := core.Word(fg&0xf)<<12 | core.Word(bg&0xf)<<8 | core.Word(ch&0x7f)
+	if blink {
+		cell |= 0x80
+	}
+	return cell
+}
+
+func (f *Framebuffer) SetPalette(palette [16]core.Word) {
+	f.screen.Palette = palette
+}
+
+func (f *Framebuffer) SetFont(font [256]core.Word) {
+	f.screen.Font = font
+}
+
+// Flush renders the frame
+func (f *Framebuffer) Flush() {
+	shot := f.screen
+	frame := shot.Image(1).(*image.RGBA)
+	f.mu.Lock()
+	f.shown, f.frame = shot, frame
+	f.mu.Unlock()
+}
+
+func (f *Framebuffer) Close() {}
+
+// Frame returns the frame rendered by the last Flush, of FrameWidth by
+// FrameHeight pixels, or nil if nothing has been flushed. The frame isn't
+// modified afterwards, so its pixels can be handed straight to a texture.
+func (f *Framebuffer) Frame() *image.RGBA {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.frame
+}
+
+// Screen returns the screen rendered by the last Flush, for frontends that
+// draw it themselves
+func (f *Framebuffer) Screen() Screenshot {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.shown
+}
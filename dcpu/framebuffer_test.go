@@ -0,0 +1,58 @@
+package dcpu
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"image/color"
+	"testing"
+)
+
+func TestFramebuffer(t *testing.T) {
+	fb := new(Framebuffer)
+	m, err := NewMachine(WithVideoBackend(fb))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Video.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Video.MapToMachine(0x8000, m); err != nil {
+		t.Fatal(err)
+	}
+	if fb.Frame() != nil {
+		t.Error("Expected no frame before the first flush")
+	}
+	// character 1 of the custom font has a solid left column, in white on
+	// red; the custom palette has a bright green border
+	m.State.Ram.Store(0x1000, 0xf401)
+	m.State.Ram.Store(0x2002, 0xff00)
+	hwi := func(a, b core.Word) {
+		m.State.SetA(a)
+		m.State.SetB(b)
+		m.Video.HandleInterrupt(&m.State)
+	}
+	hwi(lemMapScreen, 0x1000)
+	hwi(lemMapFont, 0x2000)
+	hwi(lemDumpPalette, 0x3000)
+	m.State.Ram.Store(0x3005, 0x0f0)
+	hwi(lemMapPalette, 0x3000)
+	hwi(lemSetBorderColor, 5)
+	m.Video.refresh()
+	m.Video.Flush()
+
+	if screen := fb.Screen(); screen.Cells[0] != 0xf401 || screen.Palette[5] != 0x0f0 || screen.Border != 5 {
+		t.Errorf("Unexpected screen %+v", screen)
+	}
+	frame := fb.Frame()
+	if size := frame.Bounds().Size(); size.X != FrameWidth || size.Y != FrameHeight {
+		t.Fatalf("Unexpected frame size %v", size)
+	}
+	green, white, red := color.RGBA{0, 0xff, 0, 0xff}, color.RGBA{0xff, 0xff, 0xff, 0xff}, color.RGBA{0xaa, 0, 0, 0xff}
+	for _, px := range []struct {
+		x, y     int
+		expected color.RGBA
+	}{{0, 0, green}, {16, 16, white}, {16, 23, white}, {17, 16, red}, {20, 16, color.RGBA{0, 0, 0, 0xff}}} {
+		if c := frame.At(px.x, px.y); c != px.expected {
+			t.Errorf("Unexpected color at (%d, %d); expected %v, found %v", px.x, px.y, px.expected, c)
+		}
+	}
+}
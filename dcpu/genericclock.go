@@ -0,0 +1,109 @@
+package dcpu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/kballard/dcpu16/dcpu/core"
+)
+
+// GenericClock is the Generic Clock hardware. The operation is selected by
+// A when the device is sent HWI:
+//
+//	A=0 the clock ticks 60/B times a second, or stops if B is 0. The tick
+//	    count is reset.
+//	A=1 C is set to the number of ticks since A=0
+//	A=2 if B is non-zero, an interrupt with message B is raised on each
+//	    tick; if B is 0 interrupts are turned off
+//
+// Time is measured in machine cycles rather than on the host's clock, so
+// programs behave the same however fast the machine actually runs.
+type GenericClock struct {
+	CyclesPerSecond uint64 // the cycles in a second; 0 means DefaultClockRate
+	divider         core.Word
+	start           uint64 // the cycle the clock was started at
+	ticks           uint64 // the ticks since the clock was started
+	message         core.Word
+}
+
+const (
+	GenericClockID           = 0x12d0b402
+	GenericClockVersion      = 1
+	GenericClockManufacturer = 0
+)
+
+const (
+	clockSetRate = iota
+	clockGetTicks
+	clockSetInterrupts
+)
+
+func (c *GenericClock) ID() uint32           { return GenericClockID }
+func (c *GenericClock) Version() core.Word   { return GenericClockVersion }
+func (c *GenericClock) Manufacturer() uint32 { return GenericClockManufacturer }
+
+func (c *GenericClock) HandleInterrupt(s *core.State) error {
+	switch s.A() {
+	case clockSetRate:
+		c.divider = s.B()
+		c.start = s.Cycles()
+		c.ticks = 0
+	case clockGetTicks:
+		s.SetC(core.Word(c.ticks))
+	case clockSetInterrupts:
+		c.message = s.B()
+	}
+	return nil
+}
+
+// Tick counts the clock's ticks, raising an interrupt for each if they're
+// turned on
+func (c *GenericClock) Tick(s *core.State) error {
+	if c.divider == 0 {
+		return nil
+	}
+	rate := c.CyclesPerSecond
+	if rate == 0 {
+		rate = uint64(DefaultClockRate)
+	}
+	ticks := (s.Cycles() - c.start) * 60 / (uint64(c.divider) * rate)
+	for ; c.ticks < ticks; c.ticks++ {
+		if c.message != 0 {
+			s.TriggerInterrupt(c.message)
+		}
+	}
+	return nil
+}
+
+// Reset stops the clock and turns off interrupts
+func (c *GenericClock) Reset() {
+	c.divider = 0
+	c.start = 0
+	c.ticks = 0
+	c.message = 0
+}
+
+// genericClockSnapshot is the snapshot of a GenericClock
+type genericClockSnapshot struct {
+	Divider core.Word
+	Start   uint64
+	Ticks   uint64
+	Message core.Word
+}
+
+func (c *GenericClock) Snapshot() ([]byte, error) {
+	var buf bytes.Buffer
+	snap := genericClockSnapshot{c.divider, c.start, c.ticks, c.message}
+	if err := binary.Write(&buf, binary.LittleEndian, &snap); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *GenericClock) Restore(data []byte) error {
+	var snap genericClockSnapshot
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &snap); err != nil {
+		return core.ErrBadSnapshot
+	}
+	c.divider, c.start, c.ticks, c.message = snap.Divider, snap.Start, snap.Ticks, snap.Message
+	return nil
+}
@@ -0,0 +1,48 @@
+package dcpu
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"testing"
+)
+
+func TestGenericClock(t *testing.T) {
+	state := new(core.State)
+	clock := &GenericClock{CyclesPerSecond: 600}
+	if _, err := state.AttachDevice(clock); err != nil {
+		t.Fatal(err)
+	}
+	// 60/B = 6 ticks a second, or one every 100 cycles
+	state.SetA(clockSetRate)
+	state.SetB(10)
+	clock.HandleInterrupt(state)
+	state.SetIA(0x100)
+	state.SetA(clockSetInterrupts)
+	state.SetB(0x42)
+	clock.HandleInterrupt(state)
+	// SUB PC, 1 in both the program and the interrupt handler
+	if err := state.LoadProgram([]core.Word{0x8b83}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.LoadProgram([]core.Word{0x8b83}, 0x100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := state.RunFor(250); err != nil {
+		t.Fatal(err)
+	}
+	// the first tick's interrupt has been handled
+	if state.PC() != 0x100 || state.A() != 0x42 {
+		t.Errorf("Expected the tick to interrupt; PC %#x, A %#x", state.PC(), state.A())
+	}
+	state.SetA(clockGetTicks)
+	clock.HandleInterrupt(state)
+	if state.C() != 2 {
+		t.Errorf("Expected 2 ticks, found %d", state.C())
+	}
+
+	clock.Reset()
+	state.SetA(clockGetTicks)
+	clock.HandleInterrupt(state)
+	if state.C() != 0 {
+		t.Errorf("Expected the reset clock to have no ticks, found %d", state.C())
+	}
+}
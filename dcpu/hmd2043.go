@@ -0,0 +1,275 @@
+package dcpu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"io"
+)
+
+// HMD2043 is the Harold Media Drive, with HMU1440 media of 1440 sectors of
+// 512 words. The operation is selected by A when the device is sent HWI,
+// and A is set to the error code afterwards:
+//
+//	0x0000 B is set to 1 if media is present
+//	0x0001 B is set to the words per sector, C to the number of sectors,
+//	       and X to 1 if the media is write locked
+//	0x0002 B is set to the device flags
+//	0x0003 the device flags are set to B
+//	0x0004 B is set to the type of the last interrupt
+//	0x0005 the interrupt message is set to B; 0 turns interrupts off
+//	0x0010 read C sectors starting at B into memory at X
+//	0x0011 write C sectors starting at B from memory at X
+//	0xffff B is set to 0x7fff, as the media is genuine
+//
+// Reads and writes finish immediately, unless the non-blocking flag is set.
+// Then they take as long as on the M35FD, as the spec doesn't give timings,
+// and raise an interrupt when they finish.
+type HMD2043 struct {
+	CyclesPerSecond uint64 // the cycles in a second; 0 means DefaultClockRate
+	disk            io.ReaderAt
+	writer          io.WriterAt // nil if the media is write locked
+	flags           core.Word
+	message         core.Word
+	lastInterrupt   core.Word
+	pending         core.Word // the operation in progress, or 0
+	sector          core.Word
+	count           core.Word
+	address         core.Word
+	cyclesLeft      uint64 // until the operation finishes
+	interrupt       bool   // an interrupt is due on the next tick
+}
+
+const (
+	HMD2043ID           = 0x74fa4cae
+	HMD2043Version      = 0x07c2
+	HMD2043Manufacturer = 0x21544948
+)
+
+const (
+	hmdQueryMediaPresent    = 0x0000
+	hmdQueryMediaParameters = 0x0001
+	hmdQueryDeviceFlags     = 0x0002
+	hmdUpdateDeviceFlags    = 0x0003
+	hmdQueryInterruptType   = 0x0004
+	hmdSetInterruptMessage  = 0x0005
+	hmdReadSectors          = 0x0010
+	hmdWriteSectors         = 0x0011
+	hmdQueryMediaQuality    = 0xffff
+)
+
+// HMD2043 device flags
+const (
+	HMD2043NonBlocking          = 1 << 0
+	HMD2043MediaStatusInterrupt = 1 << 1
+)
+
+// HMD2043 interrupt types
+const (
+	HMD2043InterruptNone = iota
+	HMD2043InterruptMediaStatus
+	HMD2043InterruptReadComplete
+	HMD2043InterruptWriteComplete
+)
+
+// HMD2043 errors
+const (
+	HMD2043ErrorNone = iota
+	HMD2043ErrorNoMedia
+	HMD2043ErrorInvalidSector
+	HMD2043ErrorPending
+)
+
+const HMD2043Sectors = 1440
+
+func (d *HMD2043) ID() uint32           { return HMD2043ID }
+func (d *HMD2043) Version() core.Word   { return HMD2043Version }
+func (d *HMD2043) Manufacturer() uint32 { return HMD2043Manufacturer }
+
+// Insert inserts media. If writeLocked is false, disk must also be an
+// io.WriterAt. Like the other methods, it must be called while the machine
+// is stopped or from the goroutine running it.
+func (d *HMD2043) Insert(disk io.ReaderAt, writeLocked bool) error {
+	if d.disk != nil {
+		return errors.New("HMD2043: media is already inserted")
+	}
+	var writer io.WriterAt
+	if !writeLocked {
+		var ok bool
+		if writer, ok = disk.(io.WriterAt); !ok {
+			return errors.New("HMD2043: media isn't writable")
+		}
+	}
+	d.disk, d.writer = disk, writer
+	d.mediaChanged()
+	return nil
+}
+
+// Eject removes the media, returning it. An operation in progress is
+// abandoned.
+func (d *HMD2043) Eject() io.ReaderAt {
+	disk := d.disk
+	d.disk, d.writer = nil, nil
+	d.pending = 0
+	d.mediaChanged()
+	return disk
+}
+
+func (d *HMD2043) mediaChanged() {
+	if d.flags&HMD2043MediaStatusInterrupt != 0 {
+		d.raise(HMD2043InterruptMediaStatus)
+	}
+}
+
+// raise arranges for an interrupt of the given type on the next tick
+func (d *HMD2043) raise(kind core.Word) {
+	d.lastInterrupt = kind
+	d.interrupt = true
+}
+
+func (d *HMD2043) HandleInterrupt(s *core.State) error {
+	code := core.Word(HMD2043ErrorNone)
+	switch s.A() {
+	case hmdQueryMediaPresent:
+		s.SetB(0)
+		if d.disk != nil {
+			s.SetB(1)
+		}
+	case hmdQueryMediaParameters:
+		if d.disk == nil {
+			code = HMD2043ErrorNoMedia
+			break
+		}
+		s.SetB(sectorWords)
+		s.SetC(HMD2043Sectors)
+		s.SetX(0)
+		if d.writer == nil {
+			s.SetX(1)
+		}
+	case hmdQueryDeviceFlags:
+		s.SetB(d.flags)
+	case hmdUpdateDeviceFlags:
+		d.flags = s.B()
+	case hmdQueryInterruptType:
+		s.SetB(d.lastInterrupt)
+	case hmdSetInterruptMessage:
+		d.message = s.B()
+	case hmdReadSectors, hmdWriteSectors:
+		code = d.startTransfer(s, s.A(), s.B(), s.C(), s.X())
+	case hmdQueryMediaQuality:
+		if d.disk == nil {
+			code = HMD2043ErrorNoMedia
+			break
+		}
+		s.SetB(0x7fff)
+	}
+	s.SetA(code)
+	return nil
+}
+
+// startTransfer starts a read or write, returning the error code
+func (d *HMD2043) startTransfer(s *core.State, op, sector, count, address core.Word) core.Word {
+	switch {
+	case d.disk == nil || (op == hmdWriteSectors && d.writer == nil):
+		// the spec has no error for writing to locked media
+		return HMD2043ErrorNoMedia
+	case d.pending != 0:
+		return HMD2043ErrorPending
+	case int(sector)+int(count) > HMD2043Sectors:
+		return HMD2043ErrorInvalidSector
+	}
+	d.pending, d.sector, d.count, d.address = op, sector, count, address
+	if d.flags&HMD2043NonBlocking == 0 {
+		d.finish(s)
+		return HMD2043ErrorNone
+	}
+	rate := d.CyclesPerSecond
+	if rate == 0 {
+		rate = uint64(DefaultClockRate)
+	}
+	d.cyclesLeft = uint64(count)*sectorWords*rate/m35fdWordsPerSecond + 1
+	return HMD2043ErrorNone
+}
+
+// finish completes the operation in progress
+func (d *HMD2043) finish(s *core.State) {
+	// the spec has no error for a failed transfer, so the data is lost
+	if d.pending == hmdReadSectors {
+		readSectors(d.disk, s, d.sector, d.count, d.address)
+	} else {
+		writeSectors(d.writer, s, d.sector, d.count, d.address)
+	}
+	if d.flags&HMD2043NonBlocking != 0 {
+		if d.pending == hmdReadSectors {
+			d.raise(HMD2043InterruptReadComplete)
+		} else {
+			d.raise(HMD2043InterruptWriteComplete)
+		}
+	}
+	d.pending = 0
+}
+
+// Tick finishes a non-blocking operation once it's due, and raises any
+// interrupt
+func (d *HMD2043) Tick(s *core.State) error {
+	if d.pending != 0 {
+		if d.cyclesLeft > 0 {
+			d.cyclesLeft--
+		}
+		if d.cyclesLeft == 0 {
+			d.finish(s)
+		}
+	}
+	if d.interrupt {
+		d.interrupt = false
+		if d.message != 0 {
+			s.TriggerInterrupt(d.message)
+		}
+	}
+	return nil
+}
+
+// Reset clears the flags, turns off interrupts and abandons any operation
+// in progress. The media stays inserted.
+func (d *HMD2043) Reset() {
+	d.flags = 0
+	d.message = 0
+	d.lastInterrupt = HMD2043InterruptNone
+	d.pending = 0
+	d.cyclesLeft = 0
+	d.interrupt = false
+}
+
+// hmd2043Snapshot is the snapshot of an HMD2043. The media isn't included.
+type hmd2043Snapshot struct {
+	Flags, Message, LastInterrupt   core.Word
+	Pending, Sector, Count, Address core.Word
+	CyclesLeft                      uint64
+	Interrupt                       bool
+}
+
+func (d *HMD2043) Snapshot() ([]byte, error) {
+	var buf bytes.Buffer
+	snap := hmd2043Snapshot{d.flags, d.message, d.lastInterrupt, d.pending, d.sector, d.count, d.address, d.cyclesLeft, d.interrupt}
+	if err := binary.Write(&buf, binary.LittleEndian, &snap); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Restore restores the drive's state, but keeps the media that's inserted.
+// An operation in progress is abandoned if the media has been ejected.
+func (d *HMD2043) Restore(data []byte) error {
+	var snap hmd2043Snapshot
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &snap); err != nil {
+		return core.ErrBadSnapshot
+	}
+	d.flags, d.message, d.lastInterrupt = snap.Flags, snap.Message, snap.LastInterrupt
+	d.pending, d.sector, d.count, d.address = snap.Pending, snap.Sector, snap.Count, snap.Address
+	d.cyclesLeft, d.interrupt = snap.CyclesLeft, snap.Interrupt
+	if d.disk == nil || (d.pending == hmdWriteSectors && d.writer == nil) {
+		d.pending = 0
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package dcpu
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"testing"
+)
+
+func TestHMD2043(t *testing.T) {
+	state := new(core.State)
+	drive := new(HMD2043)
+	if _, err := state.AttachDevice(drive); err != nil {
+		t.Fatal(err)
+	}
+	// SUB PC, 1 in both the program and the interrupt handler
+	if err := state.LoadProgram([]core.Word{0x8b83}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.LoadProgram([]core.Word{0x8b83}, 0x100); err != nil {
+		t.Fatal(err)
+	}
+	hwi := func(a, b, c, x core.Word) {
+		state.SetA(a)
+		state.SetB(b)
+		state.SetC(c)
+		state.SetX(x)
+		drive.HandleInterrupt(state)
+	}
+
+	hwi(hmdReadSectors, 0, 1, 0x1000)
+	if state.A() != HMD2043ErrorNoMedia {
+		t.Errorf("Expected no media, found error %d", state.A())
+	}
+
+	disk := &memDisk{make([]byte, sectorWords*2+2)}
+	disk.data[sectorWords*2], disk.data[sectorWords*2+1] = 0x12, 0x34
+	if err := drive.Insert(disk, false); err != nil {
+		t.Fatal(err)
+	}
+	hwi(hmdQueryMediaParameters, 0, 0, 0)
+	if state.A() != HMD2043ErrorNone || state.B() != 512 || state.C() != 1440 || state.X() != 0 {
+		t.Errorf("Unexpected media parameters; A %d, B %d, C %d, X %d", state.A(), state.B(), state.C(), state.X())
+	}
+
+	// blocking reads finish immediately
+	hwi(hmdReadSectors, 0, 2, 0x1000)
+	if state.A() != HMD2043ErrorNone || state.Ram.Load(0x1200) != 0x1234 || state.Ram.Load(0x1201) != 0 {
+		t.Errorf("Unexpected read; error %d, %#x", state.A(), state.Ram.Load(0x1200))
+	}
+	hwi(hmdReadSectors, 1439, 2, 0x1000)
+	if state.A() != HMD2043ErrorInvalidSector {
+		t.Errorf("Expected an invalid sector, found error %d", state.A())
+	}
+
+	// non-blocking writes interrupt when they finish
+	state.SetIA(0x100)
+	hwi(hmdUpdateDeviceFlags, HMD2043NonBlocking, 0, 0)
+	hwi(hmdSetInterruptMessage, 0x55, 0, 0)
+	state.Ram.Store(0x2000, 0xabcd)
+	hwi(hmdWriteSectors, 2, 1, 0x2000)
+	if state.A() != HMD2043ErrorNone {
+		t.Fatalf("Expected the write to start, found error %d", state.A())
+	}
+	hwi(hmdWriteSectors, 3, 1, 0x2000)
+	if state.A() != HMD2043ErrorPending {
+		t.Errorf("Expected the write to be pending, found error %d", state.A())
+	}
+	if _, err := state.RunUntil(func(s *core.State) bool { return s.PC() == 0x100 }, 10000); err != nil {
+		t.Fatal(err)
+	}
+	if state.A() != 0x55 || disk.data[sectorWords*4] != 0xab {
+		t.Errorf("Expected the write to finish and interrupt; A %#x", state.A())
+	}
+	hwi(hmdQueryInterruptType, 0, 0, 0)
+	if state.B() != HMD2043InterruptWriteComplete {
+		t.Errorf("Expected a write complete interrupt, found %d", state.B())
+	}
+
+	drive.Eject()
+	if err := drive.Insert(disk, true); err != nil {
+		t.Fatal(err)
+	}
+	hwi(hmdQueryMediaParameters, 0, 0, 0)
+	if state.X() != 1 {
+		t.Error("Expected the media to be write locked")
+	}
+}
@@ -0,0 +1,203 @@
+package dcpu
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// HostFS is a non-standard device giving programs access to the host's files
+// under a directory, so toolchains written in DCPU assembly can read their
+// sources and write their output. Paths are strings of one character per
+// word, and are always relative to the directory; ".." can't climb out of
+// it, though symlinks inside it are followed. Files are read and written a
+// byte at a time, with one byte in the low byte of each word.
+//
+// The operation is selected by A when the device is sent HWI, and A is set
+// to the error code afterwards:
+//
+//	A=0 open the file whose path of C words is at B, for reading if X is
+//	    0, for writing (creating or truncating it) if X is 1, or for
+//	    appending if X is 2. B is set to the file's handle.
+//	A=1 close the file with handle B
+//	A=2 read up to C bytes from the file with handle B into memory at X.
+//	    C is set to the number of bytes read, which is 0 at the end.
+//	A=3 write C bytes from memory at X to the file with handle B
+//	A=4 remove the file whose path of C words is at B
+type HostFS struct {
+	Root  string // the directory programs are confined to
+	files [hostFSMaxFiles]*os.File
+}
+
+const (
+	HostFSID           = 0x02090001
+	HostFSVersion      = 1
+	HostFSManufacturer = 0x6b62616c
+)
+
+// hostFSMaxFiles is how many files can be open at once. Handles run from 1.
+const hostFSMaxFiles = 16
+
+const (
+	hostFSOpen = iota
+	hostFSClose
+	hostFSRead
+	hostFSWrite
+	hostFSRemove
+)
+
+// HostFS open modes
+const (
+	HostFSRead = iota
+	HostFSWrite
+	HostFSAppend
+)
+
+// HostFS errors
+const (
+	HostFSErrorNone = iota
+	HostFSErrorNotFound
+	HostFSErrorDenied
+	HostFSErrorBadHandle
+	HostFSErrorTooManyFiles
+	HostFSErrorIO
+	HostFSErrorBadMode
+)
+
+func (h *HostFS) ID() uint32           { return HostFSID }
+func (h *HostFS) Version() core.Word   { return HostFSVersion }
+func (h *HostFS) Manufacturer() uint32 { return HostFSManufacturer }
+
+func (h *HostFS) HandleInterrupt(s *core.State) error {
+	var code core.Word
+	switch s.A() {
+	case hostFSOpen:
+		code = h.open(s)
+	case hostFSClose:
+		code = h.close(s.B())
+	case hostFSRead:
+		code = h.read(s)
+	case hostFSWrite:
+		code = h.write(s)
+	case hostFSRemove:
+		code = hostFSError(os.Remove(h.path(s)))
+	}
+	s.SetA(code)
+	return nil
+}
+
+// path returns the host path of the path of C words at B
+func (h *HostFS) path(s *core.State) string {
+	name := make([]byte, s.C())
+	for i := range name {
+		name[i] = byte(s.Ram.Load(s.B() + core.Word(i)))
+	}
+	// cleaning the path as an absolute one drops any leading ".."
+	return filepath.Join(h.Root, filepath.Clean("/"+string(name)))
+}
+
+func (h *HostFS) open(s *core.State) core.Word {
+	var flags int
+	switch s.X() {
+	case HostFSRead:
+		flags = os.O_RDONLY
+	case HostFSWrite:
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	case HostFSAppend:
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	default:
+		return HostFSErrorBadMode
+	}
+	for i, f := range h.files {
+		if f == nil {
+			f, err := os.OpenFile(h.path(s), flags, 0666)
+			if err != nil {
+				return hostFSError(err)
+			}
+			h.files[i] = f
+			s.SetB(core.Word(i + 1))
+			return HostFSErrorNone
+		}
+	}
+	return HostFSErrorTooManyFiles
+}
+
+// file returns the open file with the given handle, or nil
+func (h *HostFS) file(handle core.Word) *os.File {
+	if handle == 0 || int(handle) > len(h.files) {
+		return nil
+	}
+	return h.files[handle-1]
+}
+
+func (h *HostFS) close(handle core.Word) core.Word {
+	f := h.file(handle)
+	if f == nil {
+		return HostFSErrorBadHandle
+	}
+	h.files[handle-1] = nil
+	return hostFSError(f.Close())
+}
+
+func (h *HostFS) read(s *core.State) core.Word {
+	f := h.file(s.B())
+	if f == nil {
+		return HostFSErrorBadHandle
+	}
+	buf := make([]byte, s.C())
+	n, err := io.ReadFull(f, buf)
+	for i := 0; i < n; i++ {
+		s.Ram.Store(s.X()+core.Word(i), core.Word(buf[i]))
+	}
+	s.SetC(core.Word(n))
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return hostFSError(err)
+}
+
+func (h *HostFS) write(s *core.State) core.Word {
+	f := h.file(s.B())
+	if f == nil {
+		return HostFSErrorBadHandle
+	}
+	buf := make([]byte, s.C())
+	for i := range buf {
+		buf[i] = byte(s.Ram.Load(s.X() + core.Word(i)))
+	}
+	_, err := f.Write(buf)
+	return hostFSError(err)
+}
+
+// hostFSError returns the HostFS error code for err
+func hostFSError(err error) core.Word {
+	switch {
+	case err == nil:
+		return HostFSErrorNone
+	case os.IsNotExist(err):
+		return HostFSErrorNotFound
+	case os.IsPermission(err):
+		return HostFSErrorDenied
+	}
+	return HostFSErrorIO
+}
+
+// Reset closes any open files
+func (h *HostFS) Reset() {
+	h.Close()
+}
+
+// Close closes any open files
+func (h *HostFS) Close() error {
+	var firstErr error
+	for i, f := range h.files {
+		if f != nil {
+			if err := f.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			h.files[i] = nil
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,75 @@
+package dcpu
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHostFS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hostfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	hostfs := &HostFS{Root: dir}
+	defer hostfs.Close()
+	state := new(core.State)
+	hwi := func(a, b, c, x core.Word) {
+		state.SetA(a)
+		state.SetB(b)
+		state.SetC(c)
+		state.SetX(x)
+		if err := hostfs.HandleInterrupt(state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	storeString := func(address core.Word, s string) core.Word {
+		for i, ch := range s {
+			state.Ram.Store(address+core.Word(i), core.Word(ch))
+		}
+		return core.Word(len(s))
+	}
+
+	// ".." can't escape the root
+	n := storeString(0x1000, "../out.txt")
+	hwi(hostFSOpen, 0x1000, n, HostFSWrite)
+	if state.A() != HostFSErrorNone {
+		t.Fatalf("Unexpected error %d opening for writing", state.A())
+	}
+	handle := state.B()
+	n = storeString(0x2000, "hi\n")
+	hwi(hostFSWrite, handle, n, 0x2000)
+	if state.A() != HostFSErrorNone {
+		t.Errorf("Unexpected error %d writing", state.A())
+	}
+	hwi(hostFSClose, handle, 0, 0)
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "out.txt")); err != nil || string(data) != "hi\n" {
+		t.Errorf("Expected the file inside the root, found %q (%v)", data, err)
+	}
+
+	n = storeString(0x1000, "out.txt")
+	hwi(hostFSOpen, 0x1000, n, HostFSRead)
+	handle = state.B()
+	hwi(hostFSRead, handle, 10, 0x3000)
+	if state.A() != HostFSErrorNone || state.C() != 3 || state.Ram.Load(0x3001) != 'i' {
+		t.Errorf("Unexpected read of %d bytes with error %d", state.C(), state.A())
+	}
+	hwi(hostFSRead, handle, 10, 0x3000)
+	if state.C() != 0 {
+		t.Errorf("Expected the end of the file, read %d bytes", state.C())
+	}
+	hwi(hostFSClose, handle, 0, 0)
+	hwi(hostFSClose, handle, 0, 0)
+	if state.A() != HostFSErrorBadHandle {
+		t.Errorf("Expected a bad handle error, found %d", state.A())
+	}
+
+	hwi(hostFSRemove, 0x1000, n, 0)
+	hwi(hostFSOpen, 0x1000, n, HostFSRead)
+	if state.A() != HostFSErrorNotFound {
+		t.Errorf("Expected a not found error, found %d", state.A())
+	}
+}
@@ -0,0 +1,130 @@
+// Package ihex reads programs in the Intel HEX format.
+package ihex
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"io"
+	"strings"
+)
+
+// Format selects how the data in a HEX file maps to words
+type Format int
+
+const (
+	// BigEndian files address bytes, with each word stored high byte first
+	BigEndian Format = iota
+	// LittleEndian files address bytes, with each word stored low byte first
+	LittleEndian
+	// Words files address words, as some DCPU-16 assemblers emit. The data
+	// of each record is still given as bytes, high byte first.
+	Words
+)
+
+const (
+	recordData                   = 0x00
+	recordEOF                    = 0x01
+	recordExtendedSegmentAddress = 0x02
+	recordStartSegmentAddress    = 0x03
+	recordExtendedLinearAddress  = 0x04
+	recordStartLinearAddress     = 0x05
+)
+
+// Error is returned for a malformed HEX file
+type Error struct {
+	Line int
+	Msg  string
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", err.Line, err.Msg)
+}
+
+// Read reads a HEX file, returning the memory image it describes, starting
+// at address 0. Addresses without data are zero. Start address records are
+// ignored, as the DCPU-16 always starts at 0.
+func Read(r io.Reader, format Format) ([]core.Word, error) {
+	var image []core.Word
+	var base int // from the extended address records, in the file's units
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if text[0] != ':' {
+			return nil, &Error{line, "record doesn't start with ':'"}
+		}
+		data, err := hex.DecodeString(text[1:])
+		if err != nil || len(data) < 5 {
+			return nil, &Error{line, "invalid record"}
+		}
+		var sum byte
+		for _, b := range data {
+			sum += b
+		}
+		if sum != 0 {
+			return nil, &Error{line, "bad checksum"}
+		}
+		count := int(data[0])
+		if len(data) != count+5 {
+			return nil, &Error{line, "wrong byte count"}
+		}
+		address := int(data[1])<<8 | int(data[2])
+		payload := data[4 : 4+count]
+		switch data[3] {
+		case recordData:
+			if image, err = store(image, base+address, payload, format); err != nil {
+				return nil, &Error{line, err.Error()}
+			}
+		case recordEOF:
+			return image, nil
+		case recordExtendedSegmentAddress, recordExtendedLinearAddress:
+			if count != 2 {
+				return nil, &Error{line, "wrong byte count"}
+			}
+			base = int(payload[0])<<8 | int(payload[1])
+			if data[3] == recordExtendedSegmentAddress {
+				base <<= 4
+			} else {
+				base <<= 16
+			}
+		case recordStartSegmentAddress, recordStartLinearAddress:
+		default:
+			return nil, &Error{line, fmt.Sprintf("unknown record type %#02x", data[3])}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, &Error{0, "missing end of file record"}
+}
+
+// store copies the data of a record at address into the image, growing it
+// as needed
+func store(image []core.Word, address int, payload []byte, format Format) ([]core.Word, error) {
+	// work in bytes, as a record may start or end halfway through a word
+	start := address
+	if format == Words {
+		start *= 2
+	}
+	end := start + len(payload)
+	if end > 0x20000 {
+		return nil, fmt.Errorf("data at %#x is past the end of memory", address)
+	}
+	if words := (end + 1) / 2; words > len(image) {
+		image = append(image, make([]core.Word, words-len(image))...)
+	}
+	for i, b := range payload {
+		pos := start + i
+		shift := uint(8)
+		if (pos%2 == 1) != (format == LittleEndian) {
+			shift = 0
+		}
+		w := &image[pos/2]
+		*w = *w&^(0xff<<shift) | core.Word(b)<<shift
+	}
+	return image, nil
+}
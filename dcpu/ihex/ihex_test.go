@@ -0,0 +1,47 @@
+package ihex
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		format   Format
+		src      string
+		expected []core.Word
+	}{
+		{BigEndian, ":047C0200FF2ADE8BEC\n:00000001FF\n", nil},
+		{BigEndian, ":040004007C0100304B\n:00000001FF\n", []core.Word{0, 0, 0x7c01, 0x0030}},
+		{LittleEndian, ":04000400017C30004B\n:00000001FF\n", []core.Word{0, 0, 0x7c01, 0x0030}},
+		{Words, ":040002007C0100304D\n:00000001FF\n", []core.Word{0, 0, 0x7c01, 0x0030}},
+	}
+	tests[0].expected = make([]core.Word, 0x3e03)
+	tests[0].expected[0x3e01], tests[0].expected[0x3e02] = 0xff2a, 0xde8b
+	for i, test := range tests {
+		words, err := Read(strings.NewReader(test.src), test.format)
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(words, test.expected) {
+			t.Errorf("%d: unexpected image %#v", i, words)
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	for _, src := range []string{
+		"040004007C0100304B\n:00000001FF\n",
+		":040004007C0100304C\n:00000001FF\n",
+		":050004007C0100304A\n:00000001FF\n",
+		":040004007C0100304B\n",
+		":00000006FA\n",
+	} {
+		if _, err := Read(strings.NewReader(src), BigEndian); err == nil {
+			t.Errorf("%q: expected an error", src)
+		}
+	}
+}
@@ -9,15 +9,41 @@ package dcpu
 import (
 	"errors"
 	"github.com/kballard/dcpu16/dcpu/core"
+	"sync"
+	"sync/atomic"
 )
 
 type Keyboard struct {
+	// Sources supply keys besides those registered, pasted or held, such
+	// as the keys of an input script
+	Sources []KeyboardSource
+
+	// PasteInterval is the least cycles between the keys of pasted text;
+	// 0 means DefaultPasteInterval
+	PasteInterval uint64
+	// RepeatDelay is the cycles a key is held before it repeats, and
+	// RepeatInterval the cycles between its repeats; if RepeatInterval is
+	// 0, held keys don't repeat
+	RepeatDelay, RepeatInterval uint64
+
 	words    [0x10]core.Word
 	input    chan rune
 	offset   int
 	keysDown map[Key]bool
+	mu       sync.Mutex // guards the pasted and held keys
+	queued   int32      // accessed atomically; 1 if keys are pasted or held
+	pasted   []rune     // the keys waiting to be typed
+	pastedAt uint64     // the cycle the last pasted key was typed at
+	held     []core.Word
+	holding  bool
+	heldAt   uint64      // 1 + the cycle the held key was first typed at, or 0
+	repeats  uint64      // the times the held key has repeated
+	typing   []core.Word // what's left of the held key's codes to type
 }
 
+// DefaultPasteInterval types 100 keys a second at the DefaultClockRate
+const DefaultPasteInterval = 1000
+
 type Key uint16
 
 const (
@@ -27,17 +53,155 @@ const (
 	KeyArrowDown      = 129
 )
 
-// PollKeys checks for any pending keys and stuffs them into the buffer
-func (k *Keyboard) PollKeys() {
+// PollKeys checks for any pending keys and stuffs them into the buffer.
+// It returns the key that was added, if any.
+func (k *Keyboard) PollKeys() (core.Word, bool) {
 	if k.words[k.offset] == 0 {
 		// we have an open spot; check for a key
 		select {
 		case key := <-k.input:
-			k.words[k.offset] = core.Word(key)
-			k.offset = (k.offset + 1) % len(k.words)
+			k.pushKey(core.Word(key))
+			return core.Word(key), true
 		default:
 		}
 	}
+	return 0, false
+}
+
+// Paste types text into the keyboard, a key every PasteInterval cycles
+// once there's space in the buffer, so none are dropped as they are when
+// typed faster than the program reads them. It's safe to call from any
+// goroutine.
+func (k *Keyboard) Paste(text string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.pasted = append(k.pasted, []rune(text)...)
+	k.updateQueued()
+}
+
+// HoldKeyTyped types a character, and keeps typing it every RepeatInterval
+// cycles after the first RepeatDelay until ReleaseHeldKey, so that held
+// keys repeat at the same pace whatever the host's keyboard does. Any key
+// that was already held is released. It's safe to call from any goroutine.
+func (k *Keyboard) HoldKeyTyped(ch rune) {
+	k.hold([]core.Word{core.Word(ch)})
+}
+
+// HoldKeyPressed is like HoldKeyTyped, but for a key which is pressed and
+// released each time it repeats
+func (k *Keyboard) HoldKeyPressed(key Key) {
+	k.hold([]core.Word{core.Word(key), core.Word(key) | 0x100})
+}
+
+func (k *Keyboard) hold(codes []core.Word) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.held = codes
+	k.holding = true
+	k.heldAt = 0
+	k.repeats = 0
+	// what's left of the last key is still typed, so it isn't left pressed
+	k.updateQueued()
+}
+
+// ReleaseHeldKey stops repeating the held key, if any. It's safe to call
+// from any goroutine.
+func (k *Keyboard) ReleaseHeldKey() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.holding = false
+	k.updateQueued()
+}
+
+// updateQueued records whether there are keys for pollPaste or pollHeld to
+// type, so the machine doesn't take the lock every cycle to find out. It's
+// called with mu held.
+func (k *Keyboard) updateQueued() {
+	var queued int32
+	if len(k.pasted) > 0 || k.holding || len(k.typing) > 0 {
+		queued = 1
+	}
+	atomic.StoreInt32(&k.queued, queued)
+}
+
+// pollPaste stuffs the next pasted key into the buffer, if it's due and
+// there's space. It returns the key that was added, if any.
+func (k *Keyboard) pollPaste(cycle uint64) (core.Word, bool) {
+	if atomic.LoadInt32(&k.queued) == 0 {
+		return 0, false
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if len(k.pasted) == 0 || k.words[k.offset] != 0 {
+		return 0, false
+	}
+	interval := k.PasteInterval
+	if interval == 0 {
+		interval = DefaultPasteInterval
+	}
+	// the cycle count goes back to 0 on reset, which pastes straight away
+	if cycle-k.pastedAt < interval {
+		return 0, false
+	}
+	key := core.Word(k.pasted[0])
+	k.pasted = k.pasted[1:]
+	k.pastedAt = cycle
+	k.pushKey(key)
+	k.updateQueued()
+	return key, true
+}
+
+// pollHeld stuffs the next code of the held key into the buffer, if it's
+// due and there's space. It returns the key that was added, if any.
+func (k *Keyboard) pollHeld(cycle uint64) (core.Word, bool) {
+	if atomic.LoadInt32(&k.queued) == 0 {
+		return 0, false
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.words[k.offset] != 0 {
+		return 0, false
+	}
+	if len(k.typing) == 0 && k.holding {
+		if k.heldAt == 0 {
+			// it's typed as soon as it's held
+			k.heldAt = cycle + 1 // so it's never 0
+			k.typing = k.held
+		} else if k.RepeatInterval != 0 {
+			if due := k.heldAt + k.RepeatDelay + k.repeats*k.RepeatInterval; cycle+1 >= due {
+				// repeats missed while the buffer was full are dropped,
+				// as a real keyboard drops them
+				k.repeats = (cycle+1-k.heldAt-k.RepeatDelay)/k.RepeatInterval + 1
+				k.typing = k.held
+			}
+		}
+	}
+	if len(k.typing) == 0 {
+		return 0, false
+	}
+	key := k.typing[0]
+	k.typing = k.typing[1:]
+	k.pushKey(key)
+	k.updateQueued()
+	return key, true
+}
+
+// pushKey stuffs the key into the next spot of the buffer
+func (k *Keyboard) pushKey(key core.Word) {
+	k.words[k.offset] = key
+	k.offset = (k.offset + 1) % len(k.words)
+}
+
+// reset empties the buffer, and drops any pasted or held keys
+func (k *Keyboard) reset() {
+	k.words = [0x10]core.Word{}
+	k.offset = 0
+	k.mu.Lock()
+	k.pasted = nil
+	k.holding = false
+	k.typing = nil
+	k.updateQueued()
+	k.mu.Unlock()
 }
 
 func (k *Keyboard) MapToMachine(offset core.Word, m *Machine) error {
@@ -45,11 +209,6 @@ func (k *Keyboard) MapToMachine(offset core.Word, m *Machine) error {
 		return errors.New("Keyboard is already mapped to a machine")
 	}
 	k.input = make(chan rune, 1)
-	k.offset = 0
-	for i := 0; i < 10; i++ {
-		// zero out the words
-		k.words[i] = 0
-	}
 	get := func(offset core.Word) core.Word {
 		return k.words[offset]
 	}
@@ -57,7 +216,7 @@ func (k *Keyboard) MapToMachine(offset core.Word, m *Machine) error {
 		k.words[offset] = val
 		return nil
 	}
-	return m.State.Ram.MapRegion(offset, core.Word(len(k.words)), get, set)
+	return m.State.Ram.MapRegionOwned("keyboard", offset, core.Word(len(k.words)), get, set, nil)
 }
 
 func (k *Keyboard) UnmapFromMachine(offset core.Word, m *Machine) error {
@@ -0,0 +1,82 @@
+package dcpu
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"testing"
+)
+
+func TestPaste(t *testing.T) {
+	k := &Keyboard{PasteInterval: 10}
+	k.Paste("abc")
+	poll := func(cycle uint64, expected rune) {
+		key, ok := k.pollPaste(cycle)
+		if expected == 0 && ok {
+			t.Errorf("Expected no key at cycle %d, found %#x", cycle, key)
+		} else if expected != 0 && (!ok || key != core.Word(expected)) {
+			t.Errorf("Expected %q at cycle %d, found %#x (%v)", expected, cycle, key, ok)
+		}
+	}
+	poll(10, 'a')
+	poll(15, 0)
+	poll(20, 'b')
+	// the next spot is taken, so the key waits for the program
+	k.words[k.offset] = 'x'
+	poll(40, 0)
+	k.words[k.offset] = 0
+	poll(41, 'c')
+	poll(60, 0)
+	if k.words[0] != 'a' || k.words[1] != 'b' || k.words[2] != 'c' {
+		t.Errorf("Unexpected buffer %v", k.words[:3])
+	}
+
+	k.Paste("def")
+	k.reset()
+	poll(100, 0)
+}
+
+func TestHeldKeyRepeats(t *testing.T) {
+	k := &Keyboard{RepeatDelay: 100, RepeatInterval: 20}
+	typed := func(from, to uint64) []core.Word {
+		var keys []core.Word
+		for cycle := from; cycle < to; cycle++ {
+			if key, ok := k.pollHeld(cycle); ok {
+				keys = append(keys, key)
+				// the program reads it straight away
+				k.words[(k.offset+len(k.words)-1)%len(k.words)] = 0
+			}
+		}
+		return keys
+	}
+	if keys := typed(0, 10); len(keys) != 0 {
+		t.Errorf("Expected no keys before one is held, found %v", keys)
+	}
+	k.HoldKeyTyped('a')
+	// typed at 10, then at 110, 130 and 150
+	if keys := typed(10, 160); len(keys) != 4 {
+		t.Errorf("Expected the key and 3 repeats, found %v", keys)
+	}
+	k.ReleaseHeldKey()
+	if keys := typed(160, 400); len(keys) != 0 {
+		t.Errorf("Expected no repeats once released, found %v", keys)
+	}
+
+	// repeats missed while the buffer is full are dropped
+	k.HoldKeyPressed(KeyArrowUp)
+	if keys := typed(400, 402); len(keys) != 2 || keys[0] != core.Word(KeyArrowUp) || keys[1] != core.Word(KeyArrowUp)|0x100 {
+		t.Errorf("Expected the key pressed and released, found %v", keys)
+	}
+	k.words[k.offset] = 'x'
+	typed(402, 600)
+	k.words[k.offset] = 0
+	if keys := typed(600, 601); len(keys) != 1 {
+		t.Errorf("Expected a single repeat once there's space, found %v", keys)
+	}
+	if keys := typed(601, 619); len(keys) != 1 {
+		t.Errorf("Expected the rest of the repeat and nothing more, found %v", keys)
+	}
+
+	k.reset()
+	if keys := typed(700, 800); len(keys) != 0 {
+		t.Errorf("Expected no keys held after reset, found %v", keys)
+	}
+}
@@ -0,0 +1,73 @@
+package dcpu
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"sync"
+	"sync/atomic"
+)
+
+// KeyboardSource supplies keys to the keyboard, as a VideoBackend draws the
+// screen, such as a terminal, a browser, an input script or a test. The
+// keyboard asks its Sources in turn for a key each cycle there's space in
+// its buffer.
+type KeyboardSource interface {
+	// NextKey returns the next key code to put in the buffer at the given
+	// cycle, if there's one due. It's called on the machine's goroutine.
+	NextKey(cycle uint64) (core.Word, bool)
+}
+
+// KeyQueue is a KeyboardSource that frontends type keys into from their
+// own goroutines, as they're typed. Like a full keyboard buffer, it drops
+// keys typed while the last one is still waiting for space.
+type KeyQueue struct {
+	mu      sync.Mutex
+	keys    []core.Word
+	waiting int32 // accessed atomically; the length of keys
+}
+
+// TypeKey types a character
+func (q *KeyQueue) TypeKey(ch rune) {
+	q.push(core.Word(ch))
+}
+
+// PressKey presses and releases a key
+func (q *KeyQueue) PressKey(key Key) {
+	q.push(core.Word(key), core.Word(key)|0x100)
+}
+
+func (q *KeyQueue) push(codes ...core.Word) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.keys) == 0 {
+		q.keys = append(q.keys, codes...)
+		atomic.StoreInt32(&q.waiting, int32(len(q.keys)))
+	}
+}
+
+func (q *KeyQueue) NextKey(cycle uint64) (core.Word, bool) {
+	// don't take the lock every cycle
+	if atomic.LoadInt32(&q.waiting) == 0 {
+		return 0, false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	key := q.keys[0]
+	q.keys = q.keys[1:]
+	atomic.StoreInt32(&q.waiting, int32(len(q.keys)))
+	return key, true
+}
+
+// pollSources stuffs the next key of the first of the Sources with one into
+// the buffer, if there's space. It returns the key that was added, if any.
+func (k *Keyboard) pollSources(cycle uint64) (core.Word, bool) {
+	if k.words[k.offset] != 0 {
+		return 0, false
+	}
+	for _, source := range k.Sources {
+		if key, ok := source.NextKey(cycle); ok {
+			k.pushKey(key)
+			return key, true
+		}
+	}
+	return 0, false
+}
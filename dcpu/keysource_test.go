@@ -0,0 +1,55 @@
+package dcpu
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"testing"
+)
+
+// testKeys is a KeyboardSource that types its keys a cycle at a time
+type testKeys []core.Word
+
+func (t *testKeys) NextKey(cycle uint64) (core.Word, bool) {
+	if len(*t) == 0 {
+		return 0, false
+	}
+	key := (*t)[0]
+	*t = (*t)[1:]
+	return key, true
+}
+
+func TestKeyboardSources(t *testing.T) {
+	queue := new(KeyQueue)
+	m, err := NewMachine(WithKeyboardSources(queue, &testKeys{'a', 'b'}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// SUB PC, 1
+	if err := m.State.LoadProgram([]core.Word{0x8b83}, 0); err != nil {
+		t.Fatal(err)
+	}
+	queue.TypeKey('x')
+	// dropped, as 'x' is still waiting
+	queue.TypeKey('y')
+	if _, err := m.RunFor(10); err != nil {
+		t.Fatal(err)
+	}
+	// the first source with a key is asked first
+	if m.Keyboard.words[0] != 'x' || m.Keyboard.words[1] != 'a' || m.Keyboard.words[2] != 'b' {
+		t.Errorf("Unexpected buffer %v", m.Keyboard.words[:3])
+	}
+
+	// a pressed key is always released
+	queue.PressKey(KeyArrowUp)
+	m.Keyboard.words[m.Keyboard.offset] = 'z'
+	queue.TypeKey('y')
+	if _, err := m.RunFor(10); err != nil {
+		t.Fatal(err)
+	}
+	m.Keyboard.words[m.Keyboard.offset] = 0
+	if _, err := m.RunFor(10); err != nil {
+		t.Fatal(err)
+	}
+	if m.Keyboard.words[3] != core.Word(KeyArrowUp) || m.Keyboard.words[4] != core.Word(KeyArrowUp)|0x100 || m.Keyboard.words[5] != 0 {
+		t.Errorf("Unexpected buffer %v", m.Keyboard.words[3:6])
+	}
+}
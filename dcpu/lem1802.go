@@ -0,0 +1,237 @@
+package dcpu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/kballard/dcpu16/dcpu/core"
+)
+
+// Under the 1.7 spec, the Video is an NE LEM1802 attached as a hardware
+// device. The operation is selected by A when the device is sent HWI:
+//
+//	A=0 map the screen to the 384 words at B, or disconnect it if B is 0.
+//	    Until it's first connected, the screen shows the boot splash, and
+//	    once disconnected it's blank, border and all.
+//	A=1 map the font to the 256 words at B, or use the default if B is 0
+//	A=2 map the palette to the 16 words at B, or use the default if B is 0
+//	A=3 set the border color to palette entry B
+//	A=4 dump the default font to memory at B
+//	A=5 dump the default palette to memory at B
+//	A=6 raise an interrupt with message B as each frame is drawn, at the
+//	    Video's RefreshRate, or turn them off if B is 0
+//
+// A=6 isn't part of the LEM1802 spec. It lets programs time animation by
+// the screen, rather than by counting cycles. Like the Generic Clock's,
+// frames are measured in machine cycles, so the interrupts arrive at the
+// same point in a program however fast the machine actually runs.
+//
+// Each cell of the screen is ffff bbbb Bccc cccc: the foreground and
+// background palette entries, blink and the character. Palette entries are
+// 0000 rrrr gggg bbbb. The font is only shown by backends that are
+// FontDisplays.
+
+const (
+	LEM1802ID           = 0x7349f615
+	LEM1802Version      = 0x1802
+	LEM1802Manufacturer = 0x1c6c8b36
+)
+
+const (
+	lemMapScreen = iota
+	lemMapFont
+	lemMapPalette
+	lemSetBorderColor
+	lemDumpFont
+	lemDumpPalette
+	lemSetVsync
+)
+
+const screenWords = windowWidth * windowHeight
+
+func (v *Video) ID() uint32           { return LEM1802ID }
+func (v *Video) Version() core.Word   { return LEM1802Version }
+func (v *Video) Manufacturer() uint32 { return LEM1802Manufacturer }
+
+func (v *Video) HandleInterrupt(s *core.State) error {
+	switch s.A() {
+	case lemMapScreen:
+		v.screen = s.B()
+		v.connected = v.connected || v.screen != 0
+	case lemMapFont:
+		v.font = s.B()
+	case lemMapPalette:
+		v.palette = s.B()
+	case lemSetBorderColor:
+		v.border = s.B() & 0xf
+	case lemDumpFont:
+		for i, w := range defaultFont {
+			s.Ram.Store(s.B()+core.Word(i), w)
+		}
+	case lemDumpPalette:
+		for i, w := range DefaultPalette {
+			s.Ram.Store(s.B()+core.Word(i), w)
+		}
+	case lemSetVsync:
+		v.vsync, v.vsyncStart, v.vsyncFrames = s.B(), s.Cycles(), 0
+	}
+	return nil
+}
+
+// Tick raises an interrupt for each frame, if they're turned on
+func (v *Video) Tick(s *core.State) error {
+	if v.vsync == 0 {
+		return nil
+	}
+	rate := v.CyclesPerSecond
+	if rate == 0 {
+		rate = uint64(DefaultClockRate)
+	}
+	refreshRate := v.RefreshRate
+	if refreshRate <= 0 {
+		refreshRate = DefaultScreenRefreshRate
+	}
+	frames := (s.Cycles() - v.vsyncStart) * uint64(refreshRate) / rate
+	for ; v.vsyncFrames < frames; v.vsyncFrames++ {
+		s.TriggerInterrupt(v.vsync)
+	}
+	return nil
+}
+
+// lemSnapshot is the snapshot of an LEM1802
+type lemSnapshot struct {
+	Screen, Font, Palette, Border core.Word
+	Vsync                         core.Word
+	VsyncStart, VsyncFrames       uint64
+}
+
+// Snapshot saves the LEM1802's mappings and interrupts, for the Machine's
+// Monitors, which stay attached while it's stopped and so are saved with
+// the other devices. The Machine saves the Video itself.
+func (v *Video) Snapshot() ([]byte, error) {
+	var buf bytes.Buffer
+	snap := lemSnapshot{v.screen, v.font, v.palette, v.border, v.vsync, v.vsyncStart, v.vsyncFrames}
+	if err := binary.Write(&buf, binary.LittleEndian, &snap); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (v *Video) Restore(data []byte) error {
+	var snap lemSnapshot
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &snap); err != nil {
+		return core.ErrBadSnapshot
+	}
+	v.screen, v.font, v.palette, v.border = snap.Screen, snap.Font, snap.Palette, snap.Border
+	v.vsync, v.vsyncStart, v.vsyncFrames = snap.Vsync, snap.VsyncStart, snap.VsyncFrames
+	v.connected = v.screen != 0
+	return nil
+}
+
+// refresh draws the screen from memory. Unlike the fixed layout of the 1.1
+// spec, the screen can be anywhere in memory and written by devices as well
+// as the CPU, so it's redrawn whole rather than as it changes.
+func (v *Video) refresh() {
+	if !v.mapped || v.ram == nil {
+		return
+	}
+	v.setPalette(v.currentPalette())
+	v.setFont(v.currentFont())
+	if v.screen == 0 {
+		v.drawBorderColor(0)
+		if v.connected {
+			v.clearDisplay()
+			return
+		}
+		for i, word := range bootSplash {
+			v.updateCell(i/windowWidth, i%windowWidth, word)
+		}
+		return
+	}
+	v.drawBorderColor(byte(v.border))
+	for i := core.Word(0); i < screenWords; i++ {
+		v.updateCell(int(i/windowWidth), int(i%windowWidth), v.ram.Load(v.screen+i))
+	}
+}
+
+// bootSplash is shown until the screen is first connected, in the default
+// font and palette: the manufacturer's name in yellow over the model in
+// gray
+var bootSplash = func() (splash [screenWords]core.Word) {
+	line := func(row int, text string, color core.Word) {
+		start := row*windowWidth + (windowWidth-len(text))/2
+		for i, ch := range text {
+			splash[start+i] = color<<12 | core.Word(ch)
+		}
+	}
+	line(5, "NYA ELEKTRISKA", 0xe)
+	line(7, "LEM1802", 0x7)
+	return
+}()
+
+// currentPalette returns the colors of the palette in use
+func (v *Video) currentPalette() [16]core.Word {
+	if v.ram == nil || v.palette == 0 {
+		return DefaultPalette
+	}
+	var palette [16]core.Word
+	for i := range palette {
+		palette[i] = v.ram.Load(v.palette + core.Word(i))
+	}
+	return palette
+}
+
+// currentFont returns the font in use
+func (v *Video) currentFont() [256]core.Word {
+	if v.ram == nil || v.font == 0 {
+		return defaultFont
+	}
+	var font [256]core.Word
+	for i := range font {
+		font[i] = v.ram.Load(v.font + core.Word(i))
+	}
+	return font
+}
+
+// DefaultPalette is the LEM1802's built-in palette, which matches the
+// colors of the 1.1 spec
+var DefaultPalette = [16]core.Word{
+	0x000, 0x00a, 0x0a0, 0x0aa, 0xa00, 0xa0a, 0xa50, 0xaaa,
+	0x555, 0x55f, 0x5f5, 0x5ff, 0xf55, 0xf5f, 0xff5, 0xfff,
+}
+
+// defaultFont is the LEM1802's built-in font; each character is two words
+// of 4 columns of 8 pixels
+var defaultFont = [256]core.Word{
+	0xb79e, 0x388e, 0x722c, 0x75f4, 0x19bb, 0x7f8f, 0x85f9, 0xb158,
+	0x242e, 0x2400, 0x082a, 0x0800, 0x0008, 0x0000, 0x0808, 0x0808,
+	0x00ff, 0x0000, 0x00f8, 0x0808, 0x08f8, 0x0000, 0x080f, 0x0808,
+	0x000f, 0x0808, 0x00ff, 0x0808, 0x08f8, 0x0808, 0x08ff, 0x0000,
+	0x080f, 0x0808, 0x08ff, 0x0808, 0x6633, 0x99cc, 0x9933, 0x66cc,
+	0xfef8, 0xe080, 0x7f1f, 0x0701, 0x0107, 0x1f7f, 0x80e0, 0xf8fe,
+	0x5500, 0xaa00, 0x55aa, 0x55aa, 0xffaa, 0xff55, 0x0f0f, 0x0f0f,
+	0xf0f0, 0xf0f0, 0x0000, 0xffff, 0xffff, 0x0000, 0xffff, 0xffff,
+	0x0000, 0x0000, 0x005f, 0x0000, 0x0300, 0x0300, 0x3e14, 0x3e00,
+	0x266b, 0x3200, 0x611c, 0x4300, 0x3629, 0x7650, 0x0002, 0x0100,
+	0x1c22, 0x4100, 0x4122, 0x1c00, 0x1408, 0x1400, 0x081c, 0x0800,
+	0x4020, 0x0000, 0x0808, 0x0800, 0x0040, 0x0000, 0x601c, 0x0300,
+	0x3e49, 0x3e00, 0x427f, 0x4000, 0x6259, 0x4600, 0x2249, 0x3600,
+	0x0f08, 0x7f00, 0x2745, 0x3900, 0x3e49, 0x3200, 0x6119, 0x0700,
+	0x3649, 0x3600, 0x2649, 0x3e00, 0x0024, 0x0000, 0x4024, 0x0000,
+	0x0814, 0x2200, 0x1414, 0x1400, 0x2214, 0x0800, 0x0259, 0x0600,
+	0x3e59, 0x5e00, 0x7e09, 0x7e00, 0x7f49, 0x3600, 0x3e41, 0x2200,
+	0x7f41, 0x3e00, 0x7f49, 0x4100, 0x7f09, 0x0100, 0x3e41, 0x7a00,
+	0x7f08, 0x7f00, 0x417f, 0x4100, 0x2040, 0x3f00, 0x7f08, 0x7700,
+	0x7f40, 0x4000, 0x7f06, 0x7f00, 0x7f01, 0x7e00, 0x3e41, 0x3e00,
+	0x7f09, 0x0600, 0x3e61, 0x7e00, 0x7f09, 0x7600, 0x2649, 0x3200,
+	0x017f, 0x0100, 0x3f40, 0x7f00, 0x1f60, 0x1f00, 0x7f30, 0x7f00,
+	0x7708, 0x7700, 0x0778, 0x0700, 0x7149, 0x4700, 0x007f, 0x4100,
+	0x031c, 0x6000, 0x417f, 0x0000, 0x0201, 0x0200, 0x8080, 0x8000,
+	0x0001, 0x0200, 0x2454, 0x7800, 0x7f44, 0x3800, 0x3844, 0x2800,
+	0x3844, 0x7f00, 0x3854, 0x5800, 0x087e, 0x0900, 0x4854, 0x3c00,
+	0x7f04, 0x7800, 0x047d, 0x0000, 0x2040, 0x3d00, 0x7f10, 0x6c00,
+	0x017f, 0x0000, 0x7c18, 0x7c00, 0x7c04, 0x7800, 0x3844, 0x3800,
+	0x7c14, 0x0800, 0x0814, 0x7c00, 0x7c04, 0x0800, 0x4854, 0x2400,
+	0x043e, 0x4400, 0x3c40, 0x7c00, 0x1c60, 0x1c00, 0x7c30, 0x7c00,
+	0x6c10, 0x6c00, 0x4c50, 0x3c00, 0x6454, 0x4c00, 0x0836, 0x4100,
+	0x0077, 0x0000, 0x4136, 0x0800, 0x0201, 0x0201, 0x0205, 0x0200,
+}
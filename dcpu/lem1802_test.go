@@ -0,0 +1,239 @@
+package dcpu
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"testing"
+	"time"
+)
+
+func TestLEM1802(t *testing.T) {
+	m := new(Machine)
+	if err := m.Video.MapToMachine(0x8000, m); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.State.Devices) != 1 || m.State.Devices[0] != &m.Video {
+		t.Fatalf("Expected the LEM1802 to be attached, found %v", m.State.Devices)
+	}
+	hwi := func(a, b core.Word) {
+		m.State.SetA(a)
+		m.State.SetB(b)
+		m.Video.HandleInterrupt(&m.State)
+	}
+	hwi(lemMapScreen, 0x8000)
+	hwi(lemMapPalette, 0x9000)
+	hwi(lemSetBorderColor, 0x12)
+	if m.Video.screen != 0x8000 || m.Video.palette != 0x9000 || m.Video.border != 2 {
+		t.Errorf("Unexpected mapping; screen %#x, palette %#x, border %d", m.Video.screen, m.Video.palette, m.Video.border)
+	}
+
+	hwi(lemDumpFont, 0x1000)
+	hwi(lemDumpPalette, 0x2000)
+	// 'A' is the 0x41st character
+	if m.State.Ram.Load(0x1082) != 0x7e09 || m.State.Ram.Load(0x1083) != 0x7e00 {
+		t.Errorf("Unexpected font for 'A'; %#04x %#04x", m.State.Ram.Load(0x1082), m.State.Ram.Load(0x1083))
+	}
+	if m.State.Ram.Load(0x2001) != 0x00a || m.State.Ram.Load(0x200f) != 0xfff {
+		t.Errorf("Unexpected palette; %#03x %#03x", m.State.Ram.Load(0x2001), m.State.Ram.Load(0x200f))
+	}
+
+	data, err := m.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.reset()
+	if m.Video.screen != 0 || m.Video.palette != 0 {
+		t.Error("Expected reset to disconnect the screen")
+	}
+	if err := m.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	if m.Video.screen != 0x8000 || m.Video.palette != 0x9000 || m.Video.border != 2 {
+		t.Error("Expected the mapping to be restored")
+	}
+
+	if err := m.Video.UnmapFromMachine(0x8000, m); err != nil || len(m.State.Devices) != 0 {
+		t.Errorf("Expected the LEM1802 to be detached (%v)", err)
+	}
+}
+
+func TestLEM1802Legacy(t *testing.T) {
+	m := new(Machine)
+	m.State.Spec = core.Spec11
+	if err := m.Video.MapToMachine(0x8000, m); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.State.Devices) != 0 {
+		t.Error("Expected no hardware under the 1.1 spec")
+	}
+	if err := m.Video.UnmapFromMachine(0x8000, m); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLEM1802Vsync(t *testing.T) {
+	m := new(Machine)
+	// 6 frames a second, or one every 100 cycles
+	m.Video.RefreshRate = 6
+	m.Video.CyclesPerSecond = 600
+	if err := m.Video.MapToMachine(0x8000, m); err != nil {
+		t.Fatal(err)
+	}
+	m.State.SetIA(0x100)
+	m.State.SetA(lemSetVsync)
+	m.State.SetB(0x42)
+	m.Video.HandleInterrupt(&m.State)
+	// SUB PC, 1 in both the program and the interrupt handler
+	if err := m.State.LoadProgram([]core.Word{0x8b83}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.State.LoadProgram([]core.Word{0x8b83}, 0x100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.State.RunFor(250); err != nil {
+		t.Fatal(err)
+	}
+	// the first frame's interrupt has been handled
+	if m.State.PC() != 0x100 || m.State.A() != 0x42 {
+		t.Errorf("Expected the frame to interrupt; PC %#x, A %#x", m.State.PC(), m.State.A())
+	}
+	if m.Video.vsyncFrames != 2 {
+		t.Errorf("Expected 2 frames, found %d", m.Video.vsyncFrames)
+	}
+
+	data, err := m.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.reset()
+	if m.Video.vsync != 0 {
+		t.Error("Expected reset to turn off VSYNC interrupts")
+	}
+	if err := m.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	if m.Video.vsync != 0x42 || m.Video.vsyncFrames != 2 {
+		t.Errorf("Expected VSYNC interrupts to be restored; message %#x, frames %d", m.Video.vsync, m.Video.vsyncFrames)
+	}
+}
+
+func TestMonitors(t *testing.T) {
+	first, second := new(Capture), new(Capture)
+	m, err := NewMachine(WithMonitors(first, second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.State.Devices) != 2 || m.State.Devices[0] != m.Monitors[0] || m.State.Devices[1] != m.Monitors[1] {
+		t.Fatalf("Expected the monitors to be attached, found %v", m.State.Devices)
+	}
+	hwi := func(index, a, b core.Word) {
+		m.State.SetA(a)
+		m.State.SetB(b)
+		m.Monitors[index].HandleInterrupt(&m.State)
+	}
+	hwi(0, lemMapScreen, 0x8000)
+	hwi(1, lemMapScreen, 0x9000)
+	hwi(1, lemSetBorderColor, 4)
+	m.State.Ram.Store(0x8000, 0xf041)
+	m.State.Ram.Store(0x9000, 0xf042)
+	if err := m.RefreshScreen(); err != nil {
+		t.Fatal(err)
+	}
+	if first.Cell(0, 0) != 0xf041 || second.Cell(0, 0) != 0xf042 || second.Border() != 4 {
+		t.Errorf("Expected each monitor to show its own screen; %#04x %#04x, border %d", first.Cell(0, 0), second.Cell(0, 0), second.Border())
+	}
+
+	data, err := m.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.reset()
+	if m.Monitors[0].screen != 0 || m.Monitors[1].screen != 0 {
+		t.Error("Expected reset to disconnect the monitors")
+	}
+	if err := m.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	if m.Monitors[0].screen != 0x8000 || m.Monitors[1].screen != 0x9000 || m.Monitors[1].border != 4 {
+		t.Error("Expected the monitors' mappings to be restored")
+	}
+
+	if _, err := NewMachine(WithSpec(core.Spec11), WithMonitors(nil)); err == nil {
+		t.Error("Expected monitors to need the 1.7 spec")
+	}
+}
+
+// closeCounter is a backend counting the times it's closed
+type closeCounter struct {
+	Capture
+	closed int
+}
+
+func (c *closeCounter) Close() {
+	c.closed++
+}
+
+func TestMonitorsClosedOnError(t *testing.T) {
+	screen, monitor := new(closeCounter), new(closeCounter)
+	m, err := NewMachine(WithVideoBackend(screen), WithMonitors(monitor))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// memory is zeroed, which is an invalid opcode
+	if err := m.Start(Unthrottled); err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		if err := m.HasError(); err != nil {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("Expected the machine to halt")
+		}
+	}
+	if screen.closed != 1 || monitor.closed != 1 {
+		t.Errorf("Expected the screen and monitor to be closed once, found %d and %d", screen.closed, monitor.closed)
+	}
+}
+
+func TestLEM1802Disconnected(t *testing.T) {
+	screen := new(Capture)
+	m := &Machine{Video: Video{Backend: screen}}
+	if err := m.Video.MapToMachine(0x8000, m); err != nil {
+		t.Fatal(err)
+	}
+	hwi := func(a, b core.Word) {
+		m.State.SetA(a)
+		m.State.SetB(b)
+		m.Video.HandleInterrupt(&m.State)
+	}
+	hwi(lemSetBorderColor, 4)
+	m.State.Ram.Store(0x8000, 0xf041)
+	if err := m.RefreshScreen(); err != nil {
+		t.Fatal(err)
+	}
+	// 'N' of NYA ELEKTRISKA, in yellow
+	if screen.Cell(9, 5) != 0xe04e || screen.Border() != 0 {
+		t.Errorf("Expected the boot splash before the screen is connected; %#04x, border %d", screen.Cell(9, 5), screen.Border())
+	}
+
+	hwi(lemMapScreen, 0x8000)
+	m.RefreshScreen()
+	if screen.Cell(0, 0) != 0xf041 || screen.Cell(9, 5) != 0 || screen.Border() != 4 {
+		t.Errorf("Expected the connected screen; %#04x, border %d", screen.Cell(0, 0), screen.Border())
+	}
+
+	hwi(lemMapScreen, 0)
+	m.RefreshScreen()
+	if screen.Cell(0, 0) != 0x0020 || screen.Cell(9, 5) != 0x0020 || screen.Border() != 0 {
+		t.Errorf("Expected disconnecting to blank the screen; %#04x, border %d", screen.Cell(0, 0), screen.Border())
+	}
+	if shot, _ := m.Screenshot(); shot.Cells[0] != 0 || shot.Border != 0 {
+		t.Errorf("Expected a blank screenshot; %#04x, border %d", shot.Cells[0], shot.Border)
+	}
+
+	m.reset()
+	m.RefreshScreen()
+	if screen.Cell(9, 5) != 0xe04e {
+		t.Errorf("Expected the boot splash after a reset; %#04x", screen.Cell(9, 5))
+	}
+}
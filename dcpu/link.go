@@ -0,0 +1,110 @@
+package dcpu
+
+import "github.com/kballard/dcpu16/dcpu/core"
+
+// Link is one end of a link between two machines in the same process, made
+// with NewLink, each end being attached to one of the machines. Words sent
+// from one end are received in order at the other. The operation is
+// selected by A when the device is sent HWI:
+//
+//	A=0 B is set to the number of words waiting to be received, and C to
+//	    the number that can be sent before the other end falls behind
+//	A=1 B is sent, and C is set to 1; if the other end has fallen behind,
+//	    nothing is sent and C is set to 0
+//	A=2 B is set to the next word received, and C to 1; if there's nothing
+//	    to receive, B is set to 0 and C to 0
+//	A=3 if B is non-zero, an interrupt with message B is raised whenever
+//	    words arrive; if B is 0 interrupts are turned off
+type Link struct {
+	incoming <-chan core.Word
+	outgoing chan<- core.Word
+	buffer   []core.Word // words received but not yet read
+	message  core.Word
+}
+
+const (
+	LinkID           = 0x020a0001
+	LinkVersion      = 1
+	LinkManufacturer = 0x6b62616c
+)
+
+const (
+	linkStatus = iota
+	linkSend
+	linkReceive
+	linkSetInterrupt
+)
+
+// linkBuffer is the number of words buffered in each direction
+const linkBuffer = 256
+
+// NewLink returns the two ends of a new link. The machines they're attached
+// to can run on separate goroutines.
+func NewLink() (*Link, *Link) {
+	ab := make(chan core.Word, linkBuffer)
+	ba := make(chan core.Word, linkBuffer)
+	return &Link{incoming: ba, outgoing: ab}, &Link{incoming: ab, outgoing: ba}
+}
+
+func (l *Link) ID() uint32           { return LinkID }
+func (l *Link) Version() core.Word   { return LinkVersion }
+func (l *Link) Manufacturer() uint32 { return LinkManufacturer }
+
+func (l *Link) HandleInterrupt(s *core.State) error {
+	switch s.A() {
+	case linkStatus:
+		l.receive()
+		s.SetB(core.Word(len(l.buffer)))
+		s.SetC(core.Word(cap(l.outgoing) - len(l.outgoing)))
+	case linkSend:
+		select {
+		case l.outgoing <- s.B():
+			s.SetC(1)
+		default:
+			s.SetC(0)
+		}
+	case linkReceive:
+		l.receive()
+		if len(l.buffer) == 0 {
+			s.SetB(0)
+			s.SetC(0)
+			break
+		}
+		s.SetB(l.buffer[0])
+		s.SetC(1)
+		l.buffer = l.buffer[1:]
+	case linkSetInterrupt:
+		l.message = s.B()
+	}
+	return nil
+}
+
+// receive moves the words that have arrived into the buffer, returning
+// whether there were any
+func (l *Link) receive() bool {
+	received := false
+	for {
+		select {
+		case w := <-l.incoming:
+			l.buffer = append(l.buffer, w)
+			received = true
+		default:
+			return received
+		}
+	}
+}
+
+// Tick raises an interrupt when words arrive
+func (l *Link) Tick(s *core.State) error {
+	if l.receive() && l.message != 0 {
+		s.TriggerInterrupt(l.message)
+	}
+	return nil
+}
+
+// Reset discards the words waiting to be received and turns off interrupts
+func (l *Link) Reset() {
+	l.receive()
+	l.buffer = nil
+	l.message = 0
+}
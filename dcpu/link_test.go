@@ -0,0 +1,66 @@
+package dcpu
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"testing"
+)
+
+func TestLink(t *testing.T) {
+	a, b := NewLink()
+	stateA, stateB := new(core.State), new(core.State)
+	hwi := func(link *Link, state *core.State, op, arg core.Word) {
+		state.SetA(op)
+		state.SetB(arg)
+		if err := link.HandleInterrupt(state); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := core.Word(0); i < linkBuffer; i++ {
+		hwi(a, stateA, linkSend, i)
+	}
+	if stateA.C() != 1 {
+		t.Error("Expected the buffer to hold every word")
+	}
+	hwi(a, stateA, linkSend, 0xffff)
+	if stateA.C() != 0 {
+		t.Error("Expected a send to a full buffer to fail")
+	}
+
+	hwi(b, stateB, linkStatus, 0)
+	if stateB.B() != linkBuffer || stateB.C() != linkBuffer {
+		t.Errorf("Unexpected status B=%d C=%d", stateB.B(), stateB.C())
+	}
+	hwi(b, stateB, linkReceive, 0)
+	if stateB.B() != 0 || stateB.C() != 1 {
+		t.Errorf("Expected to receive word 0, found B=%d C=%d", stateB.B(), stateB.C())
+	}
+	hwi(a, stateA, linkStatus, 0)
+	if stateA.C() != linkBuffer {
+		t.Errorf("Expected the sending buffer to be drained, found room for %d", stateA.C())
+	}
+
+	// SUB PC, 1
+	if err := stateA.LoadProgram([]core.Word{0x8b83}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := stateA.LoadProgram([]core.Word{0x8b83}, 0x100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stateA.AttachDevice(a); err != nil {
+		t.Fatal(err)
+	}
+	stateA.SetIA(0x100)
+	hwi(a, stateA, linkSetInterrupt, 0x42)
+	hwi(b, stateB, linkSend, 0x1234)
+	if _, err := stateA.RunUntil(func(s *core.State) bool { return s.PC() == 0x100 }, 100); err != nil {
+		t.Fatal(err)
+	}
+	if stateA.A() != 0x42 {
+		t.Errorf("Expected interrupt message 0x42, found %#04x", stateA.A())
+	}
+	hwi(a, stateA, linkReceive, 0)
+	if stateA.B() != 0x1234 {
+		t.Errorf("Expected to receive 0x1234, found %#04x", stateA.B())
+	}
+}
@@ -0,0 +1,256 @@
+package dcpu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"io"
+)
+
+// M35FD is the Mackapar 3.5" Floppy Drive. Disks hold 1440 sectors of 512
+// words, in 80 tracks of 18 sectors. The operation is selected by A when
+// the device is sent HWI:
+//
+//	A=0 poll: B is set to the state and C to the last error
+//	A=1 if X is non-zero, an interrupt with message X is raised whenever
+//	    the state or error changes; if X is 0 interrupts are turned off
+//	A=2 read sector X into memory at Y. B is set to 1 if the read started.
+//	A=3 write sector X from memory at Y. B is set to 1 if the write started.
+//
+// Reads and writes take time to seek and transfer, measured in machine
+// cycles; the drive is busy until they finish.
+type M35FD struct {
+	CyclesPerSecond uint64 // the cycles in a second; 0 means DefaultClockRate
+	disk            io.ReaderAt
+	writer          io.WriterAt // nil if the disk is write protected
+	state           core.Word
+	lastError       core.Word
+	message         core.Word
+	changed         bool // the state or error changed since the last tick
+	track           int
+	op              core.Word // the operation in progress, if busy
+	sector          core.Word
+	address         core.Word
+	cyclesLeft      uint64 // until the operation finishes
+}
+
+const (
+	M35FDID           = 0x4fd524c5
+	M35FDVersion      = 0x000b
+	M35FDManufacturer = 0x1eb37e91
+)
+
+// M35FD states
+const (
+	M35FDNoMedia = iota
+	M35FDReady
+	M35FDReadyWP
+	M35FDBusy
+)
+
+// M35FD errors
+const (
+	M35FDErrorNone      = 0
+	M35FDErrorBusy      = 1
+	M35FDErrorNoMedia   = 2
+	M35FDErrorProtected = 3
+	M35FDErrorEject     = 4
+	M35FDErrorBadSector = 5
+	M35FDErrorBroken    = 0xffff
+)
+
+const (
+	m35fdPoll = iota
+	m35fdSetInterrupt
+	m35fdRead
+	m35fdWrite
+)
+
+const (
+	M35FDSectorWords     = sectorWords
+	M35FDSectorsPerTrack = 18
+	M35FDTracks          = 80
+	M35FDSectors         = M35FDSectorsPerTrack * M35FDTracks
+	// the drive seeks a track in 2.4ms and transfers 30700 words a second
+	m35fdSeekMicros     = 2400
+	m35fdWordsPerSecond = 30700
+)
+
+func (d *M35FD) ID() uint32           { return M35FDID }
+func (d *M35FD) Version() core.Word   { return M35FDVersion }
+func (d *M35FD) Manufacturer() uint32 { return M35FDManufacturer }
+
+// Insert inserts a disk. If writeProtected is false, disk must also be an
+// io.WriterAt. Like the other methods, it must be called while the machine
+// is stopped or from the goroutine running it.
+func (d *M35FD) Insert(disk io.ReaderAt, writeProtected bool) error {
+	if d.disk != nil {
+		return errors.New("M35FD: a disk is already inserted")
+	}
+	var writer io.WriterAt
+	if !writeProtected {
+		var ok bool
+		if writer, ok = disk.(io.WriterAt); !ok {
+			return errors.New("M35FD: disk isn't writable")
+		}
+	}
+	d.disk, d.writer = disk, writer
+	if writeProtected {
+		d.setState(M35FDReadyWP, d.lastError)
+	} else {
+		d.setState(M35FDReady, d.lastError)
+	}
+	return nil
+}
+
+// Eject removes the disk, returning it. An operation in progress fails with
+// M35FDErrorEject.
+func (d *M35FD) Eject() io.ReaderAt {
+	disk := d.disk
+	err := d.lastError
+	if d.state == M35FDBusy {
+		err = M35FDErrorEject
+	}
+	d.disk, d.writer = nil, nil
+	d.setState(M35FDNoMedia, err)
+	return disk
+}
+
+func (d *M35FD) setState(state, err core.Word) {
+	if state != d.state || err != d.lastError {
+		d.state, d.lastError = state, err
+		d.changed = true
+	}
+}
+
+func (d *M35FD) readyState() core.Word {
+	if d.disk == nil {
+		return M35FDNoMedia
+	} else if d.writer == nil {
+		return M35FDReadyWP
+	}
+	return M35FDReady
+}
+
+func (d *M35FD) HandleInterrupt(s *core.State) error {
+	switch s.A() {
+	case m35fdPoll:
+		s.SetB(d.state)
+		s.SetC(d.lastError)
+	case m35fdSetInterrupt:
+		d.message = s.X()
+	case m35fdRead, m35fdWrite:
+		s.SetB(0)
+		switch {
+		case d.state == M35FDBusy:
+			d.setState(d.state, M35FDErrorBusy)
+		case d.disk == nil:
+			d.setState(d.state, M35FDErrorNoMedia)
+		case s.A() == m35fdWrite && d.writer == nil:
+			d.setState(d.state, M35FDErrorProtected)
+		case s.X() >= M35FDSectors:
+			d.setState(d.state, M35FDErrorBadSector)
+		default:
+			d.op, d.sector, d.address = s.A(), s.X(), s.Y()
+			track := int(d.sector) / M35FDSectorsPerTrack
+			seek := track - d.track
+			if seek < 0 {
+				seek = -seek
+			}
+			d.track = track
+			rate := d.cyclesPerSecond()
+			d.cyclesLeft = uint64(seek)*rate*m35fdSeekMicros/1e6 + M35FDSectorWords*rate/m35fdWordsPerSecond
+			d.setState(M35FDBusy, M35FDErrorNone)
+			s.SetB(1)
+		}
+	}
+	return nil
+}
+
+func (d *M35FD) cyclesPerSecond() uint64 {
+	if d.CyclesPerSecond == 0 {
+		return uint64(DefaultClockRate)
+	}
+	return d.CyclesPerSecond
+}
+
+// Tick finishes the operation in progress once it's due, and raises an
+// interrupt if the state or error has changed
+func (d *M35FD) Tick(s *core.State) error {
+	if d.state == M35FDBusy {
+		if d.cyclesLeft > 0 {
+			d.cyclesLeft--
+		}
+		if d.cyclesLeft == 0 {
+			d.setState(d.readyState(), d.transfer(s))
+		}
+	}
+	if d.changed {
+		d.changed = false
+		if d.message != 0 {
+			s.TriggerInterrupt(d.message)
+		}
+	}
+	return nil
+}
+
+// transfer copies the sector between the disk and memory, returning the
+// error code
+func (d *M35FD) transfer(s *core.State) core.Word {
+	var err error
+	if d.op == m35fdRead {
+		err = readSectors(d.disk, s, d.sector, 1, d.address)
+	} else {
+		err = writeSectors(d.writer, s, d.sector, 1, d.address)
+	}
+	if err != nil {
+		return M35FDErrorBroken
+	}
+	return M35FDErrorNone
+}
+
+// Reset turns off interrupts and abandons any operation in progress. The
+// disk stays inserted.
+func (d *M35FD) Reset() {
+	d.state = d.readyState()
+	d.lastError = M35FDErrorNone
+	d.message = 0
+	d.changed = false
+	d.track = 0
+	d.cyclesLeft = 0
+}
+
+// m35fdSnapshot is the snapshot of an M35FD. The disk isn't included.
+type m35fdSnapshot struct {
+	State, LastError, Message core.Word
+	Changed                   bool
+	Track                     uint16
+	Op, Sector, Address       core.Word
+	CyclesLeft                uint64
+}
+
+func (d *M35FD) Snapshot() ([]byte, error) {
+	var buf bytes.Buffer
+	snap := m35fdSnapshot{d.state, d.lastError, d.message, d.changed, uint16(d.track), d.op, d.sector, d.address, d.cyclesLeft}
+	if err := binary.Write(&buf, binary.LittleEndian, &snap); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Restore restores the drive's state, but keeps the disk that's inserted.
+// If the disk was inserted or ejected since the snapshot, the state is
+// updated to match.
+func (d *M35FD) Restore(data []byte) error {
+	var snap m35fdSnapshot
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &snap); err != nil {
+		return core.ErrBadSnapshot
+	}
+	d.state, d.lastError, d.message, d.changed = snap.State, snap.LastError, snap.Message, snap.Changed
+	d.track, d.op, d.sector, d.address, d.cyclesLeft = int(snap.Track), snap.Op, snap.Sector, snap.Address, snap.CyclesLeft
+	if d.state != M35FDBusy || d.disk == nil {
+		d.setState(d.readyState(), d.lastError)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package dcpu
+
+import (
+	"bytes"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"io"
+	"testing"
+)
+
+// memDisk is an in-memory disk image
+type memDisk struct {
+	data []byte
+}
+
+func (d *memDisk) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(d.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, d.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (d *memDisk) WriteAt(p []byte, off int64) (int, error) {
+	if end := off + int64(len(p)); end > int64(len(d.data)) {
+		d.data = append(d.data, make([]byte, end-int64(len(d.data)))...)
+	}
+	return copy(d.data[off:], p), nil
+}
+
+// runUntilReady steps the state until the drive isn't busy
+func runUntilReady(t *testing.T, state *core.State, drive *M35FD) {
+	for i := 0; drive.state == M35FDBusy; i++ {
+		if i == 100000 {
+			t.Fatal("Drive didn't finish")
+		}
+		if err := state.StepCycle(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestM35FD(t *testing.T) {
+	state := new(core.State)
+	drive := new(M35FD)
+	if _, err := state.AttachDevice(drive); err != nil {
+		t.Fatal(err)
+	}
+	// SUB PC, 1
+	if err := state.LoadProgram([]core.Word{0x8b83}, 0); err != nil {
+		t.Fatal(err)
+	}
+	hwi := func(a, x, y core.Word) {
+		state.SetA(a)
+		state.SetX(x)
+		state.SetY(y)
+		drive.HandleInterrupt(state)
+	}
+
+	hwi(m35fdRead, 0, 0x1000)
+	if state.B() != 0 || drive.lastError != M35FDErrorNoMedia {
+		t.Errorf("Expected no media; B %d, error %d", state.B(), drive.lastError)
+	}
+
+	disk := &memDisk{make([]byte, 4)}
+	disk.data[2], disk.data[3] = 0x12, 0x34
+	if err := drive.Insert(disk, false); err != nil {
+		t.Fatal(err)
+	}
+	hwi(m35fdRead, 0, 0x1000)
+	if state.B() != 1 || drive.state != M35FDBusy {
+		t.Fatalf("Expected the read to start; B %d, state %d", state.B(), drive.state)
+	}
+	hwi(m35fdRead, 1, 0x1000)
+	if state.B() != 0 || drive.lastError != M35FDErrorBusy {
+		t.Errorf("Expected the drive to be busy; B %d, error %d", state.B(), drive.lastError)
+	}
+	runUntilReady(t, state, drive)
+	if state.Ram.Load(0x1001) != 0x1234 || drive.state != M35FDReady {
+		t.Errorf("Unexpected read; %#x, state %d", state.Ram.Load(0x1001), drive.state)
+	}
+
+	state.Ram.Store(0x2000, 0xabcd)
+	hwi(m35fdWrite, 19, 0x2000) // the second track
+	runUntilReady(t, state, drive)
+	offset := 19 * M35FDSectorWords * 2
+	if !bytes.Equal(disk.data[offset:offset+2], []byte{0xab, 0xcd}) || len(disk.data) != offset+M35FDSectorWords*2 {
+		t.Errorf("Unexpected write; %x", disk.data[offset:offset+2])
+	}
+
+	hwi(m35fdWrite, M35FDSectors, 0)
+	if state.B() != 0 || drive.lastError != M35FDErrorBadSector {
+		t.Errorf("Expected a bad sector; B %d, error %d", state.B(), drive.lastError)
+	}
+
+	drive.Eject()
+	if err := drive.Insert(disk, true); err != nil {
+		t.Fatal(err)
+	}
+	hwi(m35fdWrite, 0, 0)
+	if state.B() != 0 || drive.lastError != M35FDErrorProtected {
+		t.Errorf("Expected the disk to be protected; B %d, error %d", state.B(), drive.lastError)
+	}
+	hwi(m35fdPoll, 0, 0)
+	if state.B() != M35FDReadyWP || state.C() != M35FDErrorProtected {
+		t.Errorf("Unexpected poll; state %d, error %d", state.B(), state.C())
+	}
+}
+
+func TestM35FDInterrupt(t *testing.T) {
+	state := new(core.State)
+	drive := new(M35FD)
+	if _, err := state.AttachDevice(drive); err != nil {
+		t.Fatal(err)
+	}
+	// SUB PC, 1 in both the program and the interrupt handler
+	if err := state.LoadProgram([]core.Word{0x8b83}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.LoadProgram([]core.Word{0x8b83}, 0x100); err != nil {
+		t.Fatal(err)
+	}
+	state.SetIA(0x100)
+	state.SetA(m35fdSetInterrupt)
+	state.SetX(0x77)
+	drive.HandleInterrupt(state)
+	drive.Insert(&memDisk{}, true)
+	if _, err := state.RunUntil(func(s *core.State) bool { return s.PC() == 0x100 }, 20); err != nil {
+		t.Fatal(err)
+	}
+	if state.A() != 0x77 {
+		t.Errorf("Expected an interrupt on insert; A %#x", state.A())
+	}
+}
@@ -1,9 +1,11 @@
 package dcpu
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/kballard/dcpu16/dcpu/core"
+	"github.com/kballard/dcpu16/dcpu/debug"
 	"io"
 	"runtime"
 	"strconv"
@@ -15,16 +17,38 @@ type Machine struct {
 	State      core.State
 	Video      Video
 	Keyboard   Keyboard
-	ErrorC     <-chan error // indicates when an error occurs
+	Monitors   []*Video         // extra LEM1802s, attached by WithMonitors
+	Recording  *Recording       // if non-nil, inputs are recorded to it while running
+	Replay     *Recording       // if non-nil, inputs are replayed from it instead of the keyboard
+	Screencast *Screencast      // if non-nil, the screen is recorded to it as it changes while running
+	Coverage   *debug.Coverage  // the executed addresses, once EnableCoverage is called
+	CallStack  *debug.CallStack // the active calls, once EnableCallStack is called
+	EventsC    <-chan Event     // reports what happens while running
 	stopper    chan<- struct{}
 	stopped    <-chan error
+	pauser     chan<- pauseRequest
+	finished   <-chan struct{} // closed once the machine stops running
+	paused     bool
+	pausedAt   time.Time
+	segments   []segment // the images loaded by LoadProgram and LoadSegment, for Reset
+	clockRate  ClockRate // the rate used when Start is given 0
 	cycleCount uint
 	startTime  time.Time
 }
 
+// pauseRequest asks the running machine to pause or resume, or if run is
+// non-nil, to call it between cycles. done is closed once the request has
+// been handled.
+type pauseRequest struct {
+	pause bool
+	run   func()
+	done  chan struct{}
+}
+
 type MachineError struct {
 	UnderlyingError error
 	PC              core.Word
+	CallStack       []debug.Frame // the active calls, innermost first, if the call stack is enabled
 }
 
 func (err *MachineError) Error() string {
@@ -33,12 +57,34 @@ func (err *MachineError) Error() string {
 
 const DefaultClockRate ClockRate = 100000 // 100KHz
 
+// Unthrottled is a ClockRate that runs the machine as fast as possible. The
+// screen is still refreshed at its usual rate.
+const Unthrottled ClockRate = -1
+
 // Start boots up the machine, with a clock rate of 1 / period
 // 10MHz would be expressed as (Microsecond / 10)
-func (m *Machine) Start(rate ClockRate) (err error) {
+// A rate of 0 uses the machine's ClockRate.
+func (m *Machine) Start(rate ClockRate) error {
+	return m.StartContext(context.Background(), rate)
+}
+
+// StartContext is like Start, but the machine stops running once ctx is
+// done. The context's error is then sent on EventsC and returned by Stop
+// and HasError. Stop must still be called to shut down the screen.
+func (m *Machine) StartContext(ctx context.Context, rate ClockRate) (err error) {
 	if m.stopped != nil {
 		return errors.New("Machine has already started")
 	}
+	if rate == 0 {
+		rate = m.clockRate
+		if rate == 0 {
+			rate = DefaultClockRate
+		}
+	}
+	if err = rate.check(); err != nil {
+		return
+	}
+	m.clockRate = rate
 	if err = m.Video.Init(); err != nil {
 		return
 	}
@@ -47,6 +93,21 @@ func (m *Machine) Start(rate ClockRate) (err error) {
 			m.Video.Close()
 		}
 	}()
+	for i, mon := range m.Monitors {
+		if err = mon.Init(); err != nil {
+			for _, mon := range m.Monitors[:i] {
+				mon.Close()
+			}
+			return
+		}
+	}
+	defer func() {
+		if err != nil {
+			for _, mon := range m.Monitors {
+				mon.Close()
+			}
+		}
+	}()
 	if err = m.Video.MapToMachine(0x8000, m); err != nil {
 		return
 	}
@@ -57,15 +118,21 @@ func (m *Machine) Start(rate ClockRate) (err error) {
 	m.stopper = stopper
 	stopped := make(chan error, 1)
 	m.stopped = stopped
-	errchan := make(chan error, 1)
-	m.ErrorC = errchan
+	events := make(chan Event, eventBuffer)
+	m.EventsC = events
+	pauser := make(chan pauseRequest)
+	m.pauser = pauser
+	finished := make(chan struct{})
+	m.finished = finished
+	m.paused = false
 	m.cycleCount = 0
 	m.startTime = time.Now()
 	go func() {
 		// we want an acurate cycle counter
-		// Unfortunately, time.NewTicker drops cycles on the floor if it can't keep up
-		// So lets instead switch to running as many cycles as we need before using any
-		// timed delays
+		// Timers can't wake us for every cycle at high rates, so each time
+		// we wake we run all the cycles that are due, then sleep until a
+		// tick's worth more are. We wake a little early and spin for the
+		// rest, as the OS timer is too coarse to wake on time.
 		cycleChan := make(chan time.Time, 1)
 		refreshRate := m.Video.RefreshRate
 		if refreshRate == 0 {
@@ -73,26 +140,39 @@ func (m *Machine) Start(rate ClockRate) (err error) {
 		}
 		scanrate := time.NewTicker(refreshRate.ToDuration())
 		var stoperr error
-		nextTime := time.Now()
-		period := rate.ToDuration()
-		cycleChan <- nextTime
+		var clk clock
+		clk.reset(m.clockRate, time.Now())
+		wakeAt := clk.start
+		cycleChan <- wakeAt
 		var timerChan <-chan time.Time
-		// runCycle needs to be split into a function, because we want to call it if
+		paused := false
+		// runCycles needs to be split into a function, because we want to call it if
 		// any of two channels has a value
-		runCycle := func() bool {
-			if err := m.State.StepCycle(); err != nil {
-				stoperr = &MachineError{err, m.State.PC()}
-				return false
+		runCycles := func() bool {
+			spinUntil(wakeAt)
+			due := clk.due(time.Now())
+			for i := 0; i < due; i++ {
+				if err := m.stepCycle(); err != nil {
+					stoperr = err
+					return false
+				}
+				clk.cycles++
+				if m.State.Halted() {
+					// don't schedule any more cycles; there's nothing left to do
+					if len(events) < eventBuffer-2 {
+						events <- Halted{m.State.PC(), m.State.Cycles()}
+					}
+					timerChan = nil
+					return true
+				}
 			}
-			m.cycleCount++
-			m.Keyboard.PollKeys()
-			nextTime = nextTime.Add(period)
+			wakeAt = clk.next()
 			now := time.Now()
-			if now.Before(nextTime) {
-				// delay the cycle
-				timerChan = time.After(nextTime.Sub(now))
+			if due < maxBatch && wakeAt.Sub(now) > spinTime {
+				// sleep until the next batch is nearly due
+				timerChan = time.After(wakeAt.Sub(now) - spinTime)
 			} else {
-				// trigger a cycle now
+				// trigger a batch now, after checking the other channels
 				cycleChan <- now
 				// Go currently uses cooperative scheduling, so we have to give
 				// other goroutines a chance to run
@@ -103,30 +183,442 @@ func (m *Machine) Start(rate ClockRate) (err error) {
 	loop:
 		for {
 			select {
-			case <-scanrate.C:
+			case now := <-scanrate.C:
+				if m.Screencast != nil {
+					m.Screencast.capture(&m.Video, now)
+				}
+				m.Video.updateBlink(now)
+				m.Video.refresh()
+				m.Video.updatePaused(paused)
 				m.Video.UpdateStats(&m.State, m.cycleCount)
 				m.Video.Flush()
+				for _, mon := range m.Monitors {
+					mon.updateBlink(now)
+				}
+				m.refreshMonitors()
 			case <-timerChan:
-				if !runCycle() {
+				if !paused && !runCycles() {
 					break loop
 				}
 			case <-cycleChan:
-				if !runCycle() {
+				if !paused && !runCycles() {
 					break loop
 				}
+			case req := <-pauser:
+				if req.run != nil {
+					req.run()
+				} else {
+					paused = req.pause
+				}
+				if paused {
+					timerChan = nil
+				} else {
+					// start the clock afresh, rather than catching up. This
+					// also restarts a machine that had halted, and lets a
+					// new clock rate take effect from the next cycle.
+					clk.reset(m.clockRate, time.Now())
+					wakeAt = clk.start
+					timerChan = nil
+					select {
+					case <-cycleChan:
+					default:
+					}
+					cycleChan <- wakeAt
+				}
+				close(req.done)
 			case _ = <-stopper:
 				break loop
+			case <-ctx.Done():
+				stoperr = ctx.Err()
+				break loop
 			}
 		}
+		close(finished)
 		scanrate.Stop()
 		stopped <- stoperr
-		errchan <- stoperr
+		if stoperr != nil {
+			if evt := errorEvent(stoperr); evt != nil {
+				events <- evt
+			}
+			events <- Stopped{stoperr}
+		}
 		close(stopped)
-		close(errchan)
+		close(events)
 	}()
 	return nil
 }
 
+// Pause freezes the machine's clock, leaving the screen up. Once Pause
+// returns, no more cycles run until Resume is called, so the State can be
+// safely inspected and modified. Pausing a paused machine does nothing.
+func (m *Machine) Pause() error {
+	if m.stopped == nil {
+		return errors.New("Machine has not started")
+	}
+	if m.paused {
+		return nil
+	}
+	if err := m.sendRequest(pauseRequest{pause: true}); err != nil {
+		return err
+	}
+	m.paused = true
+	m.pausedAt = time.Now()
+	return nil
+}
+
+// Resume restarts the clock of a paused machine. Time spent paused doesn't
+// count towards the EffectiveClockRate.
+func (m *Machine) Resume() error {
+	if m.stopped == nil {
+		return errors.New("Machine has not started")
+	}
+	if !m.paused {
+		return errors.New("Machine is not paused")
+	}
+	if err := m.sendRequest(pauseRequest{pause: false}); err != nil {
+		return err
+	}
+	m.paused = false
+	m.startTime = m.startTime.Add(time.Since(m.pausedAt))
+	return nil
+}
+
+// SetClockRate changes the rate the machine runs at. If it's running, the
+// clock restarts at the new rate from the next cycle, without a burst of
+// cycles to catch up or a stall.
+func (m *Machine) SetClockRate(rate ClockRate) error {
+	if err := rate.check(); err != nil {
+		return err
+	}
+	if m.stopped == nil {
+		m.clockRate = rate
+		return nil
+	}
+	return m.sendRequest(pauseRequest{run: func() { m.clockRate = rate }})
+}
+
+// ClockRate returns the rate the machine runs at
+func (m *Machine) ClockRate() ClockRate {
+	if m.clockRate == 0 {
+		return DefaultClockRate
+	}
+	return m.clockRate
+}
+
+// Paused returns whether the machine is paused
+func (m *Machine) Paused() bool {
+	return m.paused
+}
+
+func (m *Machine) sendRequest(req pauseRequest) error {
+	req.done = make(chan struct{})
+	select {
+	case m.pauser <- req:
+		<-req.done
+		return nil
+	case <-m.finished:
+		return errors.New("Machine has stopped running")
+	}
+}
+
+// LoadProgram loads the program into memory at offset, and remembers it
+// for Reset. It replaces any segments loaded before.
+func (m *Machine) LoadProgram(program []core.Word, offset core.Word) error {
+	m.segments = nil
+	return m.LoadSegment(program, offset)
+}
+
+// LoadSegment loads words into memory at offset, in addition to the program
+// and any other segments, e.g. for a ROM. Segments are loaded again by
+// Reset, in the same order.
+func (m *Machine) LoadSegment(words []core.Word, offset core.Word) error {
+	if err := m.State.LoadProgram(words, offset); err != nil {
+		return err
+	}
+	m.segments = append(m.segments, segment{append([]core.Word(nil), words...), offset})
+	return nil
+}
+
+// ParseSegment parses a file to load with LoadSegment, of the form
+// file@addr, as the command line gives them
+func ParseSegment(str string) (path string, offset core.Word, err error) {
+	i := strings.LastIndex(str, "@")
+	if i <= 0 {
+		return "", 0, fmt.Errorf("expected file@addr, found %#v", str)
+	}
+	addr, err := strconv.ParseUint(str[i+1:], 0, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid load address %#v", str[i+1:])
+	}
+	return str[:i], core.Word(addr), nil
+}
+
+// segment is an image loaded into memory
+type segment struct {
+	words  []core.Word
+	offset core.Word
+}
+
+// Reset returns the machine to power-on and reloads the program and
+// segments given to LoadProgram and LoadSegment. Registers and memory are
+// cleared, and the video, keyboard and attached devices are reset. A
+// running machine keeps running from address 0; a paused one stays paused.
+func (m *Machine) Reset() error {
+	if m.stopped == nil {
+		return m.reset()
+	}
+	var err error
+	if reqErr := m.sendRequest(pauseRequest{run: func() { err = m.reset() }}); reqErr != nil {
+		return reqErr
+	}
+	return err
+}
+
+func (m *Machine) reset() error {
+	m.State.Reset()
+	for _, seg := range m.segments {
+		if err := m.State.LoadProgram(seg.words, seg.offset); err != nil {
+			return err
+		}
+	}
+	m.Video.reset()
+	for _, mon := range m.Monitors {
+		mon.reset()
+	}
+	m.Keyboard.reset()
+	if m.CallStack != nil {
+		m.CallStack.Reset()
+	}
+	return nil
+}
+
+// AttachDevice attaches a hardware device, returning its hardware number.
+// Devices can be attached and detached while the machine is running; see
+// core.State.AttachDevice.
+func (m *Machine) AttachDevice(dev core.Device) (core.Word, error) {
+	if m.stopped == nil {
+		return m.State.AttachDevice(dev)
+	}
+	var index core.Word
+	var err error
+	if reqErr := m.sendRequest(pauseRequest{run: func() { index, err = m.State.AttachDevice(dev) }}); reqErr != nil {
+		return 0, reqErr
+	}
+	return index, err
+}
+
+// attachMonitors attaches the extra Monitors, which are drawn at the
+// Video's refresh rate and time their VSYNC interrupts like it
+func (m *Machine) attachMonitors() error {
+	if len(m.Monitors) > 0 && m.State.Spec == core.Spec11 {
+		return errors.New("extra monitors need the 1.7 spec")
+	}
+	for _, mon := range m.Monitors {
+		mon.RefreshRate, mon.CyclesPerSecond = m.Video.RefreshRate, m.Video.CyclesPerSecond
+		if err := mon.MapToMachine(0, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DetachDevice detaches a hardware device. The devices after it are
+// renumbered.
+func (m *Machine) DetachDevice(dev core.Device) error {
+	if m.stopped == nil {
+		return m.State.DetachDevice(dev)
+	}
+	var err error
+	if reqErr := m.sendRequest(pauseRequest{run: func() { err = m.State.DetachDevice(dev) }}); reqErr != nil {
+		return reqErr
+	}
+	return err
+}
+
+// EnableCoverage starts recording which addresses are executed, and returns
+// the Coverage. Calling it again returns the existing Coverage.
+func (m *Machine) EnableCoverage() *debug.Coverage {
+	if m.Coverage == nil {
+		m.Coverage = debug.NewCoverage()
+		m.Coverage.Attach(&m.State)
+	}
+	return m.Coverage
+}
+
+// EnableCallStack starts tracking calls made with JSR, so errors include a
+// backtrace, and returns the CallStack. Calling it again returns the
+// existing CallStack.
+func (m *Machine) EnableCallStack() *debug.CallStack {
+	if m.CallStack == nil {
+		m.CallStack = debug.NewCallStack()
+		m.CallStack.Attach(&m.State)
+	}
+	return m.CallStack
+}
+
+// stepCycle runs a single cycle and gathers the inputs for it
+func (m *Machine) stepCycle() error {
+	if err := m.State.StepCycle(); err != nil {
+		merr := &MachineError{UnderlyingError: err, PC: m.State.PC()}
+		if m.CallStack != nil {
+			merr.CallStack = m.CallStack.Frames()
+		}
+		return merr
+	}
+	m.cycleCount++
+	m.pollInputs()
+	return nil
+}
+
+// StepOnce executes a single instruction on a paused or stopped machine,
+// returning the number of cycles it took. The screen is refreshed if the
+// machine is running. Errors are returned as *MachineError; unlike when
+// running, they don't stop the machine.
+func (m *Machine) StepOnce() (uint64, error) {
+	return m.StepN(1)
+}
+
+// StepN is like StepOnce, but executes up to n instructions, stopping early
+// if there's an error
+func (m *Machine) StepN(n int) (uint64, error) {
+	var cycles uint64
+	var err error
+	reqErr := m.whilePaused(func() {
+		for i := 0; i < n && err == nil; i++ {
+			for {
+				if err = m.stepCycle(); err != nil {
+					break
+				}
+				cycles++
+				if m.State.BetweenInstructions() {
+					break
+				}
+			}
+		}
+	})
+	if reqErr != nil {
+		return 0, reqErr
+	}
+	return cycles, err
+}
+
+// RunFor runs a paused or stopped machine for up to the given number of
+// cycles as fast as possible, returning the number run. It stops early if
+// there's an error, which is returned as from StepOnce.
+func (m *Machine) RunFor(cycles uint64) (uint64, error) {
+	var run uint64
+	var err error
+	reqErr := m.whilePaused(func() {
+		for ; run < cycles; run++ {
+			if err = m.stepCycle(); err != nil {
+				break
+			}
+		}
+	})
+	if reqErr != nil {
+		return 0, reqErr
+	}
+	return run, err
+}
+
+// RunUntil runs a paused or stopped machine as fast as possible until pred
+// returns true, as core.State.RunUntil does. If limit is non-zero, at most
+// limit cycles are run before returning core.ErrCycleLimit.
+func (m *Machine) RunUntil(pred func(s *core.State) bool, limit uint64) (uint64, error) {
+	var cycles uint64
+	var err error
+	reqErr := m.whilePaused(func() {
+		for {
+			if m.State.BetweenInstructions() {
+				if pred(&m.State) {
+					return
+				}
+				if m.State.Halted() {
+					err = core.ErrHalted
+					return
+				}
+			}
+			if limit != 0 && cycles == limit {
+				err = core.ErrCycleLimit
+				return
+			}
+			if err = m.stepCycle(); err != nil {
+				return
+			}
+			cycles++
+		}
+	})
+	if reqErr != nil {
+		return 0, reqErr
+	}
+	return cycles, err
+}
+
+// whilePaused calls run directly if the machine is stopped, or on the
+// machine's goroutine followed by a screen refresh if it's paused. It's an
+// error to call it while the machine is running.
+func (m *Machine) whilePaused(run func()) error {
+	if m.stopped == nil {
+		run()
+		return nil
+	}
+	if !m.paused {
+		return errors.New("Machine is running")
+	}
+	return m.sendRequest(pauseRequest{run: func() {
+		run()
+		m.Video.refresh()
+		m.Video.UpdateStats(&m.State, m.cycleCount)
+		m.Video.Flush()
+		m.refreshMonitors()
+	}})
+}
+
+// refreshMonitors draws the screens of the extra Monitors, as the Video's
+// is drawn
+func (m *Machine) refreshMonitors() {
+	for _, mon := range m.Monitors {
+		mon.refresh()
+		mon.Flush()
+	}
+}
+
+// RefreshScreen redraws the screen of a paused or stopped machine, as the
+// running machine does at its refresh rate, such as when running it with
+// RunUntil. The video must be mapped to draw a stopped machine's screen.
+func (m *Machine) RefreshScreen() error {
+	return m.whilePaused(func() {
+		if m.stopped == nil {
+			m.Video.refresh()
+			m.Video.Flush()
+			m.refreshMonitors()
+		}
+	})
+}
+
+// pollInputs gathers the inputs for the cycle, either from the keyboard or
+// from the Replay
+func (m *Machine) pollInputs() {
+	cycle := m.State.Cycles()
+	if m.Replay != nil {
+		m.Replay.replay(cycle, m)
+		return
+	}
+	key, ok := m.Keyboard.PollKeys()
+	if !ok {
+		key, ok = m.Keyboard.pollPaste(cycle)
+	}
+	if !ok {
+		key, ok = m.Keyboard.pollHeld(cycle)
+	}
+	if !ok {
+		key, ok = m.Keyboard.pollSources(cycle)
+	}
+	if ok && m.Recording != nil {
+		m.Recording.record(cycle, InputKey, key)
+	}
+}
+
 // Stop stops the machine. Returns an error if it's already stopped.
 // If the machine has halted due to an error, that error is returned.
 func (m *Machine) Stop() error {
@@ -136,19 +628,34 @@ func (m *Machine) Stop() error {
 	m.Video.UnmapFromMachine(0x8000, m)
 	m.Keyboard.UnmapFromMachine(0x9000, m)
 	m.stopper <- struct{}{}
-	m.Video.Close()
+	m.closeScreens()
 	err := <-m.stopped
 	close(m.stopper)
 	m.stopper = nil
 	m.stopped = nil
-	m.ErrorC = nil
+	m.EventsC = nil
+	m.pauser = nil
+	m.finished = nil
+	m.paused = false
 	return err
 }
 
+// closeScreens closes the screen and any extra monitors, as the machine
+// stops
+func (m *Machine) closeScreens() {
+	m.Video.Close()
+	for _, mon := range m.Monitors {
+		mon.Close()
+	}
+}
+
 // ClockRate represents the clock rate of the machine
 type ClockRate int64
 
 func (c ClockRate) String() string {
+	if c == Unthrottled {
+		return "max"
+	}
 	rate := float64(c)
 	// We want to do some rounding instead of pure truncation
 	// 99.999KHz shouldn't be showing as 99KHz
@@ -168,7 +675,12 @@ func (c ClockRate) String() string {
 	return fmt.Sprintf("%s%s", ratestr, suffix)
 }
 
+// Set parses a rate such as "100KHz" or "2MHz", or "max" for Unthrottled
 func (c *ClockRate) Set(str string) error {
+	if strings.ToLower(str) == "max" {
+		*c = Unthrottled
+		return nil
+	}
 	var rate int64
 	var suffix string
 	if n, err := fmt.Sscanf(str, "%d%s", &rate, &suffix); err != nil && !(n == 1 && err == io.EOF) {
@@ -191,11 +703,22 @@ func (c *ClockRate) Set(str string) error {
 }
 
 // ToDuration converts the ClockRate to a time.Duration that represents
-// the period of one clock cycle
+// the period of one clock cycle. Unthrottled has a period of 0.
 func (c ClockRate) ToDuration() time.Duration {
+	if c == Unthrottled {
+		return 0
+	}
 	return time.Second / time.Duration(c)
 }
 
+// check returns an error if c isn't positive or Unthrottled
+func (c ClockRate) check() error {
+	if c <= 0 && c != Unthrottled {
+		return errors.New("clock rate must be positive")
+	}
+	return nil
+}
+
 // EffectiveClockRate returns the current observed rate that the machine
 // is running at, as an average since the last Start()
 func (m *Machine) EffectiveClockRate() ClockRate {
@@ -213,11 +736,14 @@ func (m *Machine) HasError() error {
 	}
 	select {
 	case err := <-m.stopped:
-		m.Video.Close()
+		m.closeScreens()
 		close(m.stopper)
 		m.stopper = nil
 		m.stopped = nil
-		m.ErrorC = nil
+		m.EventsC = nil
+		m.pauser = nil
+		m.finished = nil
+		m.paused = false
 		return err
 	default:
 	}
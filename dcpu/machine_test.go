@@ -0,0 +1,263 @@
+package dcpu
+
+import (
+	"context"
+	"errors"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"github.com/kballard/dcpu16/dcpu/debug"
+	"testing"
+)
+
+func TestMachineReset(t *testing.T) {
+	m := new(Machine)
+	if err := m.LoadProgram([]core.Word{0x8801, 0x8b83}, 0x10); err != nil { // SET A, 1; SUB PC, 1
+		t.Fatal(err)
+	}
+	m.State.SetPC(0x10)
+	m.State.StepCycle()
+	m.State.Ram.Store(0x10, 0)
+	m.Video.words[0] = 0xf041
+	m.Keyboard.pushKey('a')
+	if err := m.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if m.State.A() != 0 || m.State.PC() != 0 || m.State.Cycles() != 0 {
+		t.Errorf("Registers were not reset; %v", m.State.Registers)
+	}
+	if m.State.Ram.Load(0x10) != 0x8801 || m.State.Ram.Load(0x11) != 0x8b83 {
+		t.Error("The program was not reloaded")
+	}
+	if m.Video.words[0] != 0 || m.Video.words[backgroundColorAddress] != 3 {
+		t.Error("Video memory was not reset")
+	}
+	if m.Keyboard.words[0] != 0 || m.Keyboard.offset != 0 {
+		t.Error("The keyboard was not reset")
+	}
+}
+
+func TestMachineLoadSegment(t *testing.T) {
+	m := new(Machine)
+	if err := m.LoadProgram([]core.Word{1, 2}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.LoadSegment([]core.Word{3}, 0xf000); err != nil {
+		t.Fatal(err)
+	}
+	m.State.Ram.Store(0xf000, 0)
+	if err := m.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if m.State.Ram.Load(1) != 2 || m.State.Ram.Load(0xf000) != 3 {
+		t.Error("Expected both segments to be reloaded")
+	}
+	// loading a program replaces the segments
+	if err := m.LoadProgram([]core.Word{4}, 0x10); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if m.State.Ram.Load(0xf000) != 0 || m.State.Ram.Load(0x10) != 4 {
+		t.Error("Expected only the new program to be reloaded")
+	}
+
+	// overlapping segments are loaded in order, so the last one wins, on
+	// reset too
+	if err := m.LoadSegment([]core.Word{5, 6}, 0x10); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if m.State.Ram.Load(0x10) != 5 || m.State.Ram.Load(0x11) != 6 {
+		t.Errorf("Expected the later segment to overwrite the program, found %#x %#x", m.State.Ram.Load(0x10), m.State.Ram.Load(0x11))
+	}
+	// segments past the end of memory aren't loaded, or reloaded
+	if err := m.LoadSegment([]core.Word{7, 8}, 0xffff); err != core.ErrOutOfBounds {
+		t.Errorf("Expected %v loading past the end of memory, found %v", core.ErrOutOfBounds, err)
+	}
+	if err := m.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if m.State.Ram.Load(0xffff) != 0 {
+		t.Errorf("Expected nothing at the end of memory, found %#x", m.State.Ram.Load(0xffff))
+	}
+}
+
+func TestParseSegment(t *testing.T) {
+	tests := []struct {
+		str    string
+		path   string
+		offset core.Word
+		ok     bool
+	}{
+		{"rom.bin@0xf000", "rom.bin", 0xf000, true},
+		{"a@b.bin@16", "a@b.bin", 16, true}, // the last @ separates the address
+		{"rom.bin@0x10000", "", 0, false},
+		{"rom.bin@", "", 0, false},
+		{"rom.bin", "", 0, false},
+		{"@0x100", "", 0, false},
+		{"rom.bin@-1", "", 0, false},
+		{"rom.bin@0x100:16", "", 0, false},
+	}
+	for _, test := range tests {
+		path, offset, err := ParseSegment(test.str)
+		if (err == nil) != test.ok || path != test.path || offset != test.offset {
+			t.Errorf("%#v: expected %q at %#x (ok %v), found %q at %#x (%v)", test.str, test.path, test.offset, test.ok, path, offset, err)
+		}
+	}
+}
+
+func TestMachineStep(t *testing.T) {
+	m := new(Machine)
+	program := []core.Word{
+		0x7c01, 0x0030, // SET A, 0x30
+		0x8802, // ADD A, 1
+		0x0000, // invalid
+	}
+	if err := m.LoadProgram(program, 0); err != nil {
+		t.Fatal(err)
+	}
+	if cycles, err := m.StepOnce(); err != nil || cycles != 2 || m.State.A() != 0x30 {
+		t.Errorf("Unexpected step; %d cycles, A %#x (%v)", cycles, m.State.A(), err)
+	}
+	cycles, err := m.StepN(5)
+	if cycles != 2 || m.State.A() != 0x31 {
+		t.Errorf("Unexpected steps; %d cycles, A %#x", cycles, m.State.A())
+	}
+	if merr, ok := err.(*MachineError); !ok || merr.PC != 4 {
+		t.Errorf("Expected a MachineError at PC 4, found %v", err)
+	}
+}
+
+func TestMachineRunUntil(t *testing.T) {
+	m := new(Machine)
+	// :loop ADD A, 1
+	//       SET PC, loop
+	if err := m.LoadProgram([]core.Word{0x8802, 0x8781}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if cycles, err := m.RunFor(6); err != nil || cycles != 6 || m.State.A() != 2 {
+		t.Errorf("Unexpected RunFor; %d cycles, A %#x (%v)", cycles, m.State.A(), err)
+	}
+	cycles, err := m.RunUntil(func(s *core.State) bool { return s.A() == 10 }, 0)
+	if err != nil || cycles != 23 || m.State.A() != 10 {
+		t.Errorf("Unexpected RunUntil; %d cycles, A %#x (%v)", cycles, m.State.A(), err)
+	}
+	if _, err := m.RunUntil(func(s *core.State) bool { return false }, 100); err != core.ErrCycleLimit {
+		t.Errorf("Expected %v, found %v", core.ErrCycleLimit, err)
+	}
+}
+
+func TestNewMachine(t *testing.T) {
+	dev := new(debug.Device)
+	m, err := NewMachine(WithClockRate(1000), WithRefreshRate(30), WithDevices(dev), WithSpec(core.Spec11))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.clockRate != 1000 || m.Video.RefreshRate != 30 || m.State.Spec != core.Spec11 {
+		t.Errorf("Unexpected configuration; rate %v, refresh %v, spec %v", m.clockRate, m.Video.RefreshRate, m.State.Spec)
+	}
+	if len(m.State.Devices) != 1 || m.State.Devices[0] != dev {
+		t.Errorf("Expected the device to be attached, found %v", m.State.Devices)
+	}
+
+	if m, err = NewMachine(); err != nil || m.clockRate != DefaultClockRate || m.Video.RefreshRate != DefaultScreenRefreshRate {
+		t.Errorf("Unexpected defaults (%v)", err)
+	}
+
+	for _, opt := range []Option{WithClockRate(0), WithRefreshRate(-1), WithDevices(nil)} {
+		if _, err := NewMachine(opt); err == nil {
+			t.Error("Expected an error for an invalid option")
+		}
+	}
+}
+
+func TestErrorEvent(t *testing.T) {
+	devErr := errors.New("device failed")
+	tests := []struct {
+		err   error
+		event Event
+	}{
+		{&MachineError{UnderlyingError: &core.BreakpointError{PC: 0x10}, PC: 0x10}, BreakpointHit{0x10}},
+		{&MachineError{UnderlyingError: &core.ProtectionError{Address: 0x20}, PC: 0x12}, ProtectionFault{0x12, 0x20, false}},
+		{&MachineError{UnderlyingError: &core.DeviceError{Index: 2, Err: devErr}, PC: 0x14}, DeviceError{0x14, 2, devErr}},
+		{&MachineError{UnderlyingError: core.ErrInterruptOverflow, PC: 0x16}, nil},
+		{context.Canceled, nil},
+	}
+	for _, test := range tests {
+		if evt := errorEvent(test.err); evt != test.event {
+			t.Errorf("%v: expected event %#v, found %#v", test.err, test.event, evt)
+		}
+	}
+}
+
+func TestSetClockRate(t *testing.T) {
+	m := new(Machine)
+	if m.ClockRate() != DefaultClockRate {
+		t.Errorf("Expected the default clock rate, found %v", m.ClockRate())
+	}
+	if err := m.SetClockRate(1000); err != nil || m.ClockRate() != 1000 {
+		t.Errorf("Unexpected clock rate %v (%v)", m.ClockRate(), err)
+	}
+	if err := m.SetClockRate(0); err == nil || m.ClockRate() != 1000 {
+		t.Errorf("Expected an error setting a zero clock rate, found rate %v", m.ClockRate())
+	}
+}
+
+func TestClockRateSet(t *testing.T) {
+	tests := []struct {
+		str  string
+		rate ClockRate
+	}{
+		{"100", 100},
+		{"100KHz", 100000},
+		{"2mhz", 2000000},
+		{"max", Unthrottled},
+		{"MAX", Unthrottled},
+	}
+	for _, test := range tests {
+		var rate ClockRate
+		if err := rate.Set(test.str); err != nil || rate != test.rate {
+			t.Errorf("%q: expected %v, found %v (%v)", test.str, test.rate, rate, err)
+		}
+	}
+	if Unthrottled.String() != "max" || Unthrottled.ToDuration() != 0 {
+		t.Errorf("Unexpected Unthrottled; %v, period %v", Unthrottled, Unthrottled.ToDuration())
+	}
+	m := new(Machine)
+	if err := m.SetClockRate(Unthrottled); err != nil || m.ClockRate() != Unthrottled {
+		t.Errorf("Unexpected clock rate %v (%v)", m.ClockRate(), err)
+	}
+}
+
+func TestStartContext(t *testing.T) {
+	m := new(Machine)
+	// SUB PC, 1
+	if err := m.State.LoadProgram([]core.Word{0x8b83}, 0); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := m.StartContext(ctx, Unthrottled); err != nil {
+		t.Fatal(err)
+	}
+	events := m.EventsC
+	cancel()
+	// the machine stops itself, reporting why
+	var stopped *Stopped
+	for evt := range events {
+		if evt, ok := evt.(Stopped); ok {
+			stopped = &evt
+		}
+	}
+	if stopped == nil || stopped.Err != context.Canceled {
+		t.Errorf("Expected a Stopped event with %v, found %v", context.Canceled, stopped)
+	}
+	// and stopping it afterwards is still safe
+	if err := m.Stop(); err != context.Canceled {
+		t.Errorf("Expected Stop to return %v, found %v", context.Canceled, err)
+	}
+	if err := m.Stop(); err == nil {
+		t.Error("Expected an error stopping a stopped machine")
+	}
+}
@@ -0,0 +1,111 @@
+package dcpu
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"io"
+)
+
+// NVRAMStore is where an NVRAM's contents are kept, such as a host file. It
+// is stored as big-endian words, like disk images.
+type NVRAMStore interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// NVRAM is a small battery-backed RAM that keeps its contents across runs.
+// The operation is selected by A when the device is sent HWI:
+//
+//	A=0 B is set to the size of the NVRAM in words
+//	A=1 C words are copied from the NVRAM at X to memory at B. C is set
+//	    to the number of words copied, which is less if X+C is past the end
+//	A=2 C words are copied from memory at B to the NVRAM at X, and C is
+//	    set to the number of words copied as above
+//
+// Writes go straight through to the store, so nothing is lost if the
+// emulator exits without warning. Unlike memory, the contents survive a
+// reset.
+type NVRAM struct {
+	words []core.Word
+	store NVRAMStore
+}
+
+const (
+	NVRAMID           = 0x02080001
+	NVRAMVersion      = 1
+	NVRAMManufacturer = 0x6b62616c
+)
+
+// DefaultNVRAMSize is the size of an NVRAM in words, unless told otherwise
+const DefaultNVRAMSize = 1024
+
+const (
+	nvramGetSize = iota
+	nvramRead
+	nvramWrite
+)
+
+// NewNVRAM returns an NVRAM of size words, loaded from store. A short store
+// is padded with zeros.
+func NewNVRAM(size int, store NVRAMStore) (*NVRAM, error) {
+	if size <= 0 || size > 0x10000 {
+		return nil, errors.New("NVRAM size must be between 1 and 0x10000 words")
+	}
+	buf := make([]byte, size*2)
+	if _, err := store.ReadAt(buf, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	words := make([]core.Word, size)
+	for i := range words {
+		words[i] = core.Word(binary.BigEndian.Uint16(buf[i*2:]))
+	}
+	return &NVRAM{words: words, store: store}, nil
+}
+
+func (n *NVRAM) ID() uint32           { return NVRAMID }
+func (n *NVRAM) Version() core.Word   { return NVRAMVersion }
+func (n *NVRAM) Manufacturer() uint32 { return NVRAMManufacturer }
+
+// Words returns the contents of the NVRAM
+func (n *NVRAM) Words() []core.Word {
+	return n.words
+}
+
+func (n *NVRAM) HandleInterrupt(s *core.State) error {
+	switch s.A() {
+	case nvramGetSize:
+		s.SetB(core.Word(len(n.words)))
+	case nvramRead:
+		start, count := n.clamp(s.X(), s.C())
+		for i := 0; i < count; i++ {
+			s.Ram.Store(s.B()+core.Word(i), n.words[start+i])
+		}
+		s.SetC(core.Word(count))
+	case nvramWrite:
+		start, count := n.clamp(s.X(), s.C())
+		buf := make([]byte, count*2)
+		for i := 0; i < count; i++ {
+			word := s.Ram.Load(s.B() + core.Word(i))
+			n.words[start+i] = word
+			binary.BigEndian.PutUint16(buf[i*2:], uint16(word))
+		}
+		s.SetC(core.Word(count))
+		if _, err := n.store.WriteAt(buf, int64(start)*2); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clamp returns the range of words from offset that fits in the NVRAM
+func (n *NVRAM) clamp(offset, count core.Word) (int, int) {
+	start := int(offset)
+	if start > len(n.words) {
+		start = len(n.words)
+	}
+	if end := start + int(count); end > len(n.words) {
+		return start, len(n.words) - start
+	}
+	return start, int(count)
+}
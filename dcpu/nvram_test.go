@@ -0,0 +1,56 @@
+package dcpu
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"testing"
+)
+
+func TestNVRAM(t *testing.T) {
+	store := &memDisk{[]byte{0x12, 0x34}}
+	nvram, err := NewNVRAM(4, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if words := nvram.Words(); words[0] != 0x1234 || words[1] != 0 {
+		t.Fatalf("Unexpected contents %v", words)
+	}
+	state := new(core.State)
+	hwi := func(a, b, c, x core.Word) {
+		state.SetA(a)
+		state.SetB(b)
+		state.SetC(c)
+		state.SetX(x)
+		if err := nvram.HandleInterrupt(state); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hwi(nvramGetSize, 0, 0, 0)
+	if state.B() != 4 {
+		t.Errorf("Expected a size of 4, found %d", state.B())
+	}
+
+	state.Ram.Store(0x1000, 0xbeef)
+	state.Ram.Store(0x1001, 0xcafe)
+	hwi(nvramWrite, 0x1000, 2, 3)
+	if state.C() != 1 {
+		t.Errorf("Expected the write to stop at the end, copied %d words", state.C())
+	}
+	if len(store.data) != 8 || store.data[6] != 0xbe || store.data[7] != 0xef {
+		t.Errorf("Expected the write to reach the store, found % x", store.data)
+	}
+
+	hwi(nvramRead, 0x2000, 4, 0)
+	if state.C() != 4 || state.Ram.Load(0x2000) != 0x1234 || state.Ram.Load(0x2003) != 0xbeef {
+		t.Errorf("Unexpected read of %d words", state.C())
+	}
+
+	// the contents survive into a new NVRAM
+	reloaded, err := NewNVRAM(4, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Words()[3] != 0xbeef {
+		t.Errorf("Expected the write to persist, found %v", reloaded.Words())
+	}
+}
@@ -0,0 +1,108 @@
+package dcpu
+
+import (
+	"errors"
+	"github.com/kballard/dcpu16/dcpu/core"
+)
+
+// Option configures a Machine created by NewMachine
+type Option func(m *Machine) error
+
+// NewMachine returns a Machine configured by the given options, which are
+// applied in order. Options that aren't given keep their defaults.
+func NewMachine(opts ...Option) (*Machine, error) {
+	m := &Machine{clockRate: DefaultClockRate}
+	m.Video.RefreshRate = DefaultScreenRefreshRate
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.attachMonitors(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// WithClockRate sets the rate the machine runs at when started with a
+// rate of 0, which may be Unthrottled. The default is DefaultClockRate.
+// Unless it's Unthrottled, the LEM1802's VSYNC interrupts are timed by it.
+func WithClockRate(rate ClockRate) Option {
+	return func(m *Machine) error {
+		if err := rate.check(); err != nil {
+			return err
+		}
+		m.clockRate = rate
+		if rate != Unthrottled {
+			m.Video.CyclesPerSecond = uint64(rate)
+		}
+		return nil
+	}
+}
+
+// WithRefreshRate sets the rate the screen is redrawn at. The default is
+// DefaultScreenRefreshRate.
+func WithRefreshRate(rate ClockRate) Option {
+	return func(m *Machine) error {
+		if rate <= 0 {
+			return errors.New("refresh rate must be positive")
+		}
+		m.Video.RefreshRate = rate
+		return nil
+	}
+}
+
+// WithVideoBackend sets the backend the screen is drawn with, such as
+// the terminal's. By default, the screen isn't drawn.
+func WithVideoBackend(backend VideoBackend) Option {
+	return func(m *Machine) error {
+		m.Video.Backend = backend
+		return nil
+	}
+}
+
+// WithKeyboardSources adds sources of keys to the keyboard's Sources, such
+// as the keys typed in a frontend
+func WithKeyboardSources(sources ...KeyboardSource) Option {
+	return func(m *Machine) error {
+		m.Keyboard.Sources = append(m.Keyboard.Sources, sources...)
+		return nil
+	}
+}
+
+// WithMonitors adds an extra LEM1802 to the Machine's Monitors for each
+// backend, which may be nil. They're attached once the other options have
+// been applied, and stay attached while the machine is stopped, like the
+// devices of WithDevices. Monitors need the 1.7 spec.
+func WithMonitors(backends ...VideoBackend) Option {
+	return func(m *Machine) error {
+		for _, backend := range backends {
+			m.Monitors = append(m.Monitors, &Video{Backend: backend})
+		}
+		return nil
+	}
+}
+
+// WithDevices attaches hardware devices with AttachDevice, after any
+// already attached
+func WithDevices(devices ...core.Device) Option {
+	return func(m *Machine) error {
+		for _, dev := range devices {
+			if dev == nil {
+				return errors.New("device must not be nil")
+			}
+			if _, err := m.AttachDevice(dev); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// WithSpec sets the version of the DCPU-16 spec the machine implements
+func WithSpec(spec core.SpecVersion) Option {
+	return func(m *Machine) error {
+		m.State.Spec = spec
+		return nil
+	}
+}
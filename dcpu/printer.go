@@ -0,0 +1,118 @@
+package dcpu
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"io"
+)
+
+// LinePrinter prints characters to a host file or other writer, taking time
+// for each like a real printer. The operation is selected by A when the
+// device is sent HWI:
+//
+//	A=0 B is set to the printer's state
+//	A=1 the low byte of B is printed, and C is set to 1; if the printer
+//	    isn't ready, nothing is printed and C is set to 0
+//	A=2 the low bytes of the C words at B are printed, and C is set to 1;
+//	    if the printer isn't ready, nothing is printed and C is set to 0
+//	A=3 if B is non-zero, an interrupt with message B is raised when the
+//	    printer becomes ready again; if B is 0 interrupts are turned off
+//
+// The printer is busy after printing until the characters would have been
+// printed. If writing fails, it's left in the error state until reset.
+type LinePrinter struct {
+	W                   io.Writer
+	CharactersPerSecond uint64 // 0 means DefaultPrinterSpeed
+	CyclesPerSecond     uint64 // the cycles in a second; 0 means DefaultClockRate
+	state               core.Word
+	cyclesLeft          uint64 // until the printer is ready
+	message             core.Word
+}
+
+const (
+	LinePrinterID           = 0x020c0001
+	LinePrinterVersion      = 1
+	LinePrinterManufacturer = 0x6b62616c
+)
+
+// DefaultPrinterSpeed is the speed of a LinePrinter in characters per
+// second, unless told otherwise
+const DefaultPrinterSpeed = 1000
+
+const (
+	printerStatus = iota
+	printerPrintChar
+	printerPrint
+	printerSetInterrupt
+)
+
+// LinePrinter states
+const (
+	LinePrinterReady = iota
+	LinePrinterBusy
+	LinePrinterError
+)
+
+func (p *LinePrinter) ID() uint32           { return LinePrinterID }
+func (p *LinePrinter) Version() core.Word   { return LinePrinterVersion }
+func (p *LinePrinter) Manufacturer() uint32 { return LinePrinterManufacturer }
+
+func (p *LinePrinter) HandleInterrupt(s *core.State) error {
+	switch s.A() {
+	case printerStatus:
+		s.SetB(p.state)
+	case printerPrintChar:
+		p.print(s, []byte{byte(s.B())})
+	case printerPrint:
+		buf := make([]byte, s.C())
+		for i := range buf {
+			buf[i] = byte(s.Ram.Load(s.B() + core.Word(i)))
+		}
+		p.print(s, buf)
+	case printerSetInterrupt:
+		p.message = s.B()
+	}
+	return nil
+}
+
+func (p *LinePrinter) print(s *core.State, buf []byte) {
+	if p.state != LinePrinterReady {
+		s.SetC(0)
+		return
+	}
+	s.SetC(1)
+	if _, err := p.W.Write(buf); err != nil {
+		p.state = LinePrinterError
+		return
+	}
+	speed, rate := p.CharactersPerSecond, p.CyclesPerSecond
+	if speed == 0 {
+		speed = DefaultPrinterSpeed
+	}
+	if rate == 0 {
+		rate = uint64(DefaultClockRate)
+	}
+	if p.cyclesLeft = uint64(len(buf)) * rate / speed; p.cyclesLeft > 0 {
+		p.state = LinePrinterBusy
+	}
+}
+
+// Tick counts down until the printer is ready
+func (p *LinePrinter) Tick(s *core.State) error {
+	if p.state != LinePrinterBusy {
+		return nil
+	}
+	if p.cyclesLeft--; p.cyclesLeft == 0 {
+		p.state = LinePrinterReady
+		if p.message != 0 {
+			s.TriggerInterrupt(p.message)
+		}
+	}
+	return nil
+}
+
+// Reset makes the printer ready and turns off interrupts
+func (p *LinePrinter) Reset() {
+	p.state = LinePrinterReady
+	p.cyclesLeft = 0
+	p.message = 0
+}
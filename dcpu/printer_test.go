@@ -0,0 +1,77 @@
+package dcpu
+
+import (
+	"bytes"
+	"errors"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"testing"
+)
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("out of paper")
+}
+
+func TestLinePrinter(t *testing.T) {
+	var buf bytes.Buffer
+	state := new(core.State)
+	printer := &LinePrinter{W: &buf, CharactersPerSecond: 100, CyclesPerSecond: 1000}
+	if _, err := state.AttachDevice(printer); err != nil {
+		t.Fatal(err)
+	}
+	// SUB PC, 1
+	if err := state.LoadProgram([]core.Word{0x8b83}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.LoadProgram([]core.Word{0x8b83}, 0x100); err != nil {
+		t.Fatal(err)
+	}
+	state.SetIA(0x100)
+	hwi := func(a, b, c core.Word) {
+		state.SetA(a)
+		state.SetB(b)
+		state.SetC(c)
+		if err := printer.HandleInterrupt(state); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hwi(printerSetInterrupt, 0x42, 0)
+	state.Ram.Store(0x1000, 'h')
+	state.Ram.Store(0x1001, 'i')
+	hwi(printerPrint, 0x1000, 2)
+	hwi(printerPrintChar, '\n', 0)
+	if state.C() != 0 {
+		t.Error("Expected a busy printer to refuse to print")
+	}
+	hwi(printerStatus, 0, 0)
+	if state.B() != LinePrinterBusy {
+		t.Errorf("Expected the printer to be busy, found state %d", state.B())
+	}
+	// 2 characters at 100 per second take 20 cycles at 1KHz
+	if _, err := state.RunFor(19); err != nil {
+		t.Fatal(err)
+	}
+	if printer.state != LinePrinterBusy {
+		t.Error("Expected the printer to still be busy")
+	}
+	if _, err := state.RunUntil(func(s *core.State) bool { return s.PC() == 0x100 }, 100); err != nil {
+		t.Fatal(err)
+	}
+	if state.A() != 0x42 {
+		t.Errorf("Expected interrupt message 0x42, found %#04x", state.A())
+	}
+	hwi(printerPrintChar, '\n', 0)
+	if buf.String() != "hi\n" {
+		t.Errorf("Unexpected output %q", buf.String())
+	}
+
+	printer.Reset()
+	printer.W = failingWriter{}
+	hwi(printerPrintChar, 'x', 0)
+	hwi(printerStatus, 0, 0)
+	if state.B() != LinePrinterError {
+		t.Errorf("Expected the printer to be in error, found state %d", state.B())
+	}
+}
@@ -0,0 +1,230 @@
+package dcpu
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"net"
+	"sync"
+)
+
+// Radio is a packet radio that sends frames of words to other emulators
+// over UDP. Frames are only received on the channel the radio is tuned to.
+// The operation is selected by A when the device is sent HWI:
+//
+//	A=0 B is set to the number of frames waiting, and C to the length of
+//	    the next
+//	A=1 send the C words at B as a frame. B is set to 1 if it was sent.
+//	A=2 receive the next frame into memory at B, copying at most C words.
+//	    C is set to the number of words copied, or 0 if no frame is waiting.
+//	A=3 if B is non-zero, an interrupt with message B is raised whenever a
+//	    frame arrives; if B is 0 interrupts are turned off
+//	A=4 tune to channel B, discarding any waiting frames
+//
+// Frames have at most RadioMaxFrame words, and may be lost or reordered.
+type Radio struct {
+	conn      net.PacketConn
+	peers     []net.Addr
+	incoming  chan radioFrame
+	queue     []radioFrame
+	channel   core.Word
+	message   core.Word
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+type radioFrame struct {
+	channel core.Word
+	words   []core.Word
+}
+
+const (
+	RadioID           = 0xe57d1dae
+	RadioVersion      = 1
+	RadioManufacturer = 0x6b62616c
+)
+
+const (
+	radioStatus = iota
+	radioSend
+	radioReceive
+	radioSetInterrupt
+	radioTune
+)
+
+const (
+	RadioMaxFrame = 256
+	// radioQueue is the number of frames buffered before more are dropped
+	radioQueue = 64
+	// radioMagic starts every packet, followed by the channel and the words
+	radioMagic = 0xd16a
+)
+
+// ListenRadio returns a Radio that receives frames on the UDP address addr,
+// and sends them to each of peers
+func ListenRadio(addr string, peers ...string) (*Radio, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	var peerAddrs []net.Addr
+	for _, peer := range peers {
+		a, err := net.ResolveUDPAddr("udp", peer)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		peerAddrs = append(peerAddrs, a)
+	}
+	return NewRadio(conn, peerAddrs), nil
+}
+
+// NewRadio returns a Radio that receives frames on conn, and sends them to
+// each of peers
+func NewRadio(conn net.PacketConn, peers []net.Addr) *Radio {
+	r := &Radio{
+		conn:     conn,
+		peers:    peers,
+		incoming: make(chan radioFrame, radioQueue),
+		closed:   make(chan struct{}),
+	}
+	go r.readLoop()
+	return r
+}
+
+// Addr returns the address the radio receives frames on
+func (r *Radio) Addr() net.Addr {
+	return r.conn.LocalAddr()
+}
+
+// Close stops the radio
+func (r *Radio) Close() error {
+	r.closeOnce.Do(func() { close(r.closed) })
+	return r.conn.Close()
+}
+
+func (r *Radio) readLoop() {
+	buf := make([]byte, 4+RadioMaxFrame*2)
+	for {
+		n, _, err := r.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		frame, err := decodeRadioFrame(buf[:n])
+		if err != nil {
+			continue
+		}
+		select {
+		case r.incoming <- frame:
+		case <-r.closed:
+			return
+		default:
+			// the guest isn't keeping up, so the frame is lost
+		}
+	}
+}
+
+func encodeRadioFrame(frame radioFrame) []byte {
+	buf := make([]byte, 4+len(frame.words)*2)
+	binary.BigEndian.PutUint16(buf, radioMagic)
+	binary.BigEndian.PutUint16(buf[2:], uint16(frame.channel))
+	for i, w := range frame.words {
+		binary.BigEndian.PutUint16(buf[4+i*2:], uint16(w))
+	}
+	return buf
+}
+
+func decodeRadioFrame(buf []byte) (radioFrame, error) {
+	if len(buf) < 4 || len(buf)%2 != 0 || binary.BigEndian.Uint16(buf) != radioMagic {
+		return radioFrame{}, errors.New("invalid radio frame")
+	}
+	frame := radioFrame{channel: core.Word(binary.BigEndian.Uint16(buf[2:]))}
+	for i := 4; i < len(buf); i += 2 {
+		frame.words = append(frame.words, core.Word(binary.BigEndian.Uint16(buf[i:])))
+	}
+	return frame, nil
+}
+
+func (r *Radio) ID() uint32           { return RadioID }
+func (r *Radio) Version() core.Word   { return RadioVersion }
+func (r *Radio) Manufacturer() uint32 { return RadioManufacturer }
+
+func (r *Radio) HandleInterrupt(s *core.State) error {
+	switch s.A() {
+	case radioStatus:
+		r.receive()
+		s.SetB(core.Word(len(r.queue)))
+		s.SetC(0)
+		if len(r.queue) > 0 {
+			s.SetC(core.Word(len(r.queue[0].words)))
+		}
+	case radioSend:
+		address := s.B()
+		s.SetB(0)
+		if s.C() > RadioMaxFrame {
+			break
+		}
+		frame := radioFrame{channel: r.channel, words: make([]core.Word, s.C())}
+		for i := range frame.words {
+			frame.words[i] = s.Ram.Load(address + core.Word(i))
+		}
+		packet := encodeRadioFrame(frame)
+		sent := false
+		for _, peer := range r.peers {
+			if _, err := r.conn.WriteTo(packet, peer); err == nil {
+				sent = true
+			}
+		}
+		if sent {
+			s.SetB(1)
+		}
+	case radioReceive:
+		r.receive()
+		if len(r.queue) == 0 {
+			s.SetC(0)
+			break
+		}
+		words := r.queue[0].words
+		r.queue = r.queue[1:]
+		if int(s.C()) < len(words) {
+			words = words[:s.C()]
+		}
+		for i, w := range words {
+			s.Ram.Store(s.B()+core.Word(i), w)
+		}
+		s.SetC(core.Word(len(words)))
+	case radioSetInterrupt:
+		r.message = s.B()
+	case radioTune:
+		r.channel = s.B()
+		r.receive()
+		r.queue = nil
+	}
+	return nil
+}
+
+// receive queues the frames that have arrived on the radio's channel,
+// returning whether there were any
+func (r *Radio) receive() bool {
+	received := false
+	for len(r.queue) < radioQueue {
+		select {
+		case frame := <-r.incoming:
+			if frame.channel == r.channel {
+				r.queue = append(r.queue, frame)
+				received = true
+			}
+		default:
+			return received
+		}
+	}
+	return received
+}
+
+// Tick raises an interrupt when frames arrive
+func (r *Radio) Tick(s *core.State) error {
+	if r.receive() && r.message != 0 {
+		s.TriggerInterrupt(r.message)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package dcpu
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"testing"
+	"time"
+)
+
+func TestRadio(t *testing.T) {
+	a, err := ListenRadio("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := ListenRadio("127.0.0.1:0", a.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	sender, receiver := new(core.State), new(core.State)
+	hwi := func(r *Radio, s *core.State, op, b, c core.Word) {
+		s.SetA(op)
+		s.SetB(b)
+		s.SetC(c)
+		r.HandleInterrupt(s)
+	}
+	hwi(a, receiver, radioTune, 7, 0)
+	sender.Ram.Store(0x100, 0x1234)
+	sender.Ram.Store(0x101, 0x5678)
+	// the wrong channel, then the right one
+	hwi(b, sender, radioSend, 0x100, 2)
+	hwi(b, sender, radioTune, 7, 0)
+	hwi(b, sender, radioSend, 0x100, 2)
+	if sender.B() != 1 {
+		t.Fatal("Expected the frame to be sent")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		hwi(a, receiver, radioStatus, 0, 0)
+		if receiver.B() > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected a frame to arrive")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if receiver.B() != 1 || receiver.C() != 2 {
+		t.Errorf("Unexpected status; %d frames, length %d", receiver.B(), receiver.C())
+	}
+	hwi(a, receiver, radioReceive, 0x200, 1)
+	if receiver.C() != 1 || receiver.Ram.Load(0x200) != 0x1234 || receiver.Ram.Load(0x201) != 0 {
+		t.Errorf("Unexpected frame; %d words, %#x %#x", receiver.C(), receiver.Ram.Load(0x200), receiver.Ram.Load(0x201))
+	}
+	hwi(a, receiver, radioReceive, 0x200, 2)
+	if receiver.C() != 0 {
+		t.Errorf("Expected no more frames, found %d words", receiver.C())
+	}
+}
+
+func TestRadioFrame(t *testing.T) {
+	frame := radioFrame{3, []core.Word{1, 0xffff}}
+	decoded, err := decodeRadioFrame(encodeRadioFrame(frame))
+	if err != nil || decoded.channel != 3 || len(decoded.words) != 2 || decoded.words[1] != 0xffff {
+		t.Errorf("Unexpected frame %v (%v)", decoded, err)
+	}
+	if _, err := decodeRadioFrame([]byte{1, 2, 3}); err == nil {
+		t.Error("Expected an error decoding garbage")
+	}
+}
@@ -0,0 +1,119 @@
+package dcpu
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"io"
+	"strings"
+)
+
+// InputKind identifies the source of a recorded input
+type InputKind int
+
+const (
+	InputKey InputKind = iota // a key added to the keyboard buffer
+)
+
+var inputKindNames = map[InputKind]string{
+	InputKey: "key",
+}
+
+func (k InputKind) String() string {
+	if name, ok := inputKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("InputKind(%d)", int(k))
+}
+
+// InputEvent is a non-deterministic input, timestamped with the cycle
+// count of the machine's State when it occurred
+type InputEvent struct {
+	Cycle uint64
+	Kind  InputKind
+	Value core.Word
+}
+
+// Recording is the sequence of inputs to a run of a machine. Replaying it
+// against the same program reproduces the run exactly.
+type Recording struct {
+	Events []InputEvent
+	next   int // the next event to replay
+}
+
+const recordingHeader = "# dcpu16 recording v1"
+
+// Write writes the recording to w, one event per line
+func (r *Recording) Write(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, recordingHeader); err != nil {
+		return err
+	}
+	for _, e := range r.Events {
+		if _, err := fmt.Fprintf(w, "%d %s %#04x\n", e.Cycle, e.Kind, e.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadRecording reads a recording in the format written by Recording.Write
+func ReadRecording(rd io.Reader) (*Recording, error) {
+	r := new(Recording)
+	header := false
+	scanner := bufio.NewScanner(rd)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if line == 1 {
+			if text != recordingHeader {
+				break
+			}
+			header = true
+			continue
+		}
+		if text == "" {
+			continue
+		}
+		var e InputEvent
+		var kind string
+		if _, err := fmt.Sscanf(text, "%d %s %v", &e.Cycle, &kind, &e.Value); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		found := false
+		for k, name := range inputKindNames {
+			if name == kind {
+				e.Kind, found = k, true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("line %d: unknown input %s", line, kind)
+		}
+		if n := len(r.Events); n > 0 && e.Cycle < r.Events[n-1].Cycle {
+			return nil, fmt.Errorf("line %d: events are out of order", line)
+		}
+		r.Events = append(r.Events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !header {
+		return nil, fmt.Errorf("not a recording (expected %q)", recordingHeader)
+	}
+	return r, nil
+}
+
+// record appends an event at the current cycle
+func (r *Recording) record(cycle uint64, kind InputKind, value core.Word) {
+	r.Events = append(r.Events, InputEvent{cycle, kind, value})
+}
+
+// replay applies the events due at the given cycle to the machine
+func (r *Recording) replay(cycle uint64, m *Machine) {
+	for r.next < len(r.Events) && r.Events[r.next].Cycle <= cycle {
+		e := r.Events[r.next]
+		r.next++
+		switch e.Kind {
+		case InputKey:
+			m.Keyboard.pushKey(e.Value)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package dcpu
+
+import (
+	"bytes"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"strings"
+	"testing"
+)
+
+func TestRecording(t *testing.T) {
+	rec := &Recording{Events: []InputEvent{
+		{10, InputKey, 'a'},
+		{25, InputKey, core.Word(KeyArrowUp)},
+		{25, InputKey, core.Word(KeyArrowUp) | 0x100},
+	}}
+	var buf bytes.Buffer
+	if err := rec.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadRecording(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Events) != len(rec.Events) {
+		t.Fatalf("Expected %d events, found %d", len(rec.Events), len(read.Events))
+	}
+	for i := range read.Events {
+		if read.Events[i] != rec.Events[i] {
+			t.Errorf("Unexpected event %d; expected %v, found %v", i, rec.Events[i], read.Events[i])
+		}
+	}
+
+	// replaying pushes the keys at their cycle
+	m := new(Machine)
+	read.replay(9, m)
+	if m.Keyboard.words[0] != 0 {
+		t.Errorf("Key replayed too early")
+	}
+	read.replay(30, m)
+	expected := []core.Word{'a', 0x80, 0x180, 0}
+	for i, w := range expected {
+		if m.Keyboard.words[i] != w {
+			t.Errorf("Unexpected keyboard word %d; expected %#x, found %#x", i, w, m.Keyboard.words[i])
+		}
+	}
+
+	errTests := []string{
+		"",
+		"# something else\n",
+		recordingHeader + "\n10 mouse 0x1\n",
+		recordingHeader + "\n10 key 0x1\n5 key 0x2\n",
+		recordingHeader + "\nten key 0x1\n",
+	}
+	for _, str := range errTests {
+		if _, err := ReadRecording(strings.NewReader(str)); err == nil {
+			t.Errorf("%q: expected an error", str)
+		}
+	}
+}
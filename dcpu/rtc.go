@@ -0,0 +1,61 @@
+package dcpu
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"time"
+)
+
+// RealTimeClock exposes the host's date and time. The operation is selected
+// by A when the device is sent HWI:
+//
+//	A=0 B is set to the year, C to the month (high byte) and day (low
+//	    byte), X to the hour (high byte) and minute (low byte), and Y to
+//	    the second
+//	A=1 B is set to the milliseconds and C to the day of the week, with 0
+//	    being Sunday
+//
+// Unlike the GenericClock, this follows the host's clock rather than the
+// machine's cycles. Set Now to freeze time, e.g. for tests.
+type RealTimeClock struct {
+	Now    func() time.Time // returns the current time; nil means time.Now
+	Offset time.Duration    // added to the time returned by Now
+}
+
+const (
+	RealTimeClockID           = 0x02070001
+	RealTimeClockVersion      = 1
+	RealTimeClockManufacturer = 0x6b62616c
+)
+
+const (
+	rtcGetDateTime = iota
+	rtcGetExtra
+)
+
+func (c *RealTimeClock) ID() uint32           { return RealTimeClockID }
+func (c *RealTimeClock) Version() core.Word   { return RealTimeClockVersion }
+func (c *RealTimeClock) Manufacturer() uint32 { return RealTimeClockManufacturer }
+
+// Time returns the time the clock reports to the machine
+func (c *RealTimeClock) Time() time.Time {
+	now := time.Now
+	if c.Now != nil {
+		now = c.Now
+	}
+	return now().Add(c.Offset)
+}
+
+func (c *RealTimeClock) HandleInterrupt(s *core.State) error {
+	t := c.Time()
+	switch s.A() {
+	case rtcGetDateTime:
+		s.SetB(core.Word(t.Year()))
+		s.SetC(core.Word(t.Month())<<8 | core.Word(t.Day()))
+		s.SetX(core.Word(t.Hour())<<8 | core.Word(t.Minute()))
+		s.SetY(core.Word(t.Second()))
+	case rtcGetExtra:
+		s.SetB(core.Word(t.Nanosecond() / 1e6))
+		s.SetC(core.Word(t.Weekday()))
+	}
+	return nil
+}
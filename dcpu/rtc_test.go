@@ -0,0 +1,28 @@
+package dcpu
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"testing"
+	"time"
+)
+
+func TestRealTimeClock(t *testing.T) {
+	frozen := time.Date(2012, time.April, 4, 23, 59, 30, 250e6, time.UTC)
+	rtc := &RealTimeClock{
+		Now:    func() time.Time { return frozen },
+		Offset: 45 * time.Second,
+	}
+	state := new(core.State)
+
+	state.SetA(rtcGetDateTime)
+	rtc.HandleInterrupt(state)
+	if state.B() != 2012 || state.C() != 0x0405 || state.X() != 0x0000 || state.Y() != 15 {
+		t.Errorf("Unexpected date B=%d C=%#04x X=%#04x Y=%d", state.B(), state.C(), state.X(), state.Y())
+	}
+
+	state.SetA(rtcGetExtra)
+	rtc.HandleInterrupt(state)
+	if state.B() != 250 || state.C() != core.Word(time.Thursday) {
+		t.Errorf("Unexpected milliseconds %d or weekday %d", state.B(), state.C())
+	}
+}
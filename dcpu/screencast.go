@@ -0,0 +1,95 @@
+package dcpu
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"strings"
+	"time"
+)
+
+// ScreencastFrame is the screen at a point in a Screencast
+type ScreencastFrame struct {
+	Time   time.Duration // since the recording started
+	Screen *Screenshot
+}
+
+// Screencast is the sequence of screens shown while a machine runs.
+// The screen is captured each time it's refreshed, and a frame is added
+// whenever it has changed.
+type Screencast struct {
+	Frames []ScreencastFrame
+	start  time.Time
+}
+
+// capture adds a frame if the screen has changed since the last one
+func (r *Screencast) capture(v *Video, now time.Time) {
+	shot := new(Screenshot)
+	v.screenshot(shot)
+	if len(r.Frames) == 0 {
+		r.start = now
+	} else if *r.Frames[len(r.Frames)-1].Screen == *shot {
+		return
+	}
+	r.Frames = append(r.Frames, ScreencastFrame{now.Sub(r.start), shot})
+}
+
+// WriteCast writes the recording to w as an asciinema cast (version 2) of a
+// 32x12 terminal, with each frame redrawn as text with ANSI colors
+func (r *Screencast) WriteCast(w io.Writer) error {
+	header := fmt.Sprintf(`{"version": 2, "width": %d, "height": %d}`, windowWidth, windowHeight)
+	if _, err := fmt.Fprintln(w, header); err != nil {
+		return err
+	}
+	for i, frame := range r.Frames {
+		var text strings.Builder
+		if i == 0 {
+			text.WriteString("\033[2J")
+		}
+		text.WriteString("\033[H")
+		var lines strings.Builder
+		frame.Screen.WriteText(&lines, true)
+		// terminals need a carriage return to start each line
+		text.WriteString(strings.TrimSuffix(strings.Replace(lines.String(), "\n", "\r\n", -1), "\r\n"))
+		data, err := json.Marshal(text.String())
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "[%.6f, \"o\", %s]\n", frame.Time.Seconds(), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gifLastFrame is how long the last frame of a GIF is shown, as nothing
+// follows it to say
+const gifLastFrame = time.Second
+
+// WriteGIF writes the recording to w as an animated GIF of the screen
+// images, scaled as with Screenshot.Image
+func (r *Screencast) WriteGIF(w io.Writer, scale int) error {
+	anim := new(gif.GIF)
+	for i, frame := range r.Frames {
+		img := frame.Screen.Image(scale)
+		palette := make(color.Palette, len(frame.Screen.Palette))
+		for j := range palette {
+			palette[j] = frame.Screen.Color(core.Word(j))
+		}
+		paletted := image.NewPaletted(img.Bounds(), palette)
+		draw.Draw(paletted, paletted.Rect, img, image.Point{}, draw.Src)
+		duration := gifLastFrame
+		if i+1 < len(r.Frames) {
+			duration = r.Frames[i+1].Time - frame.Time
+		}
+		anim.Image = append(anim.Image, paletted)
+		// GIF delays are in hundredths of a second
+		anim.Delay = append(anim.Delay, int(duration/(10*time.Millisecond)))
+	}
+	return gif.EncodeAll(w, anim)
+}
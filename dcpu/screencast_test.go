@@ -0,0 +1,59 @@
+package dcpu
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/gif"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScreencast(t *testing.T) {
+	m := new(Machine)
+	if err := m.Video.MapToMachine(0x8000, m); err != nil {
+		t.Fatal(err)
+	}
+	m.State.SetA(lemMapScreen)
+	m.State.SetB(0x8000)
+	m.Video.HandleInterrupt(&m.State)
+
+	cast := new(Screencast)
+	start := time.Now()
+	cast.capture(&m.Video, start)
+	// unchanged screens don't add frames
+	cast.capture(&m.Video, start.Add(100*time.Millisecond))
+	m.State.Ram.Store(0x8000, 0xf041)
+	cast.capture(&m.Video, start.Add(500*time.Millisecond))
+	if len(cast.Frames) != 2 || cast.Frames[1].Time != 500*time.Millisecond {
+		t.Fatalf("Unexpected frames %v", cast.Frames)
+	}
+
+	var buf bytes.Buffer
+	if err := cast.WriteCast(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || lines[0] != `{"version": 2, "width": 32, "height": 12}` {
+		t.Fatalf("Unexpected cast %q", buf.String())
+	}
+	var event []interface{}
+	if err := json.Unmarshal([]byte(lines[2]), &event); err != nil {
+		t.Fatal(err)
+	}
+	if len(event) != 3 || event[0] != 0.5 || event[1] != "o" || !strings.Contains(event[2].(string), "A") {
+		t.Errorf("Unexpected event %v", event)
+	}
+
+	buf.Reset()
+	if err := cast.WriteGIF(&buf, 1); err != nil {
+		t.Fatal(err)
+	}
+	anim, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anim.Image) != 2 || anim.Delay[0] != 50 || anim.Delay[1] != 100 {
+		t.Errorf("Unexpected GIF of %d frames with delays %v", len(anim.Image), anim.Delay)
+	}
+}
@@ -0,0 +1,251 @@
+package dcpu
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// Screenshot is a copy of the screen, as taken by Machine.Screenshot
+type Screenshot struct {
+	Cells   [screenWords]core.Word // row by row, in the format of the LEM1802
+	Font    [256]core.Word         // two words of 4 columns of 8 pixels per character
+	Palette [16]core.Word          // 0000 rrrr gggg bbbb colors
+	Border  core.Word              // the palette entry of the border
+}
+
+// Screenshots are written by Screenshot.Write as a magic number and
+// version, followed by the fields of the Screenshot in order:
+//
+//	magic   [4]byte "DCPS"
+//	version uint16
+//	cells   [384]uint16
+//	font    [256]uint16
+//	palette [16]uint16
+//	border  uint16
+//
+// All values are little endian.
+const (
+	screenshotMagic   = "DCPS"
+	screenshotVersion = 1
+)
+
+// screenshotBorder is the width in pixels of the border around the screen
+// in images
+const screenshotBorder = 16
+
+// Screenshot copies the screen. Under the 1.1 spec, the default font and
+// palette are used, as the terminal can't show the font anyway. It's safe
+// to call while the machine is running.
+func (m *Machine) Screenshot() (*Screenshot, error) {
+	shot := new(Screenshot)
+	if m.stopped == nil {
+		m.Video.screenshot(shot)
+		return shot, nil
+	}
+	if err := m.sendRequest(pauseRequest{run: func() { m.Video.screenshot(shot) }}); err != nil {
+		return nil, err
+	}
+	return shot, nil
+}
+
+func (v *Video) screenshot(shot *Screenshot) {
+	shot.Font, shot.Palette = defaultFont, DefaultPalette
+	if v.ram == nil {
+		copy(shot.Cells[:], v.words[:screenWords])
+		shot.Border = v.words[backgroundColorAddress] & 0xf
+		return
+	}
+	// as refresh draws it
+	switch {
+	case v.screen == 0 && !v.connected:
+		shot.Cells = bootSplash
+	case v.screen != 0:
+		shot.Border = v.border
+		for i := range shot.Cells {
+			shot.Cells[i] = v.ram.Load(v.screen + core.Word(i))
+		}
+	}
+	shot.Font = v.currentFont()
+	shot.Palette = v.currentPalette()
+}
+
+// LoadScreen shows a screenshot on the screen, e.g. one built offline or
+// saved by Screenshot.Write. Under the 1.7 spec, the cells are stored to
+// the memory of the connected screen, and the font and palette to theirs
+// unless they're the defaults. Under the 1.1 spec, only the default font
+// and palette can be shown. It's safe to call while the machine is running.
+func (m *Machine) LoadScreen(shot *Screenshot) error {
+	if m.stopped == nil {
+		return m.Video.loadScreen(shot)
+	}
+	var err error
+	if reqErr := m.sendRequest(pauseRequest{run: func() { err = m.Video.loadScreen(shot) }}); reqErr != nil {
+		return reqErr
+	}
+	return err
+}
+
+func (v *Video) loadScreen(shot *Screenshot) error {
+	if v.ram == nil {
+		if shot.Font != defaultFont || shot.Palette != DefaultPalette {
+			return errors.New("the 1.1 screen can't show a custom font or palette")
+		}
+		copy(v.words[:screenWords], shot.Cells[:])
+		v.words[backgroundColorAddress] = shot.Border & 0xf
+		v.initialized = true
+		if v.mapped {
+			v.clearDisplay()
+			v.drawBorder()
+			v.drawCells()
+		}
+		return nil
+	}
+	if v.screen == 0 {
+		return errors.New("the LEM1802 screen isn't connected")
+	}
+	regions := []struct {
+		name    string
+		address core.Word
+		words   []core.Word
+		custom  bool
+	}{
+		{"screen", v.screen, shot.Cells[:], true},
+		{"font", v.font, shot.Font[:], shot.Font != defaultFont},
+		{"palette", v.palette, shot.Palette[:], shot.Palette != DefaultPalette},
+	}
+	for _, r := range regions {
+		if !r.custom {
+			continue
+		}
+		if r.address == 0 {
+			return fmt.Errorf("the screenshot has a custom %s, but the LEM1802's %s isn't mapped", r.name, r.name)
+		}
+		for i, w := range r.words {
+			if err := v.ram.Store(r.address+core.Word(i), w); err != nil {
+				return err
+			}
+		}
+	}
+	v.border = shot.Border & 0xf
+	return nil
+}
+
+// Write writes the screenshot to w, to be read back by ReadScreenshot
+func (s *Screenshot) Write(w io.Writer) error {
+	header := saveStateHeader{Version: screenshotVersion}
+	copy(header.Magic[:], screenshotMagic)
+	if err := binary.Write(w, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, s)
+}
+
+// ReadScreenshot reads a screenshot written by Screenshot.Write
+func ReadScreenshot(r io.Reader) (*Screenshot, error) {
+	var header saveStateHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil || string(header.Magic[:]) != screenshotMagic {
+		return nil, errors.New("not a screenshot")
+	}
+	if header.Version > screenshotVersion {
+		return nil, fmt.Errorf("screenshot version %d is newer than the supported version %d", header.Version, screenshotVersion)
+	}
+	shot := new(Screenshot)
+	if err := binary.Read(r, binary.LittleEndian, shot); err != nil {
+		return nil, errors.New("the screenshot is truncated")
+	}
+	return shot, nil
+}
+
+// Color returns the RGB color of a palette entry
+func (s *Screenshot) Color(index core.Word) color.RGBA {
+	rgb := s.Palette[index&0xf]
+	// scale each 4-bit channel up to 8 bits
+	return color.RGBA{byte(rgb>>8&0xf) * 0x11, byte(rgb>>4&0xf) * 0x11, byte(rgb&0xf) * 0x11, 0xff}
+}
+
+// WriteText writes the screen to w as 12 lines of 32 characters, e.g. for
+// bug reports. Characters outside of printable ASCII are written as spaces.
+// If colors is true, each cell is given its colors with 24-bit ANSI
+// escapes; blinking isn't shown.
+func (s *Screenshot) WriteText(w io.Writer, colors bool) error {
+	bw := bufio.NewWriter(w)
+	for row := 0; row < windowHeight; row++ {
+		var last core.Word
+		for col := 0; col < windowWidth; col++ {
+			cell := s.Cells[row*windowWidth+col]
+			if attrs := cell & 0xff00; colors && (col == 0 || attrs != last&0xff00) {
+				fg, bg := s.Color(cell>>12), s.Color(cell>>8)
+				fmt.Fprintf(bw, "\033[38;2;%d;%d;%dm\033[48;2;%d;%d;%dm", fg.R, fg.G, fg.B, bg.R, bg.G, bg.B)
+			}
+			last = cell
+			if ch := byte(cell & 0x7f); ch >= 0x20 && ch < 0x7f {
+				bw.WriteByte(ch)
+			} else {
+				bw.WriteByte(' ')
+			}
+		}
+		if colors {
+			bw.WriteString("\033[m")
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// Image renders the screen with its font and palette, surrounded by the
+// border, with each pixel scaled up to scale by scale pixels. Blinking
+// characters are drawn as if they're shown.
+func (s *Screenshot) Image(scale int) image.Image {
+	if scale < 1 {
+		scale = 1
+	}
+	width, height := windowWidth*4+2*screenshotBorder, windowHeight*8+2*screenshotBorder
+	img := image.NewRGBA(image.Rect(0, 0, width*scale, height*scale))
+	set := func(x, y int, c color.RGBA) {
+		for dy := 0; dy < scale; dy++ {
+			for dx := 0; dx < scale; dx++ {
+				img.SetRGBA(x*scale+dx, y*scale+dy, c)
+			}
+		}
+	}
+	border := s.Color(s.Border)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			set(x, y, border)
+		}
+	}
+	for i, cell := range s.Cells {
+		x0, y0 := screenshotBorder+i%windowWidth*4, screenshotBorder+i/windowWidth*8
+		fg, bg := s.Color(cell>>12), s.Color(cell>>8)
+		ch := cell & 0x7f
+		glyph := [2]core.Word{s.Font[ch*2], s.Font[ch*2+1]}
+		for col := 0; col < 4; col++ {
+			// the high byte of each word is the left column, with the top
+			// pixel in the lowest bit
+			bits := byte(glyph[col/2] >> 8)
+			if col%2 == 1 {
+				bits = byte(glyph[col/2])
+			}
+			for row := 0; row < 8; row++ {
+				if bits>>uint(row)&1 != 0 {
+					set(x0+col, y0+row, fg)
+				} else {
+					set(x0+col, y0+row, bg)
+				}
+			}
+		}
+	}
+	return img
+}
+
+// WritePNG writes the Image of the screen to w as a PNG
+func (s *Screenshot) WritePNG(w io.Writer, scale int) error {
+	return png.Encode(w, s.Image(scale))
+}
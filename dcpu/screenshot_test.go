@@ -0,0 +1,135 @@
+package dcpu
+
+import (
+	"bytes"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestScreenshot(t *testing.T) {
+	m := new(Machine)
+	if err := m.Video.MapToMachine(0x8000, m); err != nil {
+		t.Fatal(err)
+	}
+	// 'A' in white on blue, then 'b' in the default black on black
+	m.State.Ram.Store(0x8000, 0xf141)
+	m.State.Ram.Store(0x8001, 0x0062)
+	m.State.SetA(lemMapScreen)
+	m.State.SetB(0x8000)
+	m.Video.HandleInterrupt(&m.State)
+	m.State.SetA(lemSetBorderColor)
+	m.State.SetB(4)
+	m.Video.HandleInterrupt(&m.State)
+
+	shot, err := m.Screenshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := shot.WriteText(&buf, false); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(buf.String(), "\n")
+	if len(lines) != windowHeight+1 || lines[0] != "Ab"+strings.Repeat(" ", windowWidth-2) {
+		t.Errorf("Unexpected text screenshot %q", buf.String())
+	}
+	buf.Reset()
+	if err := shot.WriteText(&buf, true); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "\033[38;2;255;255;255m\033[48;2;0;0;170mA\033[38;2;0;0;0m\033[48;2;0;0;0mb"; !strings.HasPrefix(buf.String(), expected) {
+		t.Errorf("Unexpected colored screenshot %q", buf.String())
+	}
+
+	img := shot.Image(2)
+	if size := img.Bounds().Size(); size.X != 320 || size.Y != 256 {
+		t.Fatalf("Unexpected image size %v", size)
+	}
+	red, white, blue := color.RGBA{0xaa, 0, 0, 0xff}, color.RGBA{0xff, 0xff, 0xff, 0xff}, color.RGBA{0, 0, 0xaa, 0xff}
+	// the left column of 'A' is 0x7e, so its top pixel is background and
+	// the next is foreground
+	for _, px := range []struct {
+		x, y     int
+		expected color.RGBA
+	}{{0, 0, red}, {32, 32, blue}, {33, 34, white}, {32, 46, blue}} {
+		if c := img.At(px.x, px.y); c != px.expected {
+			t.Errorf("Unexpected color at (%d, %d); expected %v, found %v", px.x, px.y, px.expected, c)
+		}
+	}
+
+	buf.Reset()
+	if err := shot.WritePNG(&buf, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := png.Decode(&buf); err != nil {
+		t.Errorf("Expected a valid PNG, found %v", err)
+	}
+
+	// a disconnected screen is blank
+	m.State.SetA(lemMapScreen)
+	m.State.SetB(0)
+	m.Video.HandleInterrupt(&m.State)
+	if shot, _ = m.Screenshot(); shot.Cells != [screenWords]core.Word{} {
+		t.Error("Expected a disconnected screen to be blank")
+	}
+}
+
+func TestLoadScreen(t *testing.T) {
+	m := new(Machine)
+	if err := m.Video.MapToMachine(0x8000, m); err != nil {
+		t.Fatal(err)
+	}
+	hwi := func(a, b core.Word) {
+		m.State.SetA(a)
+		m.State.SetB(b)
+		m.Video.HandleInterrupt(&m.State)
+	}
+	shot := &Screenshot{Font: defaultFont, Palette: DefaultPalette, Border: 5}
+	shot.Cells[0] = 0xf141
+	shot.Palette[1] = 0x123
+	if err := m.LoadScreen(shot); err == nil {
+		t.Error("Expected an error loading a screen that isn't connected")
+	}
+	hwi(lemMapScreen, 0x8000)
+	if err := m.LoadScreen(shot); err == nil {
+		t.Error("Expected an error loading a custom palette that isn't mapped")
+	}
+	hwi(lemMapPalette, 0x9000)
+	if err := m.LoadScreen(shot); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := shot.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadScreenshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if taken, _ := m.Screenshot(); *taken != *shot || *read != *shot {
+		t.Error("Expected the loaded screen and the screenshot read back to match the original")
+	}
+	if _, err := ReadScreenshot(strings.NewReader("DCPM")); err == nil {
+		t.Error("Expected an error reading something other than a screenshot")
+	}
+
+	legacy := new(Machine)
+	legacy.State.Spec = core.Spec11
+	if err := legacy.Video.MapToMachine(0x8000, legacy); err != nil {
+		t.Fatal(err)
+	}
+	if err := legacy.LoadScreen(shot); err == nil {
+		t.Error("Expected an error loading a custom palette under the 1.1 spec")
+	}
+	shot.Palette = DefaultPalette
+	if err := legacy.LoadScreen(shot); err != nil {
+		t.Fatal(err)
+	}
+	if legacy.State.Ram.Load(0x8000) != 0xf141 || legacy.State.Ram.Load(0x8280) != 5 {
+		t.Error("Expected the screen to be loaded into video memory")
+	}
+}
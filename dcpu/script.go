@@ -0,0 +1,119 @@
+package dcpu
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scriptKeys are the names of keys in input scripts. The arrow keys are
+// pressed and released.
+var scriptKeys = map[string][]core.Word{
+	"enter":     {'\n'},
+	"backspace": {'\b'},
+	"tab":       {'\t'},
+	"esc":       {0x1b},
+	"space":     {' '},
+	"up":        {core.Word(KeyArrowUp), core.Word(KeyArrowUp) | 0x100},
+	"down":      {core.Word(KeyArrowDown), core.Word(KeyArrowDown) | 0x100},
+	"left":      {core.Word(KeyArrowLeft), core.Word(KeyArrowLeft) | 0x100},
+	"right":     {core.Word(KeyArrowRight), core.Word(KeyArrowRight) | 0x100},
+}
+
+// ReadScript reads an input script of keys to type at set times, such as
+// for demos or tests, as a Recording for the keyboard's Sources. Each line holds
+// when to type, from the start, as a cycle count such as 5000 or a time
+// such as 250ms or 2s, and then what to type: text quoted as in Go, such as
+// "hello\n", key names (enter, backspace, tab, esc, space, up, down, left or
+// right) or key codes such as 0x1b. Times are converted to cycles at
+// cyclesPerSecond, so scripts play the same however fast the machine runs.
+// Lines must be in order. Blank lines and lines starting with # are
+// ignored.
+func ReadScript(rd io.Reader, cyclesPerSecond uint64) (*Recording, error) {
+	r := new(Recording)
+	scanner := bufio.NewScanner(rd)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		when := text
+		if i := strings.IndexAny(text, " \t"); i >= 0 {
+			when, text = text[:i], strings.TrimSpace(text[i:])
+		} else {
+			text = ""
+		}
+		cycle, err := strconv.ParseUint(when, 10, 64)
+		if err != nil {
+			d, err := time.ParseDuration(when)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("line %d: invalid time %q", line, when)
+			}
+			cycle = uint64(d.Seconds() * float64(cyclesPerSecond))
+		}
+		if n := len(r.Events); n > 0 && cycle < r.Events[n-1].Cycle {
+			return nil, fmt.Errorf("line %d: events are out of order", line)
+		}
+		keys, err := parseScriptKeys(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		for _, key := range keys {
+			r.record(cycle, InputKey, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// parseScriptKeys parses the keys to type on a line of an input script
+func parseScriptKeys(text string) ([]core.Word, error) {
+	var keys []core.Word
+	if text == "" {
+		return nil, fmt.Errorf("expected keys to type")
+	}
+	for text != "" {
+		if text[0] == '"' || text[0] == '`' {
+			quoted, err := strconv.QuotedPrefix(text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid text %s", text)
+			}
+			unquoted, _ := strconv.Unquote(quoted)
+			for _, ch := range unquoted {
+				keys = append(keys, core.Word(ch))
+			}
+			text = strings.TrimSpace(text[len(quoted):])
+			continue
+		}
+		word := text
+		if i := strings.IndexAny(text, " \t"); i >= 0 {
+			word = text[:i]
+		}
+		text = strings.TrimSpace(text[len(word):])
+		if named, ok := scriptKeys[word]; ok {
+			keys = append(keys, named...)
+		} else if n, err := strconv.ParseUint(word, 0, 16); err == nil && n != 0 {
+			keys = append(keys, core.Word(n))
+		} else {
+			return nil, fmt.Errorf("unknown key %q", word)
+		}
+	}
+	return keys, nil
+}
+
+// NextKey makes a Recording of typed keys, such as from ReadScript, a
+// KeyboardSource that types each at its cycle, or once there's space
+func (r *Recording) NextKey(cycle uint64) (core.Word, bool) {
+	if r.next >= len(r.Events) || r.Events[r.next].Cycle > cycle {
+		return 0, false
+	}
+	key := r.Events[r.next].Value
+	r.next++
+	return key, true
+}
@@ -0,0 +1,74 @@
+package dcpu
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"strings"
+	"testing"
+)
+
+func TestReadScript(t *testing.T) {
+	script := `# a demo
+0 "hi"
+10ms enter up
+20ms	0x1b ` + "`\\`" + `
+`
+	r, err := ReadScript(strings.NewReader(script), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []InputEvent{
+		{0, InputKey, 'h'},
+		{0, InputKey, 'i'},
+		{10, InputKey, '\n'},
+		{10, InputKey, 0x80},
+		{10, InputKey, 0x180},
+		{20, InputKey, 0x1b},
+		{20, InputKey, '\\'},
+	}
+	if len(r.Events) != len(expected) {
+		t.Fatalf("Expected %d events, found %v", len(expected), r.Events)
+	}
+	for i, e := range expected {
+		if r.Events[i] != e {
+			t.Errorf("Unexpected event %d; expected %v, found %v", i, e, r.Events[i])
+		}
+	}
+
+	for _, bad := range []string{"soon \"a\"", "10", "10 bogus", "10 \"unterminated", "10 \"a\"\n5 \"b\""} {
+		if _, err := ReadScript(strings.NewReader(bad), 1000); err == nil {
+			t.Errorf("Expected an error reading %q", bad)
+		}
+	}
+}
+
+func TestInput(t *testing.T) {
+	script := &Recording{Events: []InputEvent{
+		{5, InputKey, 'a'},
+		{5, InputKey, 'b'},
+	}}
+	m := &Machine{Recording: new(Recording)}
+	m.Keyboard.Sources = []KeyboardSource{script}
+	// SUB PC, 1
+	if err := m.State.LoadProgram([]core.Word{0x8b83}, 0); err != nil {
+		t.Fatal(err)
+	}
+	// the second key waits for the program to read the first
+	m.Keyboard.words[1] = 'x'
+	if _, err := m.RunFor(10); err != nil {
+		t.Fatal(err)
+	}
+	if m.Keyboard.words[0] != 'a' || m.Keyboard.words[1] != 'x' {
+		t.Errorf("Unexpected buffer %v", m.Keyboard.words[:2])
+	}
+	m.Keyboard.words[1] = 0
+	if _, err := m.RunFor(10); err != nil {
+		t.Fatal(err)
+	}
+	if m.Keyboard.words[1] != 'b' {
+		t.Errorf("Expected the second key once there was space, found %#x", m.Keyboard.words[1])
+	}
+	// typed keys are recorded, to be replayed
+	if len(m.Recording.Events) != 2 || m.Recording.Events[0].Cycle != 5 || m.Recording.Events[1].Value != 'b' {
+		t.Errorf("Unexpected recording %v", m.Recording.Events)
+	}
+}
@@ -0,0 +1,217 @@
+package dcpu
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"io"
+	"net"
+	"sync"
+)
+
+// Serial is a serial port bridged to a host connection, such as stdio or a
+// TCP socket. The operation is selected by A when the device is sent HWI:
+//
+//	A=0 B is set to the number of bytes waiting to be read, and C to 1 if
+//	    the port is connected
+//	A=1 B is set to the next byte read, and C to 1; if there's nothing to
+//	    read, B is set to 0xffff and C to 0
+//	A=2 the low byte of B is written
+//	A=3 if B is non-zero, an interrupt with message B is raised whenever
+//	    bytes arrive; if B is 0 interrupts are turned off
+//
+// Bytes written while the port isn't connected are dropped.
+type Serial struct {
+	incoming  chan byte
+	outgoing  chan byte
+	buffer    []byte // bytes received but not yet read
+	message   core.Word
+	mu        sync.Mutex
+	writer    io.Writer // the current connection, or nil
+	listener  net.Listener
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+const (
+	SerialID           = 0xe57d9027
+	SerialVersion      = 1
+	SerialManufacturer = 0x6b62616c
+)
+
+const (
+	serialStatus = iota
+	serialRead
+	serialWrite
+	serialSetInterrupt
+)
+
+// serialBuffer is the number of bytes buffered in each direction
+const serialBuffer = 4096
+
+func newSerial() *Serial {
+	return &Serial{
+		incoming: make(chan byte, serialBuffer),
+		outgoing: make(chan byte, serialBuffer),
+		closed:   make(chan struct{}),
+	}
+}
+
+// NewSerial returns a Serial port that reads from r and writes to w, such as
+// os.Stdin and os.Stdout
+func NewSerial(r io.Reader, w io.Writer) *Serial {
+	s := newSerial()
+	s.writer = w
+	go s.readFrom(r)
+	go s.writeLoop()
+	return s
+}
+
+// ListenSerial returns a Serial port that accepts TCP connections on addr,
+// one at a time. A new connection replaces the last.
+func ListenSerial(addr string) (*Serial, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := newSerial()
+	s.listener = l
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			if old, ok := s.writer.(net.Conn); ok {
+				old.Close()
+			}
+			s.writer = conn
+			s.mu.Unlock()
+			go s.readFrom(conn)
+		}
+	}()
+	go s.writeLoop()
+	return s, nil
+}
+
+// Addr returns the address a listening Serial port accepts connections on,
+// or nil
+func (s *Serial) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Close stops listening and closes the connection
+func (s *Serial) Close() error {
+	s.closeOnce.Do(func() { close(s.closed) })
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.writer.(io.Closer); ok && s.listener != nil {
+		c.Close()
+	}
+	s.writer = nil
+	return err
+}
+
+func (s *Serial) readFrom(r io.Reader) {
+	var buf [256]byte
+	for {
+		n, err := r.Read(buf[:])
+		for _, b := range buf[:n] {
+			select {
+			case s.incoming <- b:
+			case <-s.closed:
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *Serial) writeLoop() {
+	for {
+		select {
+		case b := <-s.outgoing:
+			s.mu.Lock()
+			w := s.writer
+			s.mu.Unlock()
+			if w != nil {
+				w.Write([]byte{b})
+			}
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+func (s *Serial) connected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writer != nil
+}
+
+func (s *Serial) ID() uint32           { return SerialID }
+func (s *Serial) Version() core.Word   { return SerialVersion }
+func (s *Serial) Manufacturer() uint32 { return SerialManufacturer }
+
+func (s *Serial) HandleInterrupt(state *core.State) error {
+	switch state.A() {
+	case serialStatus:
+		s.receive()
+		state.SetB(core.Word(len(s.buffer)))
+		state.SetC(0)
+		if s.connected() {
+			state.SetC(1)
+		}
+	case serialRead:
+		s.receive()
+		if len(s.buffer) == 0 {
+			state.SetB(0xffff)
+			state.SetC(0)
+			break
+		}
+		state.SetB(core.Word(s.buffer[0]))
+		state.SetC(1)
+		s.buffer = s.buffer[1:]
+	case serialWrite:
+		select {
+		case s.outgoing <- byte(state.B()):
+		default:
+			// the host isn't keeping up, so the byte is dropped
+		}
+	case serialSetInterrupt:
+		s.message = state.B()
+	}
+	return nil
+}
+
+// receive moves the bytes that have arrived into the buffer, returning
+// whether there were any
+func (s *Serial) receive() bool {
+	received := false
+	for len(s.buffer) < serialBuffer {
+		select {
+		case b := <-s.incoming:
+			s.buffer = append(s.buffer, b)
+			received = true
+		default:
+			return received
+		}
+	}
+	return received
+}
+
+// Tick raises an interrupt when bytes arrive
+func (s *Serial) Tick(state *core.State) error {
+	if s.receive() && s.message != 0 {
+		state.TriggerInterrupt(s.message)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package dcpu
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForBytes sends status to the serial port until n bytes are waiting
+func waitForBytes(t *testing.T, state *core.State, serial *Serial, n core.Word) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		state.SetA(serialStatus)
+		serial.HandleInterrupt(state)
+		if state.B() >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d bytes, found %d", n, state.B())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSerial(t *testing.T) {
+	state := new(core.State)
+	r, w := io.Pipe()
+	serial := NewSerial(strings.NewReader("hi"), w)
+	defer serial.Close()
+
+	waitForBytes(t, state, serial, 2)
+	if state.C() != 1 {
+		t.Error("Expected the port to be connected")
+	}
+	for _, expected := range []core.Word{'h', 'i', 0xffff} {
+		state.SetA(serialRead)
+		serial.HandleInterrupt(state)
+		if state.B() != expected {
+			t.Errorf("Expected to read %#x, found %#x", expected, state.B())
+		}
+	}
+
+	state.SetA(serialWrite)
+	state.SetB('!')
+	serial.HandleInterrupt(state)
+	out := make([]byte, 1)
+	if _, err := io.ReadFull(r, out); err != nil || out[0] != '!' {
+		t.Errorf("Expected %q to be written, found %q (%v)", "!", out, err)
+	}
+}
+
+func TestSerialTCP(t *testing.T) {
+	serial, err := ListenSerial("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer serial.Close()
+	conn, err := net.Dial("tcp", serial.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("x"))
+
+	state := new(core.State)
+	// SUB PC, 1 in both the program and the interrupt handler
+	if err := state.LoadProgram([]core.Word{0x8b83}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.LoadProgram([]core.Word{0x8b83}, 0x100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := state.AttachDevice(serial); err != nil {
+		t.Fatal(err)
+	}
+	state.SetIA(0x100)
+	state.SetA(serialSetInterrupt)
+	state.SetB(0x33)
+	serial.HandleInterrupt(state)
+	deadline := time.Now().Add(5 * time.Second)
+	for state.PC() != 0x100 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected an interrupt when data arrived")
+		}
+		if err := state.StepCycle(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if state.A() != 0x33 {
+		t.Errorf("Expected the interrupt message, found %#x", state.A())
+	}
+	waitForBytes(t, state, serial, 1)
+}
@@ -0,0 +1,215 @@
+package dcpu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"io"
+)
+
+// Save states are stored as a magic number and version, followed by a
+// series of sections:
+//
+//	magic   [4]byte "DCPM"
+//	version uint16
+//	sections, until the end of the data:
+//		tag    [4]byte
+//		length uint32
+//		data   [length]byte
+//
+// All values are little endian. Sections with unknown tags are skipped, so
+// new sections can be added without bumping the version. The version is
+// only bumped when an existing section changes incompatibly, and Restore
+// keeps reading older versions.
+const (
+	saveStateMagic   = "DCPM"
+	saveStateVersion = 2
+)
+
+// Section tags
+const (
+	sectionCore     = "CORE" // the core.State snapshot
+	sectionVideo    = "VIDE" // [0x400]core.Word of video memory
+	sectionKeyboard = "KEYB" // uint16 buffer offset, then [0x10]core.Word buffer
+	sectionLEM      = "LEM " // lemSection
+	sectionVSYNC    = "VSYN" // vsyncSection
+)
+
+type saveStateHeader struct {
+	Magic   [4]byte
+	Version uint16
+}
+
+type sectionHeader struct {
+	Tag    [4]byte
+	Length uint32
+}
+
+// keyboardSection is the contents of the keyboard section
+type keyboardSection struct {
+	Offset uint16
+	Words  [0x10]core.Word
+}
+
+// lemSection is the contents of the LEM1802 section
+type lemSection struct {
+	Screen, Font, Palette, Border core.Word
+}
+
+// vsyncSection is the contents of the LEM1802's VSYNC interrupt section,
+// which is separate so older save states still have a valid LEM section
+type vsyncSection struct {
+	Message       core.Word
+	Start, Frames uint64
+}
+
+// machineSnapshotV1 is the fixed-size portion of a version 1 save state,
+// which was followed by the core.State snapshot
+type machineSnapshotV1 struct {
+	Video          [0x400]core.Word
+	Keyboard       [0x10]core.Word
+	KeyboardOffset uint16
+}
+
+// Snapshot captures the state of the machine, including the registers, RAM,
+// video and keyboard memory and cycle count, into an opaque blob that can be
+// passed to Restore. The blob is suitable for saving to disk. The machine
+// must be stopped.
+func (m *Machine) Snapshot() ([]byte, error) {
+	if m.stopped != nil {
+		return nil, errors.New("Machine is running")
+	}
+	state, err := m.State.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	header := saveStateHeader{Version: saveStateVersion}
+	copy(header.Magic[:], saveStateMagic)
+	binary.Write(&buf, binary.LittleEndian, &header)
+	writeSection(&buf, sectionCore, state)
+	writeSection(&buf, sectionVideo, &m.Video.words)
+	writeSection(&buf, sectionKeyboard, &keyboardSection{uint16(m.Keyboard.offset), m.Keyboard.words})
+	writeSection(&buf, sectionLEM, &lemSection{m.Video.screen, m.Video.font, m.Video.palette, m.Video.border})
+	writeSection(&buf, sectionVSYNC, &vsyncSection{m.Video.vsync, m.Video.vsyncStart, m.Video.vsyncFrames})
+	return buf.Bytes(), nil
+}
+
+// writeSection writes a section containing data, which is either a []byte
+// or a fixed-size value for encoding/binary
+func writeSection(buf *bytes.Buffer, tag string, data interface{}) {
+	contents, ok := data.([]byte)
+	if !ok {
+		var b bytes.Buffer
+		binary.Write(&b, binary.LittleEndian, data)
+		contents = b.Bytes()
+	}
+	header := sectionHeader{Length: uint32(len(contents))}
+	copy(header.Tag[:], tag)
+	binary.Write(buf, binary.LittleEndian, &header)
+	buf.Write(contents)
+}
+
+// Restore restores a snapshot taken by Snapshot, including those written by
+// older versions. The machine must be stopped, and resumes from the
+// snapshot the next time it's started.
+func (m *Machine) Restore(data []byte) error {
+	if m.stopped != nil {
+		return errors.New("Machine is running")
+	}
+	r := bytes.NewReader(data)
+	var header saveStateHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return core.ErrBadSnapshot
+	}
+	if string(header.Magic[:]) != saveStateMagic {
+		return core.ErrBadSnapshot
+	}
+	switch {
+	case header.Version == 1:
+		return m.restoreV1(r)
+	case header.Version > saveStateVersion:
+		return fmt.Errorf("save state version %d is newer than the supported version %d", header.Version, saveStateVersion)
+	}
+	sections := make(map[string][]byte)
+	for r.Len() > 0 {
+		var sh sectionHeader
+		if err := binary.Read(r, binary.LittleEndian, &sh); err != nil || int64(sh.Length) > int64(r.Len()) {
+			return core.ErrBadSnapshot
+		}
+		contents := make([]byte, sh.Length)
+		io.ReadFull(r, contents)
+		sections[string(sh.Tag[:])] = contents
+	}
+	state, ok := sections[sectionCore]
+	if !ok {
+		return fmt.Errorf("save state is missing the %s section", sectionCore)
+	}
+	var video [0x400]core.Word
+	var keyboard keyboardSection
+	if err := readSection(sections[sectionVideo], &video); err != nil {
+		return err
+	}
+	if err := readSection(sections[sectionKeyboard], &keyboard); err != nil {
+		return err
+	}
+	var lem lemSection
+	if err := readSection(sections[sectionLEM], &lem); err != nil {
+		return err
+	}
+	var vsync vsyncSection
+	if err := readSection(sections[sectionVSYNC], &vsync); err != nil {
+		return err
+	}
+	if int(keyboard.Offset) >= len(m.Keyboard.words) {
+		return core.ErrBadSnapshot
+	}
+	if err := m.State.Restore(state); err != nil {
+		return err
+	}
+	m.Video.words = video
+	m.Video.initialized = true
+	m.Video.screen, m.Video.font, m.Video.palette, m.Video.border = lem.Screen, lem.Font, lem.Palette, lem.Border
+	m.Video.vsync, m.Video.vsyncStart, m.Video.vsyncFrames = vsync.Message, vsync.Start, vsync.Frames
+	m.Video.connected = m.Video.screen != 0
+	m.Keyboard.words = keyboard.Words
+	m.Keyboard.offset = int(keyboard.Offset)
+	return nil
+}
+
+// readSection decodes a fixed-size section into data. Missing sections leave
+// data zeroed.
+func readSection(contents []byte, data interface{}) error {
+	if contents == nil {
+		return nil
+	}
+	if len(contents) != binary.Size(data) {
+		return core.ErrBadSnapshot
+	}
+	return binary.Read(bytes.NewReader(contents), binary.LittleEndian, data)
+}
+
+// restoreV1 restores the remainder of a version 1 save state
+func (m *Machine) restoreV1(r *bytes.Reader) error {
+	var snap machineSnapshotV1
+	if err := binary.Read(r, binary.LittleEndian, &snap); err != nil {
+		return core.ErrBadSnapshot
+	}
+	if int(snap.KeyboardOffset) >= len(m.Keyboard.words) {
+		return core.ErrBadSnapshot
+	}
+	state := make([]byte, r.Len())
+	io.ReadFull(r, state)
+	if err := m.State.Restore(state); err != nil {
+		return err
+	}
+	m.Video.words = snap.Video
+	m.Video.initialized = true
+	m.Video.screen, m.Video.font, m.Video.palette, m.Video.border = 0, 0, 0, 0
+	m.Video.vsync, m.Video.vsyncStart, m.Video.vsyncFrames = 0, 0, 0
+	m.Keyboard.words = snap.Keyboard
+	m.Keyboard.offset = int(snap.KeyboardOffset)
+	return nil
+}
@@ -0,0 +1,126 @@
+package dcpu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"testing"
+)
+
+func TestMachineSnapshot(t *testing.T) {
+	m := new(Machine)
+	if err := m.State.LoadProgram([]core.Word{0x8802}, 0); err != nil { // ADD A, 1
+		t.Fatal(err)
+	}
+	m.State.StepCycle()
+	m.Video.words[0] = 0xf041
+	m.Video.words[backgroundColorAddress] = 5
+	m.Keyboard.pushKey('a')
+	data, err := m.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := new(Machine)
+	if err := restored.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	if restored.State.Registers != m.State.Registers || restored.State.Cycles() != m.State.Cycles() {
+		t.Errorf("Unexpected state; registers %v, cycles %d", restored.State.Registers, restored.State.Cycles())
+	}
+	if restored.State.Ram.Load(0) != 0x8802 {
+		t.Errorf("Unexpected memory; expected %#x, found %#x", 0x8802, restored.State.Ram.Load(0))
+	}
+	if restored.Video.words != m.Video.words || !restored.Video.initialized {
+		t.Error("Video memory was not restored")
+	}
+	if restored.Keyboard.words != m.Keyboard.words || restored.Keyboard.offset != 1 {
+		t.Error("Keyboard buffer was not restored")
+	}
+
+	if err := restored.Restore(data[4:]); err != core.ErrBadSnapshot {
+		t.Errorf("Expected %v, found %v", core.ErrBadSnapshot, err)
+	}
+}
+
+func TestSaveStateCompatibility(t *testing.T) {
+	m := new(Machine)
+	m.State.SetA(0x1234)
+	m.Video.words[1] = 0x7062
+	m.Keyboard.pushKey('x')
+	state, err := m.State.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(name string, data []byte) {
+		restored := new(Machine)
+		if err := restored.Restore(data); err != nil {
+			t.Errorf("%s: %v", name, err)
+			return
+		}
+		if restored.State.A() != 0x1234 || restored.Video.words != m.Video.words || restored.Keyboard.words != m.Keyboard.words || restored.Keyboard.offset != 1 {
+			t.Errorf("%s: machine was not restored", name)
+		}
+	}
+
+	// version 1 save states have no sections
+	var v1 bytes.Buffer
+	v1.WriteString(saveStateMagic)
+	binary.Write(&v1, binary.LittleEndian, uint16(1))
+	binary.Write(&v1, binary.LittleEndian, &machineSnapshotV1{m.Video.words, m.Keyboard.words, 1})
+	v1.Write(state)
+	check("version 1", v1.Bytes())
+
+	// unknown sections are skipped
+	data, err := m.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	buf.Write(data)
+	writeSection(&buf, "XTRA", []byte{1, 2, 3})
+	check("unknown section", buf.Bytes())
+
+	newer := append([]byte(nil), data...)
+	newer[4] = saveStateVersion + 1
+	if err := new(Machine).Restore(newer); err == nil {
+		t.Error("Expected an error restoring a newer version")
+	}
+}
+
+func TestRestoreBadCore(t *testing.T) {
+	m := new(Machine)
+	state, err := m.State.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// saveState builds a save state around a core section
+	saveState := func(state []byte) []byte {
+		var buf bytes.Buffer
+		buf.WriteString(saveStateMagic)
+		binary.Write(&buf, binary.LittleEndian, uint16(saveStateVersion))
+		writeSection(&buf, sectionCore, state)
+		writeSection(&buf, sectionVideo, &[0x400]core.Word{0xf041})
+		return buf.Bytes()
+	}
+	// the step, the opcode and the a operand follow the magic, the version
+	// and the registers; decoding SET with an a operand of 0x40 would panic
+	offset := 4 + 2 + binary.Size(m.State.Registers)
+	undecoded := append([]byte(nil), state...)
+	undecoded[offset] = 1
+	undecoded[offset+8] = 1
+	undecoded[offset+12] = 0x40
+
+	for name, data := range map[string][]byte{
+		"truncated core":   saveState(state[:len(state)-1]),
+		"bad operand":      saveState(undecoded),
+		"truncated header": saveState(state)[:12],
+	} {
+		if err := m.Restore(data); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+		if m.Video.words[0] != 0 {
+			t.Errorf("%s: failed restore changed video memory", name)
+		}
+	}
+}
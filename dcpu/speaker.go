@@ -0,0 +1,119 @@
+package dcpu
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"io"
+)
+
+// ToneOutput plays the Speaker's tones on the host. Tone is called from the
+// goroutine running the machine, so it shouldn't block.
+type ToneOutput interface {
+	// Tone starts playing a tone of freq Hz, replacing the last, or stops
+	// playing if freq is 0
+	Tone(freq core.Word)
+}
+
+// BellOutput is a ToneOutput that rings the terminal bell for each tone,
+// for hosts without audio
+type BellOutput struct {
+	W io.Writer
+}
+
+func (b BellOutput) Tone(freq core.Word) {
+	if freq != 0 {
+		b.W.Write([]byte{'\a'})
+	}
+}
+
+// Speaker is a tone generator. The operation is selected by A when the
+// device is sent HWI:
+//
+//	A=0 play a tone of B Hz until told otherwise, or stop if B is 0
+//	A=1 play a tone of B Hz for C milliseconds
+//	A=2 if B is non-zero, an interrupt with message B is raised when a
+//	    timed tone finishes; if B is 0 interrupts are turned off
+//
+// Durations are measured in machine cycles, so they're the same however
+// fast the machine actually runs.
+type Speaker struct {
+	Output          ToneOutput // where tones are played; nil plays nothing
+	CyclesPerSecond uint64     // the cycles in a second; 0 means DefaultClockRate
+	freq            core.Word
+	cyclesLeft      uint64 // until the timed tone finishes, or 0
+	message         core.Word
+}
+
+const (
+	SpeakerID           = 0x02060001
+	SpeakerVersion      = 1
+	SpeakerManufacturer = 0x6b62616c
+)
+
+const (
+	speakerTone = iota
+	speakerTimedTone
+	speakerSetInterrupt
+)
+
+func (sp *Speaker) ID() uint32           { return SpeakerID }
+func (sp *Speaker) Version() core.Word   { return SpeakerVersion }
+func (sp *Speaker) Manufacturer() uint32 { return SpeakerManufacturer }
+
+func (sp *Speaker) HandleInterrupt(s *core.State) error {
+	switch s.A() {
+	case speakerTone:
+		sp.cyclesLeft = 0
+		sp.play(s.B())
+	case speakerTimedTone:
+		rate := sp.CyclesPerSecond
+		if rate == 0 {
+			rate = uint64(DefaultClockRate)
+		}
+		sp.cyclesLeft = uint64(s.C()) * rate / 1000
+		if sp.cyclesLeft == 0 {
+			sp.play(0)
+			break
+		}
+		sp.play(s.B())
+	case speakerSetInterrupt:
+		sp.message = s.B()
+	}
+	return nil
+}
+
+func (sp *Speaker) play(freq core.Word) {
+	if freq == sp.freq {
+		return
+	}
+	sp.freq = freq
+	if sp.Output != nil {
+		sp.Output.Tone(freq)
+	}
+}
+
+// Playing returns the frequency of the tone being played, or 0
+func (sp *Speaker) Playing() core.Word {
+	return sp.freq
+}
+
+// Tick stops a timed tone once it finishes
+func (sp *Speaker) Tick(s *core.State) error {
+	if sp.cyclesLeft == 0 {
+		return nil
+	}
+	sp.cyclesLeft--
+	if sp.cyclesLeft == 0 {
+		sp.play(0)
+		if sp.message != 0 {
+			s.TriggerInterrupt(sp.message)
+		}
+	}
+	return nil
+}
+
+// Reset silences the speaker and turns off interrupts
+func (sp *Speaker) Reset() {
+	sp.cyclesLeft = 0
+	sp.message = 0
+	sp.play(0)
+}
@@ -0,0 +1,58 @@
+package dcpu
+
+import (
+	"bytes"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"testing"
+)
+
+type toneLog []core.Word
+
+func (l *toneLog) Tone(freq core.Word) { *l = append(*l, freq) }
+
+func TestSpeaker(t *testing.T) {
+	state := new(core.State)
+	var tones toneLog
+	speaker := &Speaker{Output: &tones, CyclesPerSecond: 1000}
+	if _, err := state.AttachDevice(speaker); err != nil {
+		t.Fatal(err)
+	}
+	// SUB PC, 1
+	if err := state.LoadProgram([]core.Word{0x8b83}, 0); err != nil {
+		t.Fatal(err)
+	}
+	state.SetA(speakerTimedTone)
+	state.SetB(440)
+	state.SetC(10) // 10 cycles at 1KHz
+	speaker.HandleInterrupt(state)
+	if _, err := state.RunFor(9); err != nil {
+		t.Fatal(err)
+	}
+	if speaker.Playing() != 440 {
+		t.Errorf("Expected the tone to still be playing, found %d", speaker.Playing())
+	}
+	if _, err := state.RunFor(1); err != nil {
+		t.Fatal(err)
+	}
+	if speaker.Playing() != 0 || len(tones) != 2 || tones[0] != 440 || tones[1] != 0 {
+		t.Errorf("Expected the tone to finish, found tones %v", tones)
+	}
+
+	state.SetA(speakerTone)
+	state.SetB(880)
+	speaker.HandleInterrupt(state)
+	speaker.Reset()
+	if speaker.Playing() != 0 || len(tones) != 4 {
+		t.Errorf("Expected reset to silence the speaker, found tones %v", tones)
+	}
+}
+
+func TestBellOutput(t *testing.T) {
+	var buf bytes.Buffer
+	bell := BellOutput{&buf}
+	bell.Tone(440)
+	bell.Tone(0)
+	if buf.String() != "\a" {
+		t.Errorf("Expected one bell, found %q", buf.String())
+	}
+}
@@ -0,0 +1,59 @@
+package terminal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ReadGlyphMap reads a map of the characters to draw for LEM1802
+// characters, such as the control characters, which otherwise are drawn
+// from the terminal's alternate charset with a mostly arbitrary mapping.
+// Each line holds a character, as a number such as 30 or 0x1e, and what to
+// draw for it, as a character such as ▲ or a code point such as U+25B2.
+// Blank lines and lines starting with # are ignored.
+func ReadGlyphMap(r io.Reader) (map[rune]rune, error) {
+	glyphs := make(map[rune]rune)
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected a character and its glyph", lineno)
+		}
+		ch, err := strconv.ParseUint(fields[0], 0, 8)
+		if err != nil || ch > 0x7f {
+			return nil, fmt.Errorf("line %d: invalid character %q", lineno, fields[0])
+		}
+		glyph, ok := parseGlyph(fields[1])
+		if !ok {
+			return nil, fmt.Errorf("line %d: invalid glyph %q", lineno, fields[1])
+		}
+		glyphs[rune(ch)] = glyph
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return glyphs, nil
+}
+
+// parseGlyph parses a single character, or a code point written as U+XXXX
+func parseGlyph(str string) (rune, bool) {
+	if strings.HasPrefix(str, "U+") || strings.HasPrefix(str, "u+") {
+		n, err := strconv.ParseUint(str[2:], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(n)) {
+			return 0, false
+		}
+		return rune(n), true
+	}
+	if r, size := utf8.DecodeRuneInString(str); r != utf8.RuneError && size == len(str) {
+		return r, true
+	}
+	return 0, false
+}
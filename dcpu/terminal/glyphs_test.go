@@ -0,0 +1,30 @@
+package terminal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadGlyphMap(t *testing.T) {
+	glyphs, err := ReadGlyphMap(strings.NewReader("# arrows\n0x1e ▲\n31 U+25BC\n\n0x20 #\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(glyphs) != 3 || glyphs[0x1e] != '▲' || glyphs[31] != '▼' || glyphs[' '] != '#' {
+		t.Errorf("Unexpected glyphs %q", glyphs)
+	}
+	for _, bad := range []string{"0x1e", "0x80 x", "1 xy", "1 U+zz"} {
+		if _, err := ReadGlyphMap(strings.NewReader(bad)); err == nil {
+			t.Errorf("Expected an error reading %q", bad)
+		}
+	}
+
+	b := New()
+	b.TrueColor = true
+	b.Glyphs = glyphs
+	b.SetCell(1, 1, 0x1e, 0, 0, false)
+	b.SetCell(0, 0, ' ', 0, 0, false)
+	if out := b.out.String(); !strings.Contains(out, "▲") || strings.Contains(out, "#") {
+		t.Errorf("Expected the glyph inside the border only, found %q", out)
+	}
+}
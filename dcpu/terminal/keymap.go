@@ -0,0 +1,156 @@
+package terminal
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu"
+	"github.com/kballard/termbox-go"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Keymap maps the keys termbox reports to what's sent to the program, or to
+// the emulator's commands
+type Keymap struct {
+	KeyToRune map[termbox.Key]rune     // keys that type characters
+	KeyToKey  map[termbox.Key]dcpu.Key // keys that are pressed and released
+	// KeyToCommand maps keys to the names of commands, which are listed in
+	// Commands
+	KeyToCommand map[termbox.Key]string
+	Commands     []string
+	// RuneToRune remaps the characters typed
+	RuneToRune map[rune]rune
+}
+
+// KeyNames are the names of termbox's keys in keymap files
+var KeyNames = map[string]termbox.Key{
+	"f1": termbox.KeyF1, "f2": termbox.KeyF2, "f3": termbox.KeyF3, "f4": termbox.KeyF4,
+	"f5": termbox.KeyF5, "f6": termbox.KeyF6, "f7": termbox.KeyF7, "f8": termbox.KeyF8,
+	"f9": termbox.KeyF9, "f10": termbox.KeyF10, "f11": termbox.KeyF11, "f12": termbox.KeyF12,
+	"insert":     termbox.KeyInsert,
+	"delete":     termbox.KeyDelete,
+	"home":       termbox.KeyHome,
+	"end":        termbox.KeyEnd,
+	"pgup":       termbox.KeyPgup,
+	"pgdn":       termbox.KeyPgdn,
+	"up":         termbox.KeyArrowUp,
+	"down":       termbox.KeyArrowDown,
+	"left":       termbox.KeyArrowLeft,
+	"right":      termbox.KeyArrowRight,
+	"enter":      termbox.KeyEnter,
+	"backspace":  termbox.KeyBackspace,
+	"backspace2": termbox.KeyBackspace2,
+	"tab":        termbox.KeyTab,
+	"esc":        termbox.KeyEsc,
+	"space":      termbox.KeySpace,
+}
+
+// the control keys are ctrl-a to ctrl-z, which termbox numbers as ASCII
+// does
+func init() {
+	for ch := 'a'; ch <= 'z'; ch++ {
+		KeyNames["ctrl-"+string(ch)] = termbox.Key(ch - 'a' + 1)
+	}
+}
+
+// pressedKeyNames are the names of the keys that are pressed and released,
+// rather than typed, in keymap files
+var pressedKeyNames = map[string]dcpu.Key{
+	"up":    dcpu.KeyArrowUp,
+	"down":  dcpu.KeyArrowDown,
+	"left":  dcpu.KeyArrowLeft,
+	"right": dcpu.KeyArrowRight,
+}
+
+// Read updates the keymap from a keymap file, for keys that are wrong on
+// the user's keyboard layout or terminal. Each line holds a termbox key, by
+// name such as delete or ctrl-r, and what it sends: a character or key code
+// typed to the program, a key pressed and released (up, down, left or
+// right), one of the Commands, or none. A line can also map a typed
+// character to another. Characters are written as themselves, or as code
+// points such as U+0023 (for # and space), and key codes as numbers of more
+// than one digit, such as 10 or 0x7f. Blank lines and lines starting with #
+// are ignored. The lines before a bad one have already been applied. Maps
+// that are nil are allocated, so a zero Keymap can be read into.
+func (k *Keymap) Read(r io.Reader) error {
+	if k.KeyToRune == nil {
+		k.KeyToRune = make(map[termbox.Key]rune)
+	}
+	if k.KeyToKey == nil {
+		k.KeyToKey = make(map[termbox.Key]dcpu.Key)
+	}
+	if k.KeyToCommand == nil {
+		k.KeyToCommand = make(map[termbox.Key]string)
+	}
+	if k.RuneToRune == nil {
+		k.RuneToRune = make(map[rune]rune)
+	}
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return fmt.Errorf("line %d: expected a key and what it sends", lineno)
+		}
+		to := fields[1]
+		if key, ok := KeyNames[fields[0]]; ok {
+			delete(k.KeyToRune, key)
+			delete(k.KeyToKey, key)
+			delete(k.KeyToCommand, key)
+			if pressed, ok := pressedKeyNames[to]; ok {
+				k.KeyToKey[key] = pressed
+			} else if k.isCommand(to) {
+				k.KeyToCommand[key] = to
+			} else if r, ok := parseKeymapRune(to); ok {
+				k.KeyToRune[key] = r
+			} else if to != "none" {
+				return fmt.Errorf("line %d: invalid key %q", lineno, to)
+			}
+			continue
+		}
+		ch, ok := parseKeymapChar(fields[0])
+		if !ok {
+			return fmt.Errorf("line %d: unknown key %q", lineno, fields[0])
+		}
+		r, ok := parseKeymapRune(to)
+		if !ok {
+			return fmt.Errorf("line %d: characters can only be mapped to characters, not %q", lineno, to)
+		}
+		k.RuneToRune[ch] = r
+	}
+	return scanner.Err()
+}
+
+func (k *Keymap) isCommand(name string) bool {
+	for _, cmd := range k.Commands {
+		if cmd == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseKeymapChar parses a character, or a code point written as U+XXXX
+func parseKeymapChar(str string) (rune, bool) {
+	if strings.HasPrefix(str, "U+") {
+		n, err := strconv.ParseUint(str[2:], 16, 32)
+		return rune(n), err == nil && utf8.ValidRune(rune(n))
+	}
+	r, size := utf8.DecodeRuneInString(str)
+	return r, r != utf8.RuneError && size == len(str)
+}
+
+// parseKeymapRune parses a character as parseKeymapChar does, or a key code
+// of more than one digit
+func parseKeymapRune(str string) (rune, bool) {
+	if r, ok := parseKeymapChar(str); ok {
+		return r, true
+	}
+	n, err := strconv.ParseUint(str, 0, 16)
+	return rune(n), err == nil
+}
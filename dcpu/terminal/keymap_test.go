@@ -0,0 +1,79 @@
+package terminal
+
+import (
+	"github.com/kballard/dcpu16/dcpu"
+	"github.com/kballard/termbox-go"
+	"strings"
+	"testing"
+)
+
+func newTestKeymap() *Keymap {
+	return &Keymap{
+		KeyToRune:    map[termbox.Key]rune{termbox.KeyDelete: 127},
+		KeyToKey:     map[termbox.Key]dcpu.Key{termbox.KeyArrowUp: dcpu.KeyArrowUp},
+		KeyToCommand: map[termbox.Key]string{termbox.KeyCtrlC: "quit"},
+		Commands:     []string{"quit", "pause"},
+		RuneToRune:   map[rune]rune{},
+	}
+}
+
+func TestKeymapRead(t *testing.T) {
+	k := newTestKeymap()
+	src := `
+# a comment
+delete    U+0008
+ctrl-c    none
+ctrl-q    quit
+f1        pause
+home      left
+up        0x1b
+end       a
+ß         s
+U+0023    U+0020
+`
+	if err := k.Read(strings.NewReader(src)); err != nil {
+		t.Fatal(err)
+	}
+	if k.KeyToRune[termbox.KeyDelete] != 8 || k.KeyToRune[termbox.KeyArrowUp] != 0x1b || k.KeyToRune[termbox.KeyEnd] != 'a' {
+		t.Errorf("Unexpected typed keys %v", k.KeyToRune)
+	}
+	if _, ok := k.KeyToKey[termbox.KeyArrowUp]; ok || k.KeyToKey[termbox.KeyHome] != dcpu.KeyArrowLeft {
+		t.Errorf("Unexpected pressed keys %v", k.KeyToKey)
+	}
+	if _, ok := k.KeyToCommand[termbox.KeyCtrlC]; ok || k.KeyToCommand[KeyNames["ctrl-q"]] != "quit" || k.KeyToCommand[termbox.KeyF1] != "pause" {
+		t.Errorf("Unexpected commands %v", k.KeyToCommand)
+	}
+	if k.RuneToRune['ß'] != 's' || k.RuneToRune['#'] != ' ' {
+		t.Errorf("Unexpected characters %v", k.RuneToRune)
+	}
+}
+
+func TestKeymapReadZero(t *testing.T) {
+	var k Keymap
+	if err := k.Read(strings.NewReader("delete 0x08\nhome left\na b\n")); err != nil {
+		t.Fatal(err)
+	}
+	if k.KeyToRune[termbox.KeyDelete] != 8 || k.KeyToKey[termbox.KeyHome] != dcpu.KeyArrowLeft || k.RuneToRune['a'] != 'b' {
+		t.Errorf("Unexpected keymap %+v", k)
+	}
+}
+
+func TestKeymapReadErrors(t *testing.T) {
+	for _, line := range []string{
+		"delete",
+		"delete backspace extra",
+		"delete reboot",  // not a command
+		"delete 0x10000", // too big for a key code
+		"ctrl-1 a",       // not a key
+		"ab c",           // not a character
+		"a up",           // characters only map to characters
+		"U+D800 a",       // not a valid code point
+		"U+zz a",
+	} {
+		k := newTestKeymap()
+		err := k.Read(strings.NewReader("# ok\n" + line + "\n"))
+		if err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+			t.Errorf("%q: expected an error on line 2, found %v", line, err)
+		}
+	}
+}
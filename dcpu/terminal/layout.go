@@ -0,0 +1,131 @@
+package terminal
+
+// laying the screen out in terminals of any size
+
+import (
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu"
+	"github.com/kballard/termbox-go"
+)
+
+// statusLines is the number of lines of status a dcpu.Video shows
+const statusLines = 5
+
+// monitorGap is the number of columns between screens tiled side by side
+const monitorGap = 2
+
+// layout is where the screen is drawn in the terminal
+type layout struct {
+	left, top int  // the top left of the border
+	tooSmall  bool // the screen doesn't fit, so it isn't drawn
+	hidden    bool // another screen is shown in its place
+}
+
+// visible returns whether the screen is drawn
+func (l *layout) visible() bool {
+	return !l.tooSmall && !l.hidden
+}
+
+// displaySize returns the size of the display in characters of the
+// terminal, not counting the border
+func (b *Backend) displaySize() (columns, rows int) {
+	switch b.mode {
+	case HalfBlocks:
+		return halfBlockColumns, halfBlockRows
+	case Braille:
+		return brailleColumns, brailleRows
+	}
+	return dcpu.ScreenColumns, dcpu.ScreenRows
+}
+
+// statusRow returns the row of the first line of status, below the screen,
+// its border and a blank line
+func (b *Backend) statusRow() int {
+	_, rows := b.displaySize()
+	return rows + 2 + 1
+}
+
+// layOut centers the screens side by side in the terminal, with the status
+// below the first. If they don't all fit, only the shown screen is drawn,
+// and if only the screens fit, the status is cut off.
+func (b *Backend) layOut() {
+	b.layOutIn(termbox.Size())
+}
+
+// layOutIn lays the screens out in a terminal of the given size
+func (b *Backend) layOutIn(width, height int) {
+	columns, rows := b.displaySize()
+	screens := b.screens()
+	tiled := len(screens)*(columns+2+monitorGap) - monitorGap
+	if tiled > width {
+		tiled = columns + 2
+	}
+	tooSmall := width < columns+2 || height < rows+2
+	left, top := (width-tiled)/2, (height-b.statusRow()-statusLines)/2
+	if left < 0 {
+		left = 0
+	}
+	if top < 0 {
+		top = 0
+	}
+	for i, screen := range screens {
+		screen.left, screen.top = left, top
+		screen.tooSmall = tooSmall
+		screen.hidden = tiled == columns+2 && i != b.shown
+		if tiled > columns+2 {
+			left += columns + 2 + monitorGap
+		}
+	}
+}
+
+// Resize lays the screens out again for the terminal's new size and
+// redraws them, for the frontend to call when termbox reports that the
+// terminal has been resized. If the screen no longer fits, a message asking
+// for a bigger terminal is shown instead.
+func (b *Backend) Resize() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.redraw()
+}
+
+// NextScreen shows the next of the screens, for the frontend to call when
+// the user asks to. It does nothing unless the screens are too many to be
+// tiled in the terminal, so only one is shown at a time.
+func (b *Backend) NextScreen() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.monitors == nil || !b.hidden && !b.monitors[0].hidden {
+		// they're tiled
+		return
+	}
+	b.shown = (b.shown + 1) % (len(b.monitors) + 1)
+	b.redraw()
+}
+
+// redraw lays the screens out and draws them again
+func (b *Backend) redraw() {
+	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+	b.layOut()
+	if b.tooSmall {
+		columns, rows := b.displaySize()
+		message := fmt.Sprintf("The terminal is too small for the screen: make it at least %dx%d", columns+2, rows+2)
+		termbox.DrawString(0, 0, termbox.ColorDefault, termbox.ColorDefault, message)
+		b.flush()
+		return
+	}
+	for _, screen := range b.screens() {
+		switch {
+		case !screen.visible():
+		case b.mode == Cells:
+			for y := range screen.cells {
+				for x := range screen.cells[y] {
+					screen.drawCell(x, y)
+				}
+			}
+		default:
+			screen.drawPixels()
+		}
+	}
+	b.drawStatus()
+	b.flush()
+}
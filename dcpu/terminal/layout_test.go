@@ -0,0 +1,28 @@
+package terminal
+
+import (
+	"testing"
+)
+
+func TestLayOutMonitors(t *testing.T) {
+	b := New()
+	monitor := b.Monitor()
+	// 34 columns each, with the gap
+	b.layOutIn(80, 24)
+	if !b.visible() || !monitor.visible() || b.left != 5 || monitor.left != 41 || b.top != 2 {
+		t.Errorf("Expected the screens to be tiled; %+v, %+v", b.layout, monitor.layout)
+	}
+	b.layOutIn(60, 24)
+	if !b.visible() || monitor.visible() || b.left != 13 {
+		t.Errorf("Expected only the first screen to be shown; %+v, %+v", b.layout, monitor.layout)
+	}
+	b.shown = 1
+	b.layOutIn(60, 24)
+	if b.visible() || !monitor.visible() || monitor.left != 13 {
+		t.Errorf("Expected only the monitor to be shown; %+v, %+v", b.layout, monitor.layout)
+	}
+	b.layOutIn(20, 24)
+	if b.visible() || monitor.visible() || !b.tooSmall {
+		t.Errorf("Expected the screens not to fit; %+v, %+v", b.layout, monitor.layout)
+	}
+}
@@ -0,0 +1,105 @@
+package terminal
+
+// bracketed paste, which termbox doesn't know about
+
+import (
+	"github.com/kballard/termbox-go"
+	"time"
+)
+
+// Once bracketed paste is turned on, the terminal brackets pasted text with
+// pasteStart and pasteEnd
+const (
+	bracketedPasteOn  = "\033[?2004h"
+	bracketedPasteOff = "\033[?2004l"
+	pasteStart        = "\033[200~"
+	pasteEnd          = "\033[201~"
+)
+
+// pasteTimeout is how long an Esc waits for the rest of a bracket, which the
+// terminal sends with it, before it's passed on as a key
+const pasteTimeout = 20 * time.Millisecond
+
+// ReadPastes passes termbox's events from in to events, except for text
+// pasted into a Backend with BracketedPaste, which it sends to pastes whole.
+// termbox doesn't know the brackets, so it reports each as an Esc followed
+// by the characters of the rest. It returns once in is closed.
+func ReadPastes(in <-chan termbox.Event, events chan<- termbox.Event, pastes chan<- string) {
+	var pending []termbox.Event // what may be the start of pasteStart
+	var text []rune             // the paste so far, and perhaps pasteEnd
+	pasting := false
+	flush := func() {
+		for _, ev := range pending {
+			events <- ev
+		}
+		pending = nil
+	}
+	for {
+		var ev termbox.Event
+		ok := true
+		if pending == nil {
+			ev, ok = <-in
+		} else {
+			select {
+			case ev, ok = <-in:
+			case <-time.After(pasteTimeout):
+				flush()
+				continue
+			}
+		}
+		if !ok {
+			flush()
+			return
+		}
+		ch, typed := eventRune(ev)
+		switch {
+		case !typed:
+			flush()
+			events <- ev
+		case pasting:
+			text = append(text, ch)
+			if hasSuffix(text, pasteEnd) {
+				pastes <- string(text[:len(text)-len(pasteEnd)])
+				text, pasting = nil, false
+			}
+		case ch == rune(pasteStart[len(pending)]):
+			pending = append(pending, ev)
+			if len(pending) == len(pasteStart) {
+				pending, pasting = nil, true
+			}
+		default:
+			flush()
+			if ch == rune(pasteStart[0]) {
+				pending = append(pending, ev)
+			} else {
+				events <- ev
+			}
+		}
+	}
+}
+
+// eventRune returns the character a key event types, if any. termbox
+// reports the control characters, space and backspace as keys, numbered as
+// in ASCII.
+func eventRune(ev termbox.Event) (rune, bool) {
+	if ev.Type != termbox.EventKey {
+		return 0, false
+	}
+	if ev.Ch != 0 {
+		return ev.Ch, true
+	}
+	return rune(ev.Key), ev.Key < 0x80
+}
+
+// hasSuffix returns whether text ends with the ASCII suffix
+func hasSuffix(text []rune, suffix string) bool {
+	if len(text) < len(suffix) {
+		return false
+	}
+	for i, ch := range text[len(text)-len(suffix):] {
+		if ch != rune(suffix[i]) {
+			return false
+		}
+	}
+	return true
+}
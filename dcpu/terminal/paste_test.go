@@ -0,0 +1,53 @@
+package terminal
+
+import (
+	"github.com/kballard/termbox-go"
+	"testing"
+	"time"
+)
+
+func TestReadPastes(t *testing.T) {
+	in := make(chan termbox.Event, 32)
+	events := make(chan termbox.Event, 32)
+	pastes := make(chan string, 4)
+	// termbox reports control characters and space as keys
+	key := func(ch rune) termbox.Event {
+		if ch <= ' ' {
+			return termbox.Event{Type: termbox.EventKey, Key: termbox.Key(ch)}
+		}
+		return termbox.Event{Type: termbox.EventKey, Ch: ch}
+	}
+	send := func(text string) {
+		for _, ch := range text {
+			in <- key(ch)
+		}
+	}
+	send("a" + pasteStart + "hi\r\033[A" + pasteEnd + "\033b")
+	in <- termbox.Event{Type: termbox.EventResize}
+	done := make(chan struct{})
+	go func() {
+		ReadPastes(in, events, pastes)
+		close(done)
+	}()
+
+	if text := <-pastes; text != "hi\r\033[A" {
+		t.Errorf("Unexpected paste %q", text)
+	}
+	for _, expected := range []termbox.Event{key('a'), key('\033'), key('b'), {Type: termbox.EventResize}} {
+		if ev := <-events; ev != expected {
+			t.Errorf("Expected %+v, found %+v", expected, ev)
+		}
+	}
+	// a lone Esc is passed on once the rest of a bracket doesn't follow
+	send("\033")
+	select {
+	case ev := <-events:
+		if ev != key('\033') {
+			t.Errorf("Expected Esc, found %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected Esc to be passed on")
+	}
+	close(in)
+	<-done
+}
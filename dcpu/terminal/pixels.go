@@ -0,0 +1,109 @@
+package terminal
+
+// drawing the pixels of the screen for HalfBlocks and Braille
+
+import (
+	"github.com/kballard/dcpu16/dcpu"
+	"github.com/kballard/dcpu16/dcpu/core"
+)
+
+// The size of the display in characters of the terminal, not counting the
+// border, in the pixel modes
+const (
+	halfBlockColumns = dcpu.ScreenColumns * 4
+	halfBlockRows    = dcpu.ScreenRows * 8 / 2
+	brailleColumns   = dcpu.ScreenColumns * 4 / 2
+	brailleRows      = dcpu.ScreenRows * 8 / 4
+)
+
+// cell is a character of the screen, as given to SetCell
+type cell struct {
+	ch     rune
+	fg, bg byte
+}
+
+// brailleDots are the bits of the braille patterns from U+2800 for each
+// pixel, by row and column
+var brailleDots = [4][2]rune{
+	{0x01, 0x08},
+	{0x02, 0x10},
+	{0x04, 0x20},
+	{0x40, 0x80},
+}
+
+func (b *Backend) SetFont(font [256]core.Word) {
+	root := b.rootBackend()
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	b.font = font
+}
+
+// pixel returns whether the pixel at column x and row y of the display is
+// in the foreground color of its cell, which it returns
+func (b *Backend) pixel(x, y int) (bool, cell) {
+	c := b.cells[y/8+1][x/4+1]
+	col, row := x%4, y%8
+	// the high byte of each word is the left column, with the top pixel in
+	// the lowest bit
+	word := b.font[(c.ch&0x7f)*2+rune(col/2)]
+	bits := byte(word >> 8)
+	if col%2 == 1 {
+		bits = byte(word)
+	}
+	return bits>>uint(row)&1 != 0, c
+}
+
+// drawPixels draws the cells set since the last Flush as pixels
+func (b *Backend) drawPixels() {
+	columns, rows := b.displaySize()
+	// the border is drawn as a character wide, like the cells
+	border := b.cells[0][0].bg
+	for x := 0; x < columns+2; x++ {
+		b.draw(x, 0, ' ', border, border, false)
+		b.draw(x, rows+1, ' ', border, border, false)
+	}
+	for y := 1; y <= rows; y++ {
+		b.draw(0, y, ' ', border, border, false)
+		b.draw(columns+1, y, ' ', border, border, false)
+	}
+	for y := 0; y < rows; y++ {
+		for x := 0; x < columns; x++ {
+			if b.mode == HalfBlocks {
+				b.drawHalfBlock(x, y)
+			} else {
+				b.drawBraille(x, y)
+			}
+		}
+	}
+}
+
+// drawHalfBlock draws the pixels at column x and rows 2y and 2y+1 as an
+// upper half block, in the top pixel's color over the bottom's
+func (b *Backend) drawHalfBlock(x, y int) {
+	color := func(fg bool, c cell) byte {
+		if fg {
+			return c.fg
+		}
+		return c.bg
+	}
+	top, c := b.pixel(x, y*2)
+	bottom, _ := b.pixel(x, y*2+1)
+	b.draw(x+1, y+1, '▀', color(top, c), color(bottom, c), false)
+}
+
+// drawBraille draws the 2x4 pixels from column 2x and row 4y as the dots of
+// a braille pattern, in the foreground color of their cell over its
+// background
+func (b *Backend) drawBraille(x, y int) {
+	var dots rune
+	var c cell
+	for row := 0; row < 4; row++ {
+		for col := 0; col < 2; col++ {
+			var fg bool
+			if fg, c = b.pixel(x*2+col, y*4+row); fg {
+				dots |= brailleDots[row][col]
+			}
+		}
+	}
+	b.draw(x+1, y+1, 0x2800+dots, c.fg, c.bg, false)
+}
@@ -0,0 +1,23 @@
+package terminal
+
+import (
+	"testing"
+)
+
+func TestPixel(t *testing.T) {
+	b := NewMode(Braille)
+	// character 1 has a solid second column, in color 2 on 4
+	b.font[2] = 0x00ff
+	b.SetCell(2, 1, 1, 2, 4, false)
+	for _, px := range []struct {
+		x, y int
+		fg   bool
+	}{{4, 0, false}, {5, 0, true}, {5, 7, true}, {6, 3, false}, {1, 0, false}} {
+		if fg, _ := b.pixel(px.x, px.y); fg != px.fg {
+			t.Errorf("Expected pixel (%d, %d) to be foreground: %v", px.x, px.y, px.fg)
+		}
+	}
+	if _, c := b.pixel(5, 0); c.fg != 2 || c.bg != 4 {
+		t.Errorf("Unexpected cell %+v", c)
+	}
+}
@@ -0,0 +1,349 @@
+// Package terminal draws the screen of a dcpu.Machine in the terminal,
+// using termbox.
+package terminal
+
+import (
+	"bytes"
+	"github.com/kballard/dcpu16/dcpu"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"github.com/kballard/termbox-go"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Mode is how a Backend draws the screen
+type Mode int
+
+const (
+	// Cells draws each character of the screen as a character of the
+	// terminal, so fonts aren't shown
+	Cells Mode = iota
+	// HalfBlocks draws each pair of pixels above each other as a half block
+	// in their colors, taking 130x50 characters with the border
+	HalfBlocks
+	// Braille draws each 2x4 pixels as a braille pattern, taking 66x26
+	// characters with the border
+	Braille
+)
+
+// Backend is a dcpu.VideoBackend, dcpu.StatusDisplay and dcpu.FontDisplay
+// drawing in the terminal, though only the pixel modes show the font.
+type Backend struct {
+	// TrueColor draws the exact colors of the palette with 24-bit escapes,
+	// rather than the closest of the terminal's 256 colors. Set it before
+	// Init, if SupportsTrueColor or the user says so.
+	TrueColor bool
+	// Glyphs maps LEM1802 characters to what's drawn for them in Cells
+	// mode, as read by ReadGlyphMap, overriding the alternate charset.
+	Glyphs map[rune]rune
+	// BracketedPaste has the terminal bracket pasted text, for ReadPastes
+	// to pick out of the keys. Set it before Init.
+	BracketedPaste bool
+
+	mode    Mode
+	mu      sync.Mutex // Resize is called from the frontend's goroutine
+	palette [16]core.Word
+	font    [256]core.Word
+	cells   [dcpu.ScreenRows + 2][dcpu.ScreenColumns + 2]cell // to redraw, and draw the pixel modes on Flush
+	status  []string
+	tty     *os.File     // for TrueColor and BracketedPaste
+	out     bytes.Buffer // drawn with TrueColor since the last Flush
+	layout
+
+	// Monitors share the terminal of the Backend they were made by, their
+	// root, which draws the status and is laid out along with them
+	root     *Backend
+	monitors []*Backend
+	shown    int // the screen shown when they don't all fit, 0 for the root's
+}
+
+// New returns a Backend drawing Cells, using the default palette
+func New() *Backend {
+	return NewMode(Cells)
+}
+
+// NewMode returns a Backend drawing in the given mode
+func NewMode(mode Mode) *Backend {
+	return &Backend{mode: mode, palette: dcpu.DefaultPalette}
+}
+
+// Monitor returns a Backend for another screen, such as one of a
+// dcpu.Machine's Monitors, drawn beside b's in the same mode. It draws
+// with b's TrueColor and Glyphs, and is laid out by b, so it must be made
+// before b is initialized.
+func (b *Backend) Monitor() *Backend {
+	monitor := &Backend{mode: b.mode, palette: dcpu.DefaultPalette, root: b}
+	b.monitors = append(b.monitors, monitor)
+	return monitor
+}
+
+// rootBackend returns the Backend that owns the terminal
+func (b *Backend) rootBackend() *Backend {
+	if b.root != nil {
+		return b.root
+	}
+	return b
+}
+
+// screens returns the root's screen followed by its monitors'
+func (b *Backend) screens() []*Backend {
+	return append([]*Backend{b}, b.monitors...)
+}
+
+var supportsXterm256 bool
+
+// colorToAnsi maps the 4-bit DCPU-16 colors to xterm-256 colors
+// We can't do an exact match, but we can get pretty close.
+// Note: color spec says +red, +green, -highlight puts the green channel
+// at 0xFF instead of 0xAA. After reading comments on the 0x10cwiki, this
+// is likely a bug, it should probably be dropped to 0x55. Also note that
+// this only holds if blue is off.
+var colorToAnsi [16]byte = [...]byte{
+	/* 0000 */ 16 /* 0001 */, 19 /* 0010 */, 34 /* 0011 */, 37,
+	/* 0100 */ 124 /* 0101 */, 127 /* 0110 */, 130 /* 0111 */, 145,
+	/* 1000 */ 59 /* 1001 */, 63 /* 1010 */, 71 /* 1011 */, 87,
+	/* 1100 */ 203 /* 1101 */, 207 /* 1110 */, 227 /* 1111 */, 231,
+}
+
+func (b *Backend) Init() error {
+	if b.root != nil {
+		return nil
+	}
+	if err := termbox.Init(); err != nil {
+		return err
+	}
+	b.layOut()
+	if !b.TrueColor && !b.BracketedPaste {
+		return nil
+	}
+	if err := b.openTTY(); err != nil {
+		return err
+	}
+	if b.BracketedPaste {
+		b.tty.WriteString(bracketedPasteOn)
+	}
+	return nil
+}
+
+func (b *Backend) Close() {
+	if b.root != nil {
+		return
+	}
+	termbox.Close()
+	if b.BracketedPaste && b.tty != nil {
+		b.tty.WriteString(bracketedPasteOff)
+	}
+	b.closeTTY()
+}
+
+func (b *Backend) Flush() {
+	root := b.rootBackend()
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	if b.mode != Cells && b.visible() {
+		b.drawPixels()
+	}
+	root.flush()
+}
+
+// flush shows what's been drawn in the terminal
+func (b *Backend) flush() {
+	termbox.Flush()
+	if b.TrueColor {
+		b.flushTrueColor()
+	}
+}
+
+func (b *Backend) SetPalette(palette [16]core.Word) {
+	root := b.rootBackend()
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	b.palette = palette
+}
+
+func (b *Backend) SetCell(x, y int, ch rune, fgIndex, bgIndex byte, blink bool) {
+	root := b.rootBackend()
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	// the pixel modes are drawn on Flush, once the font is known
+	b.cells[y][x] = cell{ch, fgIndex, bgIndex}
+	if b.mode == Cells && b.visible() {
+		b.drawCell(x, y)
+	}
+}
+
+// drawCell draws a cell of the grid in Cells mode
+func (b *Backend) drawCell(x, y int) {
+	c := b.cells[y][x]
+	ch := c.ch
+	// blinking characters are hidden by the machine, as many terminals
+	// ignore the blink attribute
+	if glyph, ok := b.rootBackend().Glyphs[ch]; ok && !isBorder(x, y) {
+		b.draw(x, y, glyph, c.fg, c.bg, false)
+		return
+	}
+	alt := false
+	if ch < 32 || ch == 127 {
+		// we want to render using the alternate charset
+		// There's only 26 usable characters though, and we don't have any idea what
+		// an appropriate mapping is. So for the moment, just map them fairly arbitrarily.
+		// Except for the arrow keys, those we want to match @notch's emulator.
+		// Oddly, @notch's emulator provides a character for up arrow, which is 128, which
+		// is a 0 with the blink tag set. Based on experimentation, the video RAM does default
+		// to 0, but writing a 0 back into the same spot draws the glyph.
+		// These explicit mappings are encoded in a map table. The rest are just assigned
+		// arbitrarily.
+		if ch == 127 {
+			ch = 32
+		}
+		if glyph, ok := glyphMap[ch]; ok {
+			ch = glyph
+		} else {
+			ch = ch%26 + 'a'
+		}
+		alt = true
+	}
+	b.draw(x, y, ch, c.fg, c.bg, alt)
+}
+
+// draw draws ch at column x and row y of the screen, counting from the
+// top left of its border, in the palette entries fg and bg, from the
+// alternate charset if alt is true
+func (b *Backend) draw(x, y int, ch rune, fg, bg byte, alt bool) {
+	x, y = x+b.left, y+b.top
+	if root := b.rootBackend(); root.TrueColor {
+		root.drawTrueColor(x, y, ch, b.palette[fg&0xf], b.palette[bg&0xf], alt)
+		return
+	}
+	fgAttr, bgAttr := b.paletteAttr(fg), b.paletteAttr(bg)
+	if alt {
+		fgAttr |= termbox.AttrAltCharset
+	}
+	termbox.SetCell(x, y, ch, fgAttr, bgAttr)
+}
+
+// isBorder returns whether column x and row y of the grid are the border
+func isBorder(x, y int) bool {
+	return x == 0 || y == 0 || x == dcpu.ScreenColumns+1 || y == dcpu.ScreenRows+1
+}
+
+var glyphMap = map[rune]rune{
+	0: 'm',
+	1: 'v',
+	2: 'w',
+	3: 't',
+}
+
+// SetStatus draws the lines of status below the screen
+func (b *Backend) SetStatus(lines []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.status = lines
+	if !b.tooSmall {
+		b.drawStatus()
+	}
+}
+
+func (b *Backend) drawStatus() {
+	for i, line := range b.status {
+		termbox.DrawString(b.left+1, b.top+b.statusRow()+i, termbox.ColorDefault, termbox.ColorDefault, line)
+	}
+}
+
+// paletteAttr returns the attribute for a palette entry. The default palette
+// matches the colors of the 1.1 spec, so it uses their tuned mapping.
+func (b *Backend) paletteAttr(index byte) termbox.Attribute {
+	if b.palette == dcpu.DefaultPalette {
+		return colorToAttr(index)
+	}
+	return rgbToAttr(b.palette[index&0xf])
+}
+
+func colorToAttr(color byte) termbox.Attribute {
+	var attr termbox.Attribute
+	if supportsXterm256 {
+		// special-case 0 for Terminal.app.
+		// Terminal.app adjusts the foreground colors a bit so text can be distinguished
+		// from a same-colored background. We don't want this. It doesn't appear to perform
+		// this adjustment for ANSI color 0 (but it does for xterm-256 color 16).
+		if color == 0 {
+			attr = termbox.ColorBlack
+		} else {
+			// We need to use xterm-256 colors to work properly here.
+			// Luckily, we built a table!
+			attr = termbox.ColorXterm256
+			ansi := colorToAnsi[color]
+			attr |= termbox.Attribute(ansi) << termbox.XtermColorShift
+		}
+	} else {
+		// We don't seem to support xterm-256 colors, so fall back on
+		// trying to use the normal ANSI colors
+		attr = termbox.ColorDefault
+		// bold
+		if color&0x8 != 0 {
+			attr |= termbox.AttrBold
+		}
+		// cheat a bit here. We know the termbox color attributes go in the
+		// same order as the ANSI colors, and they're monotomically-incrementing.
+		// Just figure out the ANSI code and add ColorBlack
+		ansi := termbox.Attribute(0)
+		if color&0x1 != 0 {
+			// blue
+			ansi |= 0x4
+		}
+		if color&0x2 != 0 {
+			// green
+			ansi |= 0x2
+		}
+		if color&0x4 != 0 {
+			// red
+			ansi |= 0x1
+		}
+		attr |= ansi + termbox.ColorBlack
+		return attr
+	}
+	return attr
+}
+
+// rgbToAttr returns the closest attribute to a 0000 rrrr gggg bbbb color
+func rgbToAttr(rgb core.Word) termbox.Attribute {
+	r, g, b := byte(rgb>>8&0xf), byte(rgb>>4&0xf), byte(rgb&0xf)
+	if !supportsXterm256 {
+		// pick the closest of the 1.1 colors
+		max := r
+		if g > max {
+			max = g
+		}
+		if b > max {
+			max = b
+		}
+		var color byte
+		if max > 0xb {
+			color |= 0x8
+		}
+		if b*2 > max {
+			color |= 0x1
+		}
+		if g*2 > max {
+			color |= 0x2
+		}
+		if r*2 > max {
+			color |= 0x4
+		}
+		return colorToAttr(color)
+	}
+	if r == 0 && g == 0 && b == 0 {
+		return termbox.ColorBlack
+	}
+	// the xterm-256 color cube has 6 levels per channel
+	level := func(c byte) byte { return (c*5 + 7) / 15 }
+	ansi := 16 + 36*level(r) + 6*level(g) + level(b)
+	return termbox.ColorXterm256 | termbox.Attribute(ansi)<<termbox.XtermColorShift
+}
+
+// test for xterm-256 color support
+func init() {
+	// Check $TERM for the -256color suffix
+	supportsXterm256 = strings.HasSuffix(os.ExpandEnv("$TERM"), "-256color")
+}
@@ -0,0 +1,68 @@
+package terminal
+
+// drawing with 24-bit colors, which termbox can't do
+
+import (
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"github.com/kballard/termbox-go"
+	"os"
+)
+
+// SupportsTrueColor returns whether $COLORTERM says the terminal can show
+// 24-bit colors
+func SupportsTrueColor() bool {
+	colorterm := os.Getenv("COLORTERM")
+	return colorterm == "truecolor" || colorterm == "24bit"
+}
+
+// openTTY opens the terminal to write to alongside termbox, such as to draw
+// on. termbox draws everything else, such as the status, so the screen is
+// left blank in its buffer, and it never draws over it.
+func (b *Backend) openTTY() error {
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		termbox.Close()
+		return err
+	}
+	b.tty = tty
+	return nil
+}
+
+func (b *Backend) closeTTY() {
+	if b.tty != nil {
+		b.tty.Close()
+		b.tty = nil
+	}
+}
+
+// drawTrueColor draws a character in the given colors with escapes, which
+// are written after termbox has flushed. termbox moves the cursor before
+// drawing anything itself, so moving it here doesn't confuse it.
+func (b *Backend) drawTrueColor(x, y int, ch rune, fg, bg core.Word, alt bool) {
+	fr, fgr, fb := rgb(fg)
+	br, bgr, bb := rgb(bg)
+	fmt.Fprintf(&b.out, "\033[%d;%dH\033[38;2;%d;%d;%dm\033[48;2;%d;%d;%dm", y+1, x+1, fr, fgr, fb, br, bgr, bb)
+	if alt {
+		// the DEC special graphics, as termbox uses for AttrAltCharset
+		fmt.Fprintf(&b.out, "\033(0%c\033(B", ch)
+	} else {
+		b.out.WriteRune(ch)
+	}
+}
+
+// flushTrueColor writes what's been drawn since the last Flush, leaving the
+// default attributes, which are all termbox draws with alongside
+func (b *Backend) flushTrueColor() {
+	if b.out.Len() == 0 {
+		return
+	}
+	b.out.WriteString("\033[m")
+	b.tty.Write(b.out.Bytes())
+	b.out.Reset()
+}
+
+// rgb returns the 8-bit channels of a 0000 rrrr gggg bbbb color
+func rgb(color core.Word) (r, g, b byte) {
+	return byte(color>>8&0xf) * 0x11, byte(color>>4&0xf) * 0x11, byte(color&0xf) * 0x11
+}
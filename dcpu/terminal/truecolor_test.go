@@ -0,0 +1,18 @@
+package terminal
+
+import (
+	"testing"
+)
+
+func TestTrueColor(t *testing.T) {
+	b := New()
+	b.TrueColor = true
+	b.palette[1] = 0x0f80
+	b.SetCell(2, 1, 'A', 1, 0, false)
+	b.SetCell(3, 1, 2, 1, 0, false)
+	expected := "\033[2;3H\033[38;2;255;136;0m\033[48;2;0;0;0mA" +
+		"\033[2;4H\033[38;2;255;136;0m\033[48;2;0;0;0m\033(0w\033(B"
+	if b.out.String() != expected {
+		t.Errorf("Expected %q, found %q", expected, b.out.String())
+	}
+}
@@ -0,0 +1,133 @@
+package dcpu
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu/core"
+)
+
+// TestDevice lets programs report the results of their own tests, so DCPU
+// test suites can be run under go test with RunTest. The operation is
+// selected by A when the device is sent HWI:
+//
+//	A=0 PASS: the test passed, and the machine stops
+//	A=1 FAIL: the test failed with the code in X and the message of Z
+//	    words at Y, and the machine stops
+//	A=2 ASSERT_EQ: if B and C differ, the test fails as with FAIL
+//
+// The machine stops with ErrTestPassed or a *TestFailure as the device's
+// error, and sends a TestPassed or TestFailure event.
+type TestDevice struct{}
+
+const (
+	TestDeviceID           = 0x0deb1602
+	TestDeviceVersion      = 1
+	TestDeviceManufacturer = 0x6b62616c
+)
+
+const (
+	testPass = iota
+	testFail
+	testAssertEq
+)
+
+// ErrTestPassed is the device error the machine stops with when a test
+// passes
+var ErrTestPassed = errors.New("test passed")
+
+// TestFailure is the device error the machine stops with when a test fails.
+// It's also sent on EventsC, followed by Stopped.
+type TestFailure struct {
+	PC      core.Word
+	Code    core.Word
+	Message []core.Word
+	Values  []core.Word // the differing B and C of a failed ASSERT_EQ, or nil
+}
+
+func (f *TestFailure) Error() string {
+	msg := fmt.Sprintf("test failed with code %#04x", f.Code)
+	if len(f.Message) > 0 {
+		runes := make([]rune, len(f.Message))
+		for i, w := range f.Message {
+			runes[i] = rune(w)
+		}
+		msg += ": " + string(runes)
+	}
+	if f.Values != nil {
+		msg += fmt.Sprintf(" (%#04x != %#04x)", f.Values[0], f.Values[1])
+	}
+	return msg
+}
+
+// TestPassed is sent when the machine stops because a test passed. It's
+// followed by Stopped.
+type TestPassed struct {
+	PC core.Word
+}
+
+func (TestFailure) isEvent() {}
+func (TestPassed) isEvent()  {}
+
+func (d *TestDevice) ID() uint32           { return TestDeviceID }
+func (d *TestDevice) Version() core.Word   { return TestDeviceVersion }
+func (d *TestDevice) Manufacturer() uint32 { return TestDeviceManufacturer }
+
+func (d *TestDevice) HandleInterrupt(s *core.State) error {
+	switch s.A() {
+	case testPass:
+		return ErrTestPassed
+	case testFail:
+		return d.failure(s, nil)
+	case testAssertEq:
+		if s.B() != s.C() {
+			return d.failure(s, []core.Word{s.B(), s.C()})
+		}
+	}
+	return nil
+}
+
+func (d *TestDevice) failure(s *core.State, values []core.Word) *TestFailure {
+	message := make([]core.Word, s.Z())
+	for i := range message {
+		message[i] = s.Ram.Load(s.Y() + core.Word(i))
+	}
+	return &TestFailure{PC: s.PC(), Code: s.X(), Message: message, Values: values}
+}
+
+// TestResult returns nil if err is the error a machine stopped with when a
+// test passed, or the *TestFailure if a test failed. Other errors are
+// returned as they are.
+func TestResult(err error) error {
+	merr, ok := err.(*MachineError)
+	if !ok {
+		return err
+	}
+	derr, ok := merr.UnderlyingError.(*core.DeviceError)
+	if !ok {
+		return err
+	}
+	if derr.Err == ErrTestPassed {
+		return nil
+	}
+	if failure, ok := derr.Err.(*TestFailure); ok {
+		failure.PC = merr.PC
+		return failure
+	}
+	return err
+}
+
+// RunTest runs a paused or stopped machine with a TestDevice attached until
+// the program reports the result of its test. It returns nil if the test
+// passed, or the *TestFailure if it failed. If the program halts or limit
+// cycles are run first (with limit 0 meaning no limit), it returns an error
+// saying so.
+func RunTest(m *Machine, limit uint64) error {
+	_, err := m.RunUntil(func(s *core.State) bool { return false }, limit)
+	switch err {
+	case core.ErrHalted:
+		return errors.New("program halted without passing")
+	case core.ErrCycleLimit:
+		return errors.New("program didn't finish its test")
+	}
+	return TestResult(err)
+}
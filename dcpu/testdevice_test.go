@@ -0,0 +1,48 @@
+package dcpu
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"testing"
+)
+
+func TestTestDevice(t *testing.T) {
+	newMachine := func(program []core.Word) *Machine {
+		m, err := NewMachine(WithDevices(new(TestDevice)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.LoadProgram(program, 0); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	// SET A, 0; HWI 0
+	if err := RunTest(newMachine([]core.Word{0x8401, 0x8640}), 100); err != nil {
+		t.Errorf("Expected the test to pass, found %v", err)
+	}
+
+	// SET A, 2; SET B, 1; SET C, 2; SET X, 7; SET Y, 0x100; SET Z, 2; HWI 0
+	m := newMachine([]core.Word{0x8c01, 0x8821, 0x8c41, 0xa061, 0x7c81, 0x0100, 0x8ca1, 0x8640})
+	m.State.Ram.Store(0x100, 'h')
+	m.State.Ram.Store(0x101, 'i')
+	err := RunTest(m, 100)
+	failure, ok := err.(*TestFailure)
+	if !ok {
+		t.Fatalf("Expected a TestFailure, found %v", err)
+	}
+	if failure.Code != 7 || len(failure.Values) != 2 || failure.PC != 8 {
+		t.Errorf("Unexpected failure %#v", failure)
+	}
+	if msg := failure.Error(); msg != "test failed with code 0x0007: hi (0x0001 != 0x0002)" {
+		t.Errorf("Unexpected message %q", msg)
+	}
+	if evt, ok := errorEvent(&MachineError{&core.DeviceError{Index: 0, Err: failure}, 8, nil}).(TestFailure); !ok || evt.Code != 7 {
+		t.Errorf("Expected a TestFailure event, found %#v", evt)
+	}
+
+	// SUB PC, 1
+	if err := RunTest(newMachine([]core.Word{0x8b83}), 100); err == nil {
+		t.Error("Expected a halted program to fail")
+	}
+}
@@ -0,0 +1,180 @@
+// Package toolchain reads the object and debug symbol files of the DCPU
+// Toolchain's assembler and linker, so programs built with it can be run
+// and debugged without converting them. Its flat images are plain binaries,
+// which load as they are.
+package toolchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu/core"
+)
+
+// ObjectMagic starts an object file, followed by a NUL
+const ObjectMagic = "OBJECT-FORMAT-1.0"
+
+// The kinds of linker table entries. The table ends with labelEnd.
+const (
+	labelEnd = iota
+	labelProvided
+	labelRequired
+	labelAdjustment
+	labelSection
+	labelOutput
+	labelJump
+	labelOptional
+)
+
+// labelSize is the size of the NUL-padded label of a linker table entry
+const labelSize = 256
+
+// entrySize is the size of a linker table entry: its kind, its label and
+// its address
+const entrySize = 1 + labelSize + 2
+
+// Label is a name at an address of an object's code
+type Label struct {
+	Name    string
+	Address core.Word
+}
+
+// Object is an object file, which holds code that hasn't been linked to
+// run at an address yet. Its addresses are relative to the start of Words.
+type Object struct {
+	Words []core.Word
+	// Provided are the labels the object defines
+	Provided []Label
+	// Required are the labels the object uses but doesn't define, at the
+	// words to fill in with their addresses
+	Required []Label
+	// Optional are like Required, but are left as 0 if they aren't defined
+	Optional []Label
+	// Adjustments are the words that hold addresses within the object,
+	// which move with it
+	Adjustments []core.Word
+}
+
+// IsObject returns whether data starts like an object file
+func IsObject(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(ObjectMagic+"\x00"))
+}
+
+// ObjectCode returns the bytes of an object file's code, after its linker
+// table, such as to guess their byte order. It returns nil if data isn't a
+// well-formed object file.
+func ObjectCode(data []byte) []byte {
+	_, code, err := splitObject(data)
+	if err != nil {
+		return nil
+	}
+	return code
+}
+
+// splitObject splits an object file into its linker table and its code
+func splitObject(data []byte) (table, code []byte, err error) {
+	if !IsObject(data) {
+		return nil, nil, errors.New("not an object file")
+	}
+	rest := data[len(ObjectMagic)+1:]
+	n := 0
+	for {
+		if n >= len(rest) {
+			return nil, nil, errors.New("linker table isn't terminated")
+		}
+		if rest[n] == labelEnd {
+			break
+		}
+		if n+entrySize > len(rest) {
+			return nil, nil, fmt.Errorf("linker table entry %d is truncated", n/entrySize)
+		}
+		n += entrySize
+	}
+	return rest[:n], rest[n+1:], nil
+}
+
+// ReadObject reads an object file as written by the toolchain's assembler.
+// After the magic comes the linker table, each entry being its kind, a
+// NUL-padded 256 byte label and an address, ending with a 0 byte; then the
+// code, to the end of the file. The addresses and the code are stored in
+// the same byte order, little endian if little is set.
+func ReadObject(data []byte, little bool) (*Object, error) {
+	table, code, err := splitObject(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(code)%2 != 0 {
+		return nil, errors.New("code has an odd number of bytes")
+	}
+	obj := &Object{Words: core.DecodeBytes(code, little)}
+	for i := 0; i < len(table); i += entrySize {
+		entry := table[i : i+entrySize]
+		name := entry[1 : 1+labelSize]
+		if end := bytes.IndexByte(name, 0); end >= 0 {
+			name = name[:end]
+		}
+		label := Label{string(name), core.DecodeBytes(entry[1+labelSize:], little)[0]}
+		switch entry[0] {
+		case labelProvided:
+			obj.Provided = append(obj.Provided, label)
+		case labelRequired:
+			obj.Required = append(obj.Required, label)
+		case labelOptional:
+			obj.Optional = append(obj.Optional, label)
+		case labelAdjustment:
+			obj.Adjustments = append(obj.Adjustments, label.Address)
+		case labelSection, labelOutput, labelJump:
+			// these only matter to the linker's layout of several objects
+		default:
+			return nil, fmt.Errorf("linker table entry %d has unknown kind %d", i/entrySize, entry[0])
+		}
+	}
+	return obj, nil
+}
+
+// Link returns the object's code relocated to run at origin, with its
+// required labels filled in from those it provides, along with the
+// addresses of the labels it provides. As it's linked alone, labels it
+// requires from other objects are an error.
+func (o *Object) Link(origin core.Word) ([]core.Word, map[string]core.Word, error) {
+	if int(origin)+len(o.Words) > 0x10000 {
+		return nil, nil, core.ErrOutOfBounds
+	}
+	words := append([]core.Word(nil), o.Words...)
+	symbols := make(map[string]core.Word, len(o.Provided))
+	for _, label := range o.Provided {
+		symbols[label.Name] = origin + label.Address
+	}
+	at := func(address core.Word) (*core.Word, error) {
+		if int(address) >= len(words) {
+			return nil, fmt.Errorf("linker table address %#04x is outside the code", address)
+		}
+		return &words[address], nil
+	}
+	for _, address := range o.Adjustments {
+		word, err := at(address)
+		if err != nil {
+			return nil, nil, err
+		}
+		*word += origin
+	}
+	for _, label := range o.Required {
+		address, ok := symbols[label.Name]
+		if !ok {
+			return nil, nil, fmt.Errorf("undefined label %s", label.Name)
+		}
+		word, err := at(label.Address)
+		if err != nil {
+			return nil, nil, err
+		}
+		*word = address
+	}
+	for _, label := range o.Optional {
+		word, err := at(label.Address)
+		if err != nil {
+			return nil, nil, err
+		}
+		*word = symbols[label.Name]
+	}
+	return words, symbols, nil
+}
@@ -0,0 +1,71 @@
+package toolchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu/core"
+)
+
+// SymbolsMagic starts a debug symbol file
+const SymbolsMagic = "DSYM16"
+
+// The kinds of debug symbols
+const (
+	symbolLine = 1 + iota
+	symbolString
+	symbolLabel
+)
+
+// IsSymbols returns whether data starts like a debug symbol file
+func IsSymbols(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(SymbolsMagic))
+}
+
+// ReadSymbols reads the labels of a debug symbol file, as written by the
+// toolchain's linker. After the magic comes the number of symbols, as 4
+// bytes, then for each its kind, the length of its payload, as 2 bytes, and
+// the payload. A label's payload is its address followed by its name; a
+// line's, its address, its line number and then its file. Numbers are
+// little endian. Lines and strings are skipped, as only labels are used
+// here.
+func ReadSymbols(data []byte) (map[string]core.Word, error) {
+	if !IsSymbols(data) {
+		return nil, errors.New("not a debug symbol file")
+	}
+	r := bytes.NewReader(data[len(SymbolsMagic):])
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, errors.New("truncated header")
+	}
+	symbols := make(map[string]core.Word)
+	for i := uint32(0); i < count; i++ {
+		var header struct {
+			Kind   uint8
+			Length uint16
+		}
+		if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+			return nil, fmt.Errorf("symbol %d is truncated", i)
+		}
+		if int(header.Length) > r.Len() {
+			return nil, fmt.Errorf("symbol %d is truncated", i)
+		}
+		payload := make([]byte, header.Length)
+		r.Read(payload)
+		switch header.Kind {
+		case symbolLabel:
+			if len(payload) < 2 {
+				return nil, fmt.Errorf("symbol %d is too short for a label", i)
+			}
+			symbols[string(payload[2:])] = core.Word(binary.LittleEndian.Uint16(payload))
+		case symbolLine, symbolString:
+		default:
+			return nil, fmt.Errorf("symbol %d has unknown kind %d", i, header.Kind)
+		}
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("trailing data after %d symbols", count)
+	}
+	return symbols, nil
+}
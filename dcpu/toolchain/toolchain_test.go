@@ -0,0 +1,169 @@
+package toolchain
+
+import (
+	"encoding/binary"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"reflect"
+	"testing"
+)
+
+type entry struct {
+	kind    byte
+	label   string
+	address core.Word
+}
+
+// object builds an object file with the given linker table and code
+func object(order binary.AppendByteOrder, table []entry, code []core.Word) []byte {
+	data := append([]byte(ObjectMagic), 0)
+	for _, e := range table {
+		label := make([]byte, labelSize)
+		copy(label, e.label)
+		data = append(data, e.kind)
+		data = append(data, label...)
+		data = order.AppendUint16(data, uint16(e.address))
+	}
+	data = append(data, labelEnd)
+	for _, w := range code {
+		data = order.AppendUint16(data, uint16(w))
+	}
+	return data
+}
+
+func TestReadObject(t *testing.T) {
+	// start: SET PC, next; next: SET A, [data]; SET PC, lib; data: DAT 0
+	code := []core.Word{0x7f81, 0x0002, 0x7801, 0x0006, 0x7f81, 0x0000, 0x0000}
+	table := []entry{
+		{labelProvided, "start", 0},
+		{labelProvided, "next", 2},
+		{labelProvided, "lib", 0},
+		{labelAdjustment, "", 1},
+		{labelAdjustment, "", 3},
+		{labelRequired, "lib", 5},
+		{labelOptional, "debug", 6},
+		{labelSection, "code", 0},
+	}
+	for _, little := range []bool{false, true} {
+		var order binary.AppendByteOrder = binary.BigEndian
+		if little {
+			order = binary.LittleEndian
+		}
+		data := object(order, table, code)
+		if !IsObject(data) || IsObject(data[1:]) {
+			t.Errorf("little %v: IsObject didn't recognize the object", little)
+		}
+		obj, err := ReadObject(data, little)
+		if err != nil {
+			t.Fatalf("little %v: %v", little, err)
+		}
+		if !reflect.DeepEqual(obj.Words, code) {
+			t.Errorf("little %v: unexpected code %#x", little, obj.Words)
+		}
+		if !reflect.DeepEqual(obj.Adjustments, []core.Word{1, 3}) || len(obj.Provided) != 3 || len(obj.Required) != 1 || len(obj.Optional) != 1 {
+			t.Errorf("little %v: unexpected linker table %+v", little, obj)
+		}
+		if len(ObjectCode(data)) != len(code)*2 {
+			t.Errorf("little %v: expected %d bytes of code, found %d", little, len(code)*2, len(ObjectCode(data)))
+		}
+
+		words, symbols, err := obj.Link(0x100)
+		if err != nil {
+			t.Fatalf("little %v: %v", little, err)
+		}
+		expected := []core.Word{0x7f81, 0x0102, 0x7801, 0x0106, 0x7f81, 0x0100, 0x0000}
+		if !reflect.DeepEqual(words, expected) {
+			t.Errorf("little %v: unexpected linked code %#x", little, words)
+		}
+		if !reflect.DeepEqual(symbols, map[string]core.Word{"start": 0x100, "next": 0x102, "lib": 0x100}) {
+			t.Errorf("little %v: unexpected symbols %v", little, symbols)
+		}
+		if obj.Words[1] != 0x0002 {
+			t.Errorf("little %v: linking changed the object", little)
+		}
+	}
+}
+
+func TestReadObjectErrors(t *testing.T) {
+	code := []core.Word{0x7f81, 0x0000}
+	good := object(binary.BigEndian, []entry{{labelProvided, "start", 0}}, code)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"no magic", []byte("OBJECT-FORMAT-2.0\x00\x00")},
+		{"unterminated table", good[:len(ObjectMagic)+1+entrySize]},
+		{"truncated entry", good[:len(ObjectMagic)+1+10]},
+		{"odd code", good[:len(good)-1]},
+		{"unknown kind", object(binary.BigEndian, []entry{{42, "start", 0}}, code)},
+	}
+	for _, test := range tests {
+		if _, err := ReadObject(test.data, false); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+
+	links := []struct {
+		name   string
+		table  []entry
+		origin core.Word
+	}{
+		{"undefined label", []entry{{labelRequired, "lib", 1}}, 0},
+		{"adjustment outside the code", []entry{{labelAdjustment, "", 2}}, 0},
+		{"required label outside the code", []entry{{labelProvided, "start", 0}, {labelRequired, "start", 5}}, 0},
+		{"past the end of memory", nil, 0xffff},
+	}
+	for _, test := range links {
+		obj, err := ReadObject(object(binary.BigEndian, test.table, code), false)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if _, _, err := obj.Link(test.origin); err == nil {
+			t.Errorf("%s: expected an error linking", test.name)
+		}
+	}
+}
+
+// symbols builds a debug symbol file
+func symbols(count uint32, syms ...[]byte) []byte {
+	data := binary.LittleEndian.AppendUint32([]byte(SymbolsMagic), count)
+	for _, sym := range syms {
+		data = append(data, sym...)
+	}
+	return data
+}
+
+func symbol(kind byte, payload ...byte) []byte {
+	return append(binary.LittleEndian.AppendUint16([]byte{kind}, uint16(len(payload))), payload...)
+}
+
+func TestReadSymbols(t *testing.T) {
+	data := symbols(3,
+		symbol(symbolLabel, append([]byte{0x10, 0x00}, "loop"...)...),
+		symbol(symbolLine, append([]byte{0x10, 0x00, 0x07, 0x00}, "main.dasm"...)...),
+		symbol(symbolLabel, append([]byte{0x00, 0x80}, "screen"...)...),
+	)
+	if !IsSymbols(data) {
+		t.Error("IsSymbols didn't recognize the symbols")
+	}
+	syms, err := ReadSymbols(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(syms, map[string]core.Word{"loop": 0x10, "screen": 0x8000}) {
+		t.Errorf("Unexpected symbols %v", syms)
+	}
+
+	for name, data := range map[string][]byte{
+		"no magic":       []byte("DSYM15\x00\x00\x00\x00"),
+		"no count":       []byte(SymbolsMagic + "\x01"),
+		"missing symbol": symbols(2, symbol(symbolString, 'x')),
+		"truncated":      symbols(1, symbol(symbolString, 'x', 'y')[:4]),
+		"short label":    symbols(1, symbol(symbolLabel, 1)),
+		"unknown kind":   symbols(1, symbol(9)),
+		"trailing data":  symbols(0, symbol(symbolString)),
+	} {
+		if _, err := ReadSymbols(data); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
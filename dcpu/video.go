@@ -4,16 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"github.com/kballard/dcpu16/dcpu/core"
-	"github.com/kballard/termbox-go"
-	"os"
 	"strings"
+	"time"
 )
 
-// The display is 32x12 (128x96 pixels) surrounded by a
-// 16 pixel border / background.
-//
-// We can't handle pixels, so use a 32x12 character display, with a border
-// of one character.
+// The display is 32x12 characters (128x96 pixels), surrounded by a border.
 const (
 	windowWidth            = 32
 	windowHeight           = 12
@@ -22,44 +17,187 @@ const (
 	backgroundColorAddress = 0x0280
 )
 
+// The size of the display in characters, not counting the border
+const (
+	ScreenColumns = windowWidth
+	ScreenRows    = windowHeight
+)
+
 const DefaultScreenRefreshRate ClockRate = 60 // 60Hz
 
-var supportsXterm256 bool
-
-// colorToAnsi maps the 4-bit DCPU-16 colors to xterm-256 colors
-// We can't do an exact match, but we can get pretty close.
-// Note: color spec says +red, +green, -highlight puts the green channel
-// at 0xFF instead of 0xAA. After reading comments on the 0x10cwiki, this
-// is likely a bug, it should probably be dropped to 0x55. Also note that
-// this only holds if blue is off.
-var colorToAnsi [16]byte = [...]byte{
-	/* 0000 */ 16 /* 0001 */, 19 /* 0010 */, 34 /* 0011 */, 37,
-	/* 0100 */ 124 /* 0101 */, 127 /* 0110 */, 130 /* 0111 */, 145,
-	/* 1000 */ 59 /* 1001 */, 63 /* 1010 */, 71 /* 1011 */, 87,
-	/* 1100 */ 203 /* 1101 */, 207 /* 1110 */, 227 /* 1111 */, 231,
+// blinkPeriod is how long blinking characters are shown, and then hidden
+const blinkPeriod = 500 * time.Millisecond
+
+// VideoBackend draws the screen, such as in a terminal or a window. The
+// screen is drawn as a grid of (ScreenColumns+2)x(ScreenRows+2) cells: the
+// characters of the display, surrounded by a border one cell wide. The
+// methods are called from the goroutine running the machine.
+type VideoBackend interface {
+	// Init prepares to draw, as the machine starts
+	Init() error
+	// SetCell draws the 7-bit LEM1802 character ch at column x and row y
+	// of the grid. fg and bg are palette entries. The border is drawn as
+	// spaces. Blinking characters are blinked by drawing them in their
+	// background color every other blinkPeriod, so backends needn't blink
+	// them themselves.
+	SetCell(x, y int, ch rune, fg, bg byte, blink bool)
+	// SetPalette sets the 0000 rrrr gggg bbbb colors of the palette
+	// entries for the cells drawn afterwards
+	SetPalette(palette [16]core.Word)
+	// Flush shows the cells drawn since the last Flush. It's only called
+	// when something has been drawn.
+	Flush()
+	// Close cleans up, as the machine stops
+	Close()
 }
 
+// StatusDisplay is implemented by VideoBackends that can show the
+// machine's status, such as its registers, alongside the screen
+type StatusDisplay interface {
+	SetStatus(lines []string)
+}
+
+// FontDisplay is implemented by VideoBackends that draw characters with the
+// LEM1802's font, rather than their own
+type FontDisplay interface {
+	// SetFont sets the font for the cells drawn afterwards, as two words of
+	// 4 columns of 8 pixels per character
+	SetFont(font [256]core.Word)
+}
+
+// nullBackend draws nothing, for a Video without a Backend
+type nullBackend struct{}
+
+func (nullBackend) Init() error                                        { return nil }
+func (nullBackend) SetCell(x, y int, ch rune, fg, bg byte, blink bool) {}
+func (nullBackend) SetPalette(palette [16]core.Word)                   {}
+func (nullBackend) Flush()                                             {}
+func (nullBackend) Close()                                             {}
+
 type Video struct {
-	RefreshRate ClockRate // the refresh rate of the screen
+	RefreshRate ClockRate    // the refresh rate of the screen
+	Backend     VideoBackend // draws the screen; if nil, nothing is drawn
+	// CyclesPerSecond is the machine's clock rate, which the LEM1802's
+	// VSYNC interrupts are timed by; 0 means DefaultClockRate
+	CyclesPerSecond uint64
+
 	words       [0x400]core.Word
 	mapped      bool
+	initialized bool // the default background has been set
+	paused      bool // the machine is paused, for the status
+	blinkHidden bool // blinking characters are drawn hidden
+	// What the Backend has drawn, so only changes are drawn again
+	drawn        [windowHeight + 2][windowWidth + 2]drawnCell
+	drawnPalette [16]core.Word
+	drawnFont    [256]core.Word
+	drawnStatus  []string
+	redraw       bool // everything is drawn again, until the next Flush
+	dirty        bool // something has been drawn since the last Flush
+	// Under the 1.7 spec, the video is an LEM1802 reading from RAM instead
+	// of words
+	ram     *core.Memory
+	screen  core.Word // 0 if disconnected
+	font    core.Word // 0 for the default font
+	palette core.Word // 0 for the default palette
+	border  core.Word
+	// The VSYNC interrupts raised each frame by the LEM1802
+	vsync       core.Word // the message, or 0 if they're off
+	vsyncStart  uint64    // the cycle count they were turned on at
+	vsyncFrames uint64    // the interrupts raised since
+	// The screen has been connected since the reset, so it's blank rather
+	// than showing the boot splash while disconnected
+	connected bool
+}
+
+// drawnCell is a cell as given to the Backend's SetCell
+type drawnCell struct {
+	ch     rune
+	fg, bg byte
+	blink  bool
+}
+
+// setCell draws a cell with the Backend, unless it's already drawn
+func (v *Video) setCell(x, y int, ch rune, fg, bg byte, blink bool) {
+	cell := drawnCell{ch, fg, bg, blink}
+	if !v.redraw && v.drawn[y][x] == cell {
+		return
+	}
+	v.drawn[y][x] = cell
+	v.dirty = true
+	v.backend().SetCell(x, y, ch, fg, bg, blink)
+}
+
+// setPalette sets the palette of the Backend if it's changed, in which case
+// every cell is drawn again in the new colors
+func (v *Video) setPalette(palette [16]core.Word) {
+	if !v.redraw && v.drawnPalette == palette {
+		return
+	}
+	v.drawnPalette = palette
+	v.redraw, v.dirty = true, true
+	v.backend().SetPalette(palette)
+}
+
+// setFont sets the font of the Backend, if it's a FontDisplay and the font
+// has changed, in which case every cell is drawn again
+func (v *Video) setFont(font [256]core.Word) {
+	display, ok := v.Backend.(FontDisplay)
+	if !ok || !v.redraw && v.drawnFont == font {
+		return
+	}
+	v.drawnFont = font
+	v.redraw, v.dirty = true, true
+	display.SetFont(font)
+}
+
+// backend returns the Backend, or one that draws nothing
+func (v *Video) backend() VideoBackend {
+	if v.Backend == nil {
+		return nullBackend{}
+	}
+	return v.Backend
 }
 
 func (v *Video) Init() error {
-	if err := termbox.Init(); err != nil {
+	if err := v.backend().Init(); err != nil {
 		return err
 	}
-	// Default the background to cyan, for the heck of it
-	v.words[0x0280] = 3
+	// the Backend starts out with nothing drawn
+	v.redraw, v.dirty, v.drawnStatus = true, true, nil
+	if !v.initialized {
+		// Default the background to cyan, for the heck of it
+		v.words[0x0280] = 3
+		v.initialized = true
+	}
 
+	v.setPalette(DefaultPalette)
+	v.setFont(defaultFont)
 	v.clearDisplay()
-	v.drawBorder()
+	if v.ram == nil {
+		v.drawBorder()
+		v.drawCells()
+	}
 
 	return nil
 }
 
+// reset clears video memory, disconnects the LEM1802 and turns off its
+// interrupts, and redraws the display if it's mapped
+func (v *Video) reset() {
+	v.words = [0x400]core.Word{}
+	v.words[backgroundColorAddress] = 3
+	v.initialized = true
+	v.screen, v.font, v.palette, v.border = 0, 0, 0, 0
+	v.vsync, v.vsyncStart, v.vsyncFrames = 0, 0, 0
+	v.connected = false
+	if v.mapped && v.ram == nil {
+		v.clearDisplay()
+		v.drawBorder()
+	}
+}
+
 func (v *Video) Close() {
-	termbox.Close()
+	v.backend().Close()
 }
 
 func (v *Video) handleChange(offset core.Word) {
@@ -75,155 +213,136 @@ func (v *Video) handleChange(offset core.Word) {
 }
 
 func (v *Video) updateCell(row, column int, word core.Word) {
-	// account for the border
-	row++
-	column++
-
-	ch := rune(word & 0x7F)
-	// color seems to be in the top 2 nibbles, MSB being FG and LSB are BG
-	// Within each nibble, from LSB to MSB, is blue, green, red, highlight
-	// Lastly, the bit at 0x80 is blink.
-	flag := (word & 0x80) != 0
-	colors := byte((word & 0xFF00) >> 8)
-	fgNibble := (colors & 0xF0) >> 4
-	bgNibble := colors & 0x0F
-	fg, bg := colorToAttr(fgNibble), colorToAttr(bgNibble)
-	if flag {
-		fg |= termbox.AttrBlink
+	// Each cell is ffff bbbb Bccc cccc: the foreground and background
+	// palette entries, blink and the character. Account for the border.
+	ch := rune(word & 0x7f)
+	blink := word&0x80 != 0
+	fg, bg := byte(word>>12), byte(word>>8&0xf)
+	if blink && v.blinkHidden {
+		fg = bg
 	}
-	if ch < 32 || ch == 127 {
-		// we want to render using the alternate charset
-		// There's only 26 usable characters though, and we don't have any idea what
-		// an appropriate mapping is. So for the moment, just map them fairly arbitrarily.
-		// Except for the arrow keys, those we want to match @notch's emulator.
-		// Oddly, @notch's emulator provides a character for up arrow, which is 128, which
-		// is a 0 with the blink tag set. Based on experimentation, the video RAM does default
-		// to 0, but writing a 0 back into the same spot draws the glyph.
-		// These explicit mappings are encoded in a map table. The rest are just assigned
-		// arbitrarily.
-		if ch == 127 {
-			ch = 32
-		}
-		if glyph, ok := glyphMap[ch]; ok {
-			ch = glyph
-		} else {
-			ch = ch%26 + 'a'
-		}
-		fg |= termbox.AttrAltCharset
-	}
-	termbox.SetCell(column, row, ch, fg, bg)
-}
-
-var glyphMap = map[rune]rune{
-	0: 'm',
-	1: 'v',
-	2: 'w',
-	3: 't',
-}
-
-func colorToAttr(color byte) termbox.Attribute {
-	var attr termbox.Attribute
-	if supportsXterm256 {
-		// special-case 0 for Terminal.app.
-		// Terminal.app adjusts the foreground colors a bit so text can be distinguished
-		// from a same-colored background. We don't want this. It doesn't appear to perform
-		// this adjustment for ANSI color 0 (but it does for xterm-256 color 16).
-		if color == 0 {
-			attr = termbox.ColorBlack
-		} else {
-			// We need to use xterm-256 colors to work properly here.
-			// Luckily, we built a table!
-			attr = termbox.ColorXterm256
-			ansi := colorToAnsi[color]
-			attr |= termbox.Attribute(ansi) << termbox.XtermColorShift
-		}
-	} else {
-		// We don't seem to support xterm-256 colors, so fall back on
-		// trying to use the normal ANSI colors
-		attr = termbox.ColorDefault
-		// bold
-		if color&0x8 != 0 {
-			attr |= termbox.AttrBold
-		}
-		// cheat a bit here. We know the termbox color attributes go in the
-		// same order as the ANSI colors, and they're monotomically-incrementing.
-		// Just figure out the ANSI code and add ColorBlack
-		ansi := termbox.Attribute(0)
-		if color&0x1 != 0 {
-			// blue
-			ansi |= 0x4
-		}
-		if color&0x2 != 0 {
-			// green
-			ansi |= 0x2
-		}
-		if color&0x4 != 0 {
-			// red
-			ansi |= 0x1
-		}
-		attr |= ansi + termbox.ColorBlack
-		return attr
-	}
-	return attr
+	v.setCell(column+1, row+1, ch, fg, bg, blink)
 }
 
 func (v *Video) drawBorder() {
 	// we have no good information on the background color lookup at the moment
 	// So instead just treat the low 4 bits
-	color := byte(v.words[backgroundColorAddress] & 0xf)
-	attr := colorToAttr(color)
+	v.drawBorderColor(byte(v.words[backgroundColorAddress] & 0xf))
+}
 
+// drawBorderColor draws the border in the given palette entry
+func (v *Video) drawBorderColor(color byte) {
 	// draw top/bottom
 	for _, row := range [2]int{0, windowHeight + 1} {
 		for col := 0; col < windowWidth+2; col++ {
-			termbox.SetCell(col, row, ' ', termbox.ColorDefault, attr)
+			v.setCell(col, row, ' ', 0, color, false)
 		}
 	}
 	// draw left/right
 	for _, col := range [2]int{0, windowWidth + 1} {
 		for row := 1; row < windowHeight+1; row++ {
-			termbox.SetCell(col, row, ' ', termbox.ColorDefault, attr)
+			v.setCell(col, row, ' ', 0, color, false)
 		}
 	}
 }
 
 func (v *Video) clearDisplay() {
-	// clear all cells inside of the border
-	attr := termbox.ColorBlack
-
+	// clear all cells inside of the border to black
 	for row := 1; row <= windowHeight; row++ {
 		for col := 1; col <= windowWidth; col++ {
-			termbox.SetCell(col, row, ' ', termbox.ColorDefault, attr)
+			v.setCell(col, row, ' ', 0, 0, false)
 		}
 	}
 }
 
+// drawCells draws any characters already in video memory, such as after
+// restoring a snapshot
+func (v *Video) drawCells() {
+	for offset := core.Word(0); offset < characterRangeStart; offset++ {
+		if v.words[offset] != 0 {
+			v.handleChange(offset)
+		}
+	}
+}
+
+// Flush shows what's been drawn since the last Flush, if anything
 func (v *Video) Flush() {
-	termbox.Flush()
+	if !v.dirty {
+		return
+	}
+	v.redraw, v.dirty = false, false
+	v.backend().Flush()
 }
 
+// UpdateStats shows the registers and cycle count below the display, if
+// the Backend is a StatusDisplay
 func (v *Video) UpdateStats(state *core.State, cycleCount uint) {
-	// draw stats below the display
+	display, ok := v.Backend.(StatusDisplay)
+	if !ok {
+		return
+	}
 	// Cycles: ###########  PC: 0x####
 	// A: 0x####  B: 0x####  C: 0x####  I: 0x####
 	// X: 0x####  Y: 0x####  Z: 0x####  J: 0x####
-	// O: 0x#### SP: 0x####
-
-	row := windowHeight + 2 /* border */ + 1 /* spacing */
-	fg, bg := termbox.ColorDefault, termbox.ColorDefault
-	termbox.DrawString(1, row, fg, bg, fmt.Sprintf("Cycles: %-11d  PC: %#04x", cycleCount, state.PC()))
-	row++
-	termbox.DrawString(1, row, fg, bg, fmt.Sprintf("A: %#04x  B: %#04X  C: %#04x  I: %#04x", state.A(), state.B(), state.C(), state.I()))
-	row++
-	termbox.DrawString(1, row, fg, bg, fmt.Sprintf("X: %#04x  Y: %#04x  Z: %#04x  J: %#04x", state.X(), state.Y(), state.Z(), state.J()))
-	row++
-	termbox.DrawString(1, row, fg, bg, fmt.Sprintf("O: %#04x SP: %#04x", state.O(), state.SP()))
+	// EX: 0x#### SP: 0x#### IA: 0x####
+	// Paused
+	status := "      "
+	if v.paused {
+		status = "Paused"
+	}
+	lines := []string{
+		fmt.Sprintf("Cycles: %-11d  PC: %#04x", cycleCount, state.PC()),
+		fmt.Sprintf("A: %#04x  B: %#04X  C: %#04x  I: %#04x", state.A(), state.B(), state.C(), state.I()),
+		fmt.Sprintf("X: %#04x  Y: %#04x  Z: %#04x  J: %#04x", state.X(), state.Y(), state.Z(), state.J()),
+		fmt.Sprintf("EX: %#04x SP: %#04x IA: %#04x", state.EX(), state.SP(), state.IA()),
+		status,
+	}
+	if v.drawnStatus != nil && strings.Join(lines, "\n") == strings.Join(v.drawnStatus, "\n") {
+		return
+	}
+	v.drawnStatus = lines
+	v.dirty = true
+	display.SetStatus(lines)
+}
+
+// updateBlink hides blinking characters every other blinkPeriod, as of
+// now. The 1.7 screen is redrawn on each refresh anyway, but under the 1.1
+// spec, the blinking characters are redrawn as they change.
+func (v *Video) updateBlink(now time.Time) {
+	hidden := now.UnixNano()/int64(blinkPeriod)%2 == 1
+	if hidden == v.blinkHidden {
+		return
+	}
+	v.blinkHidden = hidden
+	if v.mapped && v.ram == nil {
+		for offset := core.Word(0); offset < characterRangeStart; offset++ {
+			if v.words[offset]&0x80 != 0 {
+				v.handleChange(offset)
+			}
+		}
+	}
+}
+
+// updatePaused sets whether the status shows the machine as paused
+func (v *Video) updatePaused(paused bool) {
+	v.paused = paused
 }
 
+// MapToMachine connects the video to the machine. Under the 1.1 spec,
+// video memory is mapped at offset. Otherwise, the video is attached as an
+// LEM1802 hardware device, and offset is ignored.
 func (v *Video) MapToMachine(offset core.Word, m *Machine) error {
 	if v.mapped {
 		return errors.New("Video is already mapped to a machine")
 	}
+	if m.State.Spec != core.Spec11 {
+		if _, err := m.State.AttachDevice(v); err != nil {
+			return err
+		}
+		v.ram = &m.State.Ram
+		v.mapped = true
+		return nil
+	}
 	get := func(offset core.Word) core.Word {
 		return v.words[offset]
 	}
@@ -232,7 +351,7 @@ func (v *Video) MapToMachine(offset core.Word, m *Machine) error {
 		v.handleChange(offset)
 		return nil
 	}
-	if err := m.State.Ram.MapRegion(offset, core.Word(len(v.words)), get, set); err != nil {
+	if err := m.State.Ram.MapRegionOwned("video", offset, core.Word(len(v.words)), get, set, nil); err != nil {
 		return err
 	}
 	v.mapped = true
@@ -243,15 +362,17 @@ func (v *Video) UnmapFromMachine(offset core.Word, m *Machine) error {
 	if !v.mapped {
 		return errors.New("Video is not mapped to a machine")
 	}
+	if v.ram != nil {
+		if err := m.State.DetachDevice(v); err != nil {
+			return err
+		}
+		v.ram = nil
+		v.mapped = false
+		return nil
+	}
 	if err := m.State.Ram.UnmapRegion(offset, core.Word(len(v.words))); err != nil {
 		return err
 	}
 	v.mapped = false
 	return nil
 }
-
-// test for xterm-256 color support
-func init() {
-	// Check $TERM for the -256color suffix
-	supportsXterm256 = strings.HasSuffix(os.ExpandEnv("$TERM"), "-256color")
-}
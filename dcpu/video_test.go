@@ -0,0 +1,179 @@
+package dcpu
+
+import (
+	"github.com/kballard/dcpu16/dcpu/core"
+	"testing"
+	"time"
+)
+
+type testCell struct {
+	ch     rune
+	fg, bg byte
+	blink  bool
+}
+
+// testBackend is a VideoBackend and StatusDisplay remembering what's drawn
+type testBackend struct {
+	cells   map[[2]int]testCell
+	palette [16]core.Word
+	status  []string
+	sets    int // the calls to SetCell
+	flushes int
+}
+
+func (b *testBackend) Init() error {
+	b.cells = make(map[[2]int]testCell)
+	return nil
+}
+
+func (b *testBackend) SetCell(x, y int, ch rune, fg, bg byte, blink bool) {
+	b.cells[[2]int{x, y}] = testCell{ch, fg, bg, blink}
+	b.sets++
+}
+
+func (b *testBackend) SetPalette(palette [16]core.Word) { b.palette = palette }
+func (b *testBackend) Flush()                           { b.flushes++ }
+func (b *testBackend) Close()                           {}
+func (b *testBackend) SetStatus(lines []string)         { b.status = lines }
+
+func TestVideoBackend(t *testing.T) {
+	backend := new(testBackend)
+	m, err := NewMachine(WithVideoBackend(backend), WithSpec(core.Spec11))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Video.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Video.MapToMachine(0x8000, m); err != nil {
+		t.Fatal(err)
+	}
+	// the 1.1 screen is drawn as it's written, inside the border
+	m.State.Ram.Store(0x8021, 0x2fc1)
+	if cell := backend.cells[[2]int{2, 2}]; cell != (testCell{'A', 2, 0xf, true}) {
+		t.Errorf("Unexpected cell %+v", cell)
+	}
+	m.State.Ram.Store(0x8280, 5)
+	if cell := backend.cells[[2]int{0, 0}]; cell.bg != 5 {
+		t.Errorf("Expected the border to be drawn in color 5, found %+v", cell)
+	}
+	if backend.palette != DefaultPalette {
+		t.Error("Expected the default palette")
+	}
+
+	m.Video.updatePaused(true)
+	m.Video.UpdateStats(&m.State, 42)
+	if len(backend.status) != 5 || backend.status[0] != "Cycles: 42           PC: 0x0000" || backend.status[4] != "Paused" {
+		t.Errorf("Unexpected status %q", backend.status)
+	}
+}
+
+func TestLEM1802Backend(t *testing.T) {
+	backend := new(testBackend)
+	m, err := NewMachine(WithVideoBackend(backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Video.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Video.MapToMachine(0x8000, m); err != nil {
+		t.Fatal(err)
+	}
+	m.State.Ram.Store(0x1000, 0x41)
+	m.State.Ram.Store(0x2003, 0x123)
+	hwi := func(a, b core.Word) {
+		m.State.SetA(a)
+		m.State.SetB(b)
+		m.Video.HandleInterrupt(&m.State)
+	}
+	hwi(lemMapScreen, 0x1000)
+	hwi(lemMapPalette, 0x2000)
+	hwi(lemSetBorderColor, 3)
+	m.Video.refresh()
+	if cell := backend.cells[[2]int{1, 1}]; cell != (testCell{'A', 0, 0, false}) {
+		t.Errorf("Unexpected cell %+v", cell)
+	}
+	if cell := backend.cells[[2]int{33, 13}]; cell.bg != 3 {
+		t.Errorf("Expected the border to be drawn in color 3, found %+v", cell)
+	}
+	if backend.palette[3] != 0x123 {
+		t.Errorf("Expected the mapped palette, found %v", backend.palette)
+	}
+}
+
+func TestBlink(t *testing.T) {
+	backend := new(testBackend)
+	m, err := NewMachine(WithVideoBackend(backend), WithSpec(core.Spec11))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Video.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Video.MapToMachine(0x8000, m); err != nil {
+		t.Fatal(err)
+	}
+	m.State.Ram.Store(0x8000, 0x2fc1)
+	m.State.Ram.Store(0x8001, 0x2f42)
+	// blinking characters are hidden in the background color for every
+	// other blinkPeriod
+	m.Video.updateBlink(time.Unix(0, int64(blinkPeriod)))
+	if cell := backend.cells[[2]int{1, 1}]; cell != (testCell{'A', 0xf, 0xf, true}) {
+		t.Errorf("Expected the blinking cell to be hidden, found %+v", cell)
+	}
+	if cell := backend.cells[[2]int{2, 1}]; cell != (testCell{'B', 2, 0xf, false}) {
+		t.Errorf("Expected the steady cell to be shown, found %+v", cell)
+	}
+	m.Video.updateBlink(time.Unix(0, 2*int64(blinkPeriod)))
+	if cell := backend.cells[[2]int{1, 1}]; cell != (testCell{'A', 2, 0xf, true}) {
+		t.Errorf("Expected the blinking cell to be shown again, found %+v", cell)
+	}
+}
+
+func TestDirtyCells(t *testing.T) {
+	backend := new(testBackend)
+	m, err := NewMachine(WithVideoBackend(backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Video.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Video.MapToMachine(0x8000, m); err != nil {
+		t.Fatal(err)
+	}
+	m.State.Ram.Store(0x1000, 0xf141)
+	m.State.SetA(lemMapScreen)
+	m.State.SetB(0x1000)
+	m.Video.HandleInterrupt(&m.State)
+	m.Video.refresh()
+	m.Video.Flush()
+	sets, flushes := backend.sets, backend.flushes
+	if flushes != 1 {
+		t.Errorf("Expected 1 flush, found %d", flushes)
+	}
+
+	// an unchanged screen isn't drawn or flushed
+	m.Video.refresh()
+	m.Video.Flush()
+	if backend.sets != sets || backend.flushes != flushes {
+		t.Errorf("Expected nothing to be drawn, found %d cells and %d flushes", backend.sets-sets, backend.flushes-flushes)
+	}
+	// only the changed cell is drawn
+	m.State.Ram.Store(0x1001, 0xf142)
+	m.Video.refresh()
+	m.Video.Flush()
+	if backend.sets != sets+1 || backend.flushes != flushes+1 {
+		t.Errorf("Expected 1 cell to be drawn, found %d cells and %d flushes", backend.sets-sets, backend.flushes-flushes)
+	}
+	// a new palette draws everything again
+	m.State.SetA(lemMapPalette)
+	m.State.SetB(0x2000)
+	m.Video.HandleInterrupt(&m.State)
+	sets = backend.sets
+	m.Video.refresh()
+	if drawn := backend.sets - sets; drawn != (windowWidth+2)*(windowHeight+2) {
+		t.Errorf("Expected every cell to be drawn, found %d", drawn)
+	}
+}
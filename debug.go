@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"github.com/kballard/dcpu16/dcpu/debug"
+	"strconv"
+	"strings"
+)
+
+// watchList is a flag.Value that collects watchpoints of the form
+// addr[+length][:r|w|rw]. Watchpoints default to watching writes.
+type watchList []core.Watchpoint
+
+func (w *watchList) String() string {
+	strs := make([]string, len(*w))
+	for i, wp := range *w {
+		kind := map[core.WatchKind]string{core.WatchRead: "r", core.WatchWrite: "w", core.WatchReadWrite: "rw"}[wp.Kind]
+		strs[i] = fmt.Sprintf("%#04x+%d:%s", wp.Start, wp.Length, kind)
+	}
+	return strings.Join(strs, ",")
+}
+
+func (w *watchList) Set(str string) error {
+	wp := core.Watchpoint{Region: core.Region{Length: 1}, Kind: core.WatchWrite}
+	if i := strings.LastIndex(str, ":"); i >= 0 {
+		switch strings.ToLower(str[i+1:]) {
+		case "r":
+			wp.Kind = core.WatchRead
+		case "w":
+			wp.Kind = core.WatchWrite
+		case "rw", "wr":
+			wp.Kind = core.WatchReadWrite
+		default:
+			return fmt.Errorf("unknown watch kind %#v", str[i+1:])
+		}
+		str = str[:i]
+	}
+	if i := strings.Index(str, "+"); i >= 0 {
+		length, err := strconv.ParseUint(str[i+1:], 0, 16)
+		if err != nil || length == 0 {
+			return fmt.Errorf("invalid watch length %#v", str[i+1:])
+		}
+		wp.Length = core.Word(length)
+		str = str[:i]
+	}
+	start, err := strconv.ParseUint(str, 0, 16)
+	if err != nil {
+		return fmt.Errorf("invalid watch address %#v", str)
+	}
+	wp.Start = core.Word(start)
+	*w = append(*w, wp)
+	return nil
+}
+
+// breakList is a flag.Value that collects breakpoints of the form
+// addr[:condition]. They're parsed once the program is loaded, so both
+// the address and the condition may refer to symbols.
+type breakList []string
+
+func (b *breakList) String() string {
+	return strings.Join(*b, ",")
+}
+
+func (b *breakList) Set(str string) error {
+	if str == "" {
+		return errors.New("empty breakpoint")
+	}
+	*b = append(*b, str)
+	return nil
+}
+
+// addBreakpoints parses the breakpoints and adds them to the state
+func (b breakList) addBreakpoints(s *core.State, symbols map[string]core.Word) error {
+	for _, str := range b {
+		addrStr, condStr := str, ""
+		if i := strings.Index(str, ":"); i >= 0 {
+			addrStr, condStr = str[:i], str[i+1:]
+		}
+		addr, err := debug.ParseExpr(addrStr, symbols)
+		if err != nil {
+			return fmt.Errorf("breakpoint %s: %v", str, err)
+		}
+		var condition func(*core.State) bool
+		if condStr != "" {
+			cond, err := debug.ParseExpr(condStr, symbols)
+			if err != nil {
+				return fmt.Errorf("breakpoint %s: %v", str, err)
+			}
+			condition = cond.Test
+		}
+		s.AddBreakpoint(addr.Eval(s), condition)
+	}
+	return nil
+}
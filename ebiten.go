@@ -0,0 +1,114 @@
+//go:build ebiten
+
+package main
+
+// the Ebiten frontend, built with -tags ebiten
+
+import (
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/kballard/dcpu16/dcpu"
+)
+
+// ebitenScale is how many pixels of the window each pixel of the screen
+// starts out as. The window can be resized.
+const ebitenScale = 4
+
+var ebitenCommands = map[ebiten.Key]command{
+	ebiten.KeyC: commandQuit,
+	ebiten.KeyR: commandReset,
+	ebiten.KeyS: commandSlower,
+	ebiten.KeyF: commandFaster,
+	ebiten.KeyT: commandScreenshot,
+	ebiten.KeyP: commandPause,
+}
+
+var keymapEbitenKeyToRune = map[ebiten.Key]rune{
+	ebiten.KeyEnter:       '\n',
+	ebiten.KeyNumpadEnter: '\n',
+	ebiten.KeyBackspace:   0x08,
+	ebiten.KeyDelete:      127,
+}
+
+var keymapEbitenKeyToKey = map[ebiten.Key]dcpu.Key{
+	ebiten.KeyArrowUp:    dcpu.KeyArrowUp,
+	ebiten.KeyArrowDown:  dcpu.KeyArrowDown,
+	ebiten.KeyArrowLeft:  dcpu.KeyArrowLeft,
+	ebiten.KeyArrowRight: dcpu.KeyArrowRight,
+}
+
+func init() {
+	frontends["ebiten"] = runEbiten
+}
+
+// ebitenGame is the ebiten.Game showing a machine's screen
+type ebitenGame struct {
+	machine  *dcpu.Machine
+	fb       *dcpu.Framebuffer
+	commands chan<- command
+	done     <-chan struct{}
+	keys     []ebiten.Key
+	chars    []rune
+}
+
+func runEbiten(machine *dcpu.Machine, fb *dcpu.Framebuffer, commands chan<- command, done <-chan struct{}) error {
+	ebiten.SetWindowTitle("DCPU-16")
+	ebiten.SetWindowSize(dcpu.FrameWidth*ebitenScale, dcpu.FrameHeight*ebitenScale)
+	ebiten.SetWindowResizingMode(ebiten.WindowResizingModeEnabled)
+	// closing the window quits like ^C, so everything is saved
+	ebiten.SetWindowClosingHandled(true)
+	return ebiten.RunGame(&ebitenGame{machine: machine, fb: fb, commands: commands, done: done})
+}
+
+func (g *ebitenGame) send(cmd command) {
+	select {
+	case g.commands <- cmd:
+	case <-g.done:
+	}
+}
+
+func (g *ebitenGame) Update() error {
+	select {
+	case <-g.done:
+		return ebiten.Termination
+	default:
+	}
+	if ebiten.IsWindowBeingClosed() {
+		g.send(commandQuit)
+		return ebiten.Termination
+	}
+	ctrl := ebiten.IsKeyPressed(ebiten.KeyControl)
+	g.keys = inpututil.AppendJustPressedKeys(g.keys[:0])
+	for _, key := range g.keys {
+		if ctrl {
+			if cmd, ok := ebitenCommands[key]; ok {
+				g.send(cmd)
+			}
+		} else if r, ok := keymapEbitenKeyToRune[key]; ok {
+			typeKey(g.machine, r)
+		} else if k, ok := keymapEbitenKeyToKey[key]; ok {
+			pressKey(g.machine, k)
+		}
+	}
+	if !ctrl {
+		g.chars = ebiten.AppendInputChars(g.chars[:0])
+		for _, ch := range g.chars {
+			if ch < 0x80 {
+				typeKey(g.machine, ch)
+			}
+		}
+	}
+	return nil
+}
+
+func (g *ebitenGame) Draw(screen *ebiten.Image) {
+	if frame := g.fb.Frame(); frame != nil {
+		screen.WritePixels(frame.Pix)
+	}
+}
+
+// Layout keeps the screen at its own size, which ebiten scales to fit the
+// window
+func (g *ebitenGame) Layout(outsideWidth, outsideHeight int) (int, int) {
+	return dcpu.FrameWidth, dcpu.FrameHeight
+}
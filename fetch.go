@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxDownload is the largest program that's downloaded from a URL. It's
+// generous enough for assembly source as well as binaries.
+const maxDownload = 4 << 20
+
+// downloadTimeout bounds how long downloading a program can take
+const downloadTimeout = 30 * time.Second
+
+// isURL returns whether the program is to be downloaded
+func isURL(program string) bool {
+	return strings.HasPrefix(program, "http://") || strings.HasPrefix(program, "https://")
+}
+
+// fetchProgram downloads the program at rawurl into the cache, unless it's
+// already there or -refetch was given, and returns the path of the cached
+// copy. The copy keeps the extension of the URL's path, so it's loaded the
+// same way as a local file would be.
+func fetchProgram(rawurl string) (string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "dcpu16")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	cached := filepath.Join(dir, fmt.Sprintf("%x%s", sha256.Sum256([]byte(rawurl)), path.Ext(u.Path)))
+	if _, err := os.Stat(cached); err == nil && !*refetch {
+		return cached, nil
+	}
+
+	client := &http.Client{Timeout: downloadTimeout}
+	resp, err := client.Get(rawurl)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", rawurl, resp.Status)
+	}
+	if resp.ContentLength > maxDownload {
+		return "", fmt.Errorf("%s: the program is larger than %d bytes", rawurl, maxDownload)
+	}
+	// write to a temporary file first, so a failed download isn't cached
+	f, err := ioutil.TempFile(dir, "download")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	n, err := io.Copy(f, io.LimitReader(resp.Body, maxDownload+1))
+	if err == nil && n > maxDownload {
+		err = fmt.Errorf("%s: the program is larger than %d bytes", rawurl, maxDownload)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(f.Name(), cached); err != nil {
+		return "", err
+	}
+	return cached, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"github.com/kballard/dcpu16/dcpu"
+	"runtime"
+	"time"
+)
+
+// command is something the user asks of the emulator with a control key,
+// rather than typing it to the program
+type command int
+
+const (
+	commandQuit       command = iota // ^C
+	commandReset                     // ^R
+	commandSlower                    // ^S
+	commandFaster                    // ^F
+	commandScreenshot                // ^T
+	commandPause                     // ^P
+	commandNextScreen                // ^N, in the terminal
+)
+
+// frontend shows the screen of a machine, rendered by fb, in a window or a
+// browser, and passes typed keys to its keyboard, until done is closed. It
+// sends control keys, and closing the window as a commandQuit, to commands. It runs on the
+// main goroutine, which is locked to the main thread, as windowing libraries
+// need.
+type frontend func(machine *dcpu.Machine, fb *dcpu.Framebuffer, commands chan<- command, done <-chan struct{}) error
+
+// frontends are the graphical frontends for -frontend, besides the terminal,
+// which register themselves here in init. Those needing libraries we don't
+// want everyone to need are only built with their build tag.
+var frontends = map[string]frontend{}
+
+func init() {
+	// windowing libraries need their events handled on the main thread
+	runtime.LockOSThread()
+}
+
+// typedKeys are the keys typed in the terminal or a frontend, which main
+// adds to the keyboard's sources
+var typedKeys = new(dcpu.KeyQueue)
+
+// typeKey passes a typed character to the keyboard, unless it isn't being
+// read because the inputs are replayed
+func typeKey(machine *dcpu.Machine, ch rune) {
+	if machine.Replay == nil {
+		typedKeys.TypeKey(ch)
+	}
+}
+
+// pasteText types pasted text into the keyboard at its own pace, unless
+// it isn't being read
+func pasteText(machine *dcpu.Machine, text string) {
+	if machine.Replay == nil {
+		machine.Keyboard.Paste(text)
+	}
+}
+
+// pressKey presses and releases a key of the keyboard, unless it isn't being
+// read
+func pressKey(machine *dcpu.Machine, key dcpu.Key) {
+	if machine.Replay == nil {
+		typedKeys.PressKey(key)
+	}
+}
+
+// terminalRepeatGap is the longest a terminal takes between the repeats of
+// a held key; when it's gone longer, the key has been let go
+const terminalRepeatGap = 150 * time.Millisecond
+
+// keyHolder holds the keys typed in the terminal, so the keyboard repeats
+// them at its own steady pace. Terminals only report keys being typed, and
+// repeated, so a key is held for first, which should cover the terminal's
+// delay before it repeats the key, then for as long as it keeps repeating.
+type keyHolder struct {
+	machine *dcpu.Machine
+	first   time.Duration
+	held    heldKey
+	timer   *time.Timer // fires when the held key is let go; nil if none
+}
+
+// heldKey is a key typed, or if ch is 0 pressed, in the terminal
+type heldKey struct {
+	ch  rune
+	key dcpu.Key
+}
+
+func (h *keyHolder) holdTyped(ch rune) {
+	h.hold(heldKey{ch: ch})
+}
+
+func (h *keyHolder) holdPressed(key dcpu.Key) {
+	h.hold(heldKey{key: key})
+}
+
+func (h *keyHolder) hold(key heldKey) {
+	if h.machine.Replay != nil {
+		return
+	}
+	wait := terminalRepeatGap
+	if h.timer == nil || key != h.held {
+		// it's newly typed, rather than the terminal repeating it
+		if key.ch != 0 {
+			h.machine.Keyboard.HoldKeyTyped(key.ch)
+		} else {
+			h.machine.Keyboard.HoldKeyPressed(key.key)
+		}
+		wait = h.first
+	}
+	h.held = key
+	if h.timer == nil {
+		h.timer = time.NewTimer(wait)
+		return
+	}
+	if !h.timer.Stop() {
+		<-h.timer.C
+	}
+	h.timer.Reset(wait)
+}
+
+// released fires when the held key is let go, and release must then be
+// called. It's nil if h is.
+func (h *keyHolder) released() <-chan time.Time {
+	if h == nil || h.timer == nil {
+		return nil
+	}
+	return h.timer.C
+}
+
+func (h *keyHolder) release() {
+	h.machine.Keyboard.ReleaseHeldKey()
+	h.timer = nil
+}
@@ -3,23 +3,78 @@ package main
 // remap keys from termbox
 
 import (
+	"fmt"
 	"github.com/kballard/dcpu16/dcpu"
+	"github.com/kballard/dcpu16/dcpu/terminal"
 	"github.com/kballard/termbox-go"
+	"os"
+	"path/filepath"
 )
 
-var keymapTermboxKeyToRune = map[termbox.Key]rune{
-	termbox.KeyDelete: 127,
-	termbox.KeySpace:  0x20,
+// termboxKeymap maps the keys typed in the terminal
+var termboxKeymap = &terminal.Keymap{
+	KeyToRune: map[termbox.Key]rune{
+		termbox.KeyDelete: 127,
+		termbox.KeySpace:  0x20,
+	},
+	KeyToKey: map[termbox.Key]dcpu.Key{
+		termbox.KeyArrowUp:    dcpu.KeyArrowUp,
+		termbox.KeyArrowDown:  dcpu.KeyArrowDown,
+		termbox.KeyArrowLeft:  dcpu.KeyArrowLeft,
+		termbox.KeyArrowRight: dcpu.KeyArrowRight,
+	},
+	KeyToCommand: map[termbox.Key]string{
+		termbox.KeyCtrlC: "quit",
+		termbox.KeyCtrlR: "reset",
+		termbox.KeyCtrlS: "slower",
+		termbox.KeyCtrlF: "faster",
+		termbox.KeyCtrlT: "screenshot",
+		termbox.KeyCtrlP: "pause",
+		termbox.KeyCtrlN: "nextScreen",
+	},
+	RuneToRune: map[rune]rune{
+		'\x7F': '\x08', // fix delete on OS X
+		'\x0D': '\x0A', // fix return on OS X
+	},
 }
 
-var keymapTermboxKeyToKey = map[termbox.Key]dcpu.Key{
-	termbox.KeyArrowUp:    dcpu.KeyArrowUp,
-	termbox.KeyArrowDown:  dcpu.KeyArrowDown,
-	termbox.KeyArrowLeft:  dcpu.KeyArrowLeft,
-	termbox.KeyArrowRight: dcpu.KeyArrowRight,
+// commandNames are the names of the commands in keymap files
+var commandNames = map[string]command{
+	"quit":       commandQuit,
+	"reset":      commandReset,
+	"slower":     commandSlower,
+	"faster":     commandFaster,
+	"screenshot": commandScreenshot,
+	"pause":      commandPause,
+	"nextScreen": commandNextScreen,
 }
 
-var keymapRuneToRune = map[rune]rune{
-	'\x7F': '\x08', // fix delete on OS X
-	'\x0D': '\x0A', // fix return on OS X
+func init() {
+	for name := range commandNames {
+		termboxKeymap.Commands = append(termboxKeymap.Commands, name)
+	}
+}
+
+// loadKeymap reads the keymap file at path, or if path is empty, the
+// user's keymap under their config directory, if they have one
+func loadKeymap(path string) error {
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil
+		}
+		path = filepath.Join(dir, "dcpu16", "keymap")
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := termboxKeymap.Read(f); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
 }
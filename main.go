@@ -1,114 +1,1189 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/kballard/dcpu16/dcpu"
+	"github.com/kballard/dcpu16/dcpu/asm"
 	"github.com/kballard/dcpu16/dcpu/core"
+	"github.com/kballard/dcpu16/dcpu/debug"
+	"github.com/kballard/dcpu16/dcpu/disasm"
+	"github.com/kballard/dcpu16/dcpu/ihex"
+	"github.com/kballard/dcpu16/dcpu/terminal"
+	"github.com/kballard/dcpu16/dcpu/toolchain"
 	"github.com/kballard/termbox-go"
+	"io"
 	"io/ioutil"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
+// maxClockRate bounds the rate ^F can speed up to
+const maxClockRate dcpu.ClockRate = 100e6 // 100MHz
+
 var requestedRate dcpu.ClockRate = dcpu.DefaultClockRate
 var printRate *bool = flag.Bool("printRate", false, "Print the effective clock rate at termination")
 var screenRefreshRate dcpu.ClockRate = dcpu.DefaultScreenRefreshRate
-var littleEndian *bool = flag.Bool("littleEndian", false, "Interpret the input file as little endian")
+var binaryOrder byteOrder
+var drawMode terminalMode
+var glyphsFile *string = flag.String("glyphs", "", "Draw the LEM1802 characters in the given file, such as the control characters, as the glyphs it maps them to")
+var keymapFile *string = flag.String("keymap", "", "Read the terminal's key mappings from the given file, rather than dcpu16/keymap under the user's config directory if it exists")
+var pasteRate *int = flag.Int("pasteRate", 100, "The keys a second to type text pasted into the terminal at, in the machine's time")
+var repeatDelay *time.Duration = flag.Duration("repeatDelay", 500*time.Millisecond, "How long a key is held in the terminal before it repeats, in the machine's time (with -repeatRate)")
+var repeatRate *int = flag.Int("repeatRate", 0, "The keys a second a key held in the terminal repeats at, in the machine's time; 0 leaves repeating to the terminal")
+var trueColor *string = flag.String("trueColor", "auto", "Draw the palette's exact colors in the terminal: on, off, or auto if $COLORTERM says it can")
+var littleEndian *bool = flag.Bool("littleEndian", false, "Interpret the input file as little endian, like -byteOrder little")
+var specVersion core.SpecVersion
+var strictDivide *bool = flag.Bool("strictDivide", false, "Halt the machine on division by zero")
+var invalidOpcode core.OpcodePolicy
+var selfModify core.SelfModifyPolicy
+var listingFile *string = flag.String("listing", "", "Write an assembly listing to the given file")
+var symbolsFile *string = flag.String("symbols", "", "Write the assembled symbol map to the given file")
+var watchpoints watchList
+var breakpoints breakList
+var traceFile *string = flag.String("trace", "", "Write a trace of every executed instruction to the given file")
+var traceLast *int = flag.Int("traceLast", 0, "Print the last N executed instructions when the machine halts with an error")
+var recordFile *string = flag.String("record", "", "Record the machine's inputs to the given file, or the screen to a .cast (asciinema) or .gif file")
+var replayFile *string = flag.String("replay", "", "Replay the machine's inputs from the given file, ignoring the keyboard")
+var inputFile *string = flag.String("input", "", "Type the keys of the given script at the times it gives, alongside the keyboard")
+var saveStateFile *string = flag.String("save-state", "", "Save the machine's state to the given file when it stops")
+var profileFile *string = flag.String("profile", "", "Write a report of the busiest addresses to the given file when the machine stops")
+var profileTop *int = flag.Int("profileTop", 20, "The number of addresses to include in the -profile report")
+var coverageFile *string = flag.String("coverage", "", "Write the ranges of executed addresses to the given file when the machine stops")
+var accessStatsFile *string = flag.String("accessStats", "", "Write a histogram of memory reads and writes to the given file when the machine stops")
+var accessBucket *int = flag.Int("accessBucket", 0x100, "The number of words per -accessStats bucket (a power of 2)")
+var debugConsole *bool = flag.Bool("console", false, "Attach a debug console that prints the characters the program sends it to stderr")
+var testDevice *bool = flag.Bool("test", false, "Attach a test device, and exit with the result of the program's test (requires -headless)")
+var debugDevice *bool = flag.Bool("debugDevice", false, "Attach a debug device that lets the program checksum memory")
+var genericClock *bool = flag.Bool("clock", false, "Attach a Generic Clock device")
+var realTimeClock *bool = flag.Bool("rtc", false, "Attach a real-time clock device")
+var rtcTime *string = flag.String("rtcTime", "", "Freeze the -rtc at the given RFC 3339 time")
+var rtcOffset *time.Duration = flag.Duration("rtcOffset", 0, "Offset the time reported by the -rtc")
+var monitors *int = flag.Int("monitors", 0, "Attach the given number of extra LEM1802 monitors, drawn beside the screen")
+var dmaRate *int = flag.Int("dma", 0, "Attach a DMA controller copying the given number of words per cycle")
+var printerFile *string = flag.String("printer", "", "Attach a line printer appending to the given file")
+var bankPages *int = flag.Int("banks", 0, "Attach a bank-switching memory controller with the given number of 4K-word pages")
+var headless *bool = flag.Bool("headless", false, "Run without a screen, e.g. to talk to the program over -serial")
+var screenDump *string = flag.String("screenDump", "", "With -headless, attach a screen and write its text to the given file (- for stdout) as it changes")
+var screenDumpInterval *time.Duration = flag.Duration("screenDumpInterval", time.Second, "The least time between the -screenDump's screens")
+var frontendName *string = flag.String("frontend", "terminal", "Show the screen in the terminal, in browsers (web), or in a window with a frontend built in by its build tag (sdl or ebiten)")
+var serialPort *string = flag.String("serial", "", "Attach a serial port connected to stdio (with -headless), or listening on a TCP address")
+var radioAddr *string = flag.String("radio", "", "Attach a packet radio receiving on the given UDP address")
+var radioPeers *string = flag.String("radioPeers", "", "The comma-separated UDP addresses the -radio sends to")
+var speaker *bool = flag.Bool("speaker", false, "Attach a speaker, which rings the terminal bell for each tone")
+var floppyFile *string = flag.String("floppy", "", "Attach an M35FD floppy drive with the given disk image inserted")
+var floppyProtected *bool = flag.Bool("floppyProtected", false, "Write protect the -floppy disk")
+var mediaFile *string = flag.String("media", "", "Attach an HMD2043 media drive with the given disk image inserted")
+var mediaLocked *bool = flag.Bool("mediaLocked", false, "Write lock the -media disk")
+var nvramFile *string = flag.String("nvram", "", "Attach an NVRAM kept in the given file, which is created if needed")
+var nvramSize *int = flag.Int("nvramSize", dcpu.DefaultNVRAMSize, "The size of the -nvram in words")
+var hostFSRoot *string = flag.String("hostfs", "", "Attach a non-standard device giving the program access to the files under the given directory")
+var mapFile *string = flag.String("map", "", "Read the program's symbols from the given map file, as written by -symbols, or a DCPU Toolchain debug symbol file")
+var refetch *bool = flag.Bool("refetch", false, "Download a program given as a URL again, rather than using the cached copy")
+var programOrigin *uint = flag.Uint("org", 0, "The address to load the program at")
+var segments segmentList
+var exportFile *string = flag.String("export", "", "Write the loaded program to the given .go, .c or .h file as an array, and exit")
+var exportName *string = flag.String("exportName", "program", "The name of the -export array")
+var exportRange region
+var loadStateFile *string = flag.String("load-state", "", "Resume the machine from the given save state; the program is optional")
 
 func main() {
 	// command-line flags
-	flag.Var(&requestedRate, "rate", "Clock rate to run the machine at")
+	flag.Var(&requestedRate, "rate", "Clock rate to run the machine at, or max to run as fast as possible")
 	flag.Var(&screenRefreshRate, "screenRefreshRate", "Clock rate to refresh the screen at")
+	flag.Var(&drawMode, "draw", "How the terminal draws the screen: cells, or its pixels with halfblocks or braille")
+	flag.Var(&binaryOrder, "byteOrder", "Byte order of binary programs (big, little, or auto to guess)")
+	flag.Var(&specVersion, "spec", "DCPU-16 spec version to run the program with (1.1 or 1.7)")
+	flag.Var(&invalidOpcode, "invalidOpcode", "How to handle invalid opcodes (halt, nop or interrupt)")
+	flag.Var(&selfModify, "selfModify", "How to handle writes to executed addresses (ignore, log or pause)")
+	flag.Var(&watchpoints, "watch", "Stop when memory is accessed, as addr[+length][:r|w|rw] (may be repeated)")
+	flag.Var(&segments, "load", "Also load a binary file at an address, as file@addr (may be repeated)")
+	flag.Var(&exportRange, "exportRange", "The memory to -export, as addr+length; defaults to the loaded program")
+	flag.Var(&breakpoints, "break", "Stop before executing addr, as addr[:condition] (may be repeated)")
 	// update usage
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "usage: %s [flags] program\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] - < program\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] -load file@addr [program]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] -load-state file [program]\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 	flag.Parse()
-	if flag.NArg() != 1 {
+	if *littleEndian {
+		binaryOrder = orderLittle
+	}
+	if flag.NArg() > 1 || (flag.NArg() == 0 && *loadStateFile == "" && len(segments) == 0) {
 		flag.Usage()
 		os.Exit(2)
 	}
-	program := flag.Arg(0)
-	data, err := ioutil.ReadFile(program)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+	if *programOrigin > 0xffff {
+		fmt.Fprintln(os.Stderr, "-org must be an address below 0x10000")
+		os.Exit(2)
+	}
+	var words []core.Word
+	var symbols asm.Symbols
+	if flag.NArg() == 1 {
+		var err error
+		if words, symbols, err = loadProgram(flag.Arg(0), core.Word(*programOrigin)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 	}
-	// Interpret the file as Words
-	words := make([]core.Word, len(data)/2)
-	for i := 0; i < len(data)/2; i++ {
-		b1, b2 := core.Word(data[i*2]), core.Word(data[i*2+1])
-		var w core.Word
-		if *littleEndian {
-			w = b2<<8 + b1
+
+	if *mapFile != "" {
+		data, err := ioutil.ReadFile(*mapFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if toolchain.IsSymbols(data) {
+			symbols, err = toolchain.ReadSymbols(data)
 		} else {
-			w = b1<<8 + b2
+			symbols, err = asm.ReadSymbols(bytes.NewReader(data))
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *mapFile, err)
+			os.Exit(1)
 		}
-		words[i] = w
 	}
 
 	// Set up a machine
-	machine := new(dcpu.Machine)
-	machine.Video.RefreshRate = screenRefreshRate
-	if err := machine.State.LoadProgram(words, 0); err != nil {
+	options := []dcpu.Option{
+		dcpu.WithClockRate(requestedRate),
+		dcpu.WithRefreshRate(screenRefreshRate),
+		dcpu.WithSpec(specVersion),
+	}
+	window, graphical := frontends[*frontendName]
+	var framebuffer *dcpu.Framebuffer
+	var screen *terminal.Backend
+	if *frontendName != "terminal" && !graphical {
+		fmt.Fprintf(os.Stderr, "Unknown -frontend %s; graphical frontends are only built with their build tag, as in go build -tags %s\n", *frontendName, *frontendName)
+		os.Exit(1)
+	} else if *headless {
+		if graphical {
+			fmt.Fprintln(os.Stderr, "-frontend can't be used with -headless")
+			os.Exit(1)
+		}
+		if *screenDump != "" {
+			var w io.Writer = os.Stdout
+			if *screenDump != "-" {
+				f, err := os.Create(*screenDump)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+				defer f.Close()
+				w = f
+			}
+			options = append(options, dcpu.WithVideoBackend(&dcpu.Capture{DumpTo: w, DumpInterval: *screenDumpInterval}))
+		}
+	} else if *screenDump != "" {
+		fmt.Fprintln(os.Stderr, "-screenDump requires -headless")
+		os.Exit(1)
+	} else if graphical {
+		framebuffer = new(dcpu.Framebuffer)
+		options = append(options, dcpu.WithVideoBackend(framebuffer))
+	} else {
+		screen = terminal.NewMode(terminal.Mode(drawMode))
+		switch *trueColor {
+		case "auto":
+			screen.TrueColor = terminal.SupportsTrueColor()
+		case "on", "off":
+			screen.TrueColor = *trueColor == "on"
+		default:
+			fmt.Fprintf(os.Stderr, "unknown -trueColor %#v\n", *trueColor)
+			os.Exit(1)
+		}
+		if *glyphsFile != "" {
+			f, err := os.Open(*glyphsFile)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			screen.Glyphs, err = terminal.ReadGlyphMap(f)
+			f.Close()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", *glyphsFile, err)
+				os.Exit(1)
+			}
+		}
+		screen.BracketedPaste = true
+		if err := loadKeymap(*keymapFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		options = append(options, dcpu.WithVideoBackend(screen))
+	}
+	if *monitors > 0 {
+		if graphical {
+			fmt.Fprintln(os.Stderr, "-monitors needs the terminal frontend")
+			os.Exit(1)
+		}
+		// headless machines attach them without drawing them
+		backends := make([]dcpu.VideoBackend, *monitors)
+		if screen != nil {
+			for i := range backends {
+				backends[i] = screen.Monitor()
+			}
+		}
+		options = append(options, dcpu.WithMonitors(backends...))
+	}
+	if *genericClock {
+		clock := new(dcpu.GenericClock)
+		if requestedRate != dcpu.Unthrottled {
+			// keep the clock in step with real time
+			clock.CyclesPerSecond = uint64(requestedRate)
+		}
+		options = append(options, dcpu.WithDevices(clock))
+	}
+	if *dmaRate > 0 {
+		options = append(options, dcpu.WithDevices(&dcpu.DMA{WordsPerCycle: *dmaRate}))
+	}
+	if *printerFile != "" {
+		f, err := os.OpenFile(*printerFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		printer := &dcpu.LinePrinter{W: f}
+		if requestedRate != dcpu.Unthrottled {
+			printer.CyclesPerSecond = uint64(requestedRate)
+		}
+		options = append(options, dcpu.WithDevices(printer))
+	}
+	if *bankPages > 0 {
+		bank, err := dcpu.NewBank(*bankPages)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		options = append(options, dcpu.WithDevices(bank))
+	}
+	if *realTimeClock {
+		rtc := &dcpu.RealTimeClock{Offset: *rtcOffset}
+		if *rtcTime != "" {
+			frozen, err := time.Parse(time.RFC3339, *rtcTime)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			rtc.Now = func() time.Time { return frozen }
+		}
+		options = append(options, dcpu.WithDevices(rtc))
+	}
+	if *serialPort != "" {
+		var serial *dcpu.Serial
+		if *serialPort == "stdio" {
+			if !*headless {
+				fmt.Fprintln(os.Stderr, "-serial stdio requires -headless")
+				os.Exit(1)
+			}
+			if flag.Arg(0) == "-" {
+				fmt.Fprintln(os.Stderr, "-serial stdio can't be used with a program read from stdin")
+				os.Exit(1)
+			}
+			serial = dcpu.NewSerial(os.Stdin, os.Stdout)
+		} else {
+			var err error
+			if serial, err = dcpu.ListenSerial(*serialPort); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer serial.Close()
+		}
+		options = append(options, dcpu.WithDevices(serial))
+	}
+	if *radioAddr != "" {
+		var peers []string
+		if *radioPeers != "" {
+			peers = strings.Split(*radioPeers, ",")
+		}
+		radio, err := dcpu.ListenRadio(*radioAddr, peers...)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer radio.Close()
+		options = append(options, dcpu.WithDevices(radio))
+	}
+	if *speaker {
+		sp := &dcpu.Speaker{Output: dcpu.BellOutput{W: os.Stdout}}
+		if requestedRate != dcpu.Unthrottled {
+			sp.CyclesPerSecond = uint64(requestedRate)
+		}
+		options = append(options, dcpu.WithDevices(sp))
+	}
+	if *floppyFile != "" {
+		drive, err := openFloppy(*floppyFile, *floppyProtected)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if requestedRate != dcpu.Unthrottled {
+			drive.CyclesPerSecond = uint64(requestedRate)
+		}
+		options = append(options, dcpu.WithDevices(drive))
+	}
+	if *mediaFile != "" {
+		f, err := openDiskImage(*mediaFile, *mediaLocked)
+		if err == nil {
+			drive := new(dcpu.HMD2043)
+			if requestedRate != dcpu.Unthrottled {
+				drive.CyclesPerSecond = uint64(requestedRate)
+			}
+			if err = drive.Insert(f, *mediaLocked); err == nil {
+				options = append(options, dcpu.WithDevices(drive))
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if *nvramFile != "" {
+		f, err := os.OpenFile(*nvramFile, os.O_RDWR|os.O_CREATE, 0666)
+		if err == nil {
+			var nvram *dcpu.NVRAM
+			if nvram, err = dcpu.NewNVRAM(*nvramSize, f); err == nil {
+				options = append(options, dcpu.WithDevices(nvram))
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if *hostFSRoot != "" {
+		hostfs := &dcpu.HostFS{Root: *hostFSRoot}
+		defer hostfs.Close()
+		options = append(options, dcpu.WithDevices(hostfs))
+	}
+	// the screen belongs to the machine, so hold on to the console output
+	// until it stops
+	var consoleLog bytes.Buffer
+	if *debugConsole {
+		console := &debug.Console{W: &consoleLog}
+		if *headless {
+			console.W = os.Stderr
+		}
+		options = append(options, dcpu.WithDevices(console))
+	}
+	if *testDevice {
+		if !*headless {
+			fmt.Fprintln(os.Stderr, "-test requires -headless")
+			os.Exit(1)
+		}
+		options = append(options, dcpu.WithDevices(new(dcpu.TestDevice)))
+	}
+	if *debugDevice {
+		options = append(options, dcpu.WithDevices(new(debug.Device)))
+	}
+	options = append(options, dcpu.WithKeyboardSources(typedKeys))
+	machine, err := dcpu.NewMachine(options...)
+	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
-	if err := machine.Start(requestedRate); err != nil {
+	machine.State.StrictDivide = *strictDivide
+	if *pasteRate <= 0 {
+		fmt.Fprintln(os.Stderr, "-pasteRate must be positive")
+		os.Exit(2)
+	}
+	if *repeatRate < 0 || *repeatDelay < 0 {
+		fmt.Fprintln(os.Stderr, "-repeatRate and -repeatDelay can't be negative")
+		os.Exit(2)
+	}
+	keyRate := uint64(requestedRate)
+	if requestedRate == dcpu.Unthrottled {
+		keyRate = uint64(dcpu.DefaultClockRate)
+	}
+	machine.Keyboard.PasteInterval = keyRate / uint64(*pasteRate)
+	if *repeatRate > 0 {
+		machine.Keyboard.RepeatDelay = keyRate * uint64(*repeatDelay) / uint64(time.Second)
+		machine.Keyboard.RepeatInterval = keyRate / uint64(*repeatRate)
+	}
+	machine.State.InvalidOpcode = invalidOpcode
+	machine.State.SelfModify = selfModify
+	// the screen belongs to the machine, so hold on to the log until it stops
+	var selfModifyLog []string
+	var screenshotLog []string
+	machine.State.SelfModifyLog = func(err *core.SelfModifyError) {
+		selfModifyLog = append(selfModifyLog, err.Error())
+	}
+	if err := machine.LoadProgram(words, core.Word(*programOrigin)); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
-	// convert termbox event polling into a channel
-	events := make(chan termbox.Event)
-	go func() {
-		for {
-			events <- termbox.PollEvent()
+	// the extent of the program and segments, for -export
+	var loaded core.Region
+	if len(words) > 0 {
+		loaded = core.Region{Start: core.Word(*programOrigin), Length: core.Word(len(words))}
+	}
+	for _, seg := range segments {
+		segWords, _, err := loadProgram(seg.path, seg.offset)
+		if err == nil {
+			err = machine.LoadSegment(segWords, seg.offset)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", seg.path, err)
+			os.Exit(1)
+		}
+		extent := core.Region{Start: seg.offset, Length: core.Word(len(segWords))}
+		if loaded.Length == 0 {
+			loaded = extent
+		} else if extent.Length > 0 {
+			loaded = loaded.Union(extent)
+		}
+	}
+	if *loadStateFile != "" {
+		data, err := ioutil.ReadFile(*loadStateFile)
+		if err == nil {
+			err = machine.Restore(data)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *loadStateFile, err)
+			os.Exit(1)
+		}
+	}
+	if *exportFile != "" {
+		if exportRange.Length == 0 {
+			exportRange = region(loaded)
+		}
+		if err := exportSource(&machine.State.Ram, core.Region(exportRange)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	for _, wp := range watchpoints {
+		if err := machine.State.AddWatchpoint(wp.Start, wp.Length, wp.Kind); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if err := breakpoints.addBreakpoints(&machine.State, symbols); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *traceFile != "" {
+		f, err := os.Create(*traceFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
-	}()
+		debug.NewTracer(f).Attach(&machine.State)
+	}
+	var recent *debug.Tracer
+	if *traceLast > 0 {
+		recent = debug.NewRingTracer(*traceLast)
+		recent.Attach(&machine.State)
+	}
+	var profiler *debug.Profiler
+	if *profileFile != "" {
+		profiler = debug.NewProfiler()
+		profiler.Attach(&machine.State)
+	}
+	// track calls so errors come with a backtrace
+	machine.EnableCallStack()
+	if *coverageFile != "" {
+		machine.EnableCoverage()
+	}
+	var accessStats *debug.AccessStats
+	if *accessStatsFile != "" {
+		var err error
+		if accessStats, err = debug.NewAccessStats(*accessBucket); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		accessStats.Attach(&machine.State)
+	}
+	switch {
+	case *recordFile == "":
+	case isScreencast(*recordFile):
+		if *headless {
+			fmt.Fprintln(os.Stderr, "-record can't record the screen with -headless")
+			os.Exit(1)
+		}
+		machine.Screencast = new(dcpu.Screencast)
+	default:
+		machine.Recording = new(dcpu.Recording)
+	}
+	if *replayFile != "" {
+		f, err := os.Open(*replayFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		machine.Replay, err = dcpu.ReadRecording(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *replayFile, err)
+			os.Exit(1)
+		}
+	}
+	if *inputFile != "" {
+		if *replayFile != "" {
+			fmt.Fprintln(os.Stderr, "-input can't be used with -replay, which replays the keys it typed")
+			os.Exit(1)
+		}
+		f, err := os.Open(*inputFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		// times are in the machine's time, as for the Generic Clock
+		rate := requestedRate
+		if rate == dcpu.Unthrottled {
+			rate = dcpu.DefaultClockRate
+		}
+		script, err := dcpu.ReadScript(f, uint64(rate))
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *inputFile, err)
+			os.Exit(1)
+		}
+		machine.Keyboard.Sources = append(machine.Keyboard.Sources, script)
+	}
+	saveRecording := func() {
+		var write func(io.Writer) error
+		switch {
+		case machine.Recording != nil:
+			write = machine.Recording.Write
+		case machine.Screencast != nil && filepath.Ext(*recordFile) == ".gif":
+			write = func(w io.Writer) error { return machine.Screencast.WriteGIF(w, 2) }
+		case machine.Screencast != nil:
+			write = machine.Screencast.WriteCast
+		default:
+			return
+		}
+		if err := writeFile(*recordFile, write); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	saveProfile := func() {
+		if profiler != nil {
+			write := func(w io.Writer) error {
+				return profiler.Fprint(w, *profileTop, symbols)
+			}
+			if err := writeFile(*profileFile, write); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+	}
+	saveCoverage := func() {
+		if machine.Coverage != nil {
+			if err := writeFile(*coverageFile, machine.Coverage.Fprint); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+	}
+	saveAccessStats := func() {
+		if accessStats != nil {
+			if err := writeFile(*accessStatsFile, accessStats.Fprint); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+	}
+	printSelfModifyLog := func() {
+		const maxLog = 100
+		for i, msg := range selfModifyLog {
+			if i == maxLog {
+				fmt.Fprintf(os.Stderr, "... and %d more self-modifying writes\n", len(selfModifyLog)-maxLog)
+				break
+			}
+			fmt.Fprintln(os.Stderr, msg)
+		}
+	}
+	printScreenshotLog := func() {
+		for _, msg := range screenshotLog {
+			fmt.Fprintln(os.Stderr, msg)
+		}
+	}
+	saveState := func() {
+		if *saveStateFile == "" {
+			return
+		}
+		data, err := machine.Snapshot()
+		if err == nil {
+			err = ioutil.WriteFile(*saveStateFile, data, 0644)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
 	var effectiveRate dcpu.ClockRate
+	var halted *dcpu.Halted
 	printErr := func(err error) {
+		saveRecording()
+		saveProfile()
+		saveCoverage()
+		saveAccessStats()
+		saveState()
+		printSelfModifyLog()
+		printScreenshotLog()
+		consoleLog.WriteTo(os.Stderr)
+		fmt.Fprintln(os.Stderr, err)
+		if merr, ok := err.(*dcpu.MachineError); ok && (len(merr.CallStack) > 0 || len(symbols) > 0) {
+			fmt.Fprintln(os.Stderr, "Backtrace:")
+			debug.FprintBacktrace(os.Stderr, merr.PC, merr.CallStack, symbols)
+		}
+		machine.State.Ram.DumpMemoryOptions(os.Stderr, core.DumpOptions{
+			ASCII:      true,
+			Highlights: []core.Word{machine.State.PC()},
+			Labels:     disasm.Labels(symbols),
+		})
+		fmt.Fprintln(os.Stderr)
+		lines := disasm.Memory(&machine.State.Ram, machine.State.PC(), 4, machine.State.Spec)
+		disasm.Annotate(lines, symbols)
+		disasm.Fprint(os.Stderr, lines)
+		if recent != nil {
+			fmt.Fprintln(os.Stderr)
+			recent.Fprint(os.Stderr)
+		}
+		os.Exit(1)
+	}
+	finish := func() {
+		saveRecording()
+		saveProfile()
+		saveCoverage()
+		saveAccessStats()
+		saveState()
+		printSelfModifyLog()
+		printScreenshotLog()
+		consoleLog.WriteTo(os.Stderr)
+		if halted != nil {
+			fmt.Printf("Program halted at PC %#04x after %d cycles\n", halted.PC, halted.Cycles)
+		}
+		if *printRate {
+			fmt.Printf("Effective clock rate: %s\n", effectiveRate)
+		}
+	}
+	if *headless {
+		start := time.Now()
+		var err error
+		if *screenDump != "" {
+			// the screen is only attached when started, which headless
+			// machines aren't
+			if err = machine.Video.Init(); err == nil {
+				err = machine.Video.MapToMachine(0x8000, machine)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+		halted, err = runHeadless(machine, *screenDump != "")
+		if *screenDump != "" {
+			machine.RefreshScreen()
+			machine.Video.Close()
+		}
+		if *testDevice {
+			if err == nil {
+				err = errors.New("program stopped without passing its test")
+			} else if err = dcpu.TestResult(err); err == nil {
+				fmt.Println("Test passed")
+			}
+		}
+		if err != nil {
+			printErr(err)
+		}
+		effectiveRate = dcpu.ClockRate(float64(machine.State.Cycles()) / time.Since(start).Seconds())
+		finish()
+		return
+	}
+	if err := machine.Start(0); err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		machine.State.Ram.DumpMemory(os.Stderr, []int{int(machine.State.PC())})
 		os.Exit(1)
 	}
+	// convert termbox event polling into a channel, with pasted text picked
+	// out, unless the frontend has the keyboard
+	var events chan termbox.Event
+	var pastes chan string
+	if !graphical {
+		polled := make(chan termbox.Event)
+		events = make(chan termbox.Event)
+		pastes = make(chan string)
+		go func() {
+			for {
+				polled <- termbox.PollEvent()
+			}
+		}()
+		go terminal.ReadPastes(polled, events, pastes)
+	}
+	// held keys repeat at the keyboard's pace with -repeatRate
+	var holder *keyHolder
+	if *repeatRate > 0 {
+		// released just before the key would repeat, if the terminal
+		// hasn't repeated it yet
+		holder = &keyHolder{machine: machine, first: *repeatDelay * 9 / 10}
+	}
+	commands := make(chan command)
+	// run performs a command, returning whether to quit
+	run := func(cmd command) bool {
+		switch cmd {
+		case commandQuit:
+			effectiveRate = machine.EffectiveClockRate()
+			if err := machine.Stop(); err != nil {
+				printErr(err)
+			}
+			return true
+		case commandReset:
+			machine.Reset()
+		case commandSlower, commandFaster:
+			rate := machine.ClockRate()
+			if rate == dcpu.Unthrottled {
+				if cmd == commandSlower {
+					rate = maxClockRate
+				}
+			} else if cmd == commandSlower && rate > 1 {
+				rate /= 2
+			} else if cmd == commandFaster && rate < maxClockRate {
+				rate *= 2
+			}
+			machine.SetClockRate(rate)
+		case commandScreenshot:
+			// the screen belongs to the machine, so report it on exit
+			screenshotLog = append(screenshotLog, saveScreenshot(machine))
+		case commandPause:
+			// these only fail if the machine has stopped, which
+			// EventsC reports
+			if machine.Paused() {
+				machine.Resume()
+			} else {
+				machine.Pause()
+			}
+		case commandNextScreen:
+			screen.NextScreen()
+		}
+		return false
+	}
 	// now wait for keyboard events
-loop:
-	for {
-		select {
-		case evt := <-events:
-			if evt.Type == termbox.EventKey {
-				if evt.Key == termbox.KeyCtrlC {
-					effectiveRate = machine.EffectiveClockRate()
-					if err := machine.Stop(); err != nil {
-						printErr(err)
+	loop := func() {
+		for {
+			select {
+			case evt := <-events:
+				if evt.Type == termbox.EventResize {
+					screen.Resize()
+					continue
+				}
+				if evt.Type != termbox.EventKey {
+					continue
+				}
+				if name, ok := termboxKeymap.KeyToCommand[evt.Key]; ok {
+					if run(commandNames[name]) {
+						return
 					}
-					break loop
+					continue
 				}
 				// else pass it to the keyboard
 				if evt.Ch == 0 {
 					// it's a key constant
 					key := evt.Key
-					if r, ok := keymapTermboxKeyToRune[key]; ok {
-						machine.Keyboard.RegisterKeyTyped(r)
-					} else if k, ok := keymapTermboxKeyToKey[key]; ok {
-						machine.Keyboard.RegisterKeyPressed(k)
-						machine.Keyboard.RegisterKeyReleased(k)
+					if r, ok := termboxKeymap.KeyToRune[key]; ok {
+						if holder != nil {
+							holder.holdTyped(r)
+						} else {
+							typeKey(machine, r)
+						}
+					} else if k, ok := termboxKeymap.KeyToKey[key]; ok {
+						if holder != nil {
+							holder.holdPressed(k)
+						} else {
+							pressKey(machine, k)
+						}
 					}
 				} else {
 					ch := evt.Ch
-					if r, ok := keymapRuneToRune[evt.Ch]; ok {
+					if r, ok := termboxKeymap.RuneToRune[evt.Ch]; ok {
 						ch = r
 					}
-					machine.Keyboard.RegisterKeyTyped(ch)
+					if holder != nil {
+						holder.holdTyped(ch)
+					} else {
+						typeKey(machine, ch)
+					}
+				}
+			case text := <-pastes:
+				// with the characters remapped as when they're typed
+				pasteText(machine, strings.Map(func(ch rune) rune {
+					if r, ok := termboxKeymap.RuneToRune[ch]; ok {
+						return r
+					}
+					return ch
+				}, text))
+			case <-holder.released():
+				holder.release()
+			case cmd := <-commands:
+				if run(cmd) {
+					return
+				}
+			case evt := <-machine.EventsC:
+				switch evt := evt.(type) {
+				case dcpu.Halted:
+					// leave the screen up until the user quits
+					halted = &evt
+				case dcpu.Stopped:
+					machine.Stop() // unlike HasError(), EventsC doesn't shut down the machine
+					printErr(evt.Err)
 				}
 			}
-		case err := <-machine.ErrorC:
-			machine.Stop() // unlike HasError(), ErrorC doesn't shut down the machine
+		}
+	}
+	if !graphical {
+		loop()
+	} else {
+		// the window needs the main thread
+		done := make(chan struct{})
+		go func() {
+			loop()
+			close(done)
+		}()
+		if err := window(machine, framebuffer, commands, done); err != nil {
+			machine.Stop()
 			printErr(err)
 		}
 	}
-	if *printRate {
-		fmt.Printf("Effective clock rate: %s\n", effectiveRate)
+	finish()
+}
+
+// isScreencast returns whether the -record file is for the screen, rather
+// than the inputs
+func isScreencast(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".cast" || ext == ".gif"
+}
+
+// saveScreenshot writes the screen to a PNG and a text file, named for the
+// time, in the current directory. It returns a message saying where they
+// were saved, or why they couldn't be.
+func saveScreenshot(machine *dcpu.Machine) string {
+	shot, err := machine.Screenshot()
+	if err != nil {
+		return fmt.Sprintf("screenshot: %v", err)
+	}
+	name := "dcpu16-" + time.Now().Format("20060102-150405")
+	err = writeFile(name+".png", func(w io.Writer) error { return shot.WritePNG(w, 4) })
+	if err == nil {
+		err = writeFile(name+".txt", func(w io.Writer) error { return shot.WriteText(w, true) })
+	}
+	if err != nil {
+		return fmt.Sprintf("screenshot: %v", err)
+	}
+	return fmt.Sprintf("Saved screenshot to %s.png and %s.txt", name, name)
+}
+
+// loadProgram assembles or reads the program, depending on its extension,
+// to be loaded at origin. A program of "-" is read from stdin as a binary,
+// and http(s) URLs are downloaded first. DCPU Toolchain object files are
+// recognized by their magic, and linked to run at origin.
+func loadProgram(program string, origin core.Word) ([]core.Word, asm.Symbols, error) {
+	if isURL(program) {
+		path, err := fetchProgram(program)
+		if err != nil {
+			return nil, nil, err
+		}
+		program = path
+	}
+	switch filepath.Ext(program) {
+	case ".dasm", ".dasm16", ".asm":
+		// Assemble the source
+		prog, err := asm.CompileFile(program)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := writeAssemblyOutput(prog); err != nil {
+			return nil, nil, err
+		}
+		return prog.Words, prog.Symbols, nil
+	case ".dat", ".txt":
+		// Parse the words written out in hex
+		data, err := ioutil.ReadFile(program)
+		if err != nil {
+			return nil, nil, err
+		}
+		words, err := asm.ParseWords(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", program, err)
+		}
+		return words, nil, nil
+	case ".hex", ".ihex", ".hex16":
+		f, err := os.Open(program)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer f.Close()
+		format := ihex.BigEndian
+		if filepath.Ext(program) == ".hex16" {
+			format = ihex.Words
+		} else if binaryOrder == orderLittle {
+			format = ihex.LittleEndian
+		}
+		words, err := ihex.Read(f, format)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", program, err)
+		}
+		return words, nil, nil
+	default:
+		// Interpret the file as Words
+		data, err := readProgram(program)
+		if err != nil {
+			return nil, nil, err
+		}
+		if toolchain.IsObject(data) {
+			return loadObject(program, data, origin)
+		}
+		little := binaryOrder == orderLittle
+		if binaryOrder == orderAuto && disasm.GuessLittleEndian(data, specVersion) {
+			fmt.Fprintf(os.Stderr, "%s looks little endian; use -byteOrder big to override\n", program)
+			little = true
+		}
+		words := core.DecodeBytes(data, little)
+		return words, nil, nil
+	}
+}
+
+// loadObject links a DCPU Toolchain object file to run at origin
+func loadObject(program string, data []byte, origin core.Word) ([]core.Word, asm.Symbols, error) {
+	little := binaryOrder == orderLittle
+	if binaryOrder == orderAuto {
+		little = disasm.GuessLittleEndian(toolchain.ObjectCode(data), specVersion)
+	}
+	obj, err := toolchain.ReadObject(data, little)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", program, err)
+	}
+	words, symbols, err := obj.Link(origin)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", program, err)
+	}
+	return words, symbols, nil
+}
+
+// readProgram reads the binary program at path, or from stdin if path is
+// "-", so that it can be piped from an assembler
+func readProgram(path string) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// byteOrder is a flag.Value choosing the byte order of binary programs
+type byteOrder int
+
+const (
+	orderAuto byteOrder = iota
+	orderBig
+	orderLittle
+)
+
+func (o *byteOrder) String() string {
+	return [...]string{"auto", "big", "little"}[*o]
+}
+
+func (o *byteOrder) Set(str string) error {
+	switch str {
+	case "auto":
+		*o = orderAuto
+	case "big":
+		*o = orderBig
+	case "little":
+		*o = orderLittle
+	default:
+		return fmt.Errorf("unknown byte order %#v", str)
+	}
+	return nil
+}
+
+// terminalMode is a flag.Value choosing the terminal.Mode to draw with
+type terminalMode terminal.Mode
+
+func (m *terminalMode) String() string {
+	return [...]string{"cells", "halfblocks", "braille"}[*m]
+}
+
+func (m *terminalMode) Set(str string) error {
+	switch str {
+	case "cells":
+		*m = terminalMode(terminal.Cells)
+	case "halfblocks":
+		*m = terminalMode(terminal.HalfBlocks)
+	case "braille":
+		*m = terminalMode(terminal.Braille)
+	default:
+		return fmt.Errorf("unknown drawing mode %#v", str)
+	}
+	return nil
+}
+
+// segmentList is a flag.Value that collects files to load at addresses, of
+// the form file@addr
+type segmentList []segment
+
+type segment struct {
+	path   string
+	offset core.Word
+}
+
+func (l *segmentList) String() string {
+	strs := make([]string, len(*l))
+	for i, seg := range *l {
+		strs[i] = fmt.Sprintf("%s@%#04x", seg.path, seg.offset)
+	}
+	return strings.Join(strs, ",")
+}
+
+func (l *segmentList) Set(str string) error {
+	path, offset, err := dcpu.ParseSegment(str)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, segment{path, offset})
+	return nil
+}
+
+// region is a flag.Value holding a region of memory, of the form
+// addr+length
+type region core.Region
+
+func (r *region) String() string {
+	return fmt.Sprintf("%#04x+%d", r.Start, r.Length)
+}
+
+func (r *region) Set(str string) error {
+	reg, err := core.ParseRegion(str)
+	if err != nil {
+		return err
+	}
+	*r = region(reg)
+	return nil
+}
+
+// exportSource writes r to the -export file as source code, in the
+// language of the file's extension
+func exportSource(ram *core.Memory, r core.Region) error {
+	var format core.SourceFormat
+	switch filepath.Ext(*exportFile) {
+	case ".go":
+		format = core.SourceGo
+	case ".c", ".h":
+		format = core.SourceC
+	default:
+		return fmt.Errorf("%s: -export writes .go, .c or .h files", *exportFile)
+	}
+	if r.Length == 0 {
+		return errors.New("-export: nothing was loaded; use -exportRange")
+	}
+	return writeFile(*exportFile, func(w io.Writer) error {
+		return ram.DumpSource(w, r, format, *exportName)
+	})
+}
+
+// writeAssemblyOutput writes the listing and symbol files, if requested
+func writeAssemblyOutput(prog *asm.Program) error {
+	if *listingFile != "" {
+		if err := writeFile(*listingFile, prog.WriteListing); err != nil {
+			return err
+		}
+	}
+	if *symbolsFile != "" {
+		if err := writeFile(*symbolsFile, prog.Symbols.Write); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// openFloppy returns an M35FD with the disk image at path inserted
+func openFloppy(path string, protected bool) (*dcpu.M35FD, error) {
+	f, err := openDiskImage(path, protected)
+	if err != nil {
+		return nil, err
+	}
+	drive := new(dcpu.M35FD)
+	if err := drive.Insert(f, protected); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return drive, nil
+}
+
+// openDiskImage opens the disk image at path, read-only if it's write
+// protected
+func openDiskImage(path string, protected bool) (*os.File, error) {
+	flags := os.O_RDWR
+	if protected {
+		flags = os.O_RDONLY
+	}
+	return os.OpenFile(path, flags, 0)
+}
+
+// runHeadless runs the machine without a terminal at the requested rate,
+// until the program halts, an error occurs or the user interrupts it. If
+// screen is true, the screen is refreshed at its refresh rate.
+func runHeadless(machine *dcpu.Machine, screen bool) (*dcpu.Halted, error) {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	// run the cycles for each tick in a batch
+	const tick = 10 * time.Millisecond
+	batch := uint64(requestedRate) * uint64(tick) / uint64(time.Second)
+	if requestedRate == dcpu.Unthrottled {
+		batch = 100000
+	} else if batch == 0 {
+		batch = 1
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	var refresh <-chan time.Time
+	if screen {
+		refresher := time.NewTicker(screenRefreshRate.ToDuration())
+		defer refresher.Stop()
+		refresh = refresher.C
+	}
+	never := func(s *core.State) bool { return false }
+	for {
+		_, err := machine.RunUntil(never, batch)
+		select {
+		case <-refresh:
+			machine.RefreshScreen()
+		default:
+		}
+		if err == core.ErrHalted {
+			return &dcpu.Halted{PC: machine.State.PC(), Cycles: machine.State.Cycles()}, nil
+		} else if err != core.ErrCycleLimit {
+			return nil, err
+		}
+		if requestedRate == dcpu.Unthrottled {
+			select {
+			case <-interrupt:
+				return nil, nil
+			default:
+			}
+			continue
+		}
+		select {
+		case <-ticker.C:
+		case <-interrupt:
+			return nil, nil
+		}
 	}
 }
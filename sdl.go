@@ -0,0 +1,122 @@
+//go:build sdl
+
+package main
+
+// the SDL2 frontend, built with -tags sdl
+
+import (
+	"github.com/kballard/dcpu16/dcpu"
+	"github.com/veandco/go-sdl2/sdl"
+	"time"
+)
+
+// sdlScale is how many pixels of the window each pixel of the screen starts
+// out as. The window can be resized.
+const sdlScale = 4
+
+// sdlFrameRate is how often the window is redrawn
+const sdlFrameRate = time.Second / 60
+
+var sdlCommands = map[sdl.Keycode]command{
+	sdl.K_c: commandQuit,
+	sdl.K_r: commandReset,
+	sdl.K_s: commandSlower,
+	sdl.K_f: commandFaster,
+	sdl.K_t: commandScreenshot,
+	sdl.K_p: commandPause,
+}
+
+var keymapSDLKeyToRune = map[sdl.Keycode]rune{
+	sdl.K_RETURN:    '\n',
+	sdl.K_KP_ENTER:  '\n',
+	sdl.K_BACKSPACE: 0x08,
+	sdl.K_DELETE:    127,
+}
+
+var keymapSDLKeyToKey = map[sdl.Keycode]dcpu.Key{
+	sdl.K_UP:    dcpu.KeyArrowUp,
+	sdl.K_DOWN:  dcpu.KeyArrowDown,
+	sdl.K_LEFT:  dcpu.KeyArrowLeft,
+	sdl.K_RIGHT: dcpu.KeyArrowRight,
+}
+
+func init() {
+	frontends["sdl"] = runSDL
+}
+
+func runSDL(machine *dcpu.Machine, fb *dcpu.Framebuffer, commands chan<- command, done <-chan struct{}) error {
+	if err := sdl.Init(sdl.INIT_VIDEO); err != nil {
+		return err
+	}
+	defer sdl.Quit()
+	window, err := sdl.CreateWindow("DCPU-16", sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED,
+		dcpu.FrameWidth*sdlScale, dcpu.FrameHeight*sdlScale, sdl.WINDOW_SHOWN|sdl.WINDOW_RESIZABLE)
+	if err != nil {
+		return err
+	}
+	defer window.Destroy()
+	renderer, err := sdl.CreateRenderer(window, -1, sdl.RENDERER_ACCELERATED)
+	if err != nil {
+		return err
+	}
+	defer renderer.Destroy()
+	// keep the pixels square when the window is resized
+	renderer.SetLogicalSize(dcpu.FrameWidth, dcpu.FrameHeight)
+	// RGBA32 is the byte order of image.RGBA, whatever the machine's
+	texture, err := renderer.CreateTexture(sdl.PIXELFORMAT_RGBA32, sdl.TEXTUREACCESS_STREAMING, dcpu.FrameWidth, dcpu.FrameHeight)
+	if err != nil {
+		return err
+	}
+	defer texture.Destroy()
+	sdl.StartTextInput()
+
+	send := func(cmd command) {
+		select {
+		case commands <- cmd:
+		case <-done:
+		}
+	}
+	ticker := time.NewTicker(sdlFrameRate)
+	defer ticker.Stop()
+	for {
+		for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
+			switch event := event.(type) {
+			case *sdl.QuitEvent:
+				send(commandQuit)
+			case *sdl.TextInputEvent:
+				for _, ch := range event.GetText() {
+					if ch < 0x80 {
+						typeKey(machine, ch)
+					}
+				}
+			case *sdl.KeyboardEvent:
+				if event.Type != sdl.KEYDOWN {
+					continue
+				}
+				key := event.Keysym.Sym
+				if event.Keysym.Mod&sdl.KMOD_CTRL != 0 {
+					if cmd, ok := sdlCommands[key]; ok {
+						send(cmd)
+					}
+				} else if r, ok := keymapSDLKeyToRune[key]; ok {
+					typeKey(machine, r)
+				} else if k, ok := keymapSDLKeyToKey[key]; ok {
+					pressKey(machine, k)
+				}
+			}
+		}
+		if frame := fb.Frame(); frame != nil {
+			if err := texture.Update(nil, frame.Pix, frame.Stride); err != nil {
+				return err
+			}
+		}
+		renderer.Clear()
+		renderer.Copy(texture, nil, nil)
+		renderer.Present()
+		select {
+		case <-ticker.C:
+		case <-done:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+// the web frontend, which shows the screen in browsers
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/kballard/dcpu16/dcpu"
+	"github.com/kballard/dcpu16/dcpu/core"
+	"github.com/kballard/dcpu16/dcpu/websocket"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+)
+
+var webAddr *string = flag.String("webAddr", "localhost:8016", "The address -frontend web serves the screen on")
+
+// webUpdateRate is how often changes to the screen are sent to browsers
+const webUpdateRate = time.Second / 30
+
+// webUpdate is a message to the browser. The first sends the whole screen;
+// after that, only what's changed is sent.
+type webUpdate struct {
+	Cells   [][2]core.Word `json:"cells,omitempty"` // index and word
+	Font    []core.Word    `json:"font,omitempty"`
+	Palette []core.Word    `json:"palette,omitempty"`
+	Border  *core.Word     `json:"border,omitempty"`
+}
+
+// webKey is a message from the browser: a typed character, or one of the
+// arrow keys, named up, down, left or right
+type webKey struct {
+	Ch  rune   `json:"ch"`
+	Key string `json:"key"`
+}
+
+var keymapWebKeyToKey = map[string]dcpu.Key{
+	"up":    dcpu.KeyArrowUp,
+	"down":  dcpu.KeyArrowDown,
+	"left":  dcpu.KeyArrowLeft,
+	"right": dcpu.KeyArrowRight,
+}
+
+func init() {
+	frontends["web"] = runWeb
+}
+
+// runWeb serves the page showing the screen on -webAddr, until ^C. Anyone
+// who can reach it can watch and type to the program.
+func runWeb(machine *dcpu.Machine, fb *dcpu.Framebuffer, commands chan<- command, done <-chan struct{}) error {
+	ln, err := net.Listen("tcp", *webAddr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, webPage)
+	})
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serveWebScreen(machine, fb, conn, done)
+	})
+	server := &http.Server{Handler: mux}
+	go server.Serve(ln)
+	defer server.Close()
+	fmt.Fprintf(os.Stderr, "Showing the screen at http://%s/ (^C to quit)\n", ln.Addr())
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	select {
+	case <-interrupt:
+		select {
+		case commands <- commandQuit:
+		case <-done:
+		}
+	case <-done:
+	}
+	<-done
+	return nil
+}
+
+// serveWebScreen sends the screen to a browser as it changes, and passes
+// its keys to the keyboard, until the browser leaves or done is closed
+func serveWebScreen(machine *dcpu.Machine, fb *dcpu.Framebuffer, conn *websocket.Conn, done <-chan struct{}) {
+	left := make(chan struct{})
+	go func() {
+		defer close(left)
+		for {
+			message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var key webKey
+			if json.Unmarshal(message, &key) != nil {
+				continue
+			}
+			if k, ok := keymapWebKeyToKey[key.Key]; ok {
+				pressKey(machine, k)
+			} else if key.Ch > 0 && key.Ch < 0x80 {
+				typeKey(machine, key.Ch)
+			}
+		}
+	}()
+	ticker := time.NewTicker(webUpdateRate)
+	defer ticker.Stop()
+	var sent *dcpu.Screenshot
+	for {
+		screen := fb.Screen()
+		if update, changed := diffWebScreen(sent, &screen); changed {
+			message, err := json.Marshal(update)
+			if err != nil {
+				return
+			}
+			if conn.WriteText(message) != nil {
+				return
+			}
+			sent = &screen
+		}
+		select {
+		case <-ticker.C:
+		case <-left:
+			return
+		case <-done:
+			return
+		}
+	}
+}
+
+// diffWebScreen returns the update from the screen the browser has, or nil
+// if it has none yet, to the current one, and whether there's anything to
+// send
+func diffWebScreen(sent, screen *dcpu.Screenshot) (*webUpdate, bool) {
+	update := new(webUpdate)
+	changed := false
+	for i, cell := range screen.Cells {
+		if sent == nil || sent.Cells[i] != cell {
+			update.Cells = append(update.Cells, [2]core.Word{core.Word(i), cell})
+			changed = true
+		}
+	}
+	if sent == nil || sent.Font != screen.Font {
+		update.Font = screen.Font[:]
+		changed = true
+	}
+	if sent == nil || sent.Palette != screen.Palette {
+		update.Palette = screen.Palette[:]
+		changed = true
+	}
+	if sent == nil || sent.Border != screen.Border {
+		border := screen.Border
+		update.Border = &border
+		changed = true
+	}
+	return update, changed
+}
+
+// webPage draws the screen, with the font and palette it's sent, on a
+// canvas, and sends back the keys typed
+const webPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>DCPU-16</title>
+<style>
+body { background: #222; margin: 0; display: flex; align-items: center; justify-content: center; height: 100vh; }
+canvas { width: 640px; height: 512px; image-rendering: pixelated; }
+</style>
+</head>
+<body>
+<canvas id="screen" width="160" height="128" tabindex="0"></canvas>
+<script>
+"use strict";
+const width = 160, height = 128, border = 16, columns = 32, rows = 12;
+const canvas = document.getElementById("screen"), ctx = canvas.getContext("2d");
+const img = ctx.createImageData(width, height);
+const cells = new Array(columns * rows).fill(0);
+let font = new Array(256).fill(0), palette = new Array(16).fill(0), borderColor = 0;
+
+function color(index) {
+	const w = palette[index & 0xf];
+	return [(w >> 8 & 0xf) * 0x11, (w >> 4 & 0xf) * 0x11, (w & 0xf) * 0x11];
+}
+
+function set(x, y, c) {
+	const o = (y * width + x) * 4;
+	img.data[o] = c[0];
+	img.data[o + 1] = c[1];
+	img.data[o + 2] = c[2];
+	img.data[o + 3] = 0xff;
+}
+
+function drawCell(i) {
+	const cell = cells[i], x0 = border + i % columns * 4, y0 = border + Math.floor(i / columns) * 8;
+	const fg = color(cell >> 12), bg = color(cell >> 8), ch = cell & 0x7f;
+	for (let col = 0; col < 4; col++) {
+		// the high byte of each word is the left column, with the top
+		// pixel in the lowest bit
+		const word = font[ch * 2 + (col >> 1)], bits = col % 2 ? word & 0xff : word >> 8;
+		for (let row = 0; row < 8; row++) {
+			set(x0 + col, y0 + row, bits >> row & 1 ? fg : bg);
+		}
+	}
+}
+
+function drawAll() {
+	const c = color(borderColor);
+	for (let y = 0; y < height; y++) {
+		for (let x = 0; x < width; x++) {
+			set(x, y, c);
+		}
+	}
+	cells.forEach((_, i) => drawCell(i));
+}
+
+const ws = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/ws");
+ws.onmessage = e => {
+	const update = JSON.parse(e.data);
+	let all = false;
+	if (update.font) { font = update.font; all = true; }
+	if (update.palette) { palette = update.palette; all = true; }
+	if (update.border !== undefined) { borderColor = update.border; all = true; }
+	for (const [i, cell] of update.cells || []) {
+		cells[i] = cell;
+		if (!all) drawCell(i);
+	}
+	if (all) drawAll();
+	ctx.putImageData(img, 0, 0);
+};
+ws.onclose = () => { document.title = "DCPU-16 (disconnected)"; };
+
+const keys = { ArrowUp: "up", ArrowDown: "down", ArrowLeft: "left", ArrowRight: "right" };
+const chars = { Enter: 0x0a, Backspace: 0x08, Delete: 0x7f };
+document.addEventListener("keydown", e => {
+	if (e.ctrlKey || e.metaKey || e.altKey) return;
+	let message;
+	if (keys[e.key]) {
+		message = { key: keys[e.key] };
+	} else if (chars[e.key]) {
+		message = { ch: chars[e.key] };
+	} else if (e.key.length === 1 && e.key.charCodeAt(0) < 0x80) {
+		message = { ch: e.key.charCodeAt(0) };
+	} else {
+		return;
+	}
+	e.preventDefault();
+	if (ws.readyState === WebSocket.OPEN) ws.send(JSON.stringify(message));
+});
+</script>
+</body>
+</html>
+`